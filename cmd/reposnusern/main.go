@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"runtime/debug"
 	"syscall"
 
 	"github.com/jonmartinstorm/reposnusern/internal/bqwriter"
@@ -39,6 +40,15 @@ func main() {
 
 	logger.SetupLogger()
 
+	// `reposnusern migrate` kjører bare databasemigreringene
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(processingCtx, os.Args[2:]); err != nil {
+			slog.Error("Migrering feilet", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	cfg, err := config.NewConfig()
 	if err != nil {
 		slog.Error("Ugyldig konfigurasjon:", "error", err)
@@ -58,7 +68,7 @@ func main() {
 	switch cfg.Storage {
 	case config.StoragePostgres:
 		slog.Info("Setter opp writer for PostgreSQL-database")
-		pgWriter, err := dbwriter.NewPostgresWriter(cfg.PostgresDSN)
+		pgWriter, err := dbwriter.NewPostgresWriter(processingCtx, &cfg)
 		if err != nil {
 			slog.Error("Kunne ikke opprette databaseforbindelse til PostgreSQL", "error", err)
 			os.Exit(1)
@@ -84,13 +94,84 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Setter opp klienten mot GitHub og HTTP-cache for betingede kall
+	client := fetcher.NewClient(cfg)
+	switch cfg.HTTPCache {
+	case config.HTTPCacheFS:
+		slog.Info("Bruker HTTP-cache på filsystemet", "katalog", cfg.HTTPCacheDir, "maks_mb", cfg.HTTPCacheMaxMB)
+		cache, err := fetcher.NewFileResponseCache(cfg.HTTPCacheDir, int64(cfg.HTTPCacheMaxMB)<<20)
+		if err != nil {
+			slog.Error("Kunne ikke opprette HTTP-cache", "error", err)
+			os.Exit(1)
+		}
+		client.Cache = cache
+
+	case config.HTTPCachePostgres:
+		slog.Info("Bruker HTTP-cache i PostgreSQL", "maks_mb", cfg.HTTPCacheMaxMB)
+		cache, err := dbwriter.NewPostgresResponseCache(processingCtx, cfg.PostgresDSN, int64(cfg.HTTPCacheMaxMB)<<20, cfg.PostgresMigrate)
+		if err != nil {
+			slog.Error("Kunne ikke opprette HTTP-cache", "error", err)
+			os.Exit(1)
+		}
+		defer func() {
+			if err := cache.Close(); err != nil {
+				slog.Warn("Klarte ikke å lukke HTTP-cachen", "error", err)
+			}
+		}()
+		client.Cache = cache
+	}
+
+	// Tar opp eller spiller av GitHub-trafikken
+	var recorder *fetcher.RecordingTransport
+	switch {
+	case cfg.HTTPRecord != "":
+		slog.Info("Tar opp alle GitHub-svar", "fil", cfg.HTTPRecord)
+		recorder, err = fetcher.NewRecordingTransport(client.HTTP.Transport, cfg.HTTPRecord)
+		if err != nil {
+			slog.Error("Kunne ikke starte opptak", "error", err)
+			os.Exit(1)
+		}
+		client.HTTP.Transport = recorder
+
+	case cfg.HTTPReplay != "":
+		slog.Info("Spiller av GitHub-svar fra opptak", "fil", cfg.HTTPReplay)
+		replay, err := fetcher.NewReplayTransport(cfg.HTTPReplay)
+		if err != nil {
+			slog.Error("Kunne ikke lese opptaket", "error", err)
+			os.Exit(1)
+		}
+		client.HTTP.Transport = replay
+	}
+
 	// Initialiserer fetcher for GitHub API
 	slog.Info("Setter opp fetcher med GitHub API for å hente repositories")
-	getter := fetcher.NewRepoFetcher(cfg)
+	credentials, err := fetcher.CredentialsFromConfig(cfg, client.HTTP.Transport)
+	if err != nil {
+		slog.Error("Kunne ikke sette opp GitHub-autentisering", "error", err)
+		os.Exit(1)
+	}
+	if cfg.HTTPReplay != "" && len(credentials) == 0 {
+		// Opptaket er uten Authorization-headere, så ethvert token holder
+		credentials = []fetcher.Credential{{Name: "replay", Source: fetcher.StaticTokenSource("replay")}}
+	}
+	getter := fetcher.NewRepoFetcherWithClient(cfg, client, fetcher.NewCredentialPool(credentials))
+	if _, err := getter.ProbeServer(processingCtx); err != nil {
+		slog.Warn("Klarte ikke å finne versjonen til GitHub Enterprise Server, fortsetter med alle funksjoner på", "error", err)
+	}
+	// Funksjoner serveren ikke støtter er nå slått av, og kjøringen skal lagre det
+	cfg = getter.Cfg
 
 	app := runner.NewApp(cfg, writer, getter)
+	app.Version = binaryVersion()
 
-	if err := app.Run(processingCtx, shutdownCtx); err != nil {
+	runErr := app.Run(processingCtx, shutdownCtx)
+	// Opptaket lukkes før en eventuell os.Exit, så også feilede kjøringer kan spilles av
+	if recorder != nil {
+		if err := recorder.Close(); err != nil {
+			slog.Warn("Klarte ikke å lukke opptaket", "error", err)
+		}
+	}
+	if err := runErr; err != nil {
 		if errors.Is(err, context.Canceled) && processingCtx.Err() != nil {
 			slog.Error("Applikasjonen ble avbrutt før pågående arbeid ble ferdig", "error", err)
 			os.Exit(1)
@@ -100,3 +181,21 @@ func main() {
 	}
 
 }
+
+// binaryVersion er modulversjonen fra byggeinformasjonen, eller git-commiten
+// når binæren er bygget fra en utsjekket kildekode.
+func binaryVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "ukjent"
+	}
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return info.Main.Version
+}
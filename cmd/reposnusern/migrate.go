@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/jonmartinstorm/reposnusern/internal/dbwriter"
+)
+
+// runMigrate håndterer `reposnusern migrate [up|down N [--force]|version]`.
+// Kommandoen trenger bare POSTGRES_DSN.
+func runMigrate(ctx context.Context, args []string) error {
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		return errors.New("POSTGRES_DSN må være satt for migrering")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return fmt.Errorf("kunne ikke åpne PostgreSQL-database: %w", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	migrator, err := dbwriter.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		slog.Info("Databasen er migrert", "kjørte", applied, "versjon", migrator.Latest())
+
+	case "down":
+		steps := 1
+		for _, arg := range args[1:] {
+			if arg == "--force" {
+				migrator.Force = true
+				continue
+			}
+			steps, err = strconv.Atoi(arg)
+			if err != nil || steps < 1 {
+				return errors.New("antall steg for migrate down må være et positivt heltall")
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		slog.Info("Rullet tilbake migreringer", "antall", reverted)
+
+	case "version":
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		slog.Info("Databaseskjema", "versjon", version, "binæren_kjenner_til", migrator.Latest())
+
+	default:
+		return fmt.Errorf("ukjent migreringskommando %q – bruk up, down [N] [--force] eller version", command)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+// Package db inneholder de versjonerte migreringene for PostgreSQL-skjemaet.
+// Migreringene bygges inn i binæren og kjøres av dbwriter.
+//
+// Hver migrering er et par filer i migrations/: NNNN_navn.up.sql og
+// NNNN_navn.down.sql. sqlc leser up-filene som skjema.
+package db
+
+import "embed"
+
+//go:embed migrations/*.sql
+var Migrations embed.FS
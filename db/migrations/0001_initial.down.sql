@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS sbom_github_packages;
+DROP TABLE IF EXISTS ci_configs;
+DROP TABLE IF EXISTS repo_languages;
+DROP TABLE IF EXISTS dockerfiles;
+DROP TABLE IF EXISTS repos;
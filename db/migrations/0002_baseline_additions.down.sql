@@ -0,0 +1,27 @@
+DROP TABLE IF EXISTS http_cache;
+DROP TABLE IF EXISTS secret_findings;
+DROP TABLE IF EXISTS ci_workflow_calls;
+
+ALTER TABLE ci_configs
+    DROP COLUMN IF EXISTS uses_pnpm_install_without_frozen,
+    DROP COLUMN IF EXISTS uses_bun_install_without_frozen,
+    DROP COLUMN IF EXISTS uses_go_install_latest,
+    DROP COLUMN IF EXISTS uses_gem_install_without_version,
+    DROP COLUMN IF EXISTS uses_apk_add_without_no_cache,
+    DROP COLUMN IF EXISTS uses_apt_get_install_without_no_recommends,
+    DROP COLUMN IF EXISTS uses_poetry_install_without_lock_check,
+    DROP COLUMN IF EXISTS uses_uv_pip_install_without_hashes,
+    DROP COLUMN IF EXISTS uses_git_clone_unpinned;
+
+ALTER TABLE dockerfiles
+    DROP COLUMN IF EXISTS uses_pnpm_install_without_frozen,
+    DROP COLUMN IF EXISTS uses_bun_install_without_frozen,
+    DROP COLUMN IF EXISTS uses_go_install_latest,
+    DROP COLUMN IF EXISTS uses_gem_install_without_version,
+    DROP COLUMN IF EXISTS uses_apk_add_without_no_cache,
+    DROP COLUMN IF EXISTS uses_apt_get_install_without_no_recommends,
+    DROP COLUMN IF EXISTS uses_poetry_install_without_lock_check,
+    DROP COLUMN IF EXISTS uses_uv_pip_install_without_hashes,
+    DROP COLUMN IF EXISTS uses_git_clone_unpinned;
+
+ALTER TABLE repos DROP COLUMN IF EXISTS default_branch;
@@ -0,0 +1,70 @@
+-- Tabeller og kolonner som er lagt til etter skjemaet reposnusern hadde før
+-- migreringene. 0001 er det gamle db/schema.sql, så databaser som ble laget
+-- med det får resten herfra.
+
+ALTER TABLE repos ADD COLUMN IF NOT EXISTS default_branch TEXT NOT NULL DEFAULT '';
+
+-- Package manager antipatterns
+ALTER TABLE dockerfiles
+    ADD COLUMN IF NOT EXISTS uses_pnpm_install_without_frozen BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS uses_bun_install_without_frozen BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS uses_go_install_latest BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS uses_gem_install_without_version BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS uses_apk_add_without_no_cache BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS uses_apt_get_install_without_no_recommends BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS uses_poetry_install_without_lock_check BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS uses_uv_pip_install_without_hashes BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS uses_git_clone_unpinned BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE ci_configs
+    ADD COLUMN IF NOT EXISTS uses_pnpm_install_without_frozen BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS uses_bun_install_without_frozen BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS uses_go_install_latest BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS uses_gem_install_without_version BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS uses_apk_add_without_no_cache BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS uses_apt_get_install_without_no_recommends BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS uses_poetry_install_without_lock_check BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS uses_uv_pip_install_without_hashes BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS uses_git_clone_unpinned BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS ci_workflow_calls (
+    id SERIAL PRIMARY KEY,
+    repo_id BIGINT NOT NULL,
+    hentet_dato DATE NOT NULL,
+
+    caller_path TEXT NOT NULL,
+    job TEXT NOT NULL DEFAULT '',
+    uses TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    target TEXT NOT NULL DEFAULT '',
+    is_local BOOLEAN NOT NULL DEFAULT FALSE,
+    resolved BOOLEAN NOT NULL DEFAULT FALSE,
+
+    UNIQUE (repo_id, hentet_dato, caller_path, job, uses)
+);
+
+-- Funn fra hemmelighetsskanneren. Selve verdien lagres aldri.
+CREATE TABLE IF NOT EXISTS secret_findings (
+    id SERIAL PRIMARY KEY,
+    repo_id BIGINT NOT NULL,
+    hentet_dato DATE NOT NULL,
+
+    path TEXT NOT NULL,
+    line INTEGER NOT NULL,
+    rule TEXT NOT NULL,
+
+    UNIQUE (repo_id, hentet_dato, path, line, rule)
+);
+
+-- HTTP-cache for betingede GitHub-kall. Nøkkelen er URL-en, og ETag/Last-Modified
+-- sendes tilbake som If-None-Match/If-Modified-Since ved neste kjøring.
+CREATE TABLE IF NOT EXISTS http_cache (
+    url TEXT PRIMARY KEY,
+    etag TEXT NOT NULL DEFAULT '',
+    last_modified TEXT NOT NULL DEFAULT '',
+    body BYTEA NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    accessed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS http_cache_accessed_at_idx ON http_cache (accessed_at);
@@ -0,0 +1,30 @@
+-- Én rad per kjøring av reposnusern. Raden skrives når kjøringen starter og
+-- oppdateres når den er ferdig, så dashboards kan skille komplette snapshots
+-- fra delvise.
+CREATE TABLE IF NOT EXISTS snapshot_runs (
+    run_id TEXT PRIMARY KEY,
+    org TEXT NOT NULL,
+    hentet_dato DATE NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ,
+
+    -- running, completed, partial eller failed
+    status TEXT NOT NULL,
+    version TEXT NOT NULL,
+    -- Konfigurasjonen uten hemmeligheter (Config.DebugPrint)
+    config TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+
+    repos_processed BIGINT NOT NULL DEFAULT 0,
+    repos_failed_graphql BIGINT NOT NULL DEFAULT 0,
+    repos_failed_rows BIGINT NOT NULL DEFAULT 0,
+    rows_written BIGINT NOT NULL DEFAULT 0,
+    rows_failed BIGINT NOT NULL DEFAULT 0,
+    api_calls BIGINT NOT NULL DEFAULT 0,
+    rate_limit_waits BIGINT NOT NULL DEFAULT 0,
+    rate_limit_wait_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+    graceful_shutdown BOOLEAN NOT NULL DEFAULT FALSE,
+    failed_repos TEXT[] NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS snapshot_runs_org_started_at_idx ON snapshot_runs (org, started_at DESC);
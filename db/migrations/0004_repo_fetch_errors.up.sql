@@ -0,0 +1,24 @@
+-- Repos som ikke kunne hentes, eller ble hentet med mindre data, i en
+-- kjøring. Uten denne ser et repo som mangler i et snapshot ut som om det er
+-- slettet.
+CREATE TABLE IF NOT EXISTS repo_fetch_errors (
+    id BIGSERIAL PRIMARY KEY,
+    run_id TEXT NOT NULL,
+    hentet_dato DATE NOT NULL,
+    repo_id BIGINT NOT NULL,
+    full_name TEXT NOT NULL,
+
+    -- rate_limit, server_error, network_error, graphql_error, parse_failure,
+    -- tree_truncated, sbom_unavailable eller other
+    error_class TEXT NOT NULL,
+    message TEXT NOT NULL,
+    -- Repoet mangler i snapshotet
+    fatal BOOLEAN NOT NULL,
+    attempts INTEGER NOT NULL,
+    -- Repoet ble hentet ved nytt forsøk på slutten av kjøringen
+    recovered BOOLEAN NOT NULL DEFAULT FALSE,
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS repo_fetch_errors_hentet_dato_idx ON repo_fetch_errors (hentet_dato);
+CREATE INDEX IF NOT EXISTS repo_fetch_errors_run_id_idx ON repo_fetch_errors (run_id);
@@ -0,0 +1,22 @@
+DROP VIEW IF EXISTS ci_configs;
+DROP VIEW IF EXISTS dockerfiles;
+DROP VIEW IF EXISTS repos;
+
+ALTER TABLE ci_config_snapshots ADD COLUMN content TEXT;
+UPDATE ci_config_snapshots c SET content = b.content FROM file_blobs b WHERE b.content_sha256 = c.content_sha256;
+ALTER TABLE ci_config_snapshots ALTER COLUMN content SET NOT NULL;
+ALTER TABLE ci_config_snapshots DROP COLUMN content_sha256;
+ALTER TABLE ci_config_snapshots RENAME TO ci_configs;
+
+ALTER TABLE dockerfile_snapshots ADD COLUMN content TEXT;
+UPDATE dockerfile_snapshots d SET content = b.content FROM file_blobs b WHERE b.content_sha256 = d.content_sha256;
+ALTER TABLE dockerfile_snapshots ALTER COLUMN content SET NOT NULL;
+ALTER TABLE dockerfile_snapshots DROP COLUMN content_sha256;
+ALTER TABLE dockerfile_snapshots RENAME TO dockerfiles;
+
+ALTER TABLE repo_snapshots ADD COLUMN readme_content TEXT;
+UPDATE repo_snapshots r SET readme_content = b.content FROM file_blobs b WHERE b.content_sha256 = r.readme_sha256;
+ALTER TABLE repo_snapshots DROP COLUMN readme_sha256;
+ALTER TABLE repo_snapshots RENAME TO repos;
+
+DROP TABLE IF EXISTS file_blobs;
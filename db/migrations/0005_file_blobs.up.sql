@@ -0,0 +1,95 @@
+-- Innhold som går igjen fra uke til uke (README, Dockerfiles og CI-filer)
+-- lagres én gang i file_blobs, med sha256 av innholdet som nøkkel.
+-- Snapshot-tabellene peker dit, og viewene repos, dockerfiles og ci_configs
+-- har samme kolonner som tabellene hadde før, så eksisterende spørringer
+-- virker som før.
+CREATE TABLE IF NOT EXISTS file_blobs (
+    content_sha256 TEXT PRIMARY KEY,
+    content TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL
+);
+
+INSERT INTO file_blobs (content_sha256, content, size_bytes)
+SELECT encode(sha256(convert_to(content, 'UTF8')), 'hex'), content, octet_length(content)
+FROM (
+    SELECT readme_content AS content FROM repos WHERE readme_content IS NOT NULL
+    UNION
+    SELECT content FROM dockerfiles
+    UNION
+    SELECT content FROM ci_configs
+) AS c
+ON CONFLICT (content_sha256) DO NOTHING;
+
+ALTER TABLE repos RENAME TO repo_snapshots;
+ALTER TABLE repo_snapshots ADD COLUMN readme_sha256 TEXT REFERENCES file_blobs (content_sha256);
+UPDATE repo_snapshots SET readme_sha256 = encode(sha256(convert_to(readme_content, 'UTF8')), 'hex')
+WHERE readme_content IS NOT NULL;
+ALTER TABLE repo_snapshots DROP COLUMN readme_content;
+
+ALTER TABLE dockerfiles RENAME TO dockerfile_snapshots;
+ALTER TABLE dockerfile_snapshots ADD COLUMN content_sha256 TEXT REFERENCES file_blobs (content_sha256);
+UPDATE dockerfile_snapshots SET content_sha256 = encode(sha256(convert_to(content, 'UTF8')), 'hex');
+ALTER TABLE dockerfile_snapshots ALTER COLUMN content_sha256 SET NOT NULL;
+ALTER TABLE dockerfile_snapshots DROP COLUMN content;
+
+ALTER TABLE ci_configs RENAME TO ci_config_snapshots;
+ALTER TABLE ci_config_snapshots ADD COLUMN content_sha256 TEXT REFERENCES file_blobs (content_sha256);
+UPDATE ci_config_snapshots SET content_sha256 = encode(sha256(convert_to(content, 'UTF8')), 'hex');
+ALTER TABLE ci_config_snapshots ALTER COLUMN content_sha256 SET NOT NULL;
+ALTER TABLE ci_config_snapshots DROP COLUMN content;
+
+-- "Når endret denne filen seg" er et oppslag på path og content_sha256
+CREATE INDEX IF NOT EXISTS dockerfile_snapshots_content_sha256_idx ON dockerfile_snapshots (content_sha256);
+CREATE INDEX IF NOT EXISTS ci_config_snapshots_content_sha256_idx ON ci_config_snapshots (content_sha256);
+
+CREATE VIEW repos AS
+SELECT
+    r.id, r.hentet_dato,
+    r.name, r.full_name, r.description, r.stars, r.forks, r.archived, r.private, r.is_fork,
+    r.language, r.size_mb, r.updated_at, r.pushed_at, r.created_at, r.html_url, r.topics,
+    r.visibility, r.license, r.open_issues, r.languages_url,
+    b.content AS readme_content,
+    r.has_security_md, r.has_dependabot, r.has_codeql,
+    r.has_complete_lockfiles, r.lockfile_pairings, r.lockfile_pair_count,
+    r.default_branch
+FROM repo_snapshots r
+LEFT JOIN file_blobs b ON b.content_sha256 = r.readme_sha256;
+
+CREATE VIEW dockerfiles AS
+SELECT
+    d.id, d.repo_id, d.hentet_dato, d.full_name, d.path,
+    b.content,
+    d.base_image, d.base_tag, d.uses_latest_tag,
+    d.has_user_instruction, d.has_copy_sensitive, d.has_package_installs,
+    d.uses_multistage, d.has_healthcheck, d.uses_add_instruction,
+    d.has_label_metadata, d.has_expose, d.has_entrypoint_or_cmd,
+    d.installs_curl_or_wget, d.installs_build_tools, d.has_apt_get_clean,
+    d.world_writable, d.has_secrets_in_env_or_arg,
+    d.uses_npm_install, d.uses_npm_ci_without_ignore_scripts,
+    d.uses_yarn_install_without_frozen, d.uses_npx,
+    d.uses_pip_install_without_no_cache, d.uses_pip_install_without_hashes,
+    d.uses_curl_bash_pipe,
+    d.uses_pnpm_install_without_frozen, d.uses_bun_install_without_frozen,
+    d.uses_go_install_latest, d.uses_gem_install_without_version,
+    d.uses_apk_add_without_no_cache, d.uses_apt_get_install_without_no_recommends,
+    d.uses_poetry_install_without_lock_check, d.uses_uv_pip_install_without_hashes,
+    d.uses_git_clone_unpinned
+FROM dockerfile_snapshots d
+JOIN file_blobs b ON b.content_sha256 = d.content_sha256;
+
+CREATE VIEW ci_configs AS
+SELECT
+    c.id, c.repo_id, c.hentet_dato, c.path,
+    b.content,
+    c.uses_npm_install, c.uses_npm_ci_without_ignore_scripts,
+    c.uses_yarn_install_without_frozen, c.uses_npx,
+    c.uses_pip_install_without_no_cache, c.uses_pip_install_without_hashes,
+    c.uses_curl_bash_pipe, c.uses_sudo, c.uses_package_publish,
+    c.uses_pull_request_target, c.secret_names,
+    c.uses_pnpm_install_without_frozen, c.uses_bun_install_without_frozen,
+    c.uses_go_install_latest, c.uses_gem_install_without_version,
+    c.uses_apk_add_without_no_cache, c.uses_apt_get_install_without_no_recommends,
+    c.uses_poetry_install_without_lock_check, c.uses_uv_pip_install_without_hashes,
+    c.uses_git_clone_unpinned
+FROM ci_config_snapshots c
+JOIN file_blobs b ON b.content_sha256 = c.content_sha256;
@@ -0,0 +1,14 @@
+-- name: InsertOrUpdateCIWorkflowCalls :exec
+INSERT INTO ci_workflow_calls (
+  repo_id, hentet_dato, caller_path, job, uses, kind, target, is_local, resolved
+)
+SELECT @repo_id::BIGINT, @hentet_dato::DATE, c.caller_path, c.job, c.uses, c.kind, c.target, c.is_local, c.resolved
+FROM unnest(
+  @caller_paths::TEXT[], @jobs::TEXT[], @uses::TEXT[], @kinds::TEXT[], @targets::TEXT[],
+  @is_local::BOOLEAN[], @resolved::BOOLEAN[]
+) AS c(caller_path, job, uses, kind, target, is_local, resolved)
+ON CONFLICT (repo_id, hentet_dato, caller_path, job, uses) DO UPDATE SET
+  kind = EXCLUDED.kind,
+  target = EXCLUDED.target,
+  is_local = EXCLUDED.is_local,
+  resolved = EXCLUDED.resolved;
@@ -1,6 +1,6 @@
 -- name: InsertOrUpdateDockerfile :one
-INSERT INTO dockerfiles (
-  repo_id, hentet_dato, full_name, path, content,
+INSERT INTO dockerfile_snapshots (
+  repo_id, hentet_dato, full_name, path, content_sha256,
   base_image, base_tag, uses_latest_tag,
   has_user_instruction, has_copy_sensitive, has_package_installs,
   uses_multistage, has_healthcheck, uses_add_instruction,
@@ -10,7 +10,12 @@ INSERT INTO dockerfiles (
   uses_npm_install, uses_npm_ci_without_ignore_scripts,
   uses_yarn_install_without_frozen, uses_npx,
   uses_pip_install_without_no_cache, uses_pip_install_without_hashes,
-  uses_curl_bash_pipe
+  uses_curl_bash_pipe,
+  uses_pnpm_install_without_frozen, uses_bun_install_without_frozen,
+  uses_go_install_latest, uses_gem_install_without_version,
+  uses_apk_add_without_no_cache, uses_apt_get_install_without_no_recommends,
+  uses_poetry_install_without_lock_check, uses_uv_pip_install_without_hashes,
+  uses_git_clone_unpinned
 )
 VALUES (
   $1, $2, $3, $4, $5,
@@ -22,11 +27,16 @@ VALUES (
   $21, $22,
   $23, $24,
   $25, $26, $27,
-  $28, $29
+  $28, $29,
+  $30, $31,
+  $32, $33,
+  $34, $35,
+  $36, $37,
+  $38
 )
 ON CONFLICT (repo_id, hentet_dato, path) DO UPDATE SET
   full_name = EXCLUDED.full_name,
-  content = EXCLUDED.content,
+  content_sha256 = EXCLUDED.content_sha256,
   base_image = EXCLUDED.base_image,
   base_tag = EXCLUDED.base_tag,
   uses_latest_tag = EXCLUDED.uses_latest_tag,
@@ -50,5 +60,14 @@ ON CONFLICT (repo_id, hentet_dato, path) DO UPDATE SET
   uses_npx = EXCLUDED.uses_npx,
   uses_pip_install_without_no_cache = EXCLUDED.uses_pip_install_without_no_cache,
   uses_pip_install_without_hashes = EXCLUDED.uses_pip_install_without_hashes,
-  uses_curl_bash_pipe = EXCLUDED.uses_curl_bash_pipe
+  uses_curl_bash_pipe = EXCLUDED.uses_curl_bash_pipe,
+  uses_pnpm_install_without_frozen = EXCLUDED.uses_pnpm_install_without_frozen,
+  uses_bun_install_without_frozen = EXCLUDED.uses_bun_install_without_frozen,
+  uses_go_install_latest = EXCLUDED.uses_go_install_latest,
+  uses_gem_install_without_version = EXCLUDED.uses_gem_install_without_version,
+  uses_apk_add_without_no_cache = EXCLUDED.uses_apk_add_without_no_cache,
+  uses_apt_get_install_without_no_recommends = EXCLUDED.uses_apt_get_install_without_no_recommends,
+  uses_poetry_install_without_lock_check = EXCLUDED.uses_poetry_install_without_lock_check,
+  uses_uv_pip_install_without_hashes = EXCLUDED.uses_uv_pip_install_without_hashes,
+  uses_git_clone_unpinned = EXCLUDED.uses_git_clone_unpinned
 RETURNING id;
@@ -0,0 +1,4 @@
+-- name: InsertFileBlob :exec
+INSERT INTO file_blobs (content_sha256, content, size_bytes)
+VALUES ($1, $2, $3)
+ON CONFLICT (content_sha256) DO NOTHING;
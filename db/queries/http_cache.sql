@@ -0,0 +1,28 @@
+-- name: GetHTTPCacheEntry :one
+UPDATE http_cache
+SET accessed_at = now()
+WHERE url = $1
+RETURNING etag, last_modified, body;
+
+-- name: UpsertHTTPCacheEntry :exec
+INSERT INTO http_cache (
+  url, etag, last_modified, body, size_bytes, accessed_at
+) VALUES (
+  $1, $2, $3, $4, $5, now()
+)
+ON CONFLICT (url) DO UPDATE SET
+  etag = EXCLUDED.etag,
+  last_modified = EXCLUDED.last_modified,
+  body = EXCLUDED.body,
+  size_bytes = EXCLUDED.size_bytes,
+  accessed_at = EXCLUDED.accessed_at;
+
+-- name: EvictHTTPCacheEntries :execrows
+DELETE FROM http_cache
+WHERE url IN (
+  SELECT url FROM (
+    SELECT url, SUM(size_bytes) OVER (ORDER BY accessed_at DESC, url) AS running_size
+    FROM http_cache
+  ) ranked
+  WHERE running_size > $1
+);
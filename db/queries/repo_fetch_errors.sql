@@ -0,0 +1,9 @@
+-- name: InsertRepoFetchErrors :exec
+INSERT INTO repo_fetch_errors (
+  run_id, hentet_dato, repo_id, full_name, error_class, message, fatal, attempts, recovered
+)
+SELECT @run_id::TEXT, @hentet_dato::DATE, e.repo_id, e.full_name, e.error_class, e.message, e.fatal, e.attempts, e.recovered
+FROM unnest(
+  @repo_ids::BIGINT[], @full_names::TEXT[], @error_classes::TEXT[], @messages::TEXT[],
+  @fatals::BOOLEAN[], @attempts::INTEGER[], @recovereds::BOOLEAN[]
+) AS e(repo_id, full_name, error_class, message, fatal, attempts, recovered);
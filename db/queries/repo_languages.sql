@@ -1,8 +1,8 @@
--- name: InsertOrUpdateRepoLanguage :exec
+-- name: InsertOrUpdateRepoLanguages :exec
 INSERT INTO repo_languages (
   repo_id, hentet_dato, language, bytes
-) VALUES (
-  $1, $2, $3, $4
 )
+SELECT @repo_id::BIGINT, @hentet_dato::DATE, l.language, l.bytes
+FROM unnest(@languages::TEXT[], @bytes::BIGINT[]) AS l(language, bytes)
 ON CONFLICT (repo_id, hentet_dato, language) DO UPDATE SET
   bytes = EXCLUDED.bytes;
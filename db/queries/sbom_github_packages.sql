@@ -1,9 +1,9 @@
--- name: InsertOrUpdateGithubSBOM :exec
+-- name: InsertOrUpdateGithubSBOMPackages :exec
 INSERT INTO sbom_github_packages (
   repo_id, hentet_dato, name, version, license, purl
-) VALUES (
-  $1, $2, $3, $4, $5, $6
 )
+SELECT @repo_id::BIGINT, @hentet_dato::DATE, p.name, NULLIF(p.version, ''), NULLIF(p.license, ''), NULLIF(p.purl, '')
+FROM unnest(@names::TEXT[], @versions::TEXT[], @licenses::TEXT[], @purls::TEXT[]) AS p(name, version, license, purl)
 ON CONFLICT (repo_id, hentet_dato, name, version) DO UPDATE SET
   license = EXCLUDED.license,
-  purl = EXCLUDED.purl;
\ No newline at end of file
+  purl = EXCLUDED.purl;
@@ -0,0 +1,7 @@
+-- name: InsertSecretFindings :exec
+INSERT INTO secret_findings (
+  repo_id, hentet_dato, path, line, rule
+)
+SELECT @repo_id::BIGINT, @hentet_dato::DATE, f.path, f.line, f.rule
+FROM unnest(@paths::TEXT[], @lines::INTEGER[], @rules::TEXT[]) AS f(path, line, rule)
+ON CONFLICT (repo_id, hentet_dato, path, line, rule) DO NOTHING;
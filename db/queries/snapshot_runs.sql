@@ -0,0 +1,23 @@
+-- name: InsertSnapshotRun :exec
+INSERT INTO snapshot_runs (
+  run_id, org, hentet_dato, started_at, status, version, config
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7
+);
+
+-- name: FinishSnapshotRun :exec
+UPDATE snapshot_runs SET
+  finished_at = $2,
+  status = $3,
+  error = $4,
+  repos_processed = $5,
+  repos_failed_graphql = $6,
+  repos_failed_rows = $7,
+  rows_written = $8,
+  rows_failed = $9,
+  api_calls = $10,
+  rate_limit_waits = $11,
+  rate_limit_wait_seconds = $12,
+  graceful_shutdown = $13,
+  failed_repos = $14
+WHERE run_id = $1;
@@ -4,13 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
+	"log/slog"
 	"time"
 
 	"cloud.google.com/go/bigquery"
 	"github.com/jonmartinstorm/reposnusern/internal/config"
 	"github.com/jonmartinstorm/reposnusern/internal/models"
-	"github.com/jonmartinstorm/reposnusern/internal/parser"
+	"github.com/jonmartinstorm/reposnusern/internal/rows"
 	"google.golang.org/api/googleapi"
 )
 
@@ -18,6 +18,9 @@ type BigQueryWriter struct {
 	Client  *bigquery.Client
 	Dataset string
 	Config  *config.Config
+
+	blobs  blobIndex
+	buffer snapshotBuffer
 }
 
 func NewBigQueryWriter(ctx context.Context, cfg *config.Config) (*BigQueryWriter, error) {
@@ -35,14 +38,23 @@ func NewBigQueryWriter(ctx context.Context, cfg *config.Config) (*BigQueryWriter
 		"dockerfile_features": BGDockerfileFeatures{},
 		"dockerfile_stages":   BGDockerStageMeta{},
 		"ci_config":           BGCIConfig{},
+		"ci_workflow_calls":   BGCIWorkflowCall{},
+		"secret_findings":     BGSecretFinding{},
 		"sbom_packages":       BGSBOMPackages{},
+		"snapshot_runs":       BGSnapshotRun{},
+		"repo_fetch_errors":   BGRepoFetchError{},
+		"file_blobs":          BGFileBlob{},
 	}
 
+	opts := tableOptions{PartitionExpiration: time.Duration(cfg.BQPartitionExpirationDays) * 24 * time.Hour}
 	for tableName, schemaExample := range tables {
-		if err := ensureTableExists(ctx, client, cfg.BQDataset, tableName, schemaExample); err != nil {
+		if err := ensureTableExists(ctx, client, cfg.BQDataset, tableName, schemaExample, opts); err != nil {
 			return nil, fmt.Errorf("kunne ikke sikre tabell %s: %w", tableName, err)
 		}
 	}
+	if err := ensureContentViews(ctx, client, cfg.BQDataset); err != nil {
+		return nil, err
+	}
 
 	return &BigQueryWriter{
 		Client:  client,
@@ -51,157 +63,254 @@ func NewBigQueryWriter(ctx context.Context, cfg *config.Config) (*BigQueryWriter
 	}, nil
 }
 
-func (w *BigQueryWriter) ImportRepo(ctx context.Context, entry models.RepoEntry, snapshot time.Time) error {
-	repo := ConvertToBG(entry, snapshot)
-	langs := ConvertLanguages(entry, snapshot)
-	dockerfileFeatures, dockerfileStages := ConvertDockerfileFeatures(entry, snapshot)
-	ciconfig := ConvertCI(entry, snapshot)
-
-	if err := insert(ctx, w.Client, w.Dataset, "repos", []BGRepoEntry{repo}); err != nil {
-		return fmt.Errorf("repos insert failed: %w", err)
+// ImportRepo legger radene til repoet i bufferet og returnerer hvor mange rader
+// som ble lagt til og feilet per tabell. Feiler repo-raden returneres en feil.
+// Ellers avgjør Config.RowErrors om tabeller som feiler hoppes over, eller om
+// resten av repoet droppes. Radene samles i et eget buffer for repoet og flyttes
+// til snapshotet først når repoet er ferdig, så et droppet repo ikke blir
+// halvveis med i Commit. Ingen rader blir synlige før Commit.
+func (w *BigQueryWriter) ImportRepo(ctx context.Context, entry models.RepoEntry, snapshot time.Time) (models.ImportResult, error) {
+	var (
+		result models.ImportResult
+		repo   snapshotBuffer
+	)
+	snap := rows.FromEntry(entry)
+
+	blobs := w.blobs.claim(ctx, w, ConvertFileBlobs(snap, snapshot))
+	if err := w.bufferRepo(&repo, &result, snap, snapshot, blobs); err != nil {
+		w.blobs.forget(blobs)
+		return dropped(result), err
 	}
-	if err := insert(ctx, w.Client, w.Dataset, "repo_languages", langs); err != nil {
-		return fmt.Errorf("repo_languages insert failed: %w", err)
+	if result.Tables["file_blobs"].Failed > 0 {
+		w.blobs.forget(blobs)
 	}
-	if err := insert(ctx, w.Client, w.Dataset, "dockerfile_features", dockerfileFeatures); err != nil {
-		return fmt.Errorf("dockerfile_features insert failed: %w", err)
+
+	w.buffer.setDay(snapshot)
+	w.buffer.merge(&repo)
+	return result, nil
+}
+
+// bufferRepo legger radene i snap og blobs i buf og teller dem i result.
+func (w *BigQueryWriter) bufferRepo(buf *snapshotBuffer, result *models.ImportResult, snap rows.Snapshot, snapshot time.Time, blobs []BGFileBlob) error {
+	name := snap.Repo.FullName
+	langs := ConvertLanguages(snap, snapshot)
+	dockerfileFeatures, dockerfileStages := ConvertDockerfileFeatures(snap, snapshot)
+	ciconfig := ConvertCI(snap, snapshot)
+	ciCalls := ConvertCIWorkflowCalls(snap, snapshot)
+	secretFindings := ConvertSecretFindings(snap, snapshot)
+	sbom := ConvertSBOMPackages(snap, snapshot)
+
+	// Innholdet legges i file_blobs før radene som peker på det
+	if err := insertRows(w, buf, result, name, "file_blobs", blobs); err != nil {
+		return err
 	}
-	if err := insert(ctx, w.Client, w.Dataset, "dockerfile_stages", dockerfileStages); err != nil {
-		return fmt.Errorf("dockerfile_stagesinsert failed: %w", err)
+
+	if _, err := bufferRows(buf, "repos", []BGRepoEntry{ConvertToBG(snap, snapshot)}); err != nil {
+		result.Add("repos", 0, 1)
+		return fmt.Errorf("repos insert failed: %w", err)
 	}
-	if err := insert(ctx, w.Client, w.Dataset, "ci_config", ciconfig); err != nil {
-		return fmt.Errorf("ci_config insert failed: %w", err)
+	result.Add("repos", 1, 0)
+
+	inserts := []func() error{
+		func() error { return insertRows(w, buf, result, name, "repo_languages", langs) },
+		func() error { return insertRows(w, buf, result, name, "dockerfile_features", dockerfileFeatures) },
+		func() error { return insertRows(w, buf, result, name, "dockerfile_stages", dockerfileStages) },
+		func() error { return insertRows(w, buf, result, name, "ci_config", ciconfig) },
+		func() error { return insertRows(w, buf, result, name, "ci_workflow_calls", ciCalls) },
+		func() error { return insertRows(w, buf, result, name, "secret_findings", secretFindings) },
+		func() error { return insertRows(w, buf, result, name, "sbom_packages", sbom) },
 	}
-	if w.Config.Feature_Sbom {
-		sbom := ConvertSBOMPackages(entry, snapshot)
-		if err := insert(ctx, w.Client, w.Dataset, "sbom_packages", sbom); err != nil {
-			return fmt.Errorf("sbom insert failed: %w", err)
+	for _, write := range inserts {
+		if err := write(); err != nil {
+			return err
 		}
 	}
-
 	return nil
 }
 
-func insert[T any](ctx context.Context, client *bigquery.Client, dataset, table string, rows []T) error {
+// insertRows legger rows i buf under table og teller dem i result. Med
+// RowErrorsFail returneres feilen, ellers logges den og importen fortsetter.
+func insertRows[T any](w *BigQueryWriter, buf *snapshotBuffer, result *models.ImportResult, repo, table string, rows []T) error {
 	if len(rows) == 0 {
 		return nil
 	}
-	inserter := client.Dataset(dataset).Table(table).Inserter()
-	return inserter.Put(ctx, rows)
+	failed, err := bufferRows(buf, table, rows)
+	result.Add(table, len(rows)-failed, failed)
+	if err == nil {
+		return nil
+	}
+	if w.Config.RowErrors == config.RowErrorsFail {
+		return fmt.Errorf("%w: %s insert failed: %w", models.ErrRowsFailed, table, err)
+	}
+	slog.Warn("Kunne ikke skrive rader, hopper over", "repo", repo, "tabell", table, "antall", failed, "error", err)
+	return nil
+}
+
+// dropped teller alle rader i result som feilet, siden repoet ikke blir med i
+// snapshotet.
+func dropped(result models.ImportResult) models.ImportResult {
+	var out models.ImportResult
+	for table, t := range result.Tables {
+		out.Add(table, 0, t.Written+t.Failed)
+	}
+	return out
 }
 
 // ==== Data-strukturer ====
 
 type BGRepoEntry struct {
-	RepoID        int64     `bigquery:"repo_id"`
-	WhenCollected time.Time `bigquery:"when_collected"`
-	Name          string    `bigquery:"name"`
-	FullName      string    `bigquery:"full_name"`
-	Description   string    `bigquery:"description"`
-	Stars         int64     `bigquery:"stars"`
-	Forks         int64     `bigquery:"forks"`
-	Archived      bool      `bigquery:"archived"`
-	Private       bool      `bigquery:"private"`
-	IsFork        bool      `bigquery:"is_fork"`
-	Language      string    `bigquery:"language"`
-	SizeMB        float32   `bigquery:"size_mb"`
-	UpdatedAt     time.Time `bigquery:"updated_at"`
-	PushedAt      time.Time `bigquery:"pushed_at"`
-	CreatedAt     time.Time `bigquery:"created_at"`
-	HtmlUrl       string    `bigquery:"html_url"`
-	Topics        string    `bigquery:"topics"`
-	Visibility    string    `bigquery:"visibility"`
-	License       string    `bigquery:"license"`
-	OpenIssues    int64     `bigquery:"open_issues"`
-	LanguagesUrl  string    `bigquery:"languages_url"`
-	ReadmeContent string    `bigquery:"readme_content"`
-	HasSecurityMD bool      `bigquery:"has_security_md"`
-	HasDependabot bool      `bigquery:"has_dependabot"`
-	HasCodeQL     bool      `bigquery:"has_codeql"`
+	RepoID        int64     `bigquery:"repo_id" description:"GitHub-ID til repoet"`
+	WhenCollected time.Time `bigquery:"when_collected" description:"Tidspunktet snapshotet ble tatt"`
+	Name          string    `bigquery:"name" description:"Navnet på repoet"`
+	FullName      string    `bigquery:"full_name" description:"Fullt navn på repoet, org/repo"`
+	Description   string    `bigquery:"description" description:"Beskrivelsen av repoet på GitHub"`
+	Stars         int64     `bigquery:"stars" description:"Antall stjerner"`
+	Forks         int64     `bigquery:"forks" description:"Antall forks"`
+	Archived      bool      `bigquery:"archived" description:"Om repoet er arkivert"`
+	Private       bool      `bigquery:"private" description:"Om repoet er privat"`
+	IsFork        bool      `bigquery:"is_fork" description:"Om repoet er en fork"`
+	Language      string    `bigquery:"language" description:"Hovedspråket GitHub oppgir for repoet"`
+	SizeMB        float32   `bigquery:"size_mb" description:"Størrelsen på repoet i MB"`
+	UpdatedAt     time.Time `bigquery:"updated_at" description:"Sist repoet ble endret på GitHub"`
+	PushedAt      time.Time `bigquery:"pushed_at" description:"Siste push til repoet"`
+	CreatedAt     time.Time `bigquery:"created_at" description:"Når repoet ble opprettet"`
+	HtmlUrl       string    `bigquery:"html_url" description:"Lenke til repoet på GitHub"`
+	Topics        string    `bigquery:"topics" description:"Topics på repoet, kommaseparert"`
+	Visibility    string    `bigquery:"visibility" description:"Synligheten til repoet: public, private eller internal"`
+	License       string    `bigquery:"license" description:"SPDX-ID for lisensen til repoet"`
+	OpenIssues    int64     `bigquery:"open_issues" description:"Antall åpne issues"`
+	LanguagesUrl  string    `bigquery:"languages_url" description:"API-URL for språkene i repoet"`
+	ReadmeSHA256  string    `bigquery:"readme_sha256" description:"sha256 av README, som ligger i file_blobs. Tom uten README"`
+	HasSecurityMD bool      `bigquery:"has_security_md" description:"Om repoet har SECURITY.md"`
+	HasDependabot bool      `bigquery:"has_dependabot" description:"Om Dependabot er satt opp"`
+	HasCodeQL     bool      `bigquery:"has_codeql" description:"Om CodeQL er satt opp"`
 
 	// Dependency management
-	HasCompleteLockfiles bool   `bigquery:"has_complete_lockfiles"`
-	LockfilePairings     string `bigquery:"lockfile_pairings"`
-	LockfilePairCount    int    `bigquery:"lockfile_pair_count"`
+	HasCompleteLockfiles bool   `bigquery:"has_complete_lockfiles" description:"Om alle pakkemanifester har en lockfil"`
+	LockfilePairings     string `bigquery:"lockfile_pairings" description:"Pakkemanifestene og lockfilene deres, som JSON"`
+	LockfilePairCount    int    `bigquery:"lockfile_pair_count" description:"Antall pakkemanifester med lockfil"`
+
+	DefaultBranch string `bigquery:"default_branch" description:"Standardbranchen til repoet"`
 }
 
 type BGRepoLanguage struct {
-	RepoID        int64     `bigquery:"repo_id"`
-	WhenCollected time.Time `bigquery:"when_collected"`
-	Language      string    `bigquery:"language"`
-	Bytes         int64     `bigquery:"bytes"`
+	RepoID        int64     `bigquery:"repo_id" description:"GitHub-ID til repoet"`
+	WhenCollected time.Time `bigquery:"when_collected" description:"Tidspunktet snapshotet ble tatt"`
+	Language      string    `bigquery:"language" description:"Språket"`
+	Bytes         int64     `bigquery:"bytes" description:"Antall bytes kode i språket"`
 }
 
 type BGDockerfileFeatures struct {
-	RepoID                        int64     `bigquery:"repo_id"`
-	WhenCollected                 time.Time `bigquery:"when_collected"`
-	FileType                      string    `bigquery:"file_type"`
-	Content                       string    `bigquery:"content"`
-	Path                          string    `bigquery:"path"`
-	UsesLatestTag                 bool      `bigquery:"uses_latest_tag"`
-	HasUserInstruction            bool      `bigquery:"has_user_instruction"`
-	HasCopySensitive              bool      `bigquery:"has_copy_sensitive"`
-	HasPackageInstalls            bool      `bigquery:"has_package_installs"`
-	UsesMultistage                bool      `bigquery:"uses_multistage"`
-	HasHealthcheck                bool      `bigquery:"has_healthcheck"`
-	UsesAddInstruction            bool      `bigquery:"uses_add_instruction"`
-	HasLabelMetadata              bool      `bigquery:"has_label_metadata"`
-	HasExpose                     bool      `bigquery:"has_expose"`
-	HasEntrypointOrCmd            bool      `bigquery:"has_entrypoint_or_cmd"`
-	InstallsCurlOrWget            bool      `bigquery:"installs_curl_or_wget"`
-	InstallsBuildTools            bool      `bigquery:"installs_build_tools"`
-	HasAptGetClean                bool      `bigquery:"has_apt_get_clean"`
-	WorldWritable                 bool      `bigquery:"world_writable"`
-	HasSecretsInEnvOrArg          bool      `bigquery:"has_secrets_in_env_or_arg"`
-	UsesNpmInstall                bool      `bigquery:"uses_npm_install"`
-	UsesNpmCiWithoutIgnoreScripts bool      `bigquery:"uses_npm_ci_without_ignore_scripts"`
-	UsesYarnInstallWithoutFrozen  bool      `bigquery:"uses_yarn_install_without_frozen"`
-	UsesNpx                       bool      `bigquery:"uses_npx"`
-	UsesPipInstallWithoutNoCache  bool      `bigquery:"uses_pip_install_without_no_cache"`
-	UsesPipInstallWithoutHashes   bool      `bigquery:"uses_pip_install_without_hashes"`
-	UsesCurlBashPipe              bool      `bigquery:"uses_curl_bash_pipe"`
+	RepoID                               int64     `bigquery:"repo_id" description:"GitHub-ID til repoet"`
+	WhenCollected                        time.Time `bigquery:"when_collected" description:"Tidspunktet snapshotet ble tatt"`
+	FileType                             string    `bigquery:"file_type" description:"Filtypen, f.eks. dockerfile"`
+	ContentSHA256                        string    `bigquery:"content_sha256" description:"sha256 av innholdet, som ligger i file_blobs"`
+	Path                                 string    `bigquery:"path" description:"Stien til Dockerfilen i repoet"`
+	UsesLatestTag                        bool      `bigquery:"uses_latest_tag" description:"Bruker base-image med latest eller uten tag"`
+	HasUserInstruction                   bool      `bigquery:"has_user_instruction" description:"Har USER-instruksjon"`
+	HasCopySensitive                     bool      `bigquery:"has_copy_sensitive" description:"Kopierer sensitive filer, som .ssh eller id_rsa"`
+	HasPackageInstalls                   bool      `bigquery:"has_package_installs" description:"Installerer pakker"`
+	UsesMultistage                       bool      `bigquery:"uses_multistage" description:"Bruker flere byggesteg"`
+	HasHealthcheck                       bool      `bigquery:"has_healthcheck" description:"Har HEALTHCHECK"`
+	UsesAddInstruction                   bool      `bigquery:"uses_add_instruction" description:"Bruker ADD"`
+	HasLabelMetadata                     bool      `bigquery:"has_label_metadata" description:"Har LABEL"`
+	HasExpose                            bool      `bigquery:"has_expose" description:"Har EXPOSE"`
+	HasEntrypointOrCmd                   bool      `bigquery:"has_entrypoint_or_cmd" description:"Har ENTRYPOINT eller CMD"`
+	InstallsCurlOrWget                   bool      `bigquery:"installs_curl_or_wget" description:"Installerer curl eller wget"`
+	InstallsBuildTools                   bool      `bigquery:"installs_build_tools" description:"Installerer byggverktøy"`
+	HasAptGetClean                       bool      `bigquery:"has_apt_get_clean" description:"Rydder apt-cachen"`
+	WorldWritable                        bool      `bigquery:"world_writable" description:"Bruker chmod 777"`
+	HasSecretsInEnvOrArg                 bool      `bigquery:"has_secrets_in_env_or_arg" description:"Har ENV eller ARG med password, token eller secret i seg"`
+	UsesNpmInstall                       bool      `bigquery:"uses_npm_install" description:"Bruker npm install i stedet for npm ci"`
+	UsesNpmCiWithoutIgnoreScripts        bool      `bigquery:"uses_npm_ci_without_ignore_scripts" description:"Bruker npm ci uten --ignore-scripts"`
+	UsesYarnInstallWithoutFrozen         bool      `bigquery:"uses_yarn_install_without_frozen" description:"Bruker yarn install uten låst lockfil"`
+	UsesNpx                              bool      `bigquery:"uses_npx" description:"Bruker npx"`
+	UsesPipInstallWithoutNoCache         bool      `bigquery:"uses_pip_install_without_no_cache" description:"Bruker pip install uten --no-cache-dir"`
+	UsesPipInstallWithoutHashes          bool      `bigquery:"uses_pip_install_without_hashes" description:"Bruker pip install uten --require-hashes"`
+	UsesCurlBashPipe                     bool      `bigquery:"uses_curl_bash_pipe" description:"Sender curl eller wget rett inn i et skall"`
+	UsesPnpmInstallWithoutFrozen         bool      `bigquery:"uses_pnpm_install_without_frozen" description:"Bruker pnpm install uten låst lockfil"`
+	UsesBunInstallWithoutFrozen          bool      `bigquery:"uses_bun_install_without_frozen" description:"Bruker bun install uten låst lockfil"`
+	UsesGoInstallLatest                  bool      `bigquery:"uses_go_install_latest" description:"Bruker go install med @latest"`
+	UsesGemInstallWithoutVersion         bool      `bigquery:"uses_gem_install_without_version" description:"Bruker gem install uten versjon"`
+	UsesApkAddWithoutNoCache             bool      `bigquery:"uses_apk_add_without_no_cache" description:"Bruker apk add uten --no-cache"`
+	UsesAptGetInstallWithoutNoRecommends bool      `bigquery:"uses_apt_get_install_without_no_recommends" description:"Bruker apt-get install uten --no-install-recommends"`
+	UsesPoetryInstallWithoutLockCheck    bool      `bigquery:"uses_poetry_install_without_lock_check" description:"Bruker poetry install uten å sjekke lockfilen"`
+	UsesUvPipInstallWithoutHashes        bool      `bigquery:"uses_uv_pip_install_without_hashes" description:"Bruker uv pip install uten hashes"`
+	UsesGitCloneUnpinned                 bool      `bigquery:"uses_git_clone_unpinned" description:"Bruker git clone uten fast commit"`
 }
 
 type BGDockerStageMeta struct {
-	RepoID        int64     `bigquery:"repo_id"`
-	WhenCollected time.Time `bigquery:"when_collected"`
-	Path          string    `bigquery:"path"`
-	StageIndex    int       `bigquery:"stage_index"`
-	BaseImage     string    `bigquery:"base_image"`
-	BaseTag       string    `bigquery:"base_tag"`
+	RepoID        int64     `bigquery:"repo_id" description:"GitHub-ID til repoet"`
+	WhenCollected time.Time `bigquery:"when_collected" description:"Tidspunktet snapshotet ble tatt"`
+	Path          string    `bigquery:"path" description:"Stien til Dockerfilen i repoet"`
+	StageIndex    int       `bigquery:"stage_index" description:"Nummeret på byggesteget, fra 0"`
+	BaseImage     string    `bigquery:"base_image" description:"Base-imaget i FROM"`
+	BaseTag       string    `bigquery:"base_tag" description:"Taggen på base-imaget"`
 }
 
 type BGCIConfig struct {
-	RepoID                        int64     `bigquery:"repo_id"`
-	WhenCollected                 time.Time `bigquery:"when_collected"`
-	Path                          string    `bigquery:"path"`
-	Content                       string    `bigquery:"content"`
-	UsesNpmInstall                bool      `bigquery:"uses_npm_install"`
-	UsesNpmCiWithoutIgnoreScripts bool      `bigquery:"uses_npm_ci_without_ignore_scripts"`
-	UsesYarnInstallWithoutFrozen  bool      `bigquery:"uses_yarn_install_without_frozen"`
-	UsesNpx                       bool      `bigquery:"uses_npx"`
-	UsesPipInstallWithoutNoCache  bool      `bigquery:"uses_pip_install_without_no_cache"`
-	UsesPipInstallWithoutHashes   bool      `bigquery:"uses_pip_install_without_hashes"`
-	UsesCurlBashPipe              bool      `bigquery:"uses_curl_bash_pipe"`
-	UsesSudo                      bool      `bigquery:"uses_sudo"`
-	UsesPackagePublish            bool      `bigquery:"uses_package_publish"`
-	UsesPullRequestTarget         bool      `bigquery:"uses_pull_request_target"`
-	SecretNames                   []string  `bigquery:"secret_names"`
+	RepoID                               int64     `bigquery:"repo_id" description:"GitHub-ID til repoet"`
+	WhenCollected                        time.Time `bigquery:"when_collected" description:"Tidspunktet snapshotet ble tatt"`
+	Path                                 string    `bigquery:"path" description:"Stien til workflowen i repoet"`
+	ContentSHA256                        string    `bigquery:"content_sha256" description:"sha256 av innholdet, som ligger i file_blobs"`
+	UsesNpmInstall                       bool      `bigquery:"uses_npm_install" description:"Bruker npm install i stedet for npm ci"`
+	UsesNpmCiWithoutIgnoreScripts        bool      `bigquery:"uses_npm_ci_without_ignore_scripts" description:"Bruker npm ci uten --ignore-scripts"`
+	UsesYarnInstallWithoutFrozen         bool      `bigquery:"uses_yarn_install_without_frozen" description:"Bruker yarn install uten låst lockfil"`
+	UsesNpx                              bool      `bigquery:"uses_npx" description:"Bruker npx"`
+	UsesPipInstallWithoutNoCache         bool      `bigquery:"uses_pip_install_without_no_cache" description:"Bruker pip install uten --no-cache-dir"`
+	UsesPipInstallWithoutHashes          bool      `bigquery:"uses_pip_install_without_hashes" description:"Bruker pip install uten --require-hashes"`
+	UsesCurlBashPipe                     bool      `bigquery:"uses_curl_bash_pipe" description:"Sender curl eller wget rett inn i et skall"`
+	UsesSudo                             bool      `bigquery:"uses_sudo" description:"Bruker sudo"`
+	UsesPackagePublish                   bool      `bigquery:"uses_package_publish" description:"Publiserer pakker"`
+	UsesPullRequestTarget                bool      `bigquery:"uses_pull_request_target" description:"Trigges av pull_request_target"`
+	SecretNames                          []string  `bigquery:"secret_names" description:"Navnene på secrets workflowen bruker"`
+	UsesPnpmInstallWithoutFrozen         bool      `bigquery:"uses_pnpm_install_without_frozen" description:"Bruker pnpm install uten låst lockfil"`
+	UsesBunInstallWithoutFrozen          bool      `bigquery:"uses_bun_install_without_frozen" description:"Bruker bun install uten låst lockfil"`
+	UsesGoInstallLatest                  bool      `bigquery:"uses_go_install_latest" description:"Bruker go install med @latest"`
+	UsesGemInstallWithoutVersion         bool      `bigquery:"uses_gem_install_without_version" description:"Bruker gem install uten versjon"`
+	UsesApkAddWithoutNoCache             bool      `bigquery:"uses_apk_add_without_no_cache" description:"Bruker apk add uten --no-cache"`
+	UsesAptGetInstallWithoutNoRecommends bool      `bigquery:"uses_apt_get_install_without_no_recommends" description:"Bruker apt-get install uten --no-install-recommends"`
+	UsesPoetryInstallWithoutLockCheck    bool      `bigquery:"uses_poetry_install_without_lock_check" description:"Bruker poetry install uten å sjekke lockfilen"`
+	UsesUvPipInstallWithoutHashes        bool      `bigquery:"uses_uv_pip_install_without_hashes" description:"Bruker uv pip install uten hashes"`
+	UsesGitCloneUnpinned                 bool      `bigquery:"uses_git_clone_unpinned" description:"Bruker git clone uten fast commit"`
+}
+
+type BGCIWorkflowCall struct {
+	RepoID        int64     `bigquery:"repo_id" description:"GitHub-ID til repoet"`
+	WhenCollected time.Time `bigquery:"when_collected" description:"Tidspunktet snapshotet ble tatt"`
+	CallerPath    string    `bigquery:"caller_path" description:"Workflowen eller actionen som gjør kallet"`
+	Job           string    `bigquery:"job" description:"Jobben kallet står i"`
+	Uses          string    `bigquery:"uses" description:"Verdien i uses"`
+	Kind          string    `bigquery:"kind" description:"Typen kall: workflow eller action"`
+	Target        string    `bigquery:"target" description:"Workflowen eller actionen som kalles"`
+	IsLocal       bool      `bigquery:"is_local" description:"Om målet ligger i samme repo"`
+	Resolved      bool      `bigquery:"resolved" description:"Om målet ble hentet og analysert"`
+}
+
+type BGSecretFinding struct {
+	RepoID        int64     `bigquery:"repo_id" description:"GitHub-ID til repoet"`
+	WhenCollected time.Time `bigquery:"when_collected" description:"Tidspunktet snapshotet ble tatt"`
+	Path          string    `bigquery:"path" description:"Stien til filen med funnet"`
+	Line          int       `bigquery:"line" description:"Linjenummeret til funnet"`
+	Rule          string    `bigquery:"rule" description:"Regelen som slo til. Selve verdien lagres aldri"`
 }
 
 type BGSBOMPackages struct {
-	RepoID        int64     `bigquery:"repo_id"`
-	WhenCollected time.Time `bigquery:"when_collected"`
-	Name          string    `bigquery:"name"`
-	Version       string    `bigquery:"version"`
-	License       string    `bigquery:"license"`
-	PURL          string    `bigquery:"purl"`
+	RepoID        int64     `bigquery:"repo_id" description:"GitHub-ID til repoet"`
+	WhenCollected time.Time `bigquery:"when_collected" description:"Tidspunktet snapshotet ble tatt"`
+	Name          string    `bigquery:"name" description:"Navnet på pakken"`
+	Version       string    `bigquery:"version" description:"Versjonen av pakken"`
+	License       string    `bigquery:"license" description:"Lisensen til pakken"`
+	PURL          string    `bigquery:"purl" description:"Package URL for pakken"`
 }
 
 // ==== Mapping-funksjoner ====
 
-func ConvertToBG(entry models.RepoEntry, snapshot time.Time) BGRepoEntry {
-	r := entry.Repo
+// Radene lages av rows.FromEntry, så BigQuery får de samme radene som
+// PostgreSQL. Her legges bare snapshot-tidspunktet til.
+
+func ConvertToBG(snap rows.Snapshot, snapshot time.Time) BGRepoEntry {
+	r := snap.Repo
 
 	return BGRepoEntry{
 		RepoID:               r.ID,
@@ -215,180 +324,182 @@ func ConvertToBG(entry models.RepoEntry, snapshot time.Time) BGRepoEntry {
 		Private:              r.Private,
 		IsFork:               r.IsFork,
 		Language:             r.Language,
-		SizeMB:               float32(r.Size) / 1024.0,
+		SizeMB:               r.SizeMB,
 		UpdatedAt:            parseTime(r.UpdatedAt),
 		PushedAt:             parseTime(r.PushedAt),
 		CreatedAt:            parseTime(r.CreatedAt),
 		HtmlUrl:              r.HtmlUrl,
-		Topics:               strings.Join(r.Topics, ","),
+		Topics:               r.Topics,
 		Visibility:           r.Visibility,
-		License:              safeLicense(r.License),
+		License:              r.License,
 		OpenIssues:           r.OpenIssues,
 		LanguagesUrl:         r.LanguagesURL,
-		ReadmeContent:        r.Readme,
-		HasSecurityMD:        r.Security["has_security_md"],
-		HasDependabot:        r.Security["has_dependabot"],
-		HasCodeQL:            r.Security["has_codeql"],
+		ReadmeSHA256:         r.ReadmeSHA256,
+		HasSecurityMD:        r.HasSecurityMD,
+		HasDependabot:        r.HasDependabot,
+		HasCodeQL:            r.HasCodeQL,
 		HasCompleteLockfiles: r.HasCompleteLockfiles,
 		LockfilePairings:     marshalToJSONString(r.LockfilePairings),
-		LockfilePairCount:    r.Lockfile_pair_count,
+		LockfilePairCount:    r.LockfilePairCount,
+		DefaultBranch:        r.DefaultBranch,
 	}
 }
 
-func ConvertLanguages(entry models.RepoEntry, snapshot time.Time) []BGRepoLanguage {
+func ConvertLanguages(snap rows.Snapshot, snapshot time.Time) []BGRepoLanguage {
 	var result []BGRepoLanguage
-	for lang, size := range entry.Languages {
+	for _, l := range snap.Languages {
 		result = append(result, BGRepoLanguage{
-			RepoID:        entry.Repo.ID,
+			RepoID:        snap.Repo.ID,
 			WhenCollected: snapshot,
-			Language:      lang,
-			Bytes:         int64(size),
+			Language:      l.Language,
+			Bytes:         l.Bytes,
 		})
 	}
 	return result
 }
 
-func ConvertDockerfileFeatures(entry models.RepoEntry, snapshot time.Time) ([]BGDockerfileFeatures, []BGDockerStageMeta) {
+func ConvertDockerfileFeatures(snap rows.Snapshot, snapshot time.Time) ([]BGDockerfileFeatures, []BGDockerStageMeta) {
 	var dff []BGDockerfileFeatures
 	var dsm []BGDockerStageMeta
 
-	for typ, list := range entry.Files {
-		if !strings.HasPrefix(strings.ToLower(typ), "dockerfile") {
-			continue
-		}
-		for _, f := range list {
-			features, stages := parser.ParseDockerfile(f.Content)
-
-			dff = append(dff, BGDockerfileFeatures{
-				RepoID:                        entry.Repo.ID,
-				WhenCollected:                 snapshot,
-				FileType:                      typ,
-				Path:                          f.Path,
-				Content:                       f.Content,
-				UsesLatestTag:                 features.UsesLatestTag,
-				HasUserInstruction:            features.HasUserInstruction,
-				HasCopySensitive:              features.HasCopySensitive,
-				HasPackageInstalls:            features.HasPackageInstalls,
-				UsesMultistage:                features.UsesMultistage,
-				HasHealthcheck:                features.HasHealthcheck,
-				UsesAddInstruction:            features.UsesAddInstruction,
-				HasLabelMetadata:              features.HasLabelMetadata,
-				HasExpose:                     features.HasExpose,
-				HasEntrypointOrCmd:            features.HasEntrypointOrCmd,
-				InstallsCurlOrWget:            features.InstallsCurlOrWget,
-				InstallsBuildTools:            features.InstallsBuildTools,
-				HasAptGetClean:                features.HasAptGetClean,
-				WorldWritable:                 features.WorldWritable,
-				HasSecretsInEnvOrArg:          features.HasSecretsInEnvOrArg,
-				UsesNpmInstall:                features.UsesNpmInstall,
-				UsesNpmCiWithoutIgnoreScripts: features.UsesNpmCiWithoutIgnoreScripts,
-				UsesYarnInstallWithoutFrozen:  features.UsesYarnInstallWithoutFrozen,
-				UsesNpx:                       features.UsesNpx,
-				UsesPipInstallWithoutNoCache:  features.UsesPipInstallWithoutNoCache,
-				UsesPipInstallWithoutHashes:   features.UsesPipInstallWithoutHashes,
-				UsesCurlBashPipe:              features.UsesCurlBashPipe,
-			})
-
-			for _, stage := range stages {
-				dsm = append(dsm, BGDockerStageMeta{
-					RepoID:        entry.Repo.ID,
-					WhenCollected: snapshot,
-					Path:          f.Path,
-					StageIndex:    stage.StageIndex,
-					BaseImage:     stage.BaseImage,
-					BaseTag:       stage.BaseTag,
-				})
-			}
-		}
+	for _, d := range snap.Dockerfiles {
+		features := d.Features
+		dff = append(dff, BGDockerfileFeatures{
+			RepoID:                               snap.Repo.ID,
+			WhenCollected:                        snapshot,
+			FileType:                             d.FileType,
+			Path:                                 d.Path,
+			ContentSHA256:                        d.ContentSHA256,
+			UsesLatestTag:                        features.UsesLatestTag,
+			HasUserInstruction:                   features.HasUserInstruction,
+			HasCopySensitive:                     features.HasCopySensitive,
+			HasPackageInstalls:                   features.HasPackageInstalls,
+			UsesMultistage:                       features.UsesMultistage,
+			HasHealthcheck:                       features.HasHealthcheck,
+			UsesAddInstruction:                   features.UsesAddInstruction,
+			HasLabelMetadata:                     features.HasLabelMetadata,
+			HasExpose:                            features.HasExpose,
+			HasEntrypointOrCmd:                   features.HasEntrypointOrCmd,
+			InstallsCurlOrWget:                   features.InstallsCurlOrWget,
+			InstallsBuildTools:                   features.InstallsBuildTools,
+			HasAptGetClean:                       features.HasAptGetClean,
+			WorldWritable:                        features.WorldWritable,
+			HasSecretsInEnvOrArg:                 features.HasSecretsInEnvOrArg,
+			UsesNpmInstall:                       features.UsesNpmInstall,
+			UsesNpmCiWithoutIgnoreScripts:        features.UsesNpmCiWithoutIgnoreScripts,
+			UsesYarnInstallWithoutFrozen:         features.UsesYarnInstallWithoutFrozen,
+			UsesNpx:                              features.UsesNpx,
+			UsesPipInstallWithoutNoCache:         features.UsesPipInstallWithoutNoCache,
+			UsesPipInstallWithoutHashes:          features.UsesPipInstallWithoutHashes,
+			UsesCurlBashPipe:                     features.UsesCurlBashPipe,
+			UsesPnpmInstallWithoutFrozen:         features.UsesPnpmInstallWithoutFrozen,
+			UsesBunInstallWithoutFrozen:          features.UsesBunInstallWithoutFrozen,
+			UsesGoInstallLatest:                  features.UsesGoInstallLatest,
+			UsesGemInstallWithoutVersion:         features.UsesGemInstallWithoutVersion,
+			UsesApkAddWithoutNoCache:             features.UsesApkAddWithoutNoCache,
+			UsesAptGetInstallWithoutNoRecommends: features.UsesAptGetInstallWithoutNoRecommends,
+			UsesPoetryInstallWithoutLockCheck:    features.UsesPoetryInstallWithoutLockCheck,
+			UsesUvPipInstallWithoutHashes:        features.UsesUvPipInstallWithoutHashes,
+			UsesGitCloneUnpinned:                 features.UsesGitCloneUnpinned,
+		})
+	}
+	for _, stage := range snap.DockerStages {
+		dsm = append(dsm, BGDockerStageMeta{
+			RepoID:        snap.Repo.ID,
+			WhenCollected: snapshot,
+			Path:          stage.Path,
+			StageIndex:    stage.StageIndex,
+			BaseImage:     stage.BaseImage,
+			BaseTag:       stage.BaseTag,
+		})
 	}
 
 	return dff, dsm
 }
 
-func ConvertCI(entry models.RepoEntry, snapshot time.Time) []BGCIConfig {
+func ConvertCI(snap rows.Snapshot, snapshot time.Time) []BGCIConfig {
 	var result []BGCIConfig
-	for _, f := range entry.CIConfig {
-		features := parser.ParseCIConfig(f.Content)
+	for _, c := range snap.CIConfigs {
+		features := c.Features
 		result = append(result, BGCIConfig{
-			RepoID:                        entry.Repo.ID,
-			WhenCollected:                 snapshot,
-			Path:                          f.Path,
-			Content:                       f.Content,
-			UsesNpmInstall:                features.UsesNpmInstall,
-			UsesNpmCiWithoutIgnoreScripts: features.UsesNpmCiWithoutIgnoreScripts,
-			UsesYarnInstallWithoutFrozen:  features.UsesYarnInstallWithoutFrozen,
-			UsesNpx:                       features.UsesNpx,
-			UsesPipInstallWithoutNoCache:  features.UsesPipInstallWithoutNoCache,
-			UsesPipInstallWithoutHashes:   features.UsesPipInstallWithoutHashes,
-			UsesCurlBashPipe:              features.UsesCurlBashPipe,
-			UsesSudo:                      features.UsesSudo,
-			UsesPackagePublish:            features.UsesPackagePublish,
-			UsesPullRequestTarget:         features.UsesPullRequestTarget,
-			SecretNames:                   features.SecretNames,
+			RepoID:                               snap.Repo.ID,
+			WhenCollected:                        snapshot,
+			Path:                                 c.Path,
+			ContentSHA256:                        c.ContentSHA256,
+			UsesNpmInstall:                       features.UsesNpmInstall,
+			UsesNpmCiWithoutIgnoreScripts:        features.UsesNpmCiWithoutIgnoreScripts,
+			UsesYarnInstallWithoutFrozen:         features.UsesYarnInstallWithoutFrozen,
+			UsesNpx:                              features.UsesNpx,
+			UsesPipInstallWithoutNoCache:         features.UsesPipInstallWithoutNoCache,
+			UsesPipInstallWithoutHashes:          features.UsesPipInstallWithoutHashes,
+			UsesCurlBashPipe:                     features.UsesCurlBashPipe,
+			UsesSudo:                             features.UsesSudo,
+			UsesPackagePublish:                   features.UsesPackagePublish,
+			UsesPullRequestTarget:                features.UsesPullRequestTarget,
+			SecretNames:                          features.SecretNames,
+			UsesPnpmInstallWithoutFrozen:         features.UsesPnpmInstallWithoutFrozen,
+			UsesBunInstallWithoutFrozen:          features.UsesBunInstallWithoutFrozen,
+			UsesGoInstallLatest:                  features.UsesGoInstallLatest,
+			UsesGemInstallWithoutVersion:         features.UsesGemInstallWithoutVersion,
+			UsesApkAddWithoutNoCache:             features.UsesApkAddWithoutNoCache,
+			UsesAptGetInstallWithoutNoRecommends: features.UsesAptGetInstallWithoutNoRecommends,
+			UsesPoetryInstallWithoutLockCheck:    features.UsesPoetryInstallWithoutLockCheck,
+			UsesUvPipInstallWithoutHashes:        features.UsesUvPipInstallWithoutHashes,
+			UsesGitCloneUnpinned:                 features.UsesGitCloneUnpinned,
 		})
 	}
 	return result
 }
 
-func ConvertSBOMPackages(entry models.RepoEntry, snapshot time.Time) []BGSBOMPackages {
-	raw := entry.SBOM
-	var result []BGSBOMPackages
-
-	sbomInner, ok := raw["sbom"].(map[string]interface{})
-	if !ok {
-		return result
-	}
-	pkgs, ok := sbomInner["packages"].([]interface{})
-	if !ok {
-		return result
-	}
-
-	for _, p := range pkgs {
-		pkg, ok := p.(map[string]interface{})
-		if !ok {
-			continue
-		}
-		result = append(result, BGSBOMPackages{
-			RepoID:        entry.Repo.ID,
+func ConvertCIWorkflowCalls(snap rows.Snapshot, snapshot time.Time) []BGCIWorkflowCall {
+	var result []BGCIWorkflowCall
+	for _, c := range snap.WorkflowCalls {
+		result = append(result, BGCIWorkflowCall{
+			RepoID:        snap.Repo.ID,
 			WhenCollected: snapshot,
-			Name:          safeString(pkg["name"]),
-			Version:       safeString(pkg["versionInfo"]),
-			License:       safeString(pkg["licenseConcluded"]),
-			PURL:          extractPURL(pkg),
+			CallerPath:    c.Caller,
+			Job:           c.Job,
+			Uses:          c.Uses,
+			Kind:          c.Kind,
+			Target:        c.Target,
+			IsLocal:       c.Local,
+			Resolved:      c.Resolved,
 		})
 	}
-
 	return result
 }
 
-// ==== Hjelpefunksjoner ====
-
-func safeLicense(lic *models.License) string {
-	if lic == nil {
-		return ""
+func ConvertSecretFindings(snap rows.Snapshot, snapshot time.Time) []BGSecretFinding {
+	var result []BGSecretFinding
+	for _, f := range snap.SecretFindings {
+		result = append(result, BGSecretFinding{
+			RepoID:        snap.Repo.ID,
+			WhenCollected: snapshot,
+			Path:          f.Path,
+			Line:          f.Line,
+			Rule:          f.Rule,
+		})
 	}
-	return lic.SpdxID
-}
-
-func safeString(v interface{}) string {
-	s, _ := v.(string)
-	return s
+	return result
 }
 
-func extractPURL(pkg map[string]interface{}) string {
-	refs, ok := pkg["externalRefs"].([]interface{})
-	if !ok {
-		return ""
-	}
-	for _, ref := range refs {
-		refMap, ok := ref.(map[string]interface{})
-		if ok && refMap["referenceType"] == "purl" {
-			return safeString(refMap["referenceLocator"])
-		}
+func ConvertSBOMPackages(snap rows.Snapshot, snapshot time.Time) []BGSBOMPackages {
+	var result []BGSBOMPackages
+	for _, p := range snap.SBOMPackages {
+		result = append(result, BGSBOMPackages{
+			RepoID:        snap.Repo.ID,
+			WhenCollected: snapshot,
+			Name:          p.Name,
+			Version:       p.Version,
+			License:       p.License,
+			PURL:          p.PURL,
+		})
 	}
-	return ""
+	return result
 }
 
+// ==== Hjelpefunksjoner ====
+
 func parseTime(value string) time.Time {
 	t, _ := time.Parse(time.RFC3339, value)
 	return t
@@ -405,40 +516,75 @@ func marshalToJSONString(v interface{}) string {
 	return string(jsonBytes)
 }
 
-func ensureTableExists(ctx context.Context, client *bigquery.Client, dataset, table string, exampleStruct any) error {
+// ensureTableExists oppretter tabellen, eller oppdaterer schema, klynging og
+// partisjoner på en som finnes. Tabeller med when_collected som ble laget uten
+// partisjoner flyttes til en partisjonert tabell.
+func ensureTableExists(ctx context.Context, client *bigquery.Client, dataset, table string, exampleStruct any, opts tableOptions) error {
 	tbl := client.Dataset(dataset).Table(table)
 
-	schema, err := bigquery.InferSchema(exampleStruct)
+	schema, err := inferSchema(exampleStruct)
 	if err != nil {
 		return fmt.Errorf("klarte ikke å generere schema for %s: %w", table, err)
 	}
+	partitioning := timePartitioning(table, schema, opts)
+	clust := clustering(schema)
 
 	md, err := tbl.Metadata(ctx)
 	if err == nil {
-		return syncSchema(ctx, tbl, md, schema)
+		if partitioning != nil && md.TimePartitioning == nil {
+			if err := migrateToPartitioned(ctx, client, dataset, table, partitioning, clust); err != nil {
+				return err
+			}
+			if md, err = tbl.Metadata(ctx); err != nil {
+				return fmt.Errorf("feil ved henting av tabell-metadata: %w", err)
+			}
+		}
+		if err := syncSchema(ctx, tbl, md, schema); err != nil {
+			return err
+		}
+		return syncTableOptions(ctx, tbl, md, partitioning, clust)
 	}
 
 	if gErr, ok := err.(*googleapi.Error); !ok || gErr.Code != 404 {
 		return fmt.Errorf("feil ved henting av tabell-metadata: %w", err)
 	}
 
-	if err := tbl.Create(ctx, &bigquery.TableMetadata{Schema: schema}); err != nil {
+	md = &bigquery.TableMetadata{Schema: schema, TimePartitioning: partitioning, Clustering: clust}
+	if err := tbl.Create(ctx, md); err != nil {
 		return fmt.Errorf("klarte ikke å opprette tabell %s: %w", table, err)
 	}
 
 	return nil
 }
 
-// syncSchema legger til manglende kolonner i tabellen basert på ønsket schema.
+// syncSchema legger til manglende kolonner i tabellen basert på ønsket schema,
+// og oppdaterer beskrivelsene. Påkrevde kolonner som ikke lenger er i ønsket
+// schema gjøres valgfrie, så nye rader kan skrives uten dem.
 func syncSchema(ctx context.Context, tbl *bigquery.Table, md *bigquery.TableMetadata, desired bigquery.Schema) error {
 	existing := make(map[string]bool)
 	for _, f := range md.Schema {
 		existing[f.Name] = true
 	}
+	wanted := make(map[string]*bigquery.FieldSchema)
+	for _, f := range desired {
+		wanted[f.Name] = f
+	}
 
 	var merged bigquery.Schema
-	merged = append(merged, md.Schema...)
 	changed := false
+	for _, f := range md.Schema {
+		want, ok := wanted[f.Name]
+		if (f.Required && !ok) || (ok && f.Description != want.Description) {
+			updated := *f
+			updated.Required = f.Required && ok
+			if ok {
+				updated.Description = want.Description
+			}
+			f = &updated
+			changed = true
+		}
+		merged = append(merged, f)
+	}
 	for _, f := range desired {
 		if !existing[f.Name] {
 			f.Required = false
@@ -0,0 +1,51 @@
+package bqwriter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+)
+
+// BGRepoFetchError er ett repo som feilet, eller ble hentet med mindre data, i
+// en kjøring.
+type BGRepoFetchError struct {
+	RunID         string    `bigquery:"run_id" description:"ID til kjøringen, se snapshot_runs"`
+	RepoID        int64     `bigquery:"repo_id" description:"GitHub-ID til repoet"`
+	WhenCollected time.Time `bigquery:"when_collected" description:"Tidspunktet snapshotet ble tatt"`
+	FullName      string    `bigquery:"full_name" description:"Fullt navn på repoet, org/repo"`
+	ErrorClass    string    `bigquery:"error_class" description:"Feilklassen, f.eks. rate_limit eller tree_truncated"`
+	Message       string    `bigquery:"message" description:"Feilmeldingen"`
+	Fatal         bool      `bigquery:"fatal" description:"Om repoet mangler i snapshotet"`
+	Attempts      int       `bigquery:"attempts" description:"Antall forsøk på å hente repoet"`
+	Recovered     bool      `bigquery:"recovered" description:"Om repoet ble hentet ved nytt forsøk"`
+}
+
+func ConvertRepoFetchErrors(runID string, fetchErrors []models.RepoFetchError, snapshot time.Time) []BGRepoFetchError {
+	var result []BGRepoFetchError
+	for _, fe := range fetchErrors {
+		result = append(result, BGRepoFetchError{
+			RunID:         runID,
+			RepoID:        fe.Repo.ID,
+			WhenCollected: snapshot,
+			FullName:      fe.Repo.FullName,
+			ErrorClass:    string(fe.Class),
+			Message:       fe.Message,
+			Fatal:         fe.Fatal,
+			Attempts:      fe.Attempts,
+			Recovered:     fe.Recovered,
+		})
+	}
+	return result
+}
+
+// RecordFetchErrors laster hentefeilene fra kjøringen inn i repo_fetch_errors
+// med en egen load-jobb, så de lagres også når snapshotet ikke blir synlig.
+func (w *BigQueryWriter) RecordFetchErrors(ctx context.Context, runID string, snapshot time.Time, fetchErrors []models.RepoFetchError) error {
+	rows := ConvertRepoFetchErrors(runID, fetchErrors, snapshot)
+	if err := appendRows(ctx, w, "repo_fetch_errors", rows); err != nil {
+		return fmt.Errorf("repo_fetch_errors insert failed: %w", err)
+	}
+	return nil
+}
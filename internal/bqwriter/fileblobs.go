@@ -0,0 +1,168 @@
+package bqwriter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/jonmartinstorm/reposnusern/internal/rows"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// BGFileBlob er innhold som lagres én gang og pekes på med sha256 fra
+// repos, dockerfile_features og ci_config. WhenCollected er snapshotet
+// innholdet først ble skrevet i.
+type BGFileBlob struct {
+	ContentSHA256 string    `bigquery:"content_sha256" description:"sha256 av innholdet"`
+	WhenCollected time.Time `bigquery:"when_collected" description:"Snapshotet innholdet først ble skrevet i"`
+	Content       string    `bigquery:"content" description:"Innholdet i filen"`
+	SizeBytes     int64     `bigquery:"size_bytes" description:"Størrelsen på innholdet i bytes"`
+}
+
+func ConvertFileBlobs(snap rows.Snapshot, snapshot time.Time) []BGFileBlob {
+	var result []BGFileBlob
+	for _, b := range snap.Blobs {
+		result = append(result, BGFileBlob{
+			ContentSHA256: b.SHA256,
+			WhenCollected: snapshot,
+			Content:       b.Content,
+			SizeBytes:     int64(len(b.Content)),
+		})
+	}
+	return result
+}
+
+// blobIndex holder styr på hvilke blobs som finnes i file_blobs, så samme
+// innhold bare skrives én gang. Den fylles fra tabellen ved første bruk.
+type blobIndex struct {
+	once  sync.Once
+	mu    sync.Mutex
+	known map[string]bool
+}
+
+// claim returnerer blobene som ikke er skrevet fra før, og merker dem som
+// skrevet så parallelle repos med samme innhold ikke skriver dem igjen.
+func (idx *blobIndex) claim(ctx context.Context, w *BigQueryWriter, blobs []BGFileBlob) []BGFileBlob {
+	idx.once.Do(func() { idx.known = w.loadBlobHashes(ctx) })
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return slices.DeleteFunc(blobs, func(b BGFileBlob) bool {
+		if idx.known[b.ContentSHA256] {
+			return true
+		}
+		idx.known[b.ContentSHA256] = true
+		return false
+	})
+}
+
+// forget fjerner blobs som ikke ble skrevet, så neste repo med samme innhold
+// prøver igjen.
+func (idx *blobIndex) forget(blobs []BGFileBlob) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, b := range blobs {
+		delete(idx.known, b.ContentSHA256)
+	}
+}
+
+// loadBlobHashes leser hashene som allerede ligger i file_blobs. Feiler det
+// skrives innholdet på nytt; viewene tåler duplikater.
+func (w *BigQueryWriter) loadBlobHashes(ctx context.Context) map[string]bool {
+	known := map[string]bool{}
+	if w.Client == nil {
+		// Uten klient er det ingen tabell å lese fra, og alt innhold skrives.
+		return known
+	}
+	q := w.Client.Query(fmt.Sprintf("SELECT DISTINCT content_sha256 FROM `%s.%s.file_blobs`", w.Client.Project(), w.Dataset))
+	it, err := q.Read(ctx)
+	if err != nil {
+		slog.Warn("Kunne ikke lese eksisterende blobs, skriver alt innhold", "error", err)
+		return known
+	}
+	for {
+		var row struct {
+			ContentSHA256 string `bigquery:"content_sha256"`
+		}
+		err := it.Next(&row)
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			slog.Warn("Kunne ikke lese eksisterende blobs, skriver alt innhold", "error", err)
+			return map[string]bool{}
+		}
+		known[row.ContentSHA256] = true
+	}
+	slog.Info("Leste eksisterende blobs", "antall", len(known))
+	return known
+}
+
+// contentView er et view som setter innholdet fra file_blobs tilbake i en
+// tabell som bare har hashen, så den får samme form som før.
+type contentView struct {
+	View          string
+	Table         string
+	HashColumn    string
+	ContentColumn string
+}
+
+var contentViews = []contentView{
+	{View: "repos_with_content", Table: "repos", HashColumn: "readme_sha256", ContentColumn: "readme_content"},
+	{View: "dockerfile_features_with_content", Table: "dockerfile_features", HashColumn: "content_sha256", ContentColumn: "content"},
+	{View: "ci_config_with_content", Table: "ci_config", HashColumn: "content_sha256", ContentColumn: "content"},
+}
+
+// Query bygger spørringen bak viewet. Tabeller laget før file_blobs har
+// fortsatt innholdskolonnen, og radene derfra har innholdet der.
+func (v contentView) Query(project, dataset string, legacyContent bool) string {
+	blobs := fmt.Sprintf("SELECT content_sha256, ANY_VALUE(content) AS content FROM `%s.%s.file_blobs` GROUP BY content_sha256", project, dataset)
+	except, content := v.HashColumn, "b.content"
+	if legacyContent {
+		except = v.HashColumn + ", " + v.ContentColumn
+		content = fmt.Sprintf("COALESCE(b.content, t.%s)", v.ContentColumn)
+	}
+	return fmt.Sprintf(
+		"SELECT t.* EXCEPT (%s), %s AS %s FROM `%s.%s.%s` t LEFT JOIN (%s) b ON b.content_sha256 = t.%s",
+		except, content, v.ContentColumn, project, dataset, v.Table, blobs, v.HashColumn)
+}
+
+// ensureContentViews oppretter viewene, eller oppdaterer spørringen hvis den
+// har endret seg. Tabellene må finnes først.
+func ensureContentViews(ctx context.Context, client *bigquery.Client, dataset string) error {
+	for _, v := range contentViews {
+		md, err := client.Dataset(dataset).Table(v.Table).Metadata(ctx)
+		if err != nil {
+			return fmt.Errorf("feil ved henting av tabell-metadata for %s: %w", v.Table, err)
+		}
+		legacy := slices.ContainsFunc(md.Schema, func(f *bigquery.FieldSchema) bool { return f.Name == v.ContentColumn })
+		if err := ensureViewExists(ctx, client, dataset, v.View, v.Query(client.Project(), dataset, legacy)); err != nil {
+			return fmt.Errorf("kunne ikke sikre view %s: %w", v.View, err)
+		}
+	}
+	return nil
+}
+
+func ensureViewExists(ctx context.Context, client *bigquery.Client, dataset, view, query string) error {
+	tbl := client.Dataset(dataset).Table(view)
+
+	md, err := tbl.Metadata(ctx)
+	if err == nil {
+		if md.ViewQuery == query {
+			return nil
+		}
+		_, err := tbl.Update(ctx, bigquery.TableMetadataToUpdate{ViewQuery: query}, md.ETag)
+		return err
+	}
+
+	if gErr, ok := err.(*googleapi.Error); !ok || gErr.Code != 404 {
+		return fmt.Errorf("feil ved henting av view-metadata: %w", err)
+	}
+	return tbl.Create(ctx, &bigquery.TableMetadata{ViewQuery: query})
+}
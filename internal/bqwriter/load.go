@@ -0,0 +1,309 @@
+package bqwriter
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// stagingExpiration er hvor lenge en staging-tabell blir liggende hvis
+// kjøringen stopper før den er ryddet bort.
+const stagingExpiration = 24 * time.Hour
+
+// tableBuffer holder radene til én tabell som NDJSON til de lastes inn.
+type tableBuffer struct {
+	schema bigquery.Schema
+	rows   int
+	data   bytes.Buffer
+}
+
+// snapshotTables er tabellene der et repo har ett sett rader per dag. En ny
+// kjøring samme dag erstatter radene til repoene den skrev.
+var snapshotTables = []string{
+	"repos",
+	"repo_languages",
+	"dockerfile_features",
+	"dockerfile_stages",
+	"ci_config",
+	"ci_workflow_calls",
+	"secret_findings",
+	"sbom_packages",
+}
+
+// snapshotBuffer holder radene i snapshotet til Commit laster dem inn.
+type snapshotBuffer struct {
+	mu     sync.Mutex
+	tables map[string]*tableBuffer
+	// day er datoen radene gjelder, slik den står i when_collected.
+	day string
+}
+
+// setDay husker datoen til snapshotet, så Commit vet hvilken dag som erstattes.
+func (b *snapshotBuffer) setDay(snapshot time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.day = snapshot.UTC().Format(time.DateOnly)
+}
+
+// appendRows laster rows rett inn i table med en egen load-jobb, utenom
+// snapshotet.
+func appendRows[T any](ctx context.Context, w *BigQueryWriter, table string, rows []T) error {
+	var b snapshotBuffer
+	if _, err := bufferRows(&b, table, rows); err != nil {
+		return err
+	}
+	tables, _ := b.take()
+	if tables[table] == nil {
+		return nil
+	}
+	return load(ctx, w.Client.Dataset(w.Dataset).Table(table), tables[table], bigquery.WriteAppend)
+}
+
+// merge flytter radene i other over i b.
+func (b *snapshotBuffer) merge(other *snapshotBuffer) {
+	tables, _ := other.take()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tables == nil {
+		b.tables = map[string]*tableBuffer{}
+	}
+	for table, buf := range tables {
+		existing, ok := b.tables[table]
+		if !ok {
+			b.tables[table] = buf
+			continue
+		}
+		existing.rows += buf.rows
+		existing.data.Write(buf.data.Bytes())
+	}
+}
+
+// take tømmer bufferet og returnerer tabellene som har rader og datoen.
+func (b *snapshotBuffer) take() (map[string]*tableBuffer, string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tables := b.tables
+	b.tables = nil
+	return tables, b.day
+}
+
+// BufferedRows returnerer radene som ligger i bufferet til table, som NDJSON
+// slik Commit laster dem inn. Bufferet tømmes ikke.
+func (w *BigQueryWriter) BufferedRows(table string) []byte {
+	w.buffer.mu.Lock()
+	defer w.buffer.mu.Unlock()
+	buf, ok := w.buffer.tables[table]
+	if !ok {
+		return nil
+	}
+	return bytes.Clone(buf.data.Bytes())
+}
+
+// bufferRows legger rows i bufferet til table. Rader som ikke kan gjøres om
+// til JSON telles som feilet og hoppes over.
+func bufferRows[T any](b *snapshotBuffer, table string, rows []T) (int, error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	var zero T
+	schema, err := bigquery.InferSchema(zero)
+	if err != nil {
+		return len(rows), err
+	}
+
+	var (
+		encoded bytes.Buffer
+		errs    []error
+	)
+	for _, row := range rows {
+		line, err := encode(row, schema)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		encoded.Write(line)
+		encoded.WriteByte('\n')
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tables == nil {
+		b.tables = map[string]*tableBuffer{}
+	}
+	buf, ok := b.tables[table]
+	if !ok {
+		buf = &tableBuffer{schema: schema}
+		b.tables[table] = buf
+	}
+	buf.rows += len(rows) - len(errs)
+	buf.data.Write(encoded.Bytes())
+	return len(errs), errors.Join(errs...)
+}
+
+// encode er funksjonen bufferRows gjør rader om til JSON med. Tester bytter den
+// ut for å få rader til å feile.
+var encode = encodeRow
+
+// encodeRow gjør row om til én linje JSON med kolonnenavnene fra schema. Tomme
+// lister utelates, siden load-jobber ikke godtar NULL i REPEATED-kolonner.
+func encodeRow(row any, schema bigquery.Schema) ([]byte, error) {
+	values, _, err := (&bigquery.StructSaver{Struct: row, Schema: schema}).Save()
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range values {
+		switch t := v.(type) {
+		case time.Time:
+			values[name] = formatTimestamp(t)
+		case bigquery.NullTimestamp:
+			if t.Valid {
+				values[name] = formatTimestamp(t.Timestamp)
+			} else {
+				delete(values, name)
+			}
+		default:
+			if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.IsNil() {
+				delete(values, name)
+			}
+		}
+	}
+	return json.Marshal(values)
+}
+
+// formatTimestamp skriver t med mikrosekunder, som er det BigQuery tar imot.
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.000000") + " UTC"
+}
+
+// Commit laster radene i snapshotet inn i staging-tabeller og flytter dem til
+// tabellene i én transaksjon, så hele snapshotet blir synlig samtidig. Feiler
+// noe, blir ingen av radene synlige. Radene repoene hadde fra før samme dag
+// erstattes, så en ny kjøring samme dag ikke gir duplikater.
+func (w *BigQueryWriter) Commit(ctx context.Context) error {
+	tables, day := w.buffer.take()
+	if len(tables) == 0 {
+		return nil
+	}
+	names := slices.Sorted(maps.Keys(tables))
+	suffix := strings.ToLower(rand.Text())
+
+	var staged []string
+	defer func() { w.dropStaging(ctx, staged) }()
+
+	var total int
+	for _, table := range names {
+		staging := stagingTable(table, suffix)
+		staged = append(staged, staging)
+		if err := w.loadStaging(ctx, staging, tables[table]); err != nil {
+			return fmt.Errorf("kunne ikke laste %s: %w", table, err)
+		}
+		total += tables[table].rows
+	}
+
+	schemas := map[string]bigquery.Schema{}
+	for table, buf := range tables {
+		schemas[table] = buf.schema
+	}
+	q := w.Client.Query(commitQuery(w.Client.Project(), w.Dataset, suffix, day, schemas))
+	if err := runJob(ctx, q.Run); err != nil {
+		return fmt.Errorf("kunne ikke flytte snapshotet fra staging: %w", err)
+	}
+	slog.Info("Snapshot skrevet til BigQuery", "tabeller", len(names), "rader", total)
+	return nil
+}
+
+func stagingTable(table, suffix string) string {
+	return table + "_staging_" + suffix
+}
+
+// commitQuery bygger transaksjonen som flytter radene fra staging-tabellene.
+// Først slettes radene repoene i snapshotet allerede har for dagen, i alle
+// snapshotTables, så også rader som ikke finnes lenger forsvinner. Blobs som
+// allerede ligger i file_blobs hoppes over. Kolonnene listes opp, så tabeller
+// med gamle kolonner får NULL i dem.
+func commitQuery(project, dataset, suffix, day string, schemas map[string]bigquery.Schema) string {
+	ref := func(table string) string { return fmt.Sprintf("`%s.%s.%s`", project, dataset, table) }
+
+	var sb strings.Builder
+	sb.WriteString("BEGIN TRANSACTION;\n")
+	if _, ok := schemas["repos"]; ok {
+		repoIDs := "SELECT repo_id FROM " + ref(stagingTable("repos", suffix))
+		for _, table := range snapshotTables {
+			fmt.Fprintf(&sb, "DELETE FROM %s WHERE DATE(when_collected) = '%s' AND repo_id IN (%s);\n",
+				ref(table), day, repoIDs)
+		}
+	}
+	for _, table := range slices.Sorted(maps.Keys(schemas)) {
+		var columns []string
+		for _, f := range schemas[table] {
+			columns = append(columns, f.Name)
+		}
+		cols := strings.Join(columns, ", ")
+		from := ref(stagingTable(table, suffix))
+		if table == "file_blobs" {
+			from += fmt.Sprintf(" WHERE content_sha256 NOT IN (SELECT content_sha256 FROM %s)", ref(table))
+		}
+		fmt.Fprintf(&sb, "INSERT INTO %s (%s) SELECT %s FROM %s;\n", ref(table), cols, cols, from)
+	}
+	sb.WriteString("COMMIT TRANSACTION;\n")
+	return sb.String()
+}
+
+// loadStaging oppretter en staging-tabell som utløper av seg selv, og laster
+// radene inn i den.
+func (w *BigQueryWriter) loadStaging(ctx context.Context, staging string, buf *tableBuffer) error {
+	tbl := w.Client.Dataset(w.Dataset).Table(staging)
+	md := &bigquery.TableMetadata{
+		Schema:         buf.schema,
+		ExpirationTime: time.Now().Add(stagingExpiration),
+	}
+	if err := tbl.Create(ctx, md); err != nil {
+		return fmt.Errorf("kunne ikke opprette staging-tabell: %w", err)
+	}
+	return load(ctx, tbl, buf, bigquery.WriteTruncate)
+}
+
+// load laster radene i buf inn i tbl med én load-jobb.
+func load(ctx context.Context, tbl *bigquery.Table, buf *tableBuffer, disposition bigquery.TableWriteDisposition) error {
+	src := bigquery.NewReaderSource(&buf.data)
+	src.SourceFormat = bigquery.JSON
+	src.Schema = buf.schema
+	loader := tbl.LoaderFrom(src)
+	loader.WriteDisposition = disposition
+	return runJob(ctx, loader.Run)
+}
+
+// runJob starter en jobb og venter til den er ferdig.
+func runJob(ctx context.Context, run func(context.Context) (*bigquery.Job, error)) error {
+	job, err := run(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// dropStaging sletter staging-tabellene. Feiler det, utløper de av seg selv.
+func (w *BigQueryWriter) dropStaging(ctx context.Context, staged []string) {
+	ctx = context.WithoutCancel(ctx)
+	for _, staging := range staged {
+		if err := w.Client.Dataset(w.Dataset).Table(staging).Delete(ctx); err != nil {
+			slog.Warn("Kunne ikke slette staging-tabell", "tabell", staging, "error", err)
+		}
+	}
+}
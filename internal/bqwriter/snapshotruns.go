@@ -0,0 +1,78 @@
+package bqwriter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+)
+
+// BGSnapshotRun er én rad i snapshot_runs. Kjøringen skrives som en ny rad ved
+// start og ved slutt i stedet for å oppdateres. Raden med nyeste updated_at for
+// en run_id er gjeldende.
+type BGSnapshotRun struct {
+	RunID                string                 `bigquery:"run_id" description:"ID til kjøringen"`
+	Org                  string                 `bigquery:"org" description:"Organisasjonen som ble hentet"`
+	StartedAt            time.Time              `bigquery:"started_at" description:"Når kjøringen startet"`
+	FinishedAt           bigquery.NullTimestamp `bigquery:"finished_at" description:"Når kjøringen var ferdig. Tom mens den pågår"`
+	UpdatedAt            time.Time              `bigquery:"updated_at" description:"Når raden ble skrevet. Nyeste rad per run_id gjelder"`
+	Status               string                 `bigquery:"status" description:"Status: running, completed, partial eller failed"`
+	Version              string                 `bigquery:"version" description:"Versjonen av reposnusern"`
+	Config               string                 `bigquery:"config" description:"Konfigurasjonen, uten hemmeligheter"`
+	Error                string                 `bigquery:"error" description:"Feilen kjøringen stoppet på"`
+	ReposProcessed       int64                  `bigquery:"repos_processed" description:"Antall repos som ble behandlet"`
+	ReposFailedGraphQL   int64                  `bigquery:"repos_failed_graphql" description:"Antall repos som ikke kunne hentes"`
+	ReposFailedRows      int64                  `bigquery:"repos_failed_rows" description:"Antall repos der rader ikke kunne skrives"`
+	RowsWritten          int64                  `bigquery:"rows_written" description:"Antall rader som ble skrevet"`
+	RowsFailed           int64                  `bigquery:"rows_failed" description:"Antall rader som ikke kunne skrives"`
+	APICalls             int64                  `bigquery:"api_calls" description:"Antall kall mot GitHub"`
+	RateLimitWaits       int64                  `bigquery:"rate_limit_waits" description:"Antall ganger kjøringen ventet på rate limit"`
+	RateLimitWaitSeconds float64                `bigquery:"rate_limit_wait_seconds" description:"Sekunder kjøringen ventet på rate limit"`
+	GracefulShutdown     bool                   `bigquery:"graceful_shutdown" description:"Om kjøringen ble stoppet kontrollert"`
+	FailedRepos          []string               `bigquery:"failed_repos" description:"Repoene som feilet"`
+}
+
+func ConvertSnapshotRun(run models.SnapshotRun, updatedAt time.Time) BGSnapshotRun {
+	return BGSnapshotRun{
+		RunID:                run.ID,
+		Org:                  run.Org,
+		StartedAt:            run.StartedAt,
+		FinishedAt:           bigquery.NullTimestamp{Timestamp: run.FinishedAt, Valid: !run.FinishedAt.IsZero()},
+		UpdatedAt:            updatedAt,
+		Status:               string(run.Status),
+		Version:              run.Version,
+		Config:               run.Config,
+		Error:                run.Error,
+		ReposProcessed:       run.ReposProcessed,
+		ReposFailedGraphQL:   run.ReposFailedGraphQL,
+		ReposFailedRows:      run.ReposFailedRows,
+		RowsWritten:          run.RowsWritten,
+		RowsFailed:           run.RowsFailed,
+		APICalls:             run.APICalls,
+		RateLimitWaits:       run.RateLimitWaits,
+		RateLimitWaitSeconds: run.RateLimitWait.Seconds(),
+		GracefulShutdown:     run.GracefulShutdown,
+		FailedRepos:          run.FailedRepos,
+	}
+}
+
+// StartRun skriver en rad for kjøringen når den starter.
+func (w *BigQueryWriter) StartRun(ctx context.Context, run models.SnapshotRun) error {
+	return w.writeRun(ctx, run)
+}
+
+// FinishRun skriver en ny rad for kjøringen med status og tellere.
+func (w *BigQueryWriter) FinishRun(ctx context.Context, run models.SnapshotRun) error {
+	return w.writeRun(ctx, run)
+}
+
+// writeRun laster raden inn med en egen load-jobb, så kjøringen er synlig
+// uavhengig av snapshotet.
+func (w *BigQueryWriter) writeRun(ctx context.Context, run models.SnapshotRun) error {
+	if err := appendRows(ctx, w, "snapshot_runs", []BGSnapshotRun{ConvertSnapshotRun(run, time.Now())}); err != nil {
+		return fmt.Errorf("snapshot_runs insert failed: %w", err)
+	}
+	return nil
+}
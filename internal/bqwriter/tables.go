@@ -0,0 +1,149 @@
+package bqwriter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// clusteringColumns er kolonnene tabellene klynges på, i rekkefølge, når de
+// finnes i tabellen. Dashboards filtrerer nesten alltid på repo.
+var clusteringColumns = []string{"repo_id", "full_name"}
+
+// unpartitionedTables har when_collected, men partisjoneres ikke. I file_blobs
+// er when_collected snapshotet innholdet først ble sett i, og nyere rader peker
+// fortsatt på det, så raden kan ikke utløpe med partisjonen sin.
+var unpartitionedTables = map[string]bool{"file_blobs": true}
+
+// tableOptions er innstillingene tabellene opprettes og oppdateres med.
+type tableOptions struct {
+	// PartitionExpiration sletter partisjoner eldre enn dette. 0 beholder alt.
+	PartitionExpiration time.Duration
+}
+
+// inferSchema lager schemaet til exampleStruct med beskrivelsene fra
+// description-taggene.
+func inferSchema(exampleStruct any) (bigquery.Schema, error) {
+	schema, err := bigquery.InferSchema(exampleStruct)
+	if err != nil {
+		return nil, err
+	}
+	descriptions := map[string]string{}
+	t := reflect.TypeOf(exampleStruct)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		descriptions[f.Tag.Get("bigquery")] = f.Tag.Get("description")
+	}
+	for _, f := range schema {
+		f.Description = descriptions[f.Name]
+	}
+	return schema, nil
+}
+
+// timePartitioning gir dagspartisjoner på when_collected, eller nil for
+// tabeller uten kolonnen og for unpartitionedTables.
+func timePartitioning(table string, schema bigquery.Schema, opts tableOptions) *bigquery.TimePartitioning {
+	if unpartitionedTables[table] || !hasColumn(schema, "when_collected") {
+		return nil
+	}
+	return &bigquery.TimePartitioning{
+		Type:       bigquery.DayPartitioningType,
+		Field:      "when_collected",
+		Expiration: opts.PartitionExpiration,
+	}
+}
+
+// clustering gir klyngingen til en tabell med schema, eller nil hvis den ikke
+// har noen av clusteringColumns.
+func clustering(schema bigquery.Schema) *bigquery.Clustering {
+	var fields []string
+	for _, column := range clusteringColumns {
+		if hasColumn(schema, column) {
+			fields = append(fields, column)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return &bigquery.Clustering{Fields: fields}
+}
+
+func hasColumn(schema bigquery.Schema, name string) bool {
+	return slices.ContainsFunc(schema, func(f *bigquery.FieldSchema) bool { return f.Name == name })
+}
+
+// syncTableOptions oppdaterer klynging og levetid på partisjonene hvis de har
+// endret seg. Partisjoneringen kan ikke endres på en eksisterende tabell.
+func syncTableOptions(ctx context.Context, tbl *bigquery.Table, md *bigquery.TableMetadata, partitioning *bigquery.TimePartitioning, clust *bigquery.Clustering) error {
+	update, changed := tableOptionsUpdate(md, partitioning, clust)
+	if !changed {
+		return nil
+	}
+	if _, err := tbl.Update(ctx, update, ""); err != nil {
+		return fmt.Errorf("klarte ikke å oppdatere klynging og partisjoner for %s: %w", tbl.TableID, err)
+	}
+	return nil
+}
+
+// tableOptionsUpdate finner endringene syncTableOptions skal gjøre. En tabell
+// som ikke skal partisjoneres, men som allerede er det, mister levetiden på
+// partisjonene, så ingen rader utløper.
+func tableOptionsUpdate(md *bigquery.TableMetadata, partitioning *bigquery.TimePartitioning, clust *bigquery.Clustering) (bigquery.TableMetadataToUpdate, bool) {
+	var update bigquery.TableMetadataToUpdate
+	changed := false
+	if clust != nil && (md.Clustering == nil || !slices.Equal(md.Clustering.Fields, clust.Fields)) {
+		update.Clustering = clust
+		changed = true
+	}
+	current := md.TimePartitioning
+	switch {
+	case partitioning != nil && current != nil && current.Expiration != partitioning.Expiration:
+		update.TimePartitioning = partitioning
+		changed = true
+	case partitioning == nil && current != nil && current.Expiration != 0:
+		update.TimePartitioning = &bigquery.TimePartitioning{Type: current.Type, Field: current.Field}
+		changed = true
+	}
+	return update, changed
+}
+
+// migrateToPartitioned kopierer en tabell uten partisjoner til en ny tabell
+// med partisjoner og klynging, og bytter navn på dem. Den gamle tabellen
+// beholdes som <tabell>_unpartitioned til den slettes for hånd.
+func migrateToPartitioned(ctx context.Context, client *bigquery.Client, dataset, table string, partitioning *bigquery.TimePartitioning, clust *bigquery.Clustering) error {
+	backup := table + "_unpartitioned"
+	slog.Info("Flytter tabell til partisjonert tabell", "tabell", table, "kopi", backup)
+
+	q := client.Query(migrationQuery(client.Project(), dataset, table, partitioning, clust))
+	if err := runJob(ctx, q.Run); err != nil {
+		return fmt.Errorf("klarte ikke å partisjonere %s: %w", table, err)
+	}
+	slog.Info("Tabellen er partisjonert, den gamle kan slettes", "tabell", table, "kopi", backup)
+	return nil
+}
+
+// migrationQuery bygger skriptet som lager den partisjonerte kopien og bytter
+// navn. DDL kan ikke kjøres i en transaksjon, så feiler et av navnebyttene
+// ligger dataene i <tabell>_partitioned eller <tabell>_unpartitioned.
+func migrationQuery(project, dataset, table string, partitioning *bigquery.TimePartitioning, clust *bigquery.Clustering) string {
+	ref := func(name string) string { return fmt.Sprintf("`%s.%s.%s`", project, dataset, name) }
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "CREATE TABLE %s\nPARTITION BY DATE(%s)\n", ref(table+"_partitioned"), partitioning.Field)
+	if clust != nil {
+		fmt.Fprintf(&sb, "CLUSTER BY %s\n", strings.Join(clust.Fields, ", "))
+	}
+	if days := int(partitioning.Expiration / (24 * time.Hour)); days > 0 {
+		fmt.Fprintf(&sb, "OPTIONS (partition_expiration_days = %d)\n", days)
+	}
+	fmt.Fprintf(&sb, "AS SELECT * FROM %s;\n", ref(table))
+	fmt.Fprintf(&sb, "ALTER TABLE %s RENAME TO `%s_unpartitioned`;\n", ref(table), table)
+	fmt.Fprintf(&sb, "ALTER TABLE %s RENAME TO `%s`;\n", ref(table+"_partitioned"), table)
+	return sb.String()
+}
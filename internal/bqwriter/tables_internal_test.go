@@ -0,0 +1,81 @@
+package bqwriter
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestInferSchemaPartitionsAndClustersRepoTables(t *testing.T) {
+	schema, err := inferSchema(BGRepoFetchError{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema[0].Name != "run_id" || schema[0].Description != "ID til kjøringen, se snapshot_runs" {
+		t.Errorf("description not taken from the struct tag: %+v", schema[0])
+	}
+
+	p := timePartitioning("repo_fetch_errors", schema, tableOptions{PartitionExpiration: 30 * 24 * time.Hour})
+	if p == nil || p.Field != "when_collected" || p.Type != bigquery.DayPartitioningType || p.Expiration != 30*24*time.Hour {
+		t.Errorf("unexpected partitioning: %+v", p)
+	}
+	if c := clustering(schema); c == nil || !slices.Equal(c.Fields, []string{"repo_id", "full_name"}) {
+		t.Errorf("unexpected clustering: %+v", c)
+	}
+
+	runs, err := inferSchema(BGSnapshotRun{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := timePartitioning("snapshot_runs", runs, tableOptions{}); p != nil {
+		t.Errorf("snapshot_runs has no when_collected and should not be partitioned: %+v", p)
+	}
+	if c := clustering(runs); c != nil {
+		t.Errorf("snapshot_runs has no repo columns and should not be clustered: %+v", c)
+	}
+}
+
+func TestFileBlobsNeverExpire(t *testing.T) {
+	schema, err := inferSchema(BGFileBlob{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := tableOptions{PartitionExpiration: 30 * 24 * time.Hour}
+	if p := timePartitioning("file_blobs", schema, opts); p != nil {
+		t.Errorf("file_blobs should not be partitioned: %+v", p)
+	}
+
+	// A file_blobs table that was partitioned with an expiration loses it.
+	md := &bigquery.TableMetadata{TimePartitioning: &bigquery.TimePartitioning{
+		Type: bigquery.DayPartitioningType, Field: "when_collected", Expiration: opts.PartitionExpiration,
+	}}
+	update, changed := tableOptionsUpdate(md, nil, clustering(schema))
+	if !changed || update.TimePartitioning == nil || update.TimePartitioning.Expiration != 0 || update.TimePartitioning.Field != "when_collected" {
+		t.Errorf("expected the partition expiration to be cleared: %+v", update.TimePartitioning)
+	}
+
+	md.TimePartitioning.Expiration = 0
+	if _, changed := tableOptionsUpdate(md, nil, clustering(schema)); changed {
+		t.Error("expected no update once the expiration is cleared")
+	}
+}
+
+func TestMigrationQueryCopiesIntoPartitionedTableAndSwapsNames(t *testing.T) {
+	partitioning := &bigquery.TimePartitioning{Field: "when_collected", Expiration: 400 * 24 * time.Hour}
+	clust := &bigquery.Clustering{Fields: []string{"repo_id"}}
+
+	got := migrationQuery("p", "d", "repo_languages", partitioning, clust)
+
+	want := "CREATE TABLE `p.d.repo_languages_partitioned`\n" +
+		"PARTITION BY DATE(when_collected)\n" +
+		"CLUSTER BY repo_id\n" +
+		"OPTIONS (partition_expiration_days = 400)\n" +
+		"AS SELECT * FROM `p.d.repo_languages`;\n" +
+		"ALTER TABLE `p.d.repo_languages` RENAME TO `repo_languages_unpartitioned`;\n" +
+		"ALTER TABLE `p.d.repo_languages_partitioned` RENAME TO `repo_languages`;\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
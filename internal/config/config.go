@@ -3,12 +3,44 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 )
 
 type StorageType string
 
+// RepoListing velger hvordan repo-listen for organisasjonen hentes.
+type RepoListing string
+
+const (
+	RepoListingREST    RepoListing = "rest"
+	RepoListingGraphQL RepoListing = "graphql"
+)
+
+// HTTPCache velger hvor HTTP-cachen for betingede GitHub-kall lagres.
+type HTTPCache string
+
+const (
+	HTTPCacheNone     HTTPCache = ""
+	HTTPCacheFS       HTTPCache = "fs"
+	HTTPCachePostgres HTTPCache = "postgres"
+)
+
+// RowErrors velger hva writeren gjør når enkeltrader (språk, Dockerfiler,
+// SBOM-pakker osv.) ikke kan skrives.
+type RowErrors string
+
+const (
+	RowErrorsSkip RowErrors = "skip" // hopp over raden og skriv resten av repoet
+	RowErrorsFail RowErrors = "fail" // skriv ingenting for repoet
+)
+
+// MaxGraphQLBatchSize er øvre grense for antall repos i én GraphQL-spørring.
+// Store batcher med mange filinnhold risikerer timeout hos GitHub.
+const MaxGraphQLBatchSize = 25
+
 const (
 	StoragePostgres StorageType = "postgres"
 	StorageBigQuery StorageType = "bigquery"
@@ -17,25 +49,46 @@ const (
 type Config struct {
 	Org               string
 	Token             string
+	Tokens            []string // flere tokens fra GITHUB_TOKENS, brukes i tillegg til Token
+	GitHubURL         string   // rot-URL til GitHub Enterprise Server, tom for github.com
 	Debug             bool
 	MaxDebugRepos     int64 // maks antall repos i debug-modus
 	SkipArchived      bool
 	Storage           StorageType
 	PostgresDSN       string
+	PostgresMigrate   bool // kjør manglende databasemigreringer ved oppstart
+	PostgresMaxConns  int  // maks antall åpne tilkoblinger til PostgreSQL
 	BQProjectID       string
 	BQDataset         string
 	BQTable           string
 	BQCredentials     string           // Valgfritt hvis GCP auth skjer automatisk
 	Parallelism       int              // maks antall samtidige repo-prosesser
+	GraphQLBatchSize  int              // antall repos per GraphQL-spørring, 1 slår av batching
+	RowErrors         RowErrors        // hva som skjer når enkeltrader ikke kan skrives
+	RepoListing       RepoListing      // REST (sidenummer) eller GraphQL (cursor) for repo-listen
+	RateLimitReserve  int              // prosent av rate limit som holdes av til andre brukere av tokenet
+	HTTPCache         HTTPCache        // tom for ingen cache, ellers filsystem eller PostgreSQL
+	HTTPCacheDir      string           // katalog for filsystem-cachen
+	HTTPCacheMaxMB    int              // maks størrelse på HTTP-cachen
+	HTTPRecord        string           // fil som alle GitHub-svar tas opp til, tom for ingen opptak
+	HTTPReplay        string           // opptak som spilles av i stedet for å kalle GitHub
 	Feature_Sbom      bool             // Om SBOM-funksjonalitet er aktivert
 	Feature_GitHubApp bool             // Om GitHub App autentisering er aktivert
 	GitHubAppConfig   *GitHubAppConfig // Valgfritt, for GitHub App autentisering
+
+	Feature_RemoteCICalls bool // Om reusable workflows/actions i andre repoer i samme org skal løses opp
+
+	BQPartitionExpirationDays int // dager partisjonene i BigQuery beholdes, 0 beholder alt
 }
 
 type GitHubAppConfig struct {
 	AppID          int64
 	InstallationID int64
 	PrivateKey     []byte
+
+	// ExtraInstallationIDs er flere installasjoner av samme app. Hver installasjon
+	// har egen rate limit og brukes i tillegg til InstallationID.
+	ExtraInstallationIDs []int64
 }
 
 // NewConfig oppretter en ny konfigurasjon basert på miljøvariabler
@@ -53,6 +106,26 @@ func NewConfig() (Config, error) {
 		}
 	}
 
+	graphQLBatchSize := 10
+	if val := os.Getenv("REPOSNUSERN_GQL_BATCH"); val != "" {
+		if b, err := strconv.Atoi(val); err == nil && b > 0 && b <= MaxGraphQLBatchSize {
+			graphQLBatchSize = b
+		} else {
+			errs = append(errs, fmt.Errorf("REPOSNUSERN_GQL_BATCH må være et heltall mellom 1 og %d", MaxGraphQLBatchSize))
+		}
+	}
+
+	// Hvert repo skrives i sin egen transaksjon, så standard er én tilkobling
+	// per parallelle repo
+	postgresMaxConns := parallelism
+	if val := os.Getenv("POSTGRES_MAX_CONNS"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			postgresMaxConns = n
+		} else {
+			errs = append(errs, errors.New("POSTGRES_MAX_CONNS må være et positivt heltall"))
+		}
+	}
+
 	maxDebugRepos := int64(10)
 	if val := os.Getenv("REPOSNUSER_MAXDEBUGREPOS"); val != "" {
 		if i, err := strconv.Atoi(val); err == nil && i > 0 {
@@ -62,6 +135,72 @@ func NewConfig() (Config, error) {
 		}
 	}
 
+	rateLimitReserve := 10
+	if val := os.Getenv("REPOSNUSERN_RATE_RESERVE"); val != "" {
+		if p, err := strconv.Atoi(val); err == nil && p >= 0 && p <= 90 {
+			rateLimitReserve = p
+		} else {
+			errs = append(errs, errors.New("REPOSNUSERN_RATE_RESERVE må være en prosent mellom 0 og 90"))
+		}
+	}
+
+	bqPartitionExpirationDays := 0
+	if val := os.Getenv("BQ_PARTITION_EXPIRATION_DAYS"); val != "" {
+		if d, err := strconv.Atoi(val); err == nil && d >= 0 {
+			bqPartitionExpirationDays = d
+		} else {
+			errs = append(errs, errors.New("BQ_PARTITION_EXPIRATION_DAYS må være et heltall, 0 eller større"))
+		}
+	}
+
+	rowErrors := RowErrorsSkip
+	if val := os.Getenv("IMPORT_ROW_ERRORS"); val != "" {
+		switch RowErrors(val) {
+		case RowErrorsSkip, RowErrorsFail:
+			rowErrors = RowErrors(val)
+		default:
+			errs = append(errs, errors.New("ugyldig verdi for IMPORT_ROW_ERRORS – må være 'skip' eller 'fail'"))
+		}
+	}
+
+	repoListing := RepoListingREST
+	if val := os.Getenv("REPO_LISTING"); val != "" {
+		switch RepoListing(val) {
+		case RepoListingREST, RepoListingGraphQL:
+			repoListing = RepoListing(val)
+		default:
+			errs = append(errs, errors.New("ugyldig verdi for REPO_LISTING – må være 'rest' eller 'graphql'"))
+		}
+	}
+
+	httpCache := HTTPCache(os.Getenv("HTTP_CACHE"))
+	switch httpCache {
+	case HTTPCacheNone, HTTPCacheFS, HTTPCachePostgres:
+	default:
+		errs = append(errs, errors.New("ugyldig verdi for HTTP_CACHE – må være 'fs' eller 'postgres'"))
+	}
+
+	httpCacheDir := ".cache/reposnusern"
+	if val := os.Getenv("HTTP_CACHE_DIR"); val != "" {
+		httpCacheDir = val
+	}
+
+	httpCacheMaxMB := 512
+	if val := os.Getenv("HTTP_CACHE_MAX_MB"); val != "" {
+		if mb, err := strconv.Atoi(val); err == nil && mb > 0 {
+			httpCacheMaxMB = mb
+		} else {
+			errs = append(errs, errors.New("HTTP_CACHE_MAX_MB må være et positivt heltall"))
+		}
+	}
+
+	githubURL := strings.TrimSuffix(os.Getenv("GITHUB_URL"), "/")
+	if githubURL != "" {
+		if u, err := url.Parse(githubURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, errors.New("GITHUB_URL må være en http- eller https-URL, f.eks. https://github.example.com"))
+		}
+	}
+
 	featureGitHubApp := os.Getenv("GITHUB_APP_ENABLED") == "true"
 	var githubAppConfig *GitHubAppConfig
 	if featureGitHubApp {
@@ -75,26 +214,54 @@ func NewConfig() (Config, error) {
 	cfg := Config{
 		Org:               os.Getenv("ORG"),
 		Token:             os.Getenv("GITHUB_TOKEN"),
+		Tokens:            splitList(os.Getenv("GITHUB_TOKENS")),
+		GitHubURL:         githubURL,
 		Debug:             os.Getenv("REPOSNUSERDEBUG") == "true",
 		MaxDebugRepos:     maxDebugRepos,
 		SkipArchived:      os.Getenv("REPOSNUSERARCHIVED") != "true",
 		Storage:           storage,
 		PostgresDSN:       os.Getenv("POSTGRES_DSN"),
+		PostgresMigrate:   os.Getenv("POSTGRES_AUTO_MIGRATE") != "false",
+		PostgresMaxConns:  postgresMaxConns,
 		BQProjectID:       os.Getenv("GCP_TEAM_PROJECT_ID"),
 		BQDataset:         os.Getenv("BQ_DATASET"),
 		BQTable:           os.Getenv("BQ_TABLE"),
 		BQCredentials:     os.Getenv("BQ_CREDENTIALS"),
 		Parallelism:       parallelism,
+		GraphQLBatchSize:  graphQLBatchSize,
+		RowErrors:         rowErrors,
+		RepoListing:       repoListing,
+		RateLimitReserve:  rateLimitReserve,
+		HTTPCache:         httpCache,
+		HTTPCacheDir:      httpCacheDir,
+		HTTPCacheMaxMB:    httpCacheMaxMB,
+		HTTPRecord:        os.Getenv("HTTP_RECORD"),
+		HTTPReplay:        os.Getenv("HTTP_REPLAY"),
 		Feature_Sbom:      os.Getenv("SBOM") == "true",
 		Feature_GitHubApp: featureGitHubApp,
 		GitHubAppConfig:   githubAppConfig,
+
+		Feature_RemoteCICalls: os.Getenv("CI_REMOTE_CALLS") == "true",
+
+		BQPartitionExpirationDays: bqPartitionExpirationDays,
 	}
 
 	if cfg.Org == "" {
 		errs = append(errs, errors.New("ORG må være satt"))
 	}
-	if cfg.Token == "" && !cfg.Feature_GitHubApp {
-		errs = append(errs, errors.New("GITHUB_TOKEN må være satt, eller GitHub App må være aktivert"))
+	// Avspilling trenger ingen tilgang til GitHub
+	if cfg.Token == "" && len(cfg.Tokens) == 0 && !cfg.Feature_GitHubApp && cfg.HTTPReplay == "" {
+		errs = append(errs, errors.New("GITHUB_TOKEN eller GITHUB_TOKENS må være satt, eller GitHub App må være aktivert"))
+	}
+	if cfg.HTTPRecord != "" && cfg.HTTPReplay != "" {
+		errs = append(errs, errors.New("HTTP_RECORD og HTTP_REPLAY kan ikke brukes samtidig"))
+	}
+	if (cfg.HTTPRecord != "" || cfg.HTTPReplay != "") && cfg.HTTPCache != HTTPCacheNone {
+		// Betingede kall avhenger av hva som ligger i cachen, og blir ikke like ved avspilling
+		errs = append(errs, errors.New("HTTP_CACHE kan ikke brukes sammen med HTTP_RECORD eller HTTP_REPLAY"))
+	}
+	if cfg.HTTPCache == HTTPCachePostgres && cfg.PostgresDSN == "" {
+		errs = append(errs, errors.New("POSTGRES_DSN må være satt for HTTP-cache i PostgreSQL"))
 	}
 	if cfg.Storage == "" {
 		errs = append(errs, errors.New("REPO_STORAGE må være satt til 'postgres' eller 'bigquery'"))
@@ -130,16 +297,30 @@ func NewConfig() (Config, error) {
 
 func (cfg Config) DebugPrint() string {
 	// Printing the raw object reveals GitHub token, use this instead
-	return fmt.Sprintf("Org: %v, Token: %v, Debug: %v, MaxDebugRepos: %v, SkipArchived: %v, Storage: %v, Parallelism: %v, Feature_Sbom: %v, Feature_GitHubApp: %v",
+	return fmt.Sprintf("Org: %v, Token: %v, Tokens: %v, GitHubURL: %v, Debug: %v, MaxDebugRepos: %v, SkipArchived: %v, Storage: %v, PostgresMigrate: %v, PostgresMaxConns: %v, Parallelism: %v, GraphQLBatchSize: %v, RowErrors: %v, RepoListing: %v, RateLimitReserve: %v, HTTPCache: %v, HTTPCacheMaxMB: %v, HTTPRecord: %v, HTTPReplay: %v, Feature_Sbom: %v, Feature_GitHubApp: %v, Feature_RemoteCICalls: %v, BQPartitionExpirationDays: %v",
 		cfg.Org,
 		(cfg.Token != ""),
+		len(cfg.Tokens),
+		cfg.GitHubURL,
 		cfg.Debug,
 		cfg.MaxDebugRepos,
 		cfg.SkipArchived,
 		cfg.Storage,
+		cfg.PostgresMigrate,
+		cfg.PostgresMaxConns,
 		cfg.Parallelism,
+		cfg.GraphQLBatchSize,
+		cfg.RowErrors,
+		cfg.RepoListing,
+		cfg.RateLimitReserve,
+		cfg.HTTPCache,
+		cfg.HTTPCacheMaxMB,
+		cfg.HTTPRecord,
+		cfg.HTTPReplay,
 		cfg.Feature_Sbom,
 		cfg.Feature_GitHubApp,
+		cfg.Feature_RemoteCICalls,
+		cfg.BQPartitionExpirationDays,
 	)
 }
 
@@ -186,13 +367,36 @@ func LoadGitHubAppConfig() (*GitHubAppConfig, error) {
 		}
 	}
 
+	// Parse optional extra installation IDs
+	var extraInstallationIDs []int64
+	for _, id := range splitList(os.Getenv("GITHUB_APP_EXTRA_INSTALLATION_IDS")) {
+		parsedID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid GITHUB_APP_EXTRA_INSTALLATION_IDS: %w", err))
+			continue
+		}
+		extraInstallationIDs = append(extraInstallationIDs, parsedID)
+	}
+
 	if len(errs) > 0 {
 		return nil, errors.Join(errs...)
 	}
 
 	return &GitHubAppConfig{
-		AppID:          appIDInt,
-		InstallationID: installationIDInt,
-		PrivateKey:     []byte(privateKeyPEM),
+		AppID:                appIDInt,
+		InstallationID:       installationIDInt,
+		PrivateKey:           []byte(privateKeyPEM),
+		ExtraInstallationIDs: extraInstallationIDs,
 	}, nil
 }
+
+// splitList deler en kommaseparert miljøvariabel og fjerner tomme elementer.
+func splitList(val string) []string {
+	var items []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,36 @@
+package dbwriter
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+	"github.com/jonmartinstorm/reposnusern/internal/storage"
+)
+
+// RecordFetchErrors lagrer repos som feilet, eller ble hentet med mindre data,
+// i repo_fetch_errors.
+func (p *PostgresWriter) RecordFetchErrors(ctx context.Context, runID string, snapshotTime time.Time, fetchErrors []models.RepoFetchError) error {
+	queries := storage.New(p.DB)
+	for chunk := range slices.Chunk(fetchErrors, bulkInsertChunkSize) {
+		params := storage.InsertRepoFetchErrorsParams{
+			RunID:      runID,
+			HentetDato: snapshotTime.Truncate(24 * time.Hour),
+		}
+		for _, fe := range chunk {
+			params.RepoIds = append(params.RepoIds, fe.Repo.ID)
+			params.FullNames = append(params.FullNames, fe.Repo.FullName)
+			params.ErrorClasses = append(params.ErrorClasses, string(fe.Class))
+			params.Messages = append(params.Messages, fe.Message)
+			params.Fatals = append(params.Fatals, fe.Fatal)
+			params.Attempts = append(params.Attempts, int32(fe.Attempts))
+			params.Recovereds = append(params.Recovereds, fe.Recovered)
+		}
+		if err := queries.InsertRepoFetchErrors(ctx, params); err != nil {
+			return fmt.Errorf("kunne ikke lagre hentefeil: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package dbwriter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/fetcher"
+	"github.com/jonmartinstorm/reposnusern/internal/storage"
+)
+
+// httpCacheEvictEvery er hvor mange skrivinger som går mellom hver opprydding,
+// siden oppryddingen summerer størrelsen på hele tabellen.
+const httpCacheEvictEvery = 50
+
+// PostgresResponseCache lagrer HTTP-cachen for fetcheren i tabellen http_cache.
+// Når tabellen blir større enn maxBytes slettes de minst nylig brukte radene.
+type PostgresResponseCache struct {
+	DB       *sql.DB
+	maxBytes int64
+	puts     atomic.Int64
+}
+
+// NewPostgresResponseCache åpner en egen tilkobling for HTTP-cachen og rydder
+// den ned til maxBytes. Med autoMigrate kjøres manglende migreringer først.
+func NewPostgresResponseCache(ctx context.Context, postgresdsn string, maxBytes int64, autoMigrate bool) (*PostgresResponseCache, error) {
+	db, err := sql.Open("postgres", postgresdsn)
+	if err != nil {
+		return nil, fmt.Errorf("kunne ikke åpne PostgreSQL-database for HTTP-cache: %w", err)
+	}
+
+	db.SetMaxOpenConns(2)
+	db.SetMaxIdleConns(2)
+	db.SetConnMaxLifetime(10 * time.Minute)
+
+	if err := prepareSchema(ctx, db, autoMigrate); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	c := &PostgresResponseCache{DB: db, maxBytes: maxBytes}
+	if err := c.evict(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *PostgresResponseCache) Get(ctx context.Context, url string) (*fetcher.CachedResponse, error) {
+	row, err := storage.New(c.DB).GetHTTPCacheEntry(ctx, url)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &fetcher.CachedResponse{ETag: row.Etag, LastModified: row.LastModified, Body: row.Body}, nil
+}
+
+func (c *PostgresResponseCache) Put(ctx context.Context, url string, resp fetcher.CachedResponse) error {
+	size := int64(len(url) + len(resp.ETag) + len(resp.LastModified) + len(resp.Body))
+	if size > c.maxBytes {
+		return nil
+	}
+
+	err := storage.New(c.DB).UpsertHTTPCacheEntry(ctx, storage.UpsertHTTPCacheEntryParams{
+		Url:          url,
+		Etag:         resp.ETag,
+		LastModified: resp.LastModified,
+		Body:         resp.Body,
+		SizeBytes:    size,
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.puts.Add(1)%httpCacheEvictEvery == 0 {
+		return c.evict(ctx)
+	}
+	return nil
+}
+
+func (c *PostgresResponseCache) evict(ctx context.Context) error {
+	evicted, err := storage.New(c.DB).EvictHTTPCacheEntries(ctx, c.maxBytes)
+	if err != nil {
+		return fmt.Errorf("kunne ikke rydde HTTP-cache: %w", err)
+	}
+	if evicted > 0 {
+		slog.Info("Ryddet HTTP-cache", "slettet", evicted)
+	}
+	return nil
+}
+
+// Close lukker tilkoblingen til HTTP-cachen.
+func (c *PostgresResponseCache) Close() error {
+	return c.DB.Close()
+}
@@ -0,0 +1,278 @@
+package dbwriter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jonmartinstorm/reposnusern/db"
+)
+
+// ErrSchemaAhead betyr at databasen er migrert av en nyere versjon av
+// reposnusern enn denne. Da skriver vi ingenting, siden vi ikke vet hvordan
+// skjemaet ser ut.
+var ErrSchemaAhead = errors.New("databaseskjemaet er nyere enn denne versjonen av reposnusern")
+
+// ErrSchemaBehind betyr at databasen mangler migreringer og automatisk
+// migrering er slått av.
+var ErrSchemaBehind = errors.New("databaseskjemaet mangler migreringer")
+
+// ErrBaselineDown betyr at Down ville rullet tilbake 0001, som sletter alle
+// snapshotene. Det krever Force.
+var ErrBaselineDown = errors.New("tilbakerulling av 0001 sletter alle snapshots og krever --force")
+
+// migrationLockID er nøkkelen til advisory-låsen som hindrer at flere
+// prosesser migrerer samtidig.
+const migrationLockID = 72_657_035
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration er ett steg i skjemaet, med SQL for å gå opp og ned.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// LoadMigrations leser migreringene i dir og sorterer dem etter versjon. Hver
+// versjon må ha både en up- og en down-fil.
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("kunne ikke lese migreringer: %w", err)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, e := range entries {
+		match := migrationFileName.FindStringSubmatch(e.Name())
+		if e.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("ugyldig migreringsversjon i %s: %w", e.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("kunne ikke lese %s: %w", e.Name(), err)
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrering %d har to navn: %s og %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migrering %d_%s mangler up- eller down-fil", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator kjører migreringene mot en database og holder oversikt over hvilke
+// som er kjørt i tabellen schema_migrations.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+	// Force lar Down rulle tilbake den første migreringen. Det er bare ment
+	// for testdatabaser.
+	Force bool
+}
+
+// NewMigrator lager en migrator med migreringene som er bygget inn i binæren.
+func NewMigrator(database *sql.DB) (*Migrator, error) {
+	migrations, err := LoadMigrations(db.Migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: database, Migrations: migrations}, nil
+}
+
+// Latest er den nyeste versjonen binæren kjenner til.
+func (m *Migrator) Latest() int64 {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+// Version returnerer versjonen databasen er migrert til, 0 for en tom database.
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	var version int64
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		var err error
+		version, err = currentVersion(ctx, conn)
+		return err
+	})
+	return version, err
+}
+
+// Up kjører alle migreringer som mangler og returnerer hvor mange som ble kjørt.
+// Er databasen nyere enn binæren returneres ErrSchemaAhead.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		version, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if version > m.Latest() {
+			return fmt.Errorf("%w: databasen har versjon %d, binæren kjenner til %d", ErrSchemaAhead, version, m.Latest())
+		}
+		for _, migration := range m.Migrations {
+			if migration.Version <= version {
+				continue
+			}
+			err := inTx(ctx, conn, migration.Up,
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("migrering %d_%s feilet: %w", migration.Version, migration.Name, err)
+			}
+			slog.Info("Kjørte databasemigrering", "versjon", migration.Version, "navn", migration.Name)
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down ruller tilbake de steps nyeste migreringene og returnerer hvor mange som
+// ble rullet tilbake. Uten Force nekter den å rulle tilbake den første
+// migreringen, og returnerer ErrBaselineDown før noe er endret.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	reverted := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		version, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if version > m.Latest() {
+			return fmt.Errorf("%w: databasen har versjon %d, binæren kjenner til %d", ErrSchemaAhead, version, m.Latest())
+		}
+		var revert []Migration
+		for i := len(m.Migrations) - 1; i >= 0 && len(revert) < steps; i-- {
+			if m.Migrations[i].Version <= version {
+				revert = append(revert, m.Migrations[i])
+			}
+		}
+		if !m.Force && len(revert) > 0 && revert[len(revert)-1].Version == m.Migrations[0].Version {
+			return ErrBaselineDown
+		}
+		for _, migration := range revert {
+			err := inTx(ctx, conn, migration.Down,
+				`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("tilbakerulling av %d_%s feilet: %w", migration.Version, migration.Name, err)
+			}
+			slog.Info("Rullet tilbake databasemigrering", "versjon", migration.Version, "navn", migration.Name)
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Check sjekker at databasen er på samme versjon som binæren.
+func (m *Migrator) Check(ctx context.Context) error {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case version > m.Latest():
+		return fmt.Errorf("%w: databasen har versjon %d, binæren kjenner til %d", ErrSchemaAhead, version, m.Latest())
+	case version < m.Latest():
+		return fmt.Errorf("%w: databasen har versjon %d, binæren trenger %d", ErrSchemaBehind, version, m.Latest())
+	}
+	return nil
+}
+
+// prepareSchema migrerer databasen når autoMigrate er på, og sjekker deretter
+// at skjemaet passer med binæren.
+func prepareSchema(ctx context.Context, database *sql.DB, autoMigrate bool) error {
+	migrator, err := NewMigrator(database)
+	if err != nil {
+		return err
+	}
+	if autoMigrate {
+		if _, err := migrator.Up(ctx); err != nil {
+			return err
+		}
+	}
+	return migrator.Check(ctx)
+}
+
+// withLock kjører fn på én tilkobling mens den holder migreringslåsen.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("kunne ikke koble til databasen: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("kunne ikke ta migreringslåsen: %w", err)
+	}
+	defer func() {
+		// Bruker egen context så låsen slippes selv om ctx er avbrutt
+		_, _ = conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+	}()
+
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`); err != nil {
+		return fmt.Errorf("kunne ikke opprette schema_migrations: %w", err)
+	}
+	return fn(conn)
+}
+
+func currentVersion(ctx context.Context, conn *sql.Conn) (int64, error) {
+	var version int64
+	if err := conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("kunne ikke lese skjemaversjon: %w", err)
+	}
+	return version, nil
+}
+
+// inTx kjører migreringen og oppdateringen av schema_migrations i samme
+// transaksjon, så en feilet migrering ikke blir halvveis registrert.
+func inTx(ctx context.Context, conn *sql.Conn, migrationSQL, bookkeeping string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, migrationSQL); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,49 @@
+package dbwriter_test
+
+import (
+	"testing/fstest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/jonmartinstorm/reposnusern/db"
+	"github.com/jonmartinstorm/reposnusern/internal/dbwriter"
+)
+
+var _ = Describe("LoadMigrations", func() {
+	It("skal sortere migreringene etter versjon", func() {
+		fsys := fstest.MapFS{
+			"m/0002_add.up.sql":       {Data: []byte("ALTER TABLE a ADD b INT;")},
+			"m/0002_add.down.sql":     {Data: []byte("ALTER TABLE a DROP b;")},
+			"m/0001_initial.up.sql":   {Data: []byte("CREATE TABLE a (id INT);")},
+			"m/0001_initial.down.sql": {Data: []byte("DROP TABLE a;")},
+			"m/README.md":             {Data: []byte("ikke en migrering")},
+		}
+
+		migrations, err := dbwriter.LoadMigrations(fsys, "m")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(migrations).To(HaveLen(2))
+		Expect(migrations[0].Version).To(Equal(int64(1)))
+		Expect(migrations[0].Name).To(Equal("initial"))
+		Expect(migrations[1].Up).To(Equal("ALTER TABLE a ADD b INT;"))
+		Expect(migrations[1].Down).To(Equal("ALTER TABLE a DROP b;"))
+	})
+
+	It("skal feile når en migrering mangler down-fil", func() {
+		fsys := fstest.MapFS{
+			"m/0001_initial.up.sql": {Data: []byte("CREATE TABLE a (id INT);")},
+		}
+
+		_, err := dbwriter.LoadMigrations(fsys, "m")
+		Expect(err).To(MatchError(ContainSubstring("mangler up- eller down-fil")))
+	})
+
+	It("skal ha et komplett sett med innebygde migreringer", func() {
+		migrations, err := dbwriter.LoadMigrations(db.Migrations, "migrations")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(migrations).NotTo(BeEmpty())
+		for i, m := range migrations {
+			Expect(m.Version).To(Equal(int64(i+1)), "migreringene skal være nummerert fortløpende")
+		}
+	})
+})
@@ -6,48 +6,78 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
-	"strings"
+	"slices"
 	"time"
 
+	"github.com/jonmartinstorm/reposnusern/internal/config"
 	"github.com/jonmartinstorm/reposnusern/internal/models"
 	"github.com/jonmartinstorm/reposnusern/internal/parser"
+	"github.com/jonmartinstorm/reposnusern/internal/rows"
 	"github.com/jonmartinstorm/reposnusern/internal/storage"
 )
 
+// bulkInsertChunkSize er maks antall rader i én bulk-insert. Store SBOM-er
+// deles opp så én spørring ikke blir urimelig stor.
+const bulkInsertChunkSize = 1000
+
 type PostgresWriter struct {
 	DB *sql.DB
+	// RowErrors bestemmer om en rad som ikke kan skrives hoppes over eller
+	// gjør at hele repoet rulles tilbake. Tom verdi betyr RowErrorsSkip.
+	RowErrors config.RowErrors
 }
 
-func NewPostgresWriter(postgresdsn string) (*PostgresWriter, error) {
-	db, err := sql.Open("postgres", postgresdsn)
+// NewPostgresWriter åpner databasen og sjekker at skjemaet passer med
+// binæren. Med PostgresMigrate kjøres manglende migreringer først. Hvert repo
+// skrives i sin egen transaksjon, så PostgresMaxConns repos kan skrives samtidig.
+func NewPostgresWriter(ctx context.Context, cfg *config.Config) (*PostgresWriter, error) {
+	db, err := sql.Open("postgres", cfg.PostgresDSN)
 	if err != nil {
-		slog.Error("Kunne ikke åpne PostgreSQL-database", "dsn", postgresdsn, "error", err)
+		slog.Error("Kunne ikke åpne PostgreSQL-database", "error", err)
 		return nil, fmt.Errorf("kunne ikke åpne PostgreSQL-database: %w", err)
 	}
 
-	db.SetMaxOpenConns(1)
-	db.SetMaxIdleConns(1)
+	db.SetMaxOpenConns(cfg.PostgresMaxConns)
+	db.SetMaxIdleConns(cfg.PostgresMaxConns)
 	db.SetConnMaxLifetime(10 * time.Minute)
 
+	if err := prepareSchema(ctx, db, cfg.PostgresMigrate); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
 	return &PostgresWriter{
-		DB: db,
+		DB:        db,
+		RowErrors: cfg.RowErrors,
 	}, nil
 }
 
-func (p *PostgresWriter) ImportRepo(ctx context.Context, entry models.RepoEntry, snapshotTime time.Time) error {
+// ImportRepo skriver repoet og alle barneradene i én transaksjon, og
+// returnerer hvor mange rader som ble skrevet og feilet per tabell.
+func (p *PostgresWriter) ImportRepo(ctx context.Context, entry models.RepoEntry, snapshotTime time.Time) (models.ImportResult, error) {
 	tx, err := p.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("start tx: %w", err)
+		return models.ImportResult{}, fmt.Errorf("start tx: %w", err)
 	}
 
 	snapshotDate := snapshotTime.Truncate(24 * time.Hour)
 
 	queries := storage.New(tx)
 
-	r := entry.Repo
-	id := int64(r.ID)
+	snap := rows.FromEntry(entry)
+	r := snap.Repo
+	id := r.ID
 	name := r.FullName
 
+	// Innholdet skrives til file_blobs før radene som peker på det
+	w := &rowWriter{tx: tx, queries: queries, policy: p.RowErrors, repo: name}
+	if err := insertFileBlobs(ctx, w, snap.Blobs); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			slog.Warn("Rollback feilet", "repo", name, "error", rbErr)
+		}
+		return rolledBack(w.result), err
+	}
+
 	repo := storage.InsertOrUpdateRepoParams{
 		ID:           id,
 		HentetDato:   snapshotDate,
@@ -60,223 +90,361 @@ func (p *PostgresWriter) ImportRepo(ctx context.Context, entry models.RepoEntry,
 		Private:      r.Private,
 		IsFork:       r.IsFork,
 		Language:     r.Language,
-		SizeMb:       float32(r.Size) / 1024.0,
+		SizeMb:       r.SizeMB,
 		UpdatedAt:    r.UpdatedAt,
 		PushedAt:     r.PushedAt,
 		CreatedAt:    r.CreatedAt,
 		HtmlUrl:      r.HtmlUrl,
-		Topics:       strings.Join(r.Topics, ","),
+		Topics:       r.Topics,
 		Visibility:   r.Visibility,
-		License:      SafeLicense((*struct{ SpdxID string })(r.License)),
+		License:      r.License,
 		OpenIssues:   r.OpenIssues,
 		LanguagesUrl: r.LanguagesURL,
-		ReadmeContent: sql.NullString{
-			String: r.Readme,
-			Valid:  r.Readme != "",
+		ReadmeSha256: sql.NullString{
+			String: r.ReadmeSHA256,
+			Valid:  r.ReadmeSHA256 != "" && w.blobs[r.ReadmeSHA256],
 		},
-		HasSecurityMd:        r.Security["has_security_md"],
-		HasDependabot:        r.Security["has_dependabot"],
-		HasCodeql:            r.Security["has_codeql"],
+		HasSecurityMd:        r.HasSecurityMD,
+		HasDependabot:        r.HasDependabot,
+		HasCodeql:            r.HasCodeQL,
 		HasCompleteLockfiles: r.HasCompleteLockfiles,
 		LockfilePairings:     marshalToJSONRawMessage(r.LockfilePairings),
-		LockfilePairCount:    int32(r.Lockfile_pair_count),
+		LockfilePairCount:    int32(r.LockfilePairCount),
+		DefaultBranch:        r.DefaultBranch,
 	}
 
 	if err := queries.InsertOrUpdateRepo(ctx, repo); err != nil {
 		if rbErr := tx.Rollback(); rbErr != nil {
-			return fmt.Errorf("InsertRepo feilet: %v (rollback feilet: %w)", err, rbErr)
+			return models.ImportResult{}, fmt.Errorf("InsertRepo feilet: %v (rollback feilet: %w)", err, rbErr)
 		}
-		return fmt.Errorf("InsertRepo feilet: %w", err)
+		return models.ImportResult{}, fmt.Errorf("InsertRepo feilet: %w", err)
 	}
+	w.result.Add("repos", 1, 0)
 
-	insertLanguages(ctx, queries, id, name, entry.Languages, snapshotDate)
-	insertDockerfiles(ctx, queries, id, name, entry.Files, snapshotDate)
-	insertCIConfig(ctx, queries, id, name, entry.CIConfig, snapshotDate)
-	insertSBOMPackagesGithub(ctx, queries, id, name, entry.SBOM, snapshotDate)
+	inserts := []func() error{
+		func() error { return insertLanguages(ctx, w, id, snap.Languages, snapshotDate) },
+		func() error { return insertDockerfiles(ctx, w, id, name, snap.Dockerfiles, snapshotDate) },
+		func() error { return insertCIConfig(ctx, w, id, snap.CIConfigs, snapshotDate) },
+		func() error { return insertCIWorkflowCalls(ctx, w, id, snap.WorkflowCalls, snapshotDate) },
+		func() error { return insertSecretFindings(ctx, w, id, snap.SecretFindings, snapshotDate) },
+		func() error { return insertSBOMPackagesGithub(ctx, w, id, snap.SBOMPackages, snapshotDate) },
+	}
+	for _, insert := range inserts {
+		if err := insert(); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				slog.Warn("Rollback feilet", "repo", name, "error", rbErr)
+			}
+			return rolledBack(w.result), err
+		}
+	}
 
 	if err := tx.Commit(); err != nil {
 		slog.Error("Commit-feil – ruller tilbake", "repo", name, "error", err)
-		return fmt.Errorf("commit failed: %w", err)
+		return w.result, fmt.Errorf("commit failed: %w", err)
+	}
+
+	return w.result, nil
+}
+
+// rolledBack teller alle rader i result som feilet, siden transaksjonen ble
+// rullet tilbake.
+func rolledBack(result models.ImportResult) models.ImportResult {
+	var out models.ImportResult
+	for table, t := range result.Tables {
+		out.Add(table, 0, t.Written+t.Failed)
+	}
+	return out
+}
+
+// rowWriter skriver barneradene til ett repo i repoets transaksjon og teller
+// hvor mange rader som ble skrevet og hvor mange som feilet.
+type rowWriter struct {
+	tx      *sql.Tx
+	queries *storage.Queries
+	policy  config.RowErrors
+	repo    string
+	result  models.ImportResult
+	// blobs er hashene til innholdet som er skrevet til file_blobs.
+	blobs map[string]bool
+}
+
+// exec kjører én spørring som skriver rows rader til table. I Postgres
+// avbryter første feil hele transaksjonen, så med RowErrorsSkip kjøres
+// spørringen i et savepoint som rulles tilbake ved feil. Med RowErrorsFail
+// returneres feilen, og repoet skal rulles tilbake. Øvrige feil (savepoint som
+// ikke kan tas eller rulles tilbake) returneres alltid.
+func (w *rowWriter) exec(ctx context.Context, table string, rows int, fn func() error, logAttrs ...any) error {
+	if rows == 0 {
+		return nil
+	}
+	if w.policy == config.RowErrorsFail {
+		if err := fn(); err != nil {
+			w.result.Add(table, 0, rows)
+			return fmt.Errorf("%w: %s: %w", models.ErrRowsFailed, table, err)
+		}
+		w.result.Add(table, rows, 0)
+		return nil
 	}
 
+	if _, err := w.tx.ExecContext(ctx, "SAVEPOINT child_rows"); err != nil {
+		return fmt.Errorf("savepoint for %s: %w", table, err)
+	}
+	if err := fn(); err != nil {
+		if _, rbErr := w.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT child_rows"); rbErr != nil {
+			return fmt.Errorf("rollback til savepoint for %s: %w", table, rbErr)
+		}
+		slog.Warn("Kunne ikke skrive rader, hopper over", append([]any{"repo", w.repo, "tabell", table, "antall", rows, "error", err}, logAttrs...)...)
+		w.result.Add(table, 0, rows)
+		return nil
+	}
+	if _, err := w.tx.ExecContext(ctx, "RELEASE SAVEPOINT child_rows"); err != nil {
+		return fmt.Errorf("release savepoint for %s: %w", table, err)
+	}
+	w.result.Add(table, rows, 0)
 	return nil
 }
 
-func insertLanguages(ctx context.Context, queries *storage.Queries, repoID int64, name string, langs map[string]int, snapshotDate time.Time) {
-	for lang, size := range langs {
-		err := queries.InsertOrUpdateRepoLanguage(ctx, storage.InsertOrUpdateRepoLanguageParams{
-			RepoID:     repoID,
-			HentetDato: snapshotDate,
-			Language:   lang,
-			Bytes:      int64(size),
+// blobMissing teller en rad som ikke kan skrives fordi innholdet den peker på
+// ikke kom inn i file_blobs. Det skjer bare med RowErrorsSkip.
+func (w *rowWriter) blobMissing(table string, logAttrs ...any) {
+	slog.Warn("Innholdet mangler i file_blobs, hopper over", append([]any{"repo", w.repo, "tabell", table}, logAttrs...)...)
+	w.result.Add(table, 0, 1)
+}
+
+// insertFileBlobs skriver én rad per blob, så innhold som ikke kan skrives
+// bare går ut over radene som peker på det.
+func insertFileBlobs(ctx context.Context, w *rowWriter, blobs []models.FileBlob) error {
+	w.blobs = make(map[string]bool, len(blobs))
+	for _, b := range blobs {
+		params := storage.InsertFileBlobParams{
+			ContentSha256: b.SHA256,
+			Content:       b.Content,
+			SizeBytes:     int64(len(b.Content)),
+		}
+		written := false
+		err := w.exec(ctx, "file_blobs", 1, func() error {
+			err := w.queries.InsertFileBlob(ctx, params)
+			written = err == nil
+			return err
+		}, "sha256", b.SHA256)
+		if err != nil {
+			return err
+		}
+		w.blobs[b.SHA256] = written
+	}
+	return nil
+}
+
+func insertLanguages(ctx context.Context, w *rowWriter, repoID int64, languages []rows.Language, snapshotDate time.Time) error {
+	for chunk := range slices.Chunk(languages, bulkInsertChunkSize) {
+		params := storage.InsertOrUpdateRepoLanguagesParams{RepoID: repoID, HentetDato: snapshotDate}
+		for _, l := range chunk {
+			params.Languages = append(params.Languages, l.Language)
+			params.Bytes = append(params.Bytes, l.Bytes)
+		}
+		err := w.exec(ctx, "repo_languages", len(chunk), func() error {
+			return w.queries.InsertOrUpdateRepoLanguages(ctx, params)
 		})
 		if err != nil {
-			slog.Warn("Språkfeil", "repo", name, "language", lang, "error", err)
+			return err
 		}
 	}
+	return nil
 }
 
 func insertDockerfiles(
 	ctx context.Context,
-	queries *storage.Queries,
+	w *rowWriter,
 	repoID int64,
 	name string,
-	files map[string][]models.FileEntry,
+	dockerfiles []rows.Dockerfile,
 	snapshotDate time.Time,
-) {
-	for filetype, fileEntries := range files {
-		if !strings.HasPrefix(strings.ToLower(filetype), "dockerfile") {
+) error {
+	for _, d := range dockerfiles {
+		if !w.blobs[d.ContentSHA256] {
+			w.blobMissing("dockerfiles", "fil", d.Path)
 			continue
 		}
-		for _, f := range fileEntries {
-			features, _ := parser.ParseDockerfile(f.Content)
-			_, err := queries.InsertOrUpdateDockerfile(ctx, storage.InsertOrUpdateDockerfileParams{
-				RepoID:                        repoID,
-				HentetDato:                    snapshotDate,
-				FullName:                      name,
-				Path:                          f.Path,
-				Content:                       f.Content,
-				BaseImage:                     sql.NullString{String: features.BaseImage, Valid: features.BaseImage != ""},
-				BaseTag:                       sql.NullString{String: features.BaseTag, Valid: features.BaseTag != ""},
-				UsesLatestTag:                 sql.NullBool{Bool: features.UsesLatestTag, Valid: true},
-				HasUserInstruction:            sql.NullBool{Bool: features.HasUserInstruction, Valid: true},
-				HasCopySensitive:              sql.NullBool{Bool: features.HasCopySensitive, Valid: true},
-				HasPackageInstalls:            sql.NullBool{Bool: features.HasPackageInstalls, Valid: true},
-				UsesMultistage:                sql.NullBool{Bool: features.UsesMultistage, Valid: true},
-				HasHealthcheck:                sql.NullBool{Bool: features.HasHealthcheck, Valid: true},
-				UsesAddInstruction:            sql.NullBool{Bool: features.UsesAddInstruction, Valid: true},
-				HasLabelMetadata:              sql.NullBool{Bool: features.HasLabelMetadata, Valid: true},
-				HasExpose:                     sql.NullBool{Bool: features.HasExpose, Valid: true},
-				HasEntrypointOrCmd:            sql.NullBool{Bool: features.HasEntrypointOrCmd, Valid: true},
-				InstallsCurlOrWget:            sql.NullBool{Bool: features.InstallsCurlOrWget, Valid: true},
-				InstallsBuildTools:            sql.NullBool{Bool: features.InstallsBuildTools, Valid: true},
-				HasAptGetClean:                sql.NullBool{Bool: features.HasAptGetClean, Valid: true},
-				WorldWritable:                 sql.NullBool{Bool: features.WorldWritable, Valid: true},
-				HasSecretsInEnvOrArg:          sql.NullBool{Bool: features.HasSecretsInEnvOrArg, Valid: true},
-				UsesNpmInstall:                sql.NullBool{Bool: features.UsesNpmInstall, Valid: true},
-				UsesNpmCiWithoutIgnoreScripts: sql.NullBool{Bool: features.UsesNpmCiWithoutIgnoreScripts, Valid: true},
-				UsesYarnInstallWithoutFrozen:  sql.NullBool{Bool: features.UsesYarnInstallWithoutFrozen, Valid: true},
-				UsesNpx:                       sql.NullBool{Bool: features.UsesNpx, Valid: true},
-				UsesPipInstallWithoutNoCache:  sql.NullBool{Bool: features.UsesPipInstallWithoutNoCache, Valid: true},
-				UsesPipInstallWithoutHashes:   sql.NullBool{Bool: features.UsesPipInstallWithoutHashes, Valid: true},
-				UsesCurlBashPipe:              sql.NullBool{Bool: features.UsesCurlBashPipe, Valid: true},
-			})
-			if err != nil {
-				slog.Warn("Dockerfile-feil", "repo", name, "fil", f.Path, "error", err)
-				continue
-			}
-
+		features := d.Features
+		params := storage.InsertOrUpdateDockerfileParams{
+			RepoID:                               repoID,
+			HentetDato:                           snapshotDate,
+			FullName:                             name,
+			Path:                                 d.Path,
+			ContentSha256:                        d.ContentSHA256,
+			BaseImage:                            sql.NullString{String: features.BaseImage, Valid: features.BaseImage != ""},
+			BaseTag:                              sql.NullString{String: features.BaseTag, Valid: features.BaseTag != ""},
+			UsesLatestTag:                        sql.NullBool{Bool: features.UsesLatestTag, Valid: true},
+			HasUserInstruction:                   sql.NullBool{Bool: features.HasUserInstruction, Valid: true},
+			HasCopySensitive:                     sql.NullBool{Bool: features.HasCopySensitive, Valid: true},
+			HasPackageInstalls:                   sql.NullBool{Bool: features.HasPackageInstalls, Valid: true},
+			UsesMultistage:                       sql.NullBool{Bool: features.UsesMultistage, Valid: true},
+			HasHealthcheck:                       sql.NullBool{Bool: features.HasHealthcheck, Valid: true},
+			UsesAddInstruction:                   sql.NullBool{Bool: features.UsesAddInstruction, Valid: true},
+			HasLabelMetadata:                     sql.NullBool{Bool: features.HasLabelMetadata, Valid: true},
+			HasExpose:                            sql.NullBool{Bool: features.HasExpose, Valid: true},
+			HasEntrypointOrCmd:                   sql.NullBool{Bool: features.HasEntrypointOrCmd, Valid: true},
+			InstallsCurlOrWget:                   sql.NullBool{Bool: features.InstallsCurlOrWget, Valid: true},
+			InstallsBuildTools:                   sql.NullBool{Bool: features.InstallsBuildTools, Valid: true},
+			HasAptGetClean:                       sql.NullBool{Bool: features.HasAptGetClean, Valid: true},
+			WorldWritable:                        sql.NullBool{Bool: features.WorldWritable, Valid: true},
+			HasSecretsInEnvOrArg:                 sql.NullBool{Bool: features.HasSecretsInEnvOrArg, Valid: true},
+			UsesNpmInstall:                       sql.NullBool{Bool: features.UsesNpmInstall, Valid: true},
+			UsesNpmCiWithoutIgnoreScripts:        sql.NullBool{Bool: features.UsesNpmCiWithoutIgnoreScripts, Valid: true},
+			UsesYarnInstallWithoutFrozen:         sql.NullBool{Bool: features.UsesYarnInstallWithoutFrozen, Valid: true},
+			UsesNpx:                              sql.NullBool{Bool: features.UsesNpx, Valid: true},
+			UsesPipInstallWithoutNoCache:         sql.NullBool{Bool: features.UsesPipInstallWithoutNoCache, Valid: true},
+			UsesPipInstallWithoutHashes:          sql.NullBool{Bool: features.UsesPipInstallWithoutHashes, Valid: true},
+			UsesCurlBashPipe:                     sql.NullBool{Bool: features.UsesCurlBashPipe, Valid: true},
+			UsesPnpmInstallWithoutFrozen:         features.UsesPnpmInstallWithoutFrozen,
+			UsesBunInstallWithoutFrozen:          features.UsesBunInstallWithoutFrozen,
+			UsesGoInstallLatest:                  features.UsesGoInstallLatest,
+			UsesGemInstallWithoutVersion:         features.UsesGemInstallWithoutVersion,
+			UsesApkAddWithoutNoCache:             features.UsesApkAddWithoutNoCache,
+			UsesAptGetInstallWithoutNoRecommends: features.UsesAptGetInstallWithoutNoRecommends,
+			UsesPoetryInstallWithoutLockCheck:    features.UsesPoetryInstallWithoutLockCheck,
+			UsesUvPipInstallWithoutHashes:        features.UsesUvPipInstallWithoutHashes,
+			UsesGitCloneUnpinned:                 features.UsesGitCloneUnpinned,
+		}
+		err := w.exec(ctx, "dockerfiles", 1, func() error {
+			_, err := w.queries.InsertOrUpdateDockerfile(ctx, params)
+			return err
+		}, "fil", d.Path)
+		if err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
 func insertCIConfig(
 	ctx context.Context,
-	queries *storage.Queries,
+	w *rowWriter,
 	repoID int64,
-	name string,
-	files []models.FileEntry,
+	configs []rows.CIConfig,
 	snapshotDate time.Time,
-) {
-	for _, f := range files {
-		features := parser.ParseCIConfig(f.Content)
-		if err := queries.InsertOrUpdateCIConfig(ctx, storage.InsertOrUpdateCIConfigParams{
-			RepoID:                        repoID,
-			HentetDato:                    snapshotDate,
-			Path:                          f.Path,
-			Content:                       f.Content,
-			UsesNpmInstall:                sql.NullBool{Bool: features.UsesNpmInstall, Valid: true},
-			UsesNpmCiWithoutIgnoreScripts: sql.NullBool{Bool: features.UsesNpmCiWithoutIgnoreScripts, Valid: true},
-			UsesYarnInstallWithoutFrozen:  sql.NullBool{Bool: features.UsesYarnInstallWithoutFrozen, Valid: true},
-			UsesNpx:                       sql.NullBool{Bool: features.UsesNpx, Valid: true},
-			UsesPipInstallWithoutNoCache:  sql.NullBool{Bool: features.UsesPipInstallWithoutNoCache, Valid: true},
-			UsesPipInstallWithoutHashes:   sql.NullBool{Bool: features.UsesPipInstallWithoutHashes, Valid: true},
-			UsesCurlBashPipe:              sql.NullBool{Bool: features.UsesCurlBashPipe, Valid: true},
-			UsesSudo:                      sql.NullBool{Bool: features.UsesSudo, Valid: true},
-			UsesPackagePublish:            features.UsesPackagePublish,
-			UsesPullRequestTarget:         features.UsesPullRequestTarget,
-			SecretNames:                   features.SecretNames,
-		}); err != nil {
-			slog.Warn("CI-feil", "repo", name, "fil", f.Path, "error", err)
+) error {
+	for _, c := range configs {
+		if !w.blobs[c.ContentSHA256] {
+			w.blobMissing("ci_configs", "fil", c.Path)
+			continue
+		}
+		features := c.Features
+		params := storage.InsertOrUpdateCIConfigParams{
+			RepoID:                               repoID,
+			HentetDato:                           snapshotDate,
+			Path:                                 c.Path,
+			ContentSha256:                        c.ContentSHA256,
+			UsesNpmInstall:                       sql.NullBool{Bool: features.UsesNpmInstall, Valid: true},
+			UsesNpmCiWithoutIgnoreScripts:        sql.NullBool{Bool: features.UsesNpmCiWithoutIgnoreScripts, Valid: true},
+			UsesYarnInstallWithoutFrozen:         sql.NullBool{Bool: features.UsesYarnInstallWithoutFrozen, Valid: true},
+			UsesNpx:                              sql.NullBool{Bool: features.UsesNpx, Valid: true},
+			UsesPipInstallWithoutNoCache:         sql.NullBool{Bool: features.UsesPipInstallWithoutNoCache, Valid: true},
+			UsesPipInstallWithoutHashes:          sql.NullBool{Bool: features.UsesPipInstallWithoutHashes, Valid: true},
+			UsesCurlBashPipe:                     sql.NullBool{Bool: features.UsesCurlBashPipe, Valid: true},
+			UsesSudo:                             sql.NullBool{Bool: features.UsesSudo, Valid: true},
+			UsesPackagePublish:                   features.UsesPackagePublish,
+			UsesPullRequestTarget:                features.UsesPullRequestTarget,
+			SecretNames:                          features.SecretNames,
+			UsesPnpmInstallWithoutFrozen:         features.UsesPnpmInstallWithoutFrozen,
+			UsesBunInstallWithoutFrozen:          features.UsesBunInstallWithoutFrozen,
+			UsesGoInstallLatest:                  features.UsesGoInstallLatest,
+			UsesGemInstallWithoutVersion:         features.UsesGemInstallWithoutVersion,
+			UsesApkAddWithoutNoCache:             features.UsesApkAddWithoutNoCache,
+			UsesAptGetInstallWithoutNoRecommends: features.UsesAptGetInstallWithoutNoRecommends,
+			UsesPoetryInstallWithoutLockCheck:    features.UsesPoetryInstallWithoutLockCheck,
+			UsesUvPipInstallWithoutHashes:        features.UsesUvPipInstallWithoutHashes,
+			UsesGitCloneUnpinned:                 features.UsesGitCloneUnpinned,
+		}
+		err := w.exec(ctx, "ci_configs", 1, func() error {
+			return w.queries.InsertOrUpdateCIConfig(ctx, params)
+		}, "fil", c.Path)
+		if err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
-func insertSBOMPackagesGithub(
+func insertCIWorkflowCalls(
 	ctx context.Context,
-	queries *storage.Queries,
+	w *rowWriter,
 	repoID int64,
-	name string,
-	sbomRaw map[string]interface{},
+	calls []models.WorkflowCall,
 	snapshotDate time.Time,
-) {
-	if sbomRaw == nil {
-		return
-	}
-
-	sbomInner, ok := sbomRaw["sbom"].(map[string]interface{})
-	if !ok {
-		slog.Warn("Ugyldig sbom-format", "repo", name)
-		return
-	}
-
-	packages, ok := sbomInner["packages"].([]interface{})
-	if !ok {
-		slog.Warn("Ingen pakker i sbom", "repo", name)
-		return
-	}
-
-	for _, p := range packages {
-		pkg, ok := p.(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		nameVal := SafeString(pkg["name"])
-		version := SafeString(pkg["versionInfo"])
-		license := SafeString(pkg["licenseConcluded"])
-
-		// Prøv å hente ut PURL (Package URL) fra externalRefs
-		var purl string
-		if refs, ok := pkg["externalRefs"].([]interface{}); ok {
-			for _, ref := range refs {
-				refMap, ok := ref.(map[string]interface{})
-				if !ok {
-					continue
-				}
-				if refMap["referenceType"] == "purl" {
-					purl = SafeString(refMap["referenceLocator"])
-					break
-				}
-			}
+) error {
+	// Samme kall kan stå flere ganger, og ON CONFLICT DO UPDATE tåler ikke
+	// duplikater i én spørring. rows.FromEntry lar siste forekomst vinne.
+	for chunk := range slices.Chunk(calls, bulkInsertChunkSize) {
+		params := storage.InsertOrUpdateCIWorkflowCallsParams{RepoID: repoID, HentetDato: snapshotDate}
+		for _, c := range chunk {
+			params.CallerPaths = append(params.CallerPaths, c.Caller)
+			params.Jobs = append(params.Jobs, c.Job)
+			params.Uses = append(params.Uses, c.Uses)
+			params.Kinds = append(params.Kinds, c.Kind)
+			params.Targets = append(params.Targets, c.Target)
+			params.IsLocal = append(params.IsLocal, c.Local)
+			params.Resolved = append(params.Resolved, c.Resolved)
 		}
-
-		err := queries.InsertOrUpdateGithubSBOM(ctx, storage.InsertOrUpdateGithubSBOMParams{
-			RepoID:     repoID,
-			HentetDato: snapshotDate,
-			Name:       nameVal,
-			Version:    sql.NullString{String: version, Valid: version != ""},
-			License:    sql.NullString{String: license, Valid: license != ""},
-			Purl:       sql.NullString{String: purl, Valid: purl != ""},
+		err := w.exec(ctx, "ci_workflow_calls", len(chunk), func() error {
+			return w.queries.InsertOrUpdateCIWorkflowCalls(ctx, params)
 		})
 		if err != nil {
-			slog.Warn("SBOM-insert-feil", "repo", name, "package", nameVal, "error", err)
+			return err
 		}
 	}
+	return nil
 }
 
-func SafeLicense(lic *struct{ SpdxID string }) string {
-	if lic == nil {
-		return ""
+func insertSecretFindings(
+	ctx context.Context,
+	w *rowWriter,
+	repoID int64,
+	findings []parser.SecretFinding,
+	snapshotDate time.Time,
+) error {
+	for chunk := range slices.Chunk(findings, bulkInsertChunkSize) {
+		params := storage.InsertSecretFindingsParams{RepoID: repoID, HentetDato: snapshotDate}
+		for _, f := range chunk {
+			params.Paths = append(params.Paths, f.Path)
+			params.Lines = append(params.Lines, int32(f.Line))
+			params.Rules = append(params.Rules, f.Rule)
+		}
+		err := w.exec(ctx, "secret_findings", len(chunk), func() error {
+			return w.queries.InsertSecretFindings(ctx, params)
+		})
+		if err != nil {
+			return err
+		}
 	}
-	return lic.SpdxID
+	return nil
 }
 
-func SafeString(v interface{}) string {
-	if v == nil {
-		return ""
+func insertSBOMPackagesGithub(
+	ctx context.Context,
+	w *rowWriter,
+	repoID int64,
+	packages []rows.SBOMPackage,
+	snapshotDate time.Time,
+) error {
+	// ON CONFLICT DO UPDATE tåler ikke duplikater i én spørring, så pakkene
+	// må være unike på navn og versjon, slik rows.FromEntry lager dem.
+	for chunk := range slices.Chunk(packages, bulkInsertChunkSize) {
+		params := storage.InsertOrUpdateGithubSBOMPackagesParams{RepoID: repoID, HentetDato: snapshotDate}
+		for _, p := range chunk {
+			params.Names = append(params.Names, p.Name)
+			params.Versions = append(params.Versions, p.Version)
+			params.Licenses = append(params.Licenses, p.License)
+			params.Purls = append(params.Purls, p.PURL)
+		}
+		err := w.exec(ctx, "sbom_github_packages", len(chunk), func() error {
+			return w.queries.InsertOrUpdateGithubSBOMPackages(ctx, params)
+		})
+		if err != nil {
+			return err
+		}
 	}
-	return v.(string)
+	return nil
 }
 
 func marshalToJSONRawMessage(v interface{}) json.RawMessage {
@@ -0,0 +1,56 @@
+package dbwriter
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+	"github.com/jonmartinstorm/reposnusern/internal/storage"
+)
+
+// StartRun lagrer en ny kjøring i snapshot_runs.
+func (p *PostgresWriter) StartRun(ctx context.Context, run models.SnapshotRun) error {
+	err := storage.New(p.DB).InsertSnapshotRun(ctx, storage.InsertSnapshotRunParams{
+		RunID:      run.ID,
+		Org:        run.Org,
+		HentetDato: run.StartedAt.Truncate(24 * time.Hour),
+		StartedAt:  run.StartedAt,
+		Status:     string(run.Status),
+		Version:    run.Version,
+		Config:     run.Config,
+	})
+	if err != nil {
+		return fmt.Errorf("kunne ikke lagre kjøring: %w", err)
+	}
+	return nil
+}
+
+// FinishRun oppdaterer kjøringen med status og tellere når den er ferdig.
+func (p *PostgresWriter) FinishRun(ctx context.Context, run models.SnapshotRun) error {
+	failedRepos := run.FailedRepos
+	if failedRepos == nil {
+		failedRepos = []string{}
+	}
+	err := storage.New(p.DB).FinishSnapshotRun(ctx, storage.FinishSnapshotRunParams{
+		RunID:                run.ID,
+		FinishedAt:           sql.NullTime{Time: run.FinishedAt, Valid: !run.FinishedAt.IsZero()},
+		Status:               string(run.Status),
+		Error:                run.Error,
+		ReposProcessed:       run.ReposProcessed,
+		ReposFailedGraphql:   run.ReposFailedGraphQL,
+		ReposFailedRows:      run.ReposFailedRows,
+		RowsWritten:          run.RowsWritten,
+		RowsFailed:           run.RowsFailed,
+		ApiCalls:             run.APICalls,
+		RateLimitWaits:       run.RateLimitWaits,
+		RateLimitWaitSeconds: run.RateLimitWait.Seconds(),
+		GracefulShutdown:     run.GracefulShutdown,
+		FailedRepos:          failedRepos,
+	})
+	if err != nil {
+		return fmt.Errorf("kunne ikke oppdatere kjøring: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package fetcher
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/config"
+)
+
+// DefaultBaseURL is the REST API root of github.com.
+const DefaultBaseURL = "https://api.github.com"
+
+// APIEndpoints returns the REST root and GraphQL endpoint of the GitHub
+// instance at serverURL. An empty serverURL means github.com; anything else is
+// a GitHub Enterprise Server, which serves the APIs under /api/v3 and
+// /api/graphql. A serverURL that already ends in /api/v3 is accepted as well.
+func APIEndpoints(serverURL string) (restURL, graphQLURL string) {
+	serverURL = strings.TrimSuffix(serverURL, "/")
+	serverURL = strings.TrimSuffix(serverURL, "/api/v3")
+	if serverURL == "" || serverURL == "https://github.com" || serverURL == DefaultBaseURL {
+		return DefaultBaseURL, DefaultBaseURL + "/graphql"
+	}
+	return serverURL + "/api/v3", serverURL + "/api/graphql"
+}
+
+// Client carries everything needed to talk to one GitHub instance: endpoints,
+// transport, retry policy, rate limiter, concurrency control, response cache
+// and metrics. Fetchers sharing a Client share its budgets; separate Clients
+// are fully independent.
+type Client struct {
+	// BaseURL is the REST API root, without a trailing slash.
+	BaseURL string
+	// GraphQLURL is the GraphQL endpoint.
+	GraphQLURL string
+	// HTTP sends all requests. The default has a 30-second timeout to prevent
+	// requests from hanging indefinitely.
+	HTTP *http.Client
+	// RetryBackoff returns the wait duration before retry attempt n (1-indexed).
+	RetryBackoff func(attempt int) time.Duration
+	// SecondaryRateLimitBackoff is the wait after a secondary rate limit without
+	// a Retry-After header. GitHub asks clients to wait at least a minute.
+	SecondaryRateLimitBackoff time.Duration
+
+	Limiter     *ResourceRateLimiter
+	Concurrency *ConcurrencyController
+	// Cache is used for conditional core GET requests. nil disables caching.
+	Cache ResponseCache
+
+	apiCalls    atomic.Int64
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+	cacheErrors atomic.Int64
+}
+
+// ClientStats summarizes the requests a Client made.
+type ClientStats struct {
+	APICalls    int64
+	RateLimits  map[RateLimitResource]RateLimitStats
+	Concurrency ConcurrencyStats
+	Cache       ResponseCacheStats
+}
+
+// NewClient creates a client for github.com, or the GitHub Enterprise Server
+// in cfg.GitHubURL, using the rate limit reserve and parallelism from cfg.
+func NewClient(cfg config.Config) *Client {
+	limiter := NewResourceRateLimiter()
+	limiter.SetReservePercent(cfg.RateLimitReserve)
+	restURL, graphQLURL := APIEndpoints(cfg.GitHubURL)
+	return &Client{
+		BaseURL:    restURL,
+		GraphQLURL: graphQLURL,
+		HTTP: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		RetryBackoff:              defaultRetryBackoff,
+		SecondaryRateLimitBackoff: time.Minute,
+		Limiter:                   limiter,
+		Concurrency:               NewConcurrencyController(cfg.Parallelism),
+	}
+}
+
+func defaultRetryBackoff(attempt int) time.Duration {
+	return time.Duration(1<<uint(attempt-1)) * time.Second
+}
+
+// Stats returns a snapshot of the client's counters.
+func (c *Client) Stats() ClientStats {
+	return ClientStats{
+		APICalls:    c.apiCalls.Load(),
+		RateLimits:  c.Limiter.Stats(),
+		Concurrency: c.Concurrency.Stats(),
+		Cache: ResponseCacheStats{
+			Hits:   c.cacheHits.Load(),
+			Misses: c.cacheMisses.Load(),
+			Errors: c.cacheErrors.Load(),
+		},
+	}
+}
+
+// Enterprise reports whether the client talks to a GitHub Enterprise Server
+// rather than github.com.
+func (c *Client) Enterprise() bool {
+	return c.BaseURL != DefaultBaseURL
+}
+
+// restURL joins the REST base URL and an API path such as "/repos/o/r".
+func (c *Client) restURL(path string) string {
+	return strings.TrimSuffix(c.BaseURL, "/") + path
+}
@@ -0,0 +1,69 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/config"
+)
+
+// newTestClient returns a client that sends REST and GraphQL requests to ts
+// and retries without delay.
+func newTestClient(ts *httptest.Server) *Client {
+	c := NewClient(config.Config{})
+	c.BaseURL = ts.URL
+	c.GraphQLURL = ts.URL + "/graphql"
+	c.HTTP = ts.Client()
+	c.RetryBackoff = func(int) time.Duration { return time.Millisecond }
+	return c
+}
+
+func TestClientsKeepSeparateBudgetsAndMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `{}`)
+	}))
+	defer ts.Close()
+
+	blocked, free := newTestClient(ts), newTestClient(ts)
+	blocked.Limiter.BlockFor(RateLimitResourceCore, time.Hour)
+
+	start := time.Now()
+	var out map[string]interface{}
+	if err := free.doRequest(context.Background(), RateLimitResourceCore, "GET", ts.URL, "token", nil, &out, false); err != nil {
+		t.Fatalf("request returned error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("request waited on another client's block: %s", elapsed)
+	}
+
+	if got := free.Stats().APICalls; got != 1 {
+		t.Fatalf("expected 1 API call on the client used, got %d", got)
+	}
+	if got := blocked.Stats().APICalls; got != 0 {
+		t.Fatalf("expected no API calls on the other client, got %d", got)
+	}
+}
+
+func TestNewClientAppliesConfig(t *testing.T) {
+	c := NewClient(config.Config{Parallelism: 3, RateLimitReserve: 50})
+	if c.BaseURL != DefaultBaseURL || c.GraphQLURL != DefaultBaseURL+"/graphql" {
+		t.Fatalf("unexpected endpoints %q and %q", c.BaseURL, c.GraphQLURL)
+	}
+	if got := c.Concurrency.Stats().Limit; got != 3 {
+		t.Fatalf("expected concurrency limit from parallelism, got %d", got)
+	}
+
+	// With half the limit reserved, a budget at 40% must wait for reset.
+	c.Limiter.Observe(RateLimitResourceCore, 100, 40, time.Now().Add(50*time.Millisecond))
+	start := time.Now()
+	if err := c.Limiter.Wait(context.Background(), RateLimitResourceCore); err != nil {
+		t.Fatalf("wait returned error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Fatalf("expected reserve from config to hold back the budget, waited %s", elapsed)
+	}
+}
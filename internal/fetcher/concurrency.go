@@ -0,0 +1,131 @@
+package fetcher
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// concurrencyIncreaseAfter is the number of successful responses in a row
+// needed before the in-flight limit is raised by one again.
+const concurrencyIncreaseAfter = 50
+
+// ConcurrencyStats summarizes how the adaptive in-flight limit moved during a run.
+type ConcurrencyStats struct {
+	Limit     int // current limit, 0 means unlimited
+	Lowest    int // lowest limit reached, 0 if never lowered
+	Decreases int64
+	Increases int64
+}
+
+// ConcurrencyController caps the number of in-flight GitHub requests. It halves
+// the cap when GitHub reports a secondary rate limit and raises it by one after
+// a run of successful responses, up to the configured maximum.
+type ConcurrencyController struct {
+	mu        sync.Mutex
+	limit     int
+	ceiling   int
+	inFlight  int
+	successes int
+	stats     ConcurrencyStats
+	changed   chan struct{}
+}
+
+// NewConcurrencyController creates a controller allowing n in-flight requests.
+// An n of 0 leaves requests unlimited until a secondary limit hits.
+func NewConcurrencyController(n int) *ConcurrencyController {
+	c := &ConcurrencyController{changed: make(chan struct{})}
+	c.SetMax(n)
+	return c
+}
+
+// SetMax sets the upper bound for the limit to n and resets the limit and stats.
+func (c *ConcurrencyController) SetMax(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.limit = max(n, 0)
+	c.ceiling = c.limit
+	c.successes = 0
+	c.stats = ConcurrencyStats{}
+	c.notify()
+}
+
+// Acquire blocks until another request may be sent.
+func (c *ConcurrencyController) Acquire(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if c.limit == 0 || c.inFlight < c.limit {
+			c.inFlight++
+			c.mu.Unlock()
+			return nil
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release frees the slot taken by Acquire.
+func (c *ConcurrencyController) Release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inFlight--
+	c.notify()
+}
+
+// OnSuccess records a response that did not hit a secondary limit.
+func (c *ConcurrencyController) OnSuccess() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.limit == 0 || c.limit >= c.ceiling {
+		return
+	}
+	c.successes++
+	if c.successes < concurrencyIncreaseAfter {
+		return
+	}
+	c.successes = 0
+	c.limit++
+	c.stats.Increases++
+	c.notify()
+	slog.Info("Øker antall samtidige GitHub-kall", "grense", c.limit)
+}
+
+// OnSecondaryLimit halves the limit. When no limit was set, the number of
+// requests in flight when the limit hit becomes the ceiling.
+func (c *ConcurrencyController) OnSecondaryLimit() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	base := c.limit
+	if base == 0 {
+		base = c.inFlight + 1
+		c.ceiling = base
+	}
+	c.limit = max(1, base/2)
+	c.successes = 0
+	c.stats.Decreases++
+	if c.stats.Lowest == 0 || c.limit < c.stats.Lowest {
+		c.stats.Lowest = c.limit
+	}
+	slog.Warn("Reduserer antall samtidige GitHub-kall etter sekundær rate limit", "grense", c.limit)
+}
+
+// Stats returns a snapshot of the controller state.
+func (c *ConcurrencyController) Stats() ConcurrencyStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Limit = c.limit
+	return stats
+}
+
+// notify wakes up every waiting Acquire. Callers must hold c.mu.
+func (c *ConcurrencyController) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
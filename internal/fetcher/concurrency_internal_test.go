@@ -0,0 +1,148 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+)
+
+func TestConcurrencyControllerHalvesAndRecovers(t *testing.T) {
+	c := NewConcurrencyController(4)
+
+	c.OnSecondaryLimit()
+	if got := c.Stats().Limit; got != 2 {
+		t.Fatalf("expected limit 2 after secondary limit, got %d", got)
+	}
+	c.OnSecondaryLimit()
+	c.OnSecondaryLimit()
+	if got := c.Stats().Limit; got != 1 {
+		t.Fatalf("expected limit to bottom out at 1, got %d", got)
+	}
+
+	for range 3 * concurrencyIncreaseAfter {
+		c.OnSuccess()
+	}
+	stats := c.Stats()
+	if stats.Limit != 4 {
+		t.Fatalf("expected limit to recover to the max of 4, got %d", stats.Limit)
+	}
+	if stats.Lowest != 1 || stats.Decreases != 3 || stats.Increases != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestConcurrencyControllerBlocksAtLimit(t *testing.T) {
+	c := NewConcurrencyController(1)
+	if err := c.Acquire(context.Background()); err != nil {
+		t.Fatalf("first acquire returned error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.Acquire(ctx); err == nil {
+		t.Fatal("expected second acquire to block while the only slot is taken")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.Release()
+	}()
+	if err := c.Acquire(context.Background()); err != nil {
+		t.Fatalf("expected acquire to succeed after release, got %v", err)
+	}
+}
+
+func TestConcurrencyControllerUnlimitedUsesInFlightAsCeiling(t *testing.T) {
+	c := NewConcurrencyController(0)
+	for range 6 {
+		if err := c.Acquire(context.Background()); err != nil {
+			t.Fatalf("acquire returned error: %v", err)
+		}
+	}
+	c.Release()
+
+	c.OnSecondaryLimit()
+	if got := c.Stats().Limit; got != 3 {
+		t.Fatalf("expected limit of half the 6 requests in flight, got %d", got)
+	}
+}
+
+func TestDoRequestBacksOffOnSecondaryRateLimit(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = fmt.Fprintln(w, `{"message":"You have exceeded a secondary rate limit. Please wait a few minutes before you try again.","documentation_url":"https://docs.github.com/rest/overview/rate-limits-for-the-rest-api#about-secondary-rate-limits"}`)
+			return
+		}
+		_, _ = fmt.Fprintln(w, `{"ok":true}`)
+	}))
+	defer ts.Close()
+	c := newTestClient(ts)
+	c.Concurrency = NewConcurrencyController(4)
+	c.SecondaryRateLimitBackoff = 30 * time.Millisecond
+
+	var out map[string]bool
+	start := time.Now()
+	if err := c.doRequest(context.Background(), RateLimitResourceCore, "GET", ts.URL, "token", nil, &out, false); err != nil {
+		t.Fatalf("request returned error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("expected secondary limit backoff, took %s", elapsed)
+	}
+	if !out["ok"] || calls != 2 {
+		t.Fatalf("expected retry to succeed after one secondary limit, calls=%d out=%v", calls, out)
+	}
+
+	stats := c.Limiter.Stats()[RateLimitResourceCore]
+	if stats.SecondaryHits != 1 || stats.Hits != 0 {
+		t.Fatalf("expected secondary hit to be counted apart from primary hits, got %+v", stats)
+	}
+	if got := c.Concurrency.Stats().Limit; got != 2 {
+		t.Fatalf("expected concurrency limit to be halved to 2, got %d", got)
+	}
+}
+
+func TestDoRequestGivesUpAfterRepeatedSecondaryRateLimits(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = fmt.Fprintln(w, `{"message":"You have exceeded a secondary rate limit."}`)
+	}))
+	defer ts.Close()
+	c := newTestClient(ts)
+	c.SecondaryRateLimitBackoff = time.Millisecond
+
+	var out map[string]bool
+	err := c.doRequest(context.Background(), RateLimitResourceCore, "GET", ts.URL, "token", nil, &out, false)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Attempts != MaxSecondaryRateLimitAttempts {
+		t.Fatalf("expected APIError after %d attempts, got %v", MaxSecondaryRateLimitAttempts, err)
+	}
+	if calls != MaxSecondaryRateLimitAttempts {
+		t.Fatalf("expected %d calls, got %d", MaxSecondaryRateLimitAttempts, calls)
+	}
+	if got := ClassifyFetchError(err); got != models.FetchErrorRateLimit {
+		t.Fatalf("expected rate_limit class, got %q", got)
+	}
+}
+
+func TestIsSecondaryRateLimitIgnoresPrimaryLimit(t *testing.T) {
+	if isSecondaryRateLimit(http.StatusForbidden, []byte(`{"message":"API rate limit exceeded for user ID 1."}`)) {
+		t.Fatal("primary rate limit must not be treated as secondary")
+	}
+	if !isSecondaryRateLimit(http.StatusTooManyRequests, []byte(`{"message":"You have triggered an abuse detection mechanism."}`)) {
+		t.Fatal("expected abuse detection message to be treated as secondary")
+	}
+	if isSecondaryRateLimit(http.StatusOK, []byte(`secondary rate limit`)) {
+		t.Fatal("successful responses are never rate limits")
+	}
+}
@@ -0,0 +1,138 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/config"
+)
+
+// Credential is one way of authenticating against GitHub. Each credential has
+// its own core and GraphQL budgets in the client's limiter, tracked under Name.
+type Credential struct {
+	Name   string
+	Source TokenSource
+}
+
+// credentialLease is the credential picked for a single request, together
+// with the limiter key its budget is tracked under.
+type credentialLease struct {
+	key   rateLimitKey
+	token string
+}
+
+// requestAuth picks the credential for the next request and waits until its
+// budget for the resource in limiter allows the request.
+type requestAuth interface {
+	acquire(ctx context.Context, limiter *ResourceRateLimiter, resource RateLimitResource) (credentialLease, error)
+}
+
+// CredentialPool spreads requests over several tokens and GitHub App
+// installations. Each request goes to the credential with the most budget
+// left for its resource, so requests only wait when every credential is
+// blocked or exhausted.
+type CredentialPool struct {
+	credentials []Credential
+	err         error // returned from every acquire when the pool could not be built
+}
+
+var errNoCredentials = errors.New("no authentication token available")
+
+// CredentialsFromConfig returns one credential per GitHub App installation
+// and token in cfg. Duplicate tokens are only used once. App installation
+// tokens are cached until shortly before they expire, and minted over
+// transport, normally the Client's, so they are recorded and replayed with the
+// rest of the traffic.
+func CredentialsFromConfig(cfg config.Config, transport http.RoundTripper) ([]Credential, error) {
+	var credentials []Credential
+	if cfg.Feature_GitHubApp && cfg.GitHubAppConfig != nil {
+		baseURL, _ := APIEndpoints(cfg.GitHubURL)
+		installations := append([]int64{cfg.GitHubAppConfig.InstallationID}, cfg.GitHubAppConfig.ExtraInstallationIDs...)
+		for _, id := range installations {
+			appCfg := *cfg.GitHubAppConfig
+			appCfg.InstallationID = id
+			source, err := NewGitHubAppTokenSource(&appCfg, baseURL, transport)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create GitHub App token source: %w", err)
+			}
+			credentials = append(credentials, Credential{Name: fmt.Sprintf("app-%d", id), Source: source})
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, token := range append([]string{cfg.Token}, cfg.Tokens...) {
+		if token == "" || seen[token] {
+			continue
+		}
+		seen[token] = true
+		credentials = append(credentials, Credential{Name: fmt.Sprintf("token-%d", len(seen)), Source: StaticTokenSource(token)})
+	}
+	return credentials, nil
+}
+
+// NewCredentialPool creates a pool that spreads requests over credentials.
+func NewCredentialPool(credentials []Credential) *CredentialPool {
+	credentials = append([]Credential(nil), credentials...)
+	// A single credential keeps the unnamed budget, so it behaves exactly like
+	// requests made with a static token.
+	if len(credentials) == 1 {
+		credentials[0].Name = ""
+	}
+	if len(credentials) > 1 {
+		slog.Info("Fordeler GitHub-kall på flere credentials", "antall", len(credentials))
+	}
+	return &CredentialPool{credentials: credentials}
+}
+
+// Size returns the number of credentials in the pool.
+func (p *CredentialPool) Size() int {
+	return len(p.credentials)
+}
+
+func (p *CredentialPool) acquire(ctx context.Context, limiter *ResourceRateLimiter, resource RateLimitResource) (credentialLease, error) {
+	if p.err != nil {
+		return credentialLease{}, p.err
+	}
+	if len(p.credentials) == 0 {
+		return credentialLease{}, errNoCredentials
+	}
+
+	c := p.pick(limiter, resource, time.Now())
+	key := rateLimitKey{credential: c.Name, resource: resource}
+	if err := limiter.waitFor(ctx, key); err != nil {
+		return credentialLease{}, err
+	}
+	token, err := c.Source.Token(ctx)
+	if err != nil {
+		return credentialLease{}, err
+	}
+	return credentialLease{key: key, token: token}, nil
+}
+
+// pick returns the ready credential with the most usable budget. When none is
+// ready it returns the one that becomes ready first.
+func (p *CredentialPool) pick(limiter *ResourceRateLimiter, resource RateLimitResource, now time.Time) Credential {
+	best := p.credentials[0]
+	bestReadyAt, bestUsable := limiter.headroom(rateLimitKey{credential: best.Name, resource: resource}, now)
+	for _, c := range p.credentials[1:] {
+		readyAt, usable := limiter.headroom(rateLimitKey{credential: c.Name, resource: resource}, now)
+		ready, bestReady := !readyAt.After(now), !bestReadyAt.After(now)
+		var better bool
+		switch {
+		case ready && bestReady:
+			better = usable > bestUsable
+		case ready != bestReady:
+			better = ready
+		default:
+			better = readyAt.Before(bestReadyAt)
+		}
+		if better {
+			best, bestReadyAt, bestUsable = c, readyAt, usable
+		}
+	}
+	return best
+}
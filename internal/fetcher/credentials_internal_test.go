@@ -0,0 +1,95 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/config"
+)
+
+func TestCredentialPoolRoutesAroundExhaustedCredential(t *testing.T) {
+	var authHeaders []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeaders = append(authHeaders, r.Header.Get("Authorization"))
+		_, _ = fmt.Fprintln(w, `{}`)
+	}))
+	defer ts.Close()
+	client := newTestClient(ts)
+
+	f := NewRepoFetcherWithClient(config.Config{Token: "token-a", Tokens: []string{"token-b", "token-a"}}, client, nil)
+	if got := f.auth().Size(); got != 2 {
+		t.Fatalf("expected duplicate token to be dropped, got %d credentials", got)
+	}
+
+	resetAt := time.Now().Add(time.Hour)
+	client.Limiter.observe(rateLimitKey{credential: "token-1", resource: RateLimitResourceCore}, 5000, 0, resetAt)
+	client.Limiter.observe(rateLimitKey{credential: "token-2", resource: RateLimitResourceCore}, 5000, 4000, resetAt)
+
+	var out map[string]interface{}
+	for range 3 {
+		start := time.Now()
+		if err := f.getREST(context.Background(), ts.URL, &out, false); err != nil {
+			t.Fatalf("request returned error: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Fatalf("request waited for the exhausted credential: %s", elapsed)
+		}
+	}
+	for _, got := range authHeaders {
+		if got != "Bearer token-b" {
+			t.Fatalf("expected all requests on the credential with budget left, got %q", got)
+		}
+	}
+
+	stats := client.Limiter.Stats()[RateLimitResourceCore]
+	if stats.Limit != 10000 || stats.Remaining != 3997 {
+		t.Fatalf("expected stats summed over credentials, got %+v", stats)
+	}
+}
+
+func TestCredentialPoolPicksFirstReadyWhenAllBlocked(t *testing.T) {
+	limiter := NewResourceRateLimiter()
+
+	pool := NewCredentialPool([]Credential{{Name: "token-1", Source: StaticTokenSource("a")}, {Name: "token-2", Source: StaticTokenSource("b")}})
+	limiter.blockFor(rateLimitKey{credential: "token-1", resource: RateLimitResourceGraphQL}, time.Hour)
+	limiter.blockFor(rateLimitKey{credential: "token-2", resource: RateLimitResourceGraphQL}, 30*time.Millisecond)
+
+	start := time.Now()
+	lease, err := pool.acquire(context.Background(), limiter, RateLimitResourceGraphQL)
+	if err != nil {
+		t.Fatalf("acquire returned error: %v", err)
+	}
+	if lease.token != "b" {
+		t.Fatalf("expected the credential whose block ends first, got %q", lease.token)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("expected to wait for the block to end, took %s", elapsed)
+	}
+
+	// The core bucket of the same credentials is unaffected.
+	if lease, err = pool.acquire(context.Background(), limiter, RateLimitResourceCore); err != nil || lease.token != "a" {
+		t.Fatalf("expected first credential for core, got %q (err %v)", lease.token, err)
+	}
+}
+
+func TestCredentialPoolWithSingleTokenUsesUnnamedBudget(t *testing.T) {
+	limiter := NewResourceRateLimiter()
+
+	lease, err := NewCredentialPool([]Credential{{Name: "token-1", Source: StaticTokenSource("only")}}).acquire(context.Background(), limiter, RateLimitResourceCore)
+	if err != nil {
+		t.Fatalf("acquire returned error: %v", err)
+	}
+	if lease.key != (rateLimitKey{resource: RateLimitResourceCore}) || lease.token != "only" {
+		t.Fatalf("unexpected lease: %+v", lease)
+	}
+
+	_, err = NewCredentialPool(nil).acquire(context.Background(), limiter, RateLimitResourceCore)
+	if !errors.Is(err, errNoCredentials) {
+		t.Fatalf("expected errNoCredentials for an empty pool, got %v", err)
+	}
+}
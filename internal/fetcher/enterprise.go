@@ -0,0 +1,112 @@
+package fetcher
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/jonmartinstorm/reposnusern/internal/config"
+)
+
+// ServerInfo describes the GitHub instance a fetcher talks to.
+type ServerInfo struct {
+	Enterprise bool
+	// Version is the installed GitHub Enterprise Server version, such as
+	// "3.12.4". It is empty for github.com.
+	Version string
+}
+
+// enterpriseFeature is an optional feature that needs a minimum GitHub
+// Enterprise Server version.
+type enterpriseFeature struct {
+	name       string
+	minVersion string
+	flag       func(cfg *config.Config) *bool
+}
+
+var enterpriseFeatures = []enterpriseFeature{
+	// The SBOM export endpoint of the dependency graph arrived in 3.9.
+	{name: "SBOM", minVersion: "3.9", flag: func(cfg *config.Config) *bool { return &cfg.Feature_Sbom }},
+}
+
+// ProbeServer asks a GitHub Enterprise Server for its version and turns off
+// features the version does not support in r.Cfg, which callers should use
+// from then on. It does nothing for github.com. Instances with rate limiting
+// turned off send no X-RateLimit-* headers, so the limiter never paces them.
+func (r *RepoFetcher) ProbeServer(ctx context.Context) (ServerInfo, error) {
+	if !r.client.Enterprise() {
+		return ServerInfo{}, nil
+	}
+	info := ServerInfo{Enterprise: true}
+
+	var meta struct {
+		InstalledVersion string `json:"installed_version"`
+	}
+	if err := r.getREST(ctx, r.client.restURL("/meta"), &meta, false); err != nil {
+		return info, err
+	}
+	info.Version = meta.InstalledVersion
+
+	slog.Info("Bruker GitHub Enterprise Server", "url", r.client.BaseURL, "versjon", info.Version)
+	r.disableUnsupportedFeatures(info.Version)
+	return info, nil
+}
+
+// disableUnsupportedFeatures turns off the enabled features that need a newer
+// GitHub Enterprise Server than version.
+func (r *RepoFetcher) disableUnsupportedFeatures(version string) {
+	for _, feature := range enterpriseFeatures {
+		flag := feature.flag(&r.Cfg)
+		if !*flag {
+			continue
+		}
+		supported, ok := versionAtLeast(version, feature.minVersion)
+		if !ok {
+			slog.Warn("Ukjent GitHub Enterprise Server-versjon, beholder funksjonen", "funksjon", feature.name, "versjon", version)
+			continue
+		}
+		if !supported {
+			slog.Warn("Funksjonen støttes ikke av GitHub Enterprise Server-versjonen og slås av", "funksjon", feature.name, "versjon", version, "krever", feature.minVersion)
+			*flag = false
+		}
+	}
+}
+
+// versionAtLeast compares dotted version numbers. ok is false when version
+// cannot be parsed.
+func versionAtLeast(version, minVersion string) (atLeast, ok bool) {
+	have, ok := parseVersion(version)
+	if !ok {
+		return false, false
+	}
+	want, _ := parseVersion(minVersion)
+	for i := range max(len(have), len(want)) {
+		var h, w int
+		if i < len(have) {
+			h = have[i]
+		}
+		if i < len(want) {
+			w = want[i]
+		}
+		if h != w {
+			return h > w, true
+		}
+	}
+	return true, true
+}
+
+func parseVersion(version string) ([]int, bool) {
+	if version == "" {
+		return nil, false
+	}
+	var parts []int
+	for _, part := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+	return parts, true
+}
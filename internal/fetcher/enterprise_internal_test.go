@@ -0,0 +1,95 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/config"
+)
+
+func TestAPIEndpoints(t *testing.T) {
+	cases := []struct {
+		serverURL, rest, graphQL string
+	}{
+		{"", "https://api.github.com", "https://api.github.com/graphql"},
+		{"https://github.com", "https://api.github.com", "https://api.github.com/graphql"},
+		{"https://github.example.com", "https://github.example.com/api/v3", "https://github.example.com/api/graphql"},
+		{"https://github.example.com/api/v3/", "https://github.example.com/api/v3", "https://github.example.com/api/graphql"},
+	}
+	for _, tc := range cases {
+		rest, graphQL := APIEndpoints(tc.serverURL)
+		if rest != tc.rest || graphQL != tc.graphQL {
+			t.Errorf("APIEndpoints(%q) = %q, %q, want %q, %q", tc.serverURL, rest, graphQL, tc.rest, tc.graphQL)
+		}
+	}
+}
+
+func TestProbeServerDisablesUnsupportedFeatures(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/meta":
+			_, _ = fmt.Fprintln(w, `{"installed_version":"3.8.2"}`)
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := config.Config{Token: "token", GitHubURL: ts.URL, Feature_Sbom: true, Feature_RemoteCICalls: true}
+	client := NewClient(cfg)
+	client.HTTP = ts.Client()
+	client.RetryBackoff = func(int) time.Duration { return time.Millisecond }
+	f := NewRepoFetcherWithClient(cfg, client, nil)
+
+	info, err := f.ProbeServer(context.Background())
+	if err != nil {
+		t.Fatalf("probe returned error: %v", err)
+	}
+	if !info.Enterprise || info.Version != "3.8.2" {
+		t.Fatalf("unexpected server info: %+v", info)
+	}
+	if f.Cfg.Feature_Sbom {
+		t.Fatal("expected SBOM to be turned off before 3.9")
+	}
+	if !f.Cfg.Feature_RemoteCICalls {
+		t.Fatal("expected features without a version requirement to stay on")
+	}
+	if stats := client.Stats().RateLimits[RateLimitResourceCore]; stats.Limit != 0 {
+		t.Fatalf("expected no budget without rate-limit headers, got %+v", stats)
+	}
+}
+
+func TestProbeServerSkipsGitHubCom(t *testing.T) {
+	f := NewRepoFetcher(config.Config{Token: "token", Feature_Sbom: true})
+	info, err := f.ProbeServer(context.Background())
+	if err != nil || info.Enterprise {
+		t.Fatalf("unexpected probe result %+v (err %v)", info, err)
+	}
+	if f.Stats().APICalls != 0 || !f.Cfg.Feature_Sbom {
+		t.Fatal("expected github.com to be left untouched")
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	cases := []struct {
+		version, minVersion string
+		atLeast, ok         bool
+	}{
+		{"3.9.0", "3.9", true, true},
+		{"3.10.1", "3.9", true, true},
+		{"3.8.14", "3.9", false, true},
+		{"4.0", "3.9", true, true},
+		{"", "3.9", false, false},
+		{"3.x", "3.9", false, false},
+	}
+	for _, tc := range cases {
+		atLeast, ok := versionAtLeast(tc.version, tc.minVersion)
+		if atLeast != tc.atLeast || ok != tc.ok {
+			t.Errorf("versionAtLeast(%q, %q) = %v, %v", tc.version, tc.minVersion, atLeast, ok)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package fetcher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+)
+
+// ErrRateLimited is wrapped around errors from waits on a rate limit, so a
+// repo that was interrupted while waiting can be told apart from one that failed.
+var ErrRateLimited = errors.New("rate limited")
+
+// ErrParseRepoData means GraphQL answered, but the repository data could not be parsed.
+var ErrParseRepoData = errors.New("could not parse repository data")
+
+// APIError is a GitHub response with a status the client did not retry, or
+// gave up retrying.
+type APIError struct {
+	StatusCode int
+	// Attempts is set when the client gave up after retrying.
+	Attempts int
+	Body     string
+}
+
+func (e *APIError) Error() string {
+	if e.Attempts > 0 {
+		return fmt.Sprintf("GitHub API-feil etter %d forsøk: status %d", e.Attempts, e.StatusCode)
+	}
+	return fmt.Sprintf("GitHub API-feil: status %d – %s", e.StatusCode, e.Body)
+}
+
+// GraphQLError is a GraphQL response that reported errors, or had no
+// repository data, for a repo.
+type GraphQLError struct {
+	Repo   string
+	Errors interface{}
+}
+
+func (e *GraphQLError) Error() string {
+	if e.Errors == nil {
+		return fmt.Sprintf("ingen repository-data for %s", e.Repo)
+	}
+	return fmt.Sprintf("GraphQL returnerte feil for %s: %v", e.Repo, e.Errors)
+}
+
+// ClassifyFetchError maps an error from FetchRepoGraphQL or FetchReposGraphQL
+// to the class stored in repo_fetch_errors.
+func ClassifyFetchError(err error) models.FetchErrorClass {
+	var (
+		apiErr       *APIError
+		gqlErr       *GraphQLError
+		syntaxErr    *json.SyntaxError
+		unmarshalErr *json.UnmarshalTypeError
+		netErr       net.Error
+	)
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrRateLimited):
+		return models.FetchErrorRateLimit
+	case errors.As(err, &apiErr):
+		switch {
+		case apiErr.StatusCode == http.StatusTooManyRequests,
+			apiErr.StatusCode == http.StatusForbidden && strings.Contains(strings.ToLower(apiErr.Body), "rate limit"):
+			return models.FetchErrorRateLimit
+		case apiErr.StatusCode >= 500:
+			return models.FetchErrorServer
+		}
+		return models.FetchErrorOther
+	case errors.As(err, &gqlErr):
+		return models.FetchErrorGraphQL
+	case errors.Is(err, ErrParseRepoData), errors.As(err, &syntaxErr), errors.As(err, &unmarshalErr):
+		return models.FetchErrorParse
+	case errors.As(err, &netErr):
+		return models.FetchErrorNetwork
+	}
+	return models.FetchErrorOther
+}
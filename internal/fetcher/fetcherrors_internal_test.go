@@ -0,0 +1,57 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jonmartinstorm/reposnusern/internal/config"
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+)
+
+func TestClassifyFetchError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want models.FetchErrorClass
+	}{
+		{"interrupted rate limit wait", fmt.Errorf("%w: %w", ErrRateLimited, ErrWaitInterrupted), models.FetchErrorRateLimit},
+		{"too many requests", &APIError{StatusCode: http.StatusTooManyRequests}, models.FetchErrorRateLimit},
+		{"forbidden by rate limit", &APIError{StatusCode: http.StatusForbidden, Body: `{"message":"API rate limit exceeded"}`}, models.FetchErrorRateLimit},
+		{"forbidden", &APIError{StatusCode: http.StatusForbidden, Body: `{"message":"forbidden"}`}, models.FetchErrorOther},
+		{"server error", &APIError{StatusCode: http.StatusBadGateway, Attempts: MaxAttempts}, models.FetchErrorServer},
+		{"graphql error", &GraphQLError{Repo: "org/repo", Errors: "NOT_FOUND"}, models.FetchErrorGraphQL},
+		{"no repository data", &GraphQLError{Repo: "org/repo"}, models.FetchErrorGraphQL},
+		{"unparseable repo", fmt.Errorf("org/repo: %w", ErrParseRepoData), models.FetchErrorParse},
+		{"invalid json", &json.SyntaxError{}, models.FetchErrorParse},
+		{"network", &url.Error{Op: "Post", URL: "https://api.github.com/graphql", Err: errors.New("connection reset")}, models.FetchErrorNetwork},
+		{"unknown", errors.New("something else"), models.FetchErrorOther},
+	}
+	for _, tt := range tests {
+		if got := ClassifyFetchError(tt.err); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFetchAndParseFiletreeReportsTruncatedTree(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, `{"tree": [], "truncated": true}`)
+	}))
+	defer ts.Close()
+
+	credentials := NewCredentialPool([]Credential{{Name: "token", Source: StaticTokenSource("token")}})
+	r := NewRepoFetcherWithClient(config.Config{Org: "org"}, newTestClient(ts), credentials)
+	entry := &models.RepoEntry{Files: map[string][]models.FileEntry{}}
+
+	entry = r.fetchAndParseFiletree(context.Background(), models.RepoMeta{Name: "repo", FullName: "org/repo"}, entry)
+
+	if len(entry.FetchIssues) != 1 || entry.FetchIssues[0].Class != models.FetchErrorTreeTruncated {
+		t.Fatalf("expected one tree_truncated issue, got %+v", entry.FetchIssues)
+	}
+}
@@ -13,20 +13,35 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"sync/atomic"
+	"sync"
 	"time"
 
-	"github.com/bradleyfalzon/ghinstallation/v2"
 	"github.com/jonmartinstorm/reposnusern/internal/config"
 	"github.com/jonmartinstorm/reposnusern/internal/models"
 	"github.com/jonmartinstorm/reposnusern/internal/parser"
 )
 
-// apiCallCounter tracks the total number of external API calls made
-var apiCallCounter atomic.Int64
-
 type RepoFetcher struct {
 	Cfg config.Config
+
+	client *Client
+
+	// remoteCICallees caches same-org workflows and actions by owner/repo/path@ref,
+	// since many repos call the same shared workflow.
+	remoteCICallees sync.Map
+
+	// batchSizer adapts the GraphQL batch size across calls to FetchReposGraphQL.
+	batchSizer     *graphQLBatchSizer
+	batchSizerOnce sync.Once
+
+	// credentials is injected or built from Cfg on first use.
+	credentials     *CredentialPool
+	credentialsOnce sync.Once
+
+	// listingCursors maps a page number to the GraphQL cursor it starts after.
+	listingMu       sync.Mutex
+	listingCursors  map[int]string
+	listingLastPage int
 }
 
 // TreeEntry represents a single entry in a Git tree from GitHub's API
@@ -43,22 +58,6 @@ type TreeFile struct {
 	Type string `json:"type"`
 }
 
-// GraphQLEndpoint is the GitHub GraphQL API URL. Can be overridden in tests.
-var GraphQLEndpoint = "https://api.github.com/graphql"
-
-// HttpClient is the HTTP client used for all GitHub API requests.
-// It can be overridden in tests. The default client has a 30-second timeout
-// to prevent requests from hanging indefinitely.
-var HttpClient = &http.Client{
-	Timeout: 30 * time.Second,
-}
-
-// RetryBackoff returns the wait duration before retry attempt n (1-indexed).
-// Can be overridden in tests to speed up retry logic.
-var RetryBackoff = func(attempt int) time.Duration {
-	return time.Duration(1<<uint(attempt-1)) * time.Second
-}
-
 var dockerfileNamePattern = regexp.MustCompile(`(^|[._-])dockerfile([._-]|$)`)
 
 func formatWaitForLog(wait time.Duration) string {
@@ -77,58 +76,36 @@ func formatResetAtForLog(resetAt time.Time) string {
 	return resetAt.Local().Format(time.RFC3339)
 }
 
+// NewRepoFetcher creates a fetcher with its own client and credentials built from cfg.
 func NewRepoFetcher(cfg config.Config) *RepoFetcher {
-	return &RepoFetcher{
-		Cfg: cfg,
-	}
+	return NewRepoFetcherWithClient(cfg, NewClient(cfg), nil)
 }
 
-// GetAPICallCount returns the total number of external API calls made
-func GetAPICallCount() int64 {
-	return apiCallCounter.Load()
-}
-
-// CreateGitHubAppTransport creates a transport for the GitHub App
-func CreateGitHubAppTransport(config *config.GitHubAppConfig) (http.RoundTripper, error) {
-	tr, err := ghinstallation.New(
-		http.DefaultTransport,
-		config.AppID,
-		config.InstallationID,
-		config.PrivateKey,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create GitHub installation transport: %w", err)
+// NewRepoFetcherWithClient creates a fetcher that sends requests through client
+// and authenticates with credentials. A nil pool is built from cfg on first use.
+func NewRepoFetcherWithClient(cfg config.Config, client *Client, credentials *CredentialPool) *RepoFetcher {
+	return &RepoFetcher{
+		Cfg:         cfg,
+		client:      client,
+		credentials: credentials,
 	}
-	return tr, nil
 }
 
-// CreateGitHubAppToken creates an access token for GitHub API using the provided transport
-func CreateGitHubAppToken(ctx context.Context, config *config.GitHubAppConfig) (string, error) {
-	tr := http.DefaultTransport
-	// Fix: Use AppID instead of InstallationID for the second parameter
-	appsTransport, err := ghinstallation.NewAppsTransport(tr, config.AppID, config.PrivateKey)
-	if err != nil {
-		return "", fmt.Errorf("failed to create GitHub apps transport: %w", err)
-	}
-	itr := ghinstallation.NewFromAppsTransport(appsTransport, config.InstallationID)
-	token, err := itr.Token(ctx)
-	if err != nil {
-		return "", fmt.Errorf("failed to get installation token: %w", err)
-	}
-	return token, nil
+// Stats returns the counters of the fetcher's client.
+func (r *RepoFetcher) Stats() ClientStats {
+	return r.client.Stats()
 }
 
 func (r *RepoFetcher) GetReposPage(ctx context.Context, cfg config.Config, page int) ([]models.RepoMeta, error) {
-	url := fmt.Sprintf("https://api.github.com/orgs/%s/repos?per_page=100&type=all&page=%d", cfg.Org, page)
+	if cfg.RepoListing == config.RepoListingGraphQL {
+		return r.getReposPageGraphQL(ctx, cfg.Org, page)
+	}
+
+	url := r.client.restURL(fmt.Sprintf("/orgs/%s/repos?per_page=100&type=all&page=%d", cfg.Org, page))
 	var pageRepos []models.RepoMeta
 	slog.Info("Henter repos", "page", page)
 
-	token, err := r.GetAuthToken(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get auth token: %w", err)
-	}
-
-	err = DoRequestWithRateLimit(ctx, "GET", url, token, nil, &pageRepos)
+	err := r.getREST(ctx, url, &pageRepos, false)
 	if err != nil {
 		return nil, err
 	}
@@ -153,60 +130,51 @@ func (r *RepoFetcher) FetchRepoGraphQL(ctx context.Context, baseRepo models.Repo
 		return nil, err
 	}
 
-	token, err := r.GetAuthToken(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get auth token: %w", err)
+	var result map[string]interface{}
+	if _, err := r.postGraphQL(ctx, r.Cfg.Org+"/"+baseRepo.Name, bodyBytes, &result); err != nil {
+		slog.Error("GraphQL-kall feilet", "repo", r.Cfg.Org+"/"+baseRepo.Name, "error", err)
+		return nil, err
 	}
 
-	for rateLimitAttempt := 1; ; rateLimitAttempt++ {
-		var result map[string]interface{}
-		headers, err := doRequestWithHeaders(ctx, RateLimitResourceGraphQL, "POST", GraphQLEndpoint, token, bodyBytes, &result, false)
-		if err != nil {
-			slog.Error("GraphQL-kall feilet", "repo", r.Cfg.Org+"/"+baseRepo.Name, "error", err)
-			return nil, err
-		}
-
-		if errs, ok := result["errors"]; ok {
-			if isGraphQLRateLimitError(errs) {
-				wait := graphQLRateLimitWait(headers, rateLimitAttempt)
-				blockResult := SharedRateLimiter.BlockFor(RateLimitResourceGraphQL, wait)
-				switch {
-				case blockResult.StartedNewBlock:
-					slog.Warn("GraphQL rate limit nådd", "repo", r.Cfg.Org+"/"+baseRepo.Name, "venter", formatWaitForLog(blockResult.RemainingCooldown), "reset_at", formatResetAtForLog(blockResult.BlockedUntil))
-				case blockResult.ExtendedBlock:
-					slog.Warn("GraphQL rate limit forlenget", "repo", r.Cfg.Org+"/"+baseRepo.Name, "venter", formatWaitForLog(blockResult.RemainingCooldown), "reset_at", formatResetAtForLog(blockResult.BlockedUntil))
-				}
-				continue
-			}
-			return nil, fmt.Errorf("GraphQL returnerte feil for %s/%s: %v", r.Cfg.Org, baseRepo.Name, errs)
-		}
+	if errs, ok := result["errors"]; ok {
+		return nil, &GraphQLError{Repo: r.Cfg.Org + "/" + baseRepo.Name, Errors: errs}
+	}
 
-		data, ok := result["data"].(map[string]interface{})
-		if !ok || data["repository"] == nil {
-			slog.Warn("Ingen repository-data fra GraphQL", "repo", r.Cfg.Org+"/"+baseRepo.Name)
-			return nil, fmt.Errorf("ingen repository-data for %s/%s", r.Cfg.Org, baseRepo.Name)
-		}
+	data, ok := result["data"].(map[string]interface{})
+	if !ok || data["repository"] == nil {
+		slog.Warn("Ingen repository-data fra GraphQL", "repo", r.Cfg.Org+"/"+baseRepo.Name)
+		return nil, &GraphQLError{Repo: r.Cfg.Org + "/" + baseRepo.Name}
+	}
 
-		entry := ParseRepoData(data, baseRepo)
-		if entry == nil {
-			return nil, fmt.Errorf("klarte ikke parse repository-data for %s/%s", r.Cfg.Org, baseRepo.Name)
-		}
+	entry := ParseRepoData(data, baseRepo)
+	if entry == nil {
+		return nil, fmt.Errorf("klarte ikke parse repository-data for %s/%s: %w", r.Cfg.Org, baseRepo.Name, ErrParseRepoData)
+	}
 
-		// Hent SBOM hvis feature_sbom er true
-		if r.Cfg.Feature_Sbom {
-			sbom := r.fetchSBOM(ctx, r.Cfg.Org, baseRepo.Name)
-			entry.SBOM = sbom
+	return r.enrichEntry(ctx, baseRepo, entry), nil
+}
+
+// enrichEntry runs the REST follow-ups for a parsed GraphQL entry: SBOM, deep
+// file search, CI workflow calls and lockfile pairings.
+func (r *RepoFetcher) enrichEntry(ctx context.Context, baseRepo models.RepoMeta, entry *models.RepoEntry) *models.RepoEntry {
+	// Hent SBOM hvis feature_sbom er true
+	if r.Cfg.Feature_Sbom {
+		sbom, err := r.fetchSBOM(ctx, r.Cfg.Org, baseRepo.Name)
+		if err != nil {
+			entry.FetchIssues = append(entry.FetchIssues, models.FetchIssue{Class: models.FetchErrorSBOMUnavailable, Message: err.Error()})
 		}
+		entry.SBOM = sbom
+	}
 
-		entry = r.needsFiletreeFetching(ctx, baseRepo, entry)
+	entry = r.needsFiletreeFetching(ctx, baseRepo, entry)
+	entry = r.resolveCIWorkflowCalls(ctx, baseRepo, entry)
 
-		// Analyze lockfile pairings now that all files have been fetched
-		entry.Repo.LockfilePairings = parser.DetectLockfilePairings(entry.Files)
-		entry.Repo.HasCompleteLockfiles = parser.HasCompleteLockfiles(entry.Repo.LockfilePairings)
-		entry.Repo.Lockfile_pair_count = len(entry.Repo.LockfilePairings)
+	// Analyze lockfile pairings now that all files have been fetched
+	entry.Repo.LockfilePairings = parser.DetectLockfilePairings(entry.Files)
+	entry.Repo.HasCompleteLockfiles = parser.HasCompleteLockfiles(entry.Repo.LockfilePairings)
+	entry.Repo.Lockfile_pair_count = len(entry.Repo.LockfilePairings)
 
-		return entry, nil
-	}
+	return entry
 }
 
 // Checks if we should fetch the entire filetree
@@ -229,12 +197,16 @@ func (r *RepoFetcher) needsFiletreeFetching(ctx context.Context, baseRepo models
 func (r *RepoFetcher) fetchAndParseFiletree(ctx context.Context, baseRepo models.RepoMeta, entry *models.RepoEntry) *models.RepoEntry {
 	// Fetch tree once if we need to search deeper for files
 	// TODO: Should combine the "fetchDockerfileFromTree and fetchDependencyfilesFromTree into one function to avoid duplication!
-	var treeEntries []TreeEntry
-	var treeErr error
-
-	treeEntries, treeErr = r.fetchRepoTreeREST(ctx, r.Cfg.Org, baseRepo.Name)
+	treeEntries, truncated, treeErr := r.fetchRepoTreeREST(ctx, r.Cfg.Org, baseRepo.Name)
 	if treeErr != nil {
 		slog.Warn("Klarte ikke hente repo-tree", "repo", baseRepo.FullName, "error", treeErr)
+		entry.FetchIssues = append(entry.FetchIssues, models.FetchIssue{Class: ClassifyFetchError(treeErr), Message: treeErr.Error()})
+	}
+	if truncated {
+		entry.FetchIssues = append(entry.FetchIssues, models.FetchIssue{
+			Class:   models.FetchErrorTreeTruncated,
+			Message: "git tree was truncated, files deep in the tree may be missing",
+		})
 	}
 	if treeEntries != nil {
 		files := r.FetchDockerfilesFromTree(ctx, r.Cfg.Org, baseRepo.Name, treeEntries)
@@ -277,54 +249,97 @@ func sleepWithContext(ctx context.Context, d time.Duration) error {
 // MaxAttempts is the total number of attempts (including the initial call) for
 // transient errors (5xx responses and network failures). E.g. MaxAttempts=3
 // means: 1st call → wait 1s → 2nd call → wait 2s → 3rd call → give up.
-// Rate-limit retries are unlimited and do not count against this total.
+// Rate-limit retries do not count against this total.
 const MaxAttempts = 3
 
-// doRequest runs a GitHub request through the shared per-resource limiter and retry policy.
+// MaxSecondaryRateLimitAttempts is how many secondary rate limits one
+// request may get before the client gives up on it. Primary rate limits have a
+// known reset and are always waited out.
+const MaxSecondaryRateLimitAttempts = 5
+
+// doRequest runs a GitHub request through the client's per-resource limiter and retry policy.
 // Set allow404=true for optional endpoints where 404 means "not available".
-func doRequest(ctx context.Context, resource RateLimitResource, method, url, token string, body []byte, out interface{}, allow404 bool) error {
-	_, err := doRequestWithHeaders(ctx, resource, method, url, token, body, out, allow404)
+func (c *Client) doRequest(ctx context.Context, resource RateLimitResource, method, url, token string, body []byte, out interface{}, allow404 bool) error {
+	_, err := c.doRequestWithHeaders(ctx, resource, method, url, token, body, out, allow404)
 	return err
 }
 
 // doRequestWithHeaders behaves like doRequest but also returns the response headers.
-func doRequestWithHeaders(ctx context.Context, resource RateLimitResource, method, url, token string, body []byte, out interface{}, allow404 bool) (http.Header, error) {
+func (c *Client) doRequestWithHeaders(ctx context.Context, resource RateLimitResource, method, url, token string, body []byte, out interface{}, allow404 bool) (http.Header, error) {
+	headers, _, err := c.doRequestWithAuth(ctx, StaticTokenSource(token), resource, method, url, body, out, allow404)
+	return headers, err
+}
+
+// doRequestWithAuth is the request loop behind all GitHub calls. auth picks
+// the credential for each attempt, so a retry after a rate limit can move to
+// another credential. It returns the lease used for the final attempt.
+func (c *Client) doRequestWithAuth(ctx context.Context, auth requestAuth, resource RateLimitResource, method, url string, body []byte, out interface{}, allow404 bool) (http.Header, credentialLease, error) {
+	var lease credentialLease
+	cached := c.lookupCachedResponse(ctx, resource, method, url)
+	secondaryLimits := 0
 	for attempt := 1; ; attempt++ {
 		if err := ctx.Err(); err != nil {
-			return nil, err
+			return nil, lease, err
 		}
-		if err := SharedRateLimiter.Wait(ctx, resource); err != nil {
-			return nil, err
+		var err error
+		if lease, err = auth.acquire(ctx, c.Limiter, resource); err != nil {
+			return nil, lease, err
 		}
 
-		apiCallCounter.Add(1)
+		c.apiCalls.Add(1)
 
 		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
 		if err != nil {
-			return nil, err
+			return nil, lease, err
 		}
-		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Authorization", "Bearer "+lease.token)
 		req.Header.Set("Accept", "application/vnd.github+json")
 		if method == "POST" {
 			req.Header.Set("Content-Type", "application/json")
 		}
+		setConditionalHeaders(req, cached)
 
-		resp, err := HttpClient.Do(req)
+		if err := c.Concurrency.Acquire(ctx); err != nil {
+			return nil, lease, err
+		}
+		resp, err := c.HTTP.Do(req)
+		var respBody []byte
+		if err == nil {
+			respBody, err = io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+		}
+		c.Concurrency.Release()
 		if err != nil {
 			if attempt >= MaxAttempts {
-				return nil, err
+				return nil, lease, err
 			}
-			wait := RetryBackoff(attempt)
+			wait := c.RetryBackoff(attempt)
 			slog.Warn("Nettverksfeil, prøver igjen", "forsøk", attempt, "venter", formatWaitForLog(wait), "error", err)
 			if sleepErr := sleepWithContext(ctx, wait); sleepErr != nil {
-				return nil, sleepErr
+				return nil, lease, sleepErr
 			}
 			continue
 		}
 
+		c.observeRateLimitHeaders(lease.key, resp.Header)
+
+		if isSecondaryRateLimit(resp.StatusCode, respBody) {
+			blockResult := c.Limiter.blockSecondary(lease.key, c.secondaryRateLimitWait(resp.Header))
+			if blockResult.StartedNewBlock {
+				c.Concurrency.OnSecondaryLimit()
+				slog.Warn("Sekundær rate limit nådd", "ressurs", resource, "venter", formatWaitForLog(blockResult.RemainingCooldown), "reset_at", formatResetAtForLog(blockResult.BlockedUntil))
+			}
+			if secondaryLimits++; secondaryLimits >= MaxSecondaryRateLimitAttempts {
+				slog.Error("Gir opp etter gjentatte sekundære rate limits", "ressurs", resource, "forsøk", secondaryLimits, "url", url)
+				return nil, lease, &APIError{StatusCode: resp.StatusCode, Attempts: secondaryLimits, Body: string(respBody)}
+			}
+			attempt = 0 // reset transient counter; incremented to 1 at top of next iteration
+			continue
+		}
+		c.Concurrency.OnSuccess()
+
 		if wait, ok := rateLimitWait(resp.Header, resp.StatusCode); ok {
-			_ = resp.Body.Close()
-			blockResult := SharedRateLimiter.BlockFor(resource, wait)
+			blockResult := c.Limiter.blockFor(lease.key, wait)
 			switch {
 			case blockResult.StartedNewBlock:
 				slog.Warn("Rate limit nådd", "ressurs", resource, "venter", formatWaitForLog(blockResult.RemainingCooldown), "reset_at", formatResetAtForLog(blockResult.BlockedUntil))
@@ -335,64 +350,97 @@ func doRequestWithHeaders(ctx context.Context, resource RateLimitResource, metho
 			continue
 		}
 
+		if resp.StatusCode == http.StatusNotModified && cached != nil {
+			// GitHub does not charge for 304s, so give back the request we reserved.
+			c.cacheHits.Add(1)
+			c.Limiter.refund(lease.key)
+			return resp.Header, lease, json.Unmarshal(cached.Body, out)
+		}
+
 		if allow404 && resp.StatusCode == 404 {
 			slog.Info("Ressurs ikke tilgjengelig (404)", "url", url)
-			_ = resp.Body.Close()
-			return nil, nil
+			return nil, lease, nil
 		}
 
 		if resp.StatusCode >= 500 {
-			_ = resp.Body.Close()
 			if attempt >= MaxAttempts {
-				return nil, fmt.Errorf("GitHub API-feil etter %d forsøk: status %d", MaxAttempts, resp.StatusCode)
+				return nil, lease, &APIError{StatusCode: resp.StatusCode, Attempts: MaxAttempts, Body: string(respBody)}
 			}
-			wait := RetryBackoff(attempt)
+			wait := c.RetryBackoff(attempt)
 			slog.Warn("Serverfeil, prøver igjen", "status", resp.StatusCode, "forsøk", attempt, "venter", formatWaitForLog(wait))
 			if sleepErr := sleepWithContext(ctx, wait); sleepErr != nil {
-				return nil, sleepErr
+				return nil, lease, sleepErr
 			}
 			continue
 		}
 
 		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			bodyBytes, _ := io.ReadAll(resp.Body)
-			_ = resp.Body.Close()
-			slog.Error("GitHub-feil", "status", resp.StatusCode, "body", string(bodyBytes))
-			return nil, fmt.Errorf("GitHub API-feil: status %d – %s", resp.StatusCode, string(bodyBytes))
+			slog.Error("GitHub-feil", "status", resp.StatusCode, "body", string(respBody))
+			return nil, lease, &APIError{StatusCode: resp.StatusCode, Body: string(respBody)}
 		}
 
-		err = json.NewDecoder(resp.Body).Decode(out)
-		_ = resp.Body.Close()
-		return resp.Header, err
+		c.storeCachedResponse(ctx, resource, method, url, resp.Header, respBody)
+		return resp.Header, lease, json.Unmarshal(respBody, out)
 	}
 }
 
-// DoRequestWithRateLimit issues a core REST request with shared rate-limit handling.
-func DoRequestWithRateLimit(ctx context.Context, method, url, token string, body []byte, out interface{}) error {
-	return doRequest(ctx, RateLimitResourceCore, method, url, token, body, out, false)
+// auth returns the credential pool requests of this fetcher are spread over.
+func (r *RepoFetcher) auth() *CredentialPool {
+	r.credentialsOnce.Do(func() {
+		if r.credentials != nil {
+			return
+		}
+		credentials, err := CredentialsFromConfig(r.Cfg, r.client.HTTP.Transport)
+		r.credentials = NewCredentialPool(credentials)
+		r.credentials.err = err
+	})
+	return r.credentials
 }
 
-func (r *RepoFetcher) fetchSBOM(ctx context.Context, owner, repo string) map[string]interface{} {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/dependency-graph/sbom", owner, repo)
+// getREST issues a core REST GET with one of the fetcher's credentials.
+func (r *RepoFetcher) getREST(ctx context.Context, url string, out interface{}, allow404 bool) error {
+	_, _, err := r.client.doRequestWithAuth(ctx, r.auth(), RateLimitResourceCore, "GET", url, nil, out, allow404)
+	return err
+}
 
-	token, err := r.GetAuthToken(ctx)
-	if err != nil {
-		slog.Warn("Kunne ikke hente auth token for SBOM", "repo", owner+"/"+repo, "error", err)
-		return nil
+// postGraphQL posts a GraphQL query with one of the fetcher's credentials and
+// retries while the response body reports a GraphQL rate limit, so callers only
+// see other GraphQL errors. It returns the limiter key of the credential that
+// answered. label is only used for logging.
+func (r *RepoFetcher) postGraphQL(ctx context.Context, label string, body []byte, out interface{}) (rateLimitKey, error) {
+	for rateLimitAttempt := 1; ; rateLimitAttempt++ {
+		var raw json.RawMessage
+		headers, lease, err := r.client.doRequestWithAuth(ctx, r.auth(), RateLimitResourceGraphQL, "POST", r.client.GraphQLURL, body, &raw, false)
+		if err != nil {
+			return lease.key, err
+		}
+
+		var envelope struct {
+			Errors interface{} `json:"errors"`
+		}
+		if err := json.Unmarshal(raw, &envelope); err != nil {
+			return lease.key, err
+		}
+		if envelope.Errors != nil && isGraphQLRateLimitError(envelope.Errors) {
+			r.client.blockForGraphQLRateLimit(lease.key, headers, rateLimitAttempt, label)
+			continue
+		}
+		return lease.key, json.Unmarshal(raw, out)
 	}
+}
+
+// fetchSBOM returns nil without an error when the repo has no dependency
+// graph, since that is a setting of the repo and not a failed fetch.
+func (r *RepoFetcher) fetchSBOM(ctx context.Context, owner, repo string) (map[string]interface{}, error) {
+	url := r.client.restURL(fmt.Sprintf("/repos/%s/%s/dependency-graph/sbom", owner, repo))
 
 	var sbom map[string]interface{}
-	err = doRequestWithRateLimitAndOptional404(ctx, "GET", url, token, nil, &sbom)
+	err := r.getREST(ctx, url, &sbom, true)
 	if err != nil {
 		slog.Warn("SBOM-kall feilet", "repo", owner+"/"+repo, "error", err)
-		return nil
+		return nil, err
 	}
-	return sbom
-}
-
-// doRequestWithRateLimitAndOptional404 is the core REST variant where 404 is non-fatal.
-func doRequestWithRateLimitAndOptional404(ctx context.Context, method, url, token string, body []byte, out interface{}) error {
-	return doRequest(ctx, RateLimitResourceCore, method, url, token, body, out, true)
+	return sbom, nil
 }
 
 func isGraphQLRateLimitError(errs interface{}) bool {
@@ -428,8 +476,21 @@ func isRateLimitGraphQLErrorMap(errMap map[string]interface{}) bool {
 	return strings.EqualFold(code, "RATE_LIMIT") || strings.EqualFold(code, "graphql_rate_limit")
 }
 
+// blockForGraphQLRateLimit blocks the GraphQL bucket of the credential that got
+// a rate-limit error in a response body. repo is only used for logging.
+func (c *Client) blockForGraphQLRateLimit(key rateLimitKey, headers http.Header, attempt int, repo string) {
+	wait := c.graphQLRateLimitWait(headers, attempt)
+	blockResult := c.Limiter.blockFor(key, wait)
+	switch {
+	case blockResult.StartedNewBlock:
+		slog.Warn("GraphQL rate limit nådd", "repo", repo, "venter", formatWaitForLog(blockResult.RemainingCooldown), "reset_at", formatResetAtForLog(blockResult.BlockedUntil))
+	case blockResult.ExtendedBlock:
+		slog.Warn("GraphQL rate limit forlenget", "repo", repo, "venter", formatWaitForLog(blockResult.RemainingCooldown), "reset_at", formatResetAtForLog(blockResult.BlockedUntil))
+	}
+}
+
 // graphQLRateLimitWait prefers server-provided wait hints, then falls back to retry backoff.
-func graphQLRateLimitWait(headers http.Header, attempt int) time.Duration {
+func (c *Client) graphQLRateLimitWait(headers http.Header, attempt int) time.Duration {
 	if headers != nil {
 		if wait, ok := retryAfterWait(headers.Get("Retry-After")); ok {
 			return wait
@@ -443,7 +504,51 @@ func graphQLRateLimitWait(headers http.Header, attempt int) time.Duration {
 		}
 	}
 
-	return RetryBackoff(attempt)
+	return c.RetryBackoff(attempt)
+}
+
+// observeRateLimitHeaders feeds the X-RateLimit-* headers of a response to the
+// client's limiter. Responses that GitHub attributes to another bucket, such as
+// search, are ignored.
+func (c *Client) observeRateLimitHeaders(key rateLimitKey, headers http.Header) {
+	if bucket := headers.Get("X-RateLimit-Resource"); bucket != "" && bucket != string(key.resource) {
+		return
+	}
+	limit, errLimit := strconv.ParseInt(headers.Get("X-RateLimit-Limit"), 10, 64)
+	remaining, errRemaining := strconv.ParseInt(headers.Get("X-RateLimit-Remaining"), 10, 64)
+	reset, errReset := strconv.ParseInt(headers.Get("X-RateLimit-Reset"), 10, 64)
+	if errLimit != nil || errRemaining != nil || errReset != nil {
+		return
+	}
+	c.Limiter.observe(key, limit, remaining, time.Unix(reset, 0))
+}
+
+// isSecondaryRateLimit reports whether a response is GitHub's secondary
+// (abuse-detection) rate limit rather than an exhausted primary budget.
+func isSecondaryRateLimit(statusCode int, body []byte) bool {
+	if statusCode != http.StatusForbidden && statusCode != http.StatusTooManyRequests {
+		return false
+	}
+	lower := strings.ToLower(string(body))
+	return strings.Contains(lower, "secondary rate limit") ||
+		strings.Contains(lower, "abuse detection") ||
+		strings.Contains(lower, "secondary-rate-limits")
+}
+
+// secondaryRateLimitWait prefers Retry-After, then an exhausted budget's reset,
+// and falls back to SecondaryRateLimitBackoff.
+func (c *Client) secondaryRateLimitWait(headers http.Header) time.Duration {
+	if wait, ok := retryAfterWait(headers.Get("Retry-After")); ok {
+		return wait
+	}
+	if headers.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(headers.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			if wait := time.Until(time.Unix(reset, 0)) + time.Second; wait > 0 {
+				return wait
+			}
+		}
+	}
+	return c.SecondaryRateLimitBackoff
 }
 
 // rateLimitWait derives a shared cooldown from a failed REST response.
@@ -718,67 +823,74 @@ func ExtractReadme(data map[string]interface{}) string {
 	return ""
 }
 
-func BuildRepoQuery(owner string, name string) string {
-	query := `
-	query RepoDetails($owner: String!, $name: String!) {
-		repository(owner: $owner, name: $name) {
-			defaultBranchRef {
-				name
-			}
-			README: object(expression: "HEAD:README.md") {
-				... on Blob {
-					text
-				}
+// repoFieldsFragment selects everything we read from a repository. It is
+// shared by the single-repo and the batched query.
+const repoFieldsFragment = `
+	fragment RepoFields on Repository {
+		defaultBranchRef {
+			name
+		}
+		README: object(expression: "HEAD:README.md") {
+			... on Blob {
+				text
 			}
-			SECURITY: object(expression: "HEAD:SECURITY.md") {
-				... on Blob {
-					text
-				}
+		}
+		SECURITY: object(expression: "HEAD:SECURITY.md") {
+			... on Blob {
+				text
 			}
-			dependabot: object(expression: "HEAD:.github/dependabot.yml") {
-				... on Blob {
-					text
-				}
+		}
+		dependabot: object(expression: "HEAD:.github/dependabot.yml") {
+			... on Blob {
+				text
 			}
-			codeql: object(expression: "HEAD:.github/codeql.yml") {
-				... on Blob {
-					text
-				}
+		}
+		codeql: object(expression: "HEAD:.github/codeql.yml") {
+			... on Blob {
+				text
 			}
-			workflows: object(expression: "HEAD:.github/workflows") {
-				... on Tree {
-					entries {
-						name
-						object {
-							... on Blob {
-								text
-							}
+		}
+		workflows: object(expression: "HEAD:.github/workflows") {
+			... on Tree {
+				entries {
+					name
+					object {
+						... on Blob {
+							text
 						}
 					}
 				}
 			}
-			dependencies: object(expression: "HEAD:") {
-				... on Tree {
-					entries {
-						name
-						object {
-							... on Blob {
-								text
-							}
+		}
+		dependencies: object(expression: "HEAD:") {
+			... on Tree {
+				entries {
+					name
+					object {
+						... on Blob {
+							text
 						}
 					}
 				}
 			}
-			languages(first: 10) {
-				edges {
-					size
-					node {
-						name
-					}
+		}
+		languages(first: 10) {
+			edges {
+				size
+				node {
+					name
 				}
 			}
 		}
 	}`
+
+func BuildRepoQuery(owner string, name string) string {
+	query := `
+	query RepoDetails($owner: String!, $name: String!) {
+		repository(owner: $owner, name: $name) {
+			...RepoFields
+		}
+	}` + repoFieldsFragment
 	return query
 }
 
@@ -801,32 +913,27 @@ func ConvertFiles(input map[string][]map[string]string) map[string][]models.File
 	return out
 }
 
-// fetchRepoTreeREST fetches the git tree structure from GitHub REST API
-func (r *RepoFetcher) fetchRepoTreeREST(ctx context.Context, owner, repo string) ([]TreeEntry, error) {
-	treeURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/git/trees/HEAD?recursive=1", owner, repo)
+// fetchRepoTreeREST fetches the git tree structure from GitHub REST API and
+// reports whether GitHub truncated it.
+func (r *RepoFetcher) fetchRepoTreeREST(ctx context.Context, owner, repo string) ([]TreeEntry, bool, error) {
+	treeURL := r.client.restURL(fmt.Sprintf("/repos/%s/%s/git/trees/HEAD?recursive=1", owner, repo))
 
 	var tree struct {
 		Tree      []TreeEntry `json:"tree"`
 		Truncated bool        `json:"truncated"`
 	}
 
-	token, err := r.GetAuthToken(ctx)
-	if err != nil {
-		slog.Warn("Kunne ikke hente auth token for git tree", "repo", owner+"/"+repo)
-		return nil, fmt.Errorf("could not get auth token: %w", err)
-	}
-
-	err = DoRequestWithRateLimit(ctx, "GET", treeURL, token, nil, &tree)
+	err := r.getREST(ctx, treeURL, &tree, false)
 	if err != nil {
 		slog.Warn("Kunne ikke hente repo tree", "repo", owner+"/"+repo)
-		return nil, fmt.Errorf("could not fetch repo tree: %w", err)
+		return nil, false, fmt.Errorf("could not fetch repo tree: %w", err)
 	}
 
 	if tree.Truncated {
 		slog.Warn("Git tree was truncated (too large)", "repo", owner+"/"+repo)
 	}
 
-	return tree.Tree, nil
+	return tree.Tree, tree.Truncated, nil
 }
 
 // FetchDockerfilesFromTree extracts Dockerfiles from a provided git tree structure
@@ -913,19 +1020,13 @@ func (r *RepoFetcher) FetchDependencyfilesFromTree(ctx context.Context, owner, r
 }
 
 func (r *RepoFetcher) fetchFileContent(ctx context.Context, owner, repo, path string) string {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/%s/contents/%s", owner, repo, path)
-
-	token, err := r.GetAuthToken(ctx)
-	if err != nil {
-		slog.Warn("Kunne ikke hente auth token for filinnhold", "repo", owner+"/"+repo, "path", path, "error", err)
-		return ""
-	}
+	url := r.client.restURL(fmt.Sprintf("/repos/%s/%s/contents/%s", owner, repo, path))
 
 	var file struct {
 		Content  string `json:"content"`
 		Encoding string `json:"encoding"`
 	}
-	err = DoRequestWithRateLimit(ctx, "GET", url, token, nil, &file)
+	err := r.getREST(ctx, url, &file, false)
 	if err != nil {
 		slog.Warn("Klarte ikke hente filinnhold", "repo", owner+"/"+repo, "path", path, "error", err)
 		return ""
@@ -939,21 +1040,3 @@ func (r *RepoFetcher) fetchFileContent(ctx context.Context, owner, repo, path st
 	}
 	return ""
 }
-
-// GetAuthToken returns the appropriate authentication token based on configuration
-func (r *RepoFetcher) GetAuthToken(ctx context.Context) (string, error) {
-	if r.Cfg.Feature_GitHubApp && r.Cfg.GitHubAppConfig != nil {
-		// Use GitHub App authentication
-		token, err := CreateGitHubAppToken(ctx, r.Cfg.GitHubAppConfig)
-		if err != nil {
-			return "", fmt.Errorf("failed to create GitHub App token: %w", err)
-		}
-		return token, nil
-	}
-
-	// Use personal access token
-	if r.Cfg.Token == "" {
-		return "", fmt.Errorf("no authentication token available")
-	}
-	return r.Cfg.Token, nil
-}
@@ -3,7 +3,6 @@ package fetcher_test
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -256,24 +255,10 @@ var _ = Describe("GraphQL-relaterte hjelpefunksjoner", func() {
 })
 
 var _ = Describe("FetchRepoGraphQL", func() {
-	var originalClient *http.Client
-	var originalEndpoint string
-	var originalBackoff func(int) time.Duration
-	var originalLimiter *fetcher.ResourceRateLimiter
+	var client *fetcher.Client
 
 	BeforeEach(func() {
-		originalClient = fetcher.HttpClient
-		originalEndpoint = fetcher.GraphQLEndpoint
-		originalBackoff = fetcher.RetryBackoff
-		originalLimiter = fetcher.SharedRateLimiter
-		fetcher.SharedRateLimiter = fetcher.NewResourceRateLimiter()
-	})
-
-	AfterEach(func() {
-		fetcher.HttpClient = originalClient
-		fetcher.GraphQLEndpoint = originalEndpoint
-		fetcher.RetryBackoff = originalBackoff
-		fetcher.SharedRateLimiter = originalLimiter
+		client = fetcher.NewClient(config.Config{})
 	})
 
 	It("skal retrye når GraphQL-svaret inneholder rate-limit-feil", func() {
@@ -296,11 +281,11 @@ var _ = Describe("FetchRepoGraphQL", func() {
 		}))
 		defer ts.Close()
 
-		fetcher.HttpClient = ts.Client()
-		fetcher.GraphQLEndpoint = ts.URL
-		fetcher.RetryBackoff = func(_ int) time.Duration { return time.Millisecond }
+		client.HTTP = ts.Client()
+		client.GraphQLURL = ts.URL
+		client.RetryBackoff = func(_ int) time.Duration { return time.Millisecond }
 
-		f := fetcher.NewRepoFetcher(config.Config{Org: "testorg", Token: "fake-token"})
+		f := fetcher.NewRepoFetcherWithClient(config.Config{Org: "testorg", Token: "fake-token"}, client, nil)
 		entry, err := f.FetchRepoGraphQL(context.Background(), models.RepoMeta{Name: "missing"})
 		Expect(err).NotTo(HaveOccurred())
 		Expect(entry).NotTo(BeNil())
@@ -321,10 +306,10 @@ var _ = Describe("FetchRepoGraphQL", func() {
 		}))
 		defer ts.Close()
 
-		fetcher.HttpClient = ts.Client()
-		fetcher.GraphQLEndpoint = ts.URL
+		client.HTTP = ts.Client()
+		client.GraphQLURL = ts.URL
 
-		f := fetcher.NewRepoFetcher(config.Config{Org: "testorg", Token: "fake-token"})
+		f := fetcher.NewRepoFetcherWithClient(config.Config{Org: "testorg", Token: "fake-token"}, client, nil)
 		_, err := f.FetchRepoGraphQL(context.Background(), models.RepoMeta{Name: "missing"})
 		Expect(err).To(HaveOccurred())
 		Expect(err.Error()).To(ContainSubstring("GraphQL returnerte feil"))
@@ -338,10 +323,10 @@ var _ = Describe("FetchRepoGraphQL", func() {
 		}))
 		defer ts.Close()
 
-		fetcher.HttpClient = ts.Client()
-		fetcher.GraphQLEndpoint = ts.URL
+		client.HTTP = ts.Client()
+		client.GraphQLURL = ts.URL
 
-		f := fetcher.NewRepoFetcher(config.Config{Org: "testorg", Token: "fake-token"})
+		f := fetcher.NewRepoFetcherWithClient(config.Config{Org: "testorg", Token: "fake-token"}, client, nil)
 		_, err := f.FetchRepoGraphQL(context.Background(), models.RepoMeta{Name: "broken"})
 		Expect(err).To(HaveOccurred())
 		Expect(err.Error()).To(ContainSubstring("klarte ikke parse repository-data"))
@@ -357,11 +342,11 @@ var _ = Describe("FetchRepoGraphQL", func() {
 		}))
 		defer ts.Close()
 
-		fetcher.HttpClient = ts.Client()
-		fetcher.GraphQLEndpoint = ts.URL
-		fetcher.SharedRateLimiter.BlockFor(fetcher.RateLimitResourceGraphQL, 40*time.Millisecond)
+		client.HTTP = ts.Client()
+		client.GraphQLURL = ts.URL
+		client.Limiter.BlockFor(fetcher.RateLimitResourceGraphQL, 40*time.Millisecond)
 
-		f := fetcher.NewRepoFetcher(config.Config{Org: "testorg", Token: "fake-token"})
+		f := fetcher.NewRepoFetcherWithClient(config.Config{Org: "testorg", Token: "fake-token"}, client, nil)
 		start := time.Now()
 		entry, err := f.FetchRepoGraphQL(context.Background(), models.RepoMeta{Name: "missing"})
 		Expect(err).NotTo(HaveOccurred())
@@ -370,216 +355,3 @@ var _ = Describe("FetchRepoGraphQL", func() {
 		Expect(callCount).To(Equal(1))
 	})
 })
-
-var _ = Describe("doRequestWithRateLimit", func() {
-	var originalClient *http.Client
-	var originalBackoff func(int) time.Duration
-	var originalLimiter *fetcher.ResourceRateLimiter
-
-	BeforeEach(func() {
-		originalClient = fetcher.HttpClient
-		originalBackoff = fetcher.RetryBackoff
-		originalLimiter = fetcher.SharedRateLimiter
-		fetcher.RetryBackoff = func(_ int) time.Duration { return time.Millisecond }
-		fetcher.SharedRateLimiter = fetcher.NewResourceRateLimiter()
-	})
-
-	AfterEach(func() {
-		fetcher.HttpClient = originalClient
-		fetcher.RetryBackoff = originalBackoff
-		fetcher.SharedRateLimiter = originalLimiter
-	})
-
-	It("skal håndtere rate limit (403) og retry riktig", func() {
-		callCount := 0
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			callCount++
-			if callCount == 1 {
-				// Simuler at vi har truffet rate limit — GitHub sender 403 med X-RateLimit-Remaining: 0
-				w.Header().Set("X-RateLimit-Remaining", "0")
-				w.Header().Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(50*time.Millisecond).Unix()))
-				w.WriteHeader(http.StatusForbidden)
-				_, _ = fmt.Fprintln(w, `{}`)
-				return
-			}
-
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			_, _ = fmt.Fprintln(w, `{"message": "ok"}`)
-		}))
-		defer ts.Close()
-
-		fetcher.HttpClient = ts.Client()
-		ctx := context.Background()
-		var result struct{ Message string }
-		err := fetcher.DoRequestWithRateLimit(ctx, "GET", ts.URL, "dummy-token", nil, &result)
-		Expect(err).To(BeNil())
-		Expect(result.Message).To(Equal("ok"))
-		Expect(callCount).To(BeNumerically(">=", 2))
-	})
-
-	It("skal ikke retry når X-RateLimit-Remaining er 0 men svaret er 200", func() {
-		callCount := 0
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			callCount++
-			w.Header().Set("X-RateLimit-Remaining", "0")
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			_, _ = fmt.Fprintln(w, `{"message": "last-call"}`)
-		}))
-		defer ts.Close()
-
-		fetcher.HttpClient = ts.Client()
-		ctx := context.Background()
-		var result struct{ Message string }
-		err := fetcher.DoRequestWithRateLimit(ctx, "GET", ts.URL, "dummy-token", nil, &result)
-		Expect(err).To(BeNil())
-		Expect(result.Message).To(Equal("last-call"))
-		Expect(callCount).To(Equal(1))
-	})
-
-	It("skal sette Content-Type header for POST", func() {
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			Expect(r.Method).To(Equal("POST"))
-			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
-			w.WriteHeader(http.StatusOK)
-			_, _ = fmt.Fprintln(w, `{"message": "ok"}`)
-		}))
-		defer ts.Close()
-
-		fetcher.HttpClient = ts.Client()
-		ctx := context.Background()
-
-		var result struct{ Message string }
-		err := fetcher.DoRequestWithRateLimit(ctx, "POST", ts.URL, "token", []byte(`{}`), &result)
-		Expect(err).To(BeNil())
-		Expect(result.Message).To(Equal("ok"))
-	})
-
-	It("skal feile på ugyldig URL (DNS-feil)", func() {
-		var result any
-		ctx := context.Background()
-		err := fetcher.DoRequestWithRateLimit(ctx, "GET", "http://invalid-url", "token", nil, &result)
-		Expect(err).To(HaveOccurred())
-	})
-
-	It("skal feile på ugyldig request-format (syntax)", func() {
-		var result any
-		ctx := context.Background()
-		err := fetcher.DoRequestWithRateLimit(ctx, "GET", ":", "token", nil, &result)
-		Expect(err).To(HaveOccurred())
-	})
-
-	It("skal returnere feil hvis GitHub API svarer med status != 2xx", func() {
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(http.StatusForbidden) // 403
-			_, _ = fmt.Fprint(w, `{"message":"access denied"}`)
-		}))
-		defer ts.Close()
-
-		fetcher.HttpClient = ts.Client()
-		ctx := context.Background()
-
-		var result any
-		err := fetcher.DoRequestWithRateLimit(ctx, "GET", ts.URL, "token", nil, &result)
-		Expect(err).To(HaveOccurred())
-		Expect(err.Error()).To(ContainSubstring("GitHub API-feil"))
-		Expect(err.Error()).To(ContainSubstring("403"))
-		Expect(err.Error()).To(ContainSubstring("access denied"))
-	})
-
-	It("skal avbryte rate limit-ventetid ved kontekstkansellering", func() {
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("X-RateLimit-Remaining", "0")
-			w.Header().Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(60*time.Second).Unix()))
-			w.WriteHeader(http.StatusForbidden)
-			_, _ = fmt.Fprintln(w, `{}`)
-		}))
-		defer ts.Close()
-
-		fetcher.HttpClient = ts.Client()
-		ctx, cancel := context.WithCancel(context.Background())
-		go func() {
-			time.Sleep(50 * time.Millisecond)
-			cancel()
-		}()
-
-		start := time.Now()
-		var result any
-		err := fetcher.DoRequestWithRateLimit(ctx, "GET", ts.URL, "dummy-token", nil, &result)
-
-		Expect(err).To(HaveOccurred())
-		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
-		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
-	})
-
-	It("skal avbryte rate limit-ventetid ved shutdown-signal uten å kansellere hovedkonteksten", func() {
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("X-RateLimit-Remaining", "0")
-			w.Header().Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(60*time.Second).Unix()))
-			w.WriteHeader(http.StatusForbidden)
-			_, _ = fmt.Fprintln(w, `{}`)
-		}))
-		defer ts.Close()
-
-		fetcher.HttpClient = ts.Client()
-		baseCtx := context.Background()
-		waitCtx, cancelWait := context.WithCancel(context.Background())
-		go func() {
-			time.Sleep(50 * time.Millisecond)
-			cancelWait()
-		}()
-
-		start := time.Now()
-		var result any
-		err := fetcher.DoRequestWithRateLimit(fetcher.WithWaitInterrupt(baseCtx, waitCtx), "GET", ts.URL, "dummy-token", nil, &result)
-
-		Expect(err).To(HaveOccurred())
-		Expect(errors.Is(err, fetcher.ErrWaitInterrupted)).To(BeTrue())
-		Expect(baseCtx.Err()).NotTo(HaveOccurred())
-		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
-	})
-
-	It("skal prøve igjen ved serverfeil og lykkes til slutt", func() {
-		callCount := 0
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			callCount++
-			if callCount < 3 {
-				w.WriteHeader(http.StatusInternalServerError)
-				_, _ = fmt.Fprintln(w, `{"message":"internal error"}`)
-				return
-			}
-			w.WriteHeader(http.StatusOK)
-			_, _ = fmt.Fprintln(w, `{"message":"ok"}`)
-		}))
-		defer ts.Close()
-
-		fetcher.HttpClient = ts.Client()
-		ctx := context.Background()
-		var result struct{ Message string }
-		err := fetcher.DoRequestWithRateLimit(ctx, "GET", ts.URL, "token", nil, &result)
-
-		Expect(err).ToNot(HaveOccurred())
-		Expect(result.Message).To(Equal("ok"))
-		Expect(callCount).To(Equal(3))
-	})
-
-	It("skal gi opp etter max antall serverfeilforsøk", func() {
-		callCount := 0
-		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			callCount++
-			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = fmt.Fprintln(w, `{"message":"always fails"}`)
-		}))
-		defer ts.Close()
-
-		fetcher.HttpClient = ts.Client()
-		ctx := context.Background()
-		var result any
-		err := fetcher.DoRequestWithRateLimit(ctx, "GET", ts.URL, "token", nil, &result)
-
-		Expect(err).To(HaveOccurred())
-		Expect(err.Error()).To(ContainSubstring("GitHub API-feil etter"))
-		Expect(callCount).To(Equal(fetcher.MaxAttempts))
-	})
-})
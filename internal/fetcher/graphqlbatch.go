@@ -0,0 +1,242 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+)
+
+// graphQLBatchCostBudget is the highest rate limit cost we accept for a single
+// batched query. When GitHub reports a higher cost the batch size is scaled down.
+const graphQLBatchCostBudget = 10
+
+// graphQLBatchSizer adapts the number of repos per batched query. It halves the
+// size when a whole batch fails (typically a timeout on large file contents),
+// grows it by one after a clean batch that cost less than
+// graphQLBatchCostBudget, and scales it down when a batch cost more. A fixed
+// sizer never changes size, so batches are the same from run to run.
+type graphQLBatchSizer struct {
+	mu    sync.Mutex
+	max   int
+	size  int
+	fixed bool
+}
+
+func newGraphQLBatchSizer(max int) *graphQLBatchSizer {
+	if max < 1 {
+		max = 1
+	}
+	return &graphQLBatchSizer{max: max, size: max}
+}
+
+func (s *graphQLBatchSizer) current() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+func (s *graphQLBatchSizer) shrink() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fixed {
+		return
+	}
+	s.size = max(1, s.size/2)
+}
+
+func (s *graphQLBatchSizer) grow() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fixed {
+		return
+	}
+	s.size = min(s.max, s.size+1)
+}
+
+// observeCost scales the size down when a batch of n repos cost more than the
+// budget. It reports whether the batch cost less than the budget, so the size
+// may grow.
+func (s *graphQLBatchSizer) observeCost(cost float64, n int) bool {
+	if cost < graphQLBatchCostBudget {
+		return true
+	}
+	if cost == graphQLBatchCostBudget || n < 1 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fixed {
+		return false
+	}
+	s.size = max(1, min(s.size, int(float64(n)*graphQLBatchCostBudget/cost)))
+	return false
+}
+
+// graphQLBatchSizer returns the sizer shared by all batches of the fetcher. It
+// is fixed while recording or replaying, since a replay only finds a batched
+// query whose body, and so whose repos, are the same as in the recording.
+func (r *RepoFetcher) graphQLBatchSizer() *graphQLBatchSizer {
+	r.batchSizerOnce.Do(func() {
+		r.batchSizer = newGraphQLBatchSizer(r.Cfg.GraphQLBatchSize)
+		r.batchSizer.fixed = r.Cfg.HTTPRecord != "" || r.Cfg.HTTPReplay != ""
+	})
+	return r.batchSizer
+}
+
+// BuildBatchRepoQuery builds a query for count repositories, aliased r0..rN and
+// bound to the variables $name0..$nameN. It also asks for the query cost.
+func BuildBatchRepoQuery(count int) string {
+	var b strings.Builder
+	b.WriteString("\n\tquery RepoBatch($owner: String!")
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(&b, ", $name%d: String!", i)
+	}
+	b.WriteString(") {\n")
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(&b, "\t\tr%d: repository(owner: $owner, name: $name%d) {\n\t\t\t...RepoFields\n\t\t}\n", i, i)
+	}
+	b.WriteString("\t\trateLimit {\n\t\t\tcost\n\t\t\tlimit\n\t\t\tremaining\n\t\t\tresetAt\n\t\t}\n\t}")
+	b.WriteString(repoFieldsFragment)
+	return b.String()
+}
+
+// FetchReposGraphQL fetches and enriches several repos using batched GraphQL
+// queries. Results and errors are index-aligned with repos. Repos that fail
+// inside a batch are retried one by one through FetchRepoGraphQL.
+func (r *RepoFetcher) FetchReposGraphQL(ctx context.Context, repos []models.RepoMeta) ([]*models.RepoEntry, []error) {
+	entries := make([]*models.RepoEntry, len(repos))
+	errs := make([]error, len(repos))
+	sizer := r.graphQLBatchSizer()
+
+	for start := 0; start < len(repos); {
+		batch := repos[start:min(start+sizer.current(), len(repos))]
+		if len(batch) == 1 {
+			entries[start], errs[start] = r.FetchRepoGraphQL(ctx, batch[0])
+			start++
+			continue
+		}
+
+		parsed, cost, err := r.fetchRepoBatch(ctx, batch)
+		switch {
+		case err != nil && (errors.Is(err, ErrWaitInterrupted) || ctx.Err() != nil):
+			for i := start; i < len(repos); i++ {
+				errs[i] = err
+			}
+			return entries, errs
+		case err != nil:
+			slog.Warn("Batchet GraphQL-kall feilet, henter repoene enkeltvis", "antall", len(batch), "error", err)
+			sizer.shrink()
+			parsed = make([]*models.RepoEntry, len(batch))
+		case sizer.observeCost(cost, len(batch)):
+			sizer.grow()
+		}
+
+		for i, repo := range batch {
+			if parsed[i] != nil {
+				entries[start+i] = r.enrichEntry(ctx, repo, parsed[i])
+				continue
+			}
+			entries[start+i], errs[start+i] = r.FetchRepoGraphQL(ctx, repo)
+		}
+		start += len(batch)
+	}
+
+	return entries, errs
+}
+
+// fetchRepoBatch runs one batched query and returns the parsed, not yet
+// enriched, entries and the rate limit cost GitHub reported, or 0. Entries are
+// nil for repos that GraphQL reported errors for. An error means the batch as a
+// whole failed.
+func (r *RepoFetcher) fetchRepoBatch(ctx context.Context, batch []models.RepoMeta) ([]*models.RepoEntry, float64, error) {
+	variables := map[string]string{"owner": r.Cfg.Org}
+	for i, repo := range batch {
+		variables[fmt.Sprintf("name%d", i)] = repo.Name
+	}
+	bodyBytes, err := json.Marshal(map[string]interface{}{
+		"query":     BuildBatchRepoQuery(len(batch)),
+		"variables": variables,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var result map[string]interface{}
+	key, err := r.postGraphQL(ctx, fmt.Sprintf("%s (batch på %d)", r.Cfg.Org, len(batch)), bodyBytes, &result)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	gqlErrs := result["errors"]
+	data, _ := result["data"].(map[string]interface{})
+	if data == nil {
+		return nil, 0, fmt.Errorf("GraphQL returnerte ingen data for batch: %v", gqlErrs)
+	}
+
+	failed, global := graphQLErrorAliases(gqlErrs)
+	if global {
+		return nil, 0, fmt.Errorf("GraphQL returnerte feil for batch: %v", gqlErrs)
+	}
+
+	var cost float64
+	if rateLimit, ok := data["rateLimit"].(map[string]interface{}); ok {
+		cost, _ = rateLimit["cost"].(float64)
+		r.client.observeGraphQLRateLimit(key, rateLimit)
+	}
+
+	entries := make([]*models.RepoEntry, len(batch))
+	for i, repo := range batch {
+		alias := fmt.Sprintf("r%d", i)
+		if failed[alias] || data[alias] == nil {
+			slog.Debug("Repo feilet i GraphQL-batch", "repo", repo.FullName)
+			continue
+		}
+		entries[i] = ParseRepoData(map[string]interface{}{"repository": data[alias]}, repo)
+	}
+	return entries, cost, nil
+}
+
+// observeGraphQLRateLimit feeds a `rateLimit { limit remaining resetAt }`
+// selection to the client's limiter budget of the credential that answered. It can be
+// more recent than the headers when the query cost more than one point.
+func (c *Client) observeGraphQLRateLimit(key rateLimitKey, rateLimit map[string]interface{}) {
+	limit, okLimit := rateLimit["limit"].(float64)
+	remaining, okRemaining := rateLimit["remaining"].(float64)
+	resetAt, errReset := time.Parse(time.RFC3339, fmt.Sprint(rateLimit["resetAt"]))
+	if !okLimit || !okRemaining || errReset != nil {
+		return
+	}
+	c.Limiter.observe(key, int64(limit), int64(remaining), resetAt)
+}
+
+// graphQLErrorAliases returns the top-level aliases named in the error paths.
+// global is true when an error cannot be tied to a single alias. Errors on the
+// rateLimit field are ignored: GitHub Enterprise Server reports one there when
+// rate limiting is turned off, and the repos are still fine.
+func graphQLErrorAliases(errs interface{}) (map[string]bool, bool) {
+	aliases := map[string]bool{}
+	errorList, _ := errs.([]interface{})
+	for _, rawErr := range errorList {
+		errMap, _ := rawErr.(map[string]interface{})
+		pathList, _ := errMap["path"].([]interface{})
+		if len(pathList) == 0 {
+			return aliases, true
+		}
+		alias, ok := pathList[0].(string)
+		if !ok {
+			return aliases, true
+		}
+		if alias == "rateLimit" {
+			continue
+		}
+		aliases[alias] = true
+	}
+	return aliases, false
+}
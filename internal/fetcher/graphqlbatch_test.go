@@ -0,0 +1,210 @@
+package fetcher_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/jonmartinstorm/reposnusern/internal/config"
+	"github.com/jonmartinstorm/reposnusern/internal/fetcher"
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+)
+
+func minimalRepoJSON(readme string) string {
+	return fmt.Sprintf(`{"languages":{"edges":[]},"README":{"text":%q},"SECURITY":null,"dependabot":null,"codeql":null,"dependencies":{"entries":[]},"workflows":{"entries":[]}}`, readme)
+}
+
+var _ = Describe("BuildBatchRepoQuery", func() {
+	It("skal aliasere hvert repo og dele fragmentet", func() {
+		query := fetcher.BuildBatchRepoQuery(2)
+		Expect(query).To(ContainSubstring(`query RepoBatch($owner: String!, $name0: String!, $name1: String!)`))
+		Expect(query).To(ContainSubstring(`r0: repository(owner: $owner, name: $name0)`))
+		Expect(query).To(ContainSubstring(`r1: repository(owner: $owner, name: $name1)`))
+		Expect(query).To(ContainSubstring("fragment RepoFields on Repository"))
+		Expect(query).To(ContainSubstring("rateLimit"))
+		Expect(query).NotTo(ContainSubstring("r2:"))
+	})
+})
+
+var _ = Describe("FetchReposGraphQL", func() {
+	var client *fetcher.Client
+
+	BeforeEach(func() {
+		client = fetcher.NewClient(config.Config{})
+	})
+
+	repos := []models.RepoMeta{
+		{Name: "a", FullName: "testorg/a"},
+		{Name: "b", FullName: "testorg/b"},
+		{Name: "c", FullName: "testorg/c"},
+	}
+
+	serve := func(handler func(batch bool, variables map[string]interface{}) string) (*httptest.Server, *[]string) {
+		var mu sync.Mutex
+		var calls []string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body struct {
+				Query     string                 `json:"query"`
+				Variables map[string]interface{} `json:"variables"`
+			}
+			Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+			batch := strings.Contains(body.Query, "RepoBatch")
+
+			mu.Lock()
+			if batch {
+				calls = append(calls, "batch")
+			} else {
+				calls = append(calls, fmt.Sprint(body.Variables["name"]))
+			}
+			mu.Unlock()
+
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprintln(w, handler(batch, body.Variables))
+		}))
+		client.HTTP = ts.Client()
+		client.GraphQLURL = ts.URL
+		return ts, &calls
+	}
+
+	It("skal dele opp batch-svaret per repo i riktig rekkefølge", func() {
+		ts, calls := serve(func(batch bool, variables map[string]interface{}) string {
+			Expect(variables).To(HaveKeyWithValue("name2", "c"))
+			return fmt.Sprintf(`{"data":{"r0":%s,"r1":%s,"r2":%s,"rateLimit":{"cost":1,"remaining":4999}}}`,
+				minimalRepoJSON("A"), minimalRepoJSON("B"), minimalRepoJSON("C"))
+		})
+		defer ts.Close()
+
+		f := fetcher.NewRepoFetcherWithClient(config.Config{Org: "testorg", Token: "fake-token", GraphQLBatchSize: 10}, client, nil)
+		entries, errs := f.FetchReposGraphQL(context.Background(), repos)
+
+		Expect(errs).To(Equal([]error{nil, nil, nil}))
+		Expect(entries[0].Repo.Readme).To(Equal("A"))
+		Expect(entries[1].Repo.Readme).To(Equal("B"))
+		Expect(entries[2].Repo.FullName).To(Equal("testorg/c"))
+		Expect(*calls).To(Equal([]string{"batch"}))
+	})
+
+	It("skal hente repoer med delvise feil enkeltvis", func() {
+		ts, calls := serve(func(batch bool, variables map[string]interface{}) string {
+			if batch {
+				return fmt.Sprintf(`{"data":{"r0":%s,"r1":null,"r2":%s},"errors":[{"type":"NOT_FOUND","path":["r1"],"message":"Could not resolve to a Repository"}]}`,
+					minimalRepoJSON("A"), minimalRepoJSON("C"))
+			}
+			return fmt.Sprintf(`{"data":{"repository":%s}}`, minimalRepoJSON("B på nytt"))
+		})
+		defer ts.Close()
+
+		f := fetcher.NewRepoFetcherWithClient(config.Config{Org: "testorg", Token: "fake-token", GraphQLBatchSize: 10}, client, nil)
+		entries, errs := f.FetchReposGraphQL(context.Background(), repos)
+
+		Expect(errs).To(Equal([]error{nil, nil, nil}))
+		Expect(entries[1].Repo.Readme).To(Equal("B på nytt"))
+		Expect(*calls).To(Equal([]string{"batch", "b"}))
+	})
+
+	It("skal godta feil på rateLimit når GitHub Enterprise Server har slått av rate limit", func() {
+		ts, calls := serve(func(batch bool, variables map[string]interface{}) string {
+			return fmt.Sprintf(`{"data":{"r0":%s,"r1":%s,"r2":%s,"rateLimit":null},"errors":[{"path":["rateLimit"],"message":"Rate limiting is not enabled"}]}`,
+				minimalRepoJSON("A"), minimalRepoJSON("B"), minimalRepoJSON("C"))
+		})
+		defer ts.Close()
+
+		f := fetcher.NewRepoFetcherWithClient(config.Config{Org: "testorg", Token: "fake-token", GraphQLBatchSize: 10}, client, nil)
+		entries, errs := f.FetchReposGraphQL(context.Background(), repos)
+
+		Expect(errs).To(Equal([]error{nil, nil, nil}))
+		Expect(entries[2].Repo.Readme).To(Equal("C"))
+		Expect(*calls).To(Equal([]string{"batch"}))
+	})
+
+	It("skal falle tilbake til enkeltspørringer og krympe batchen når hele batchen feiler", func() {
+		ts, calls := serve(func(batch bool, variables map[string]interface{}) string {
+			if batch {
+				return `{"data":null,"errors":[{"message":"Something went wrong while executing your query. This may be the result of a timeout"}]}`
+			}
+			if variables["name"] == "c" {
+				return `{"errors":[{"message":"Could not resolve to a Repository"}]}`
+			}
+			return fmt.Sprintf(`{"data":{"repository":%s}}`, minimalRepoJSON("enkelt"))
+		})
+		defer ts.Close()
+
+		f := fetcher.NewRepoFetcherWithClient(config.Config{Org: "testorg", Token: "fake-token", GraphQLBatchSize: 2}, client, nil)
+		entries, errs := f.FetchReposGraphQL(context.Background(), repos)
+
+		Expect(errs[0]).NotTo(HaveOccurred())
+		Expect(errs[1]).NotTo(HaveOccurred())
+		Expect(errs[2]).To(MatchError(ContainSubstring("GraphQL returnerte feil")))
+		Expect(entries[0].Repo.Readme).To(Equal("enkelt"))
+		Expect(entries[2]).To(BeNil())
+		// The first batch of two fails and halves the size, so c is fetched alone.
+		Expect(*calls).To(Equal([]string{"batch", "a", "b", "c"}))
+	})
+
+	It("skal ikke vokse igjen etter en batch som kostet mer enn budsjettet", func() {
+		var sizes []int
+		ts, _ := serve(func(batch bool, variables map[string]interface{}) string {
+			n := len(variables) - 1 // owner
+			sizes = append(sizes, n)
+			data := make([]string, n)
+			for i := range data {
+				data[i] = fmt.Sprintf(`"r%d":%s`, i, minimalRepoJSON(""))
+			}
+			cost := 1
+			if len(sizes) == 1 {
+				cost = 20 // twice the budget, so the size is halved to 2
+			}
+			return fmt.Sprintf(`{"data":{%s,"rateLimit":{"cost":%d,"remaining":4999}}}`, strings.Join(data, ","), cost)
+		})
+		defer ts.Close()
+
+		var many []models.RepoMeta
+		for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"} {
+			many = append(many, models.RepoMeta{Name: name, FullName: "testorg/" + name})
+		}
+		f := fetcher.NewRepoFetcherWithClient(config.Config{Org: "testorg", Token: "fake-token", GraphQLBatchSize: 4}, client, nil)
+		_, errs := f.FetchReposGraphQL(context.Background(), many)
+
+		Expect(errs).To(HaveEach(BeNil()))
+		// The clean batch after the cut grows the size again.
+		Expect(sizes).To(Equal([]int{4, 2, 3}))
+	})
+
+	It("skal holde batchstørrelsen fast under opptak, så avspillingen får de samme batchene", func() {
+		var sizes []int
+		ts, _ := serve(func(batch bool, variables map[string]interface{}) string {
+			n := len(variables) - 1 // owner
+			sizes = append(sizes, n)
+			if !batch {
+				return fmt.Sprintf(`{"data":{"repository":%s}}`, minimalRepoJSON(""))
+			}
+			if len(sizes) == 1 {
+				return `{"data":null,"errors":[{"message":"Something went wrong while executing your query. This may be the result of a timeout"}]}`
+			}
+			data := make([]string, n)
+			for i := range data {
+				data[i] = fmt.Sprintf(`"r%d":%s`, i, minimalRepoJSON(""))
+			}
+			return fmt.Sprintf(`{"data":{%s,"rateLimit":{"cost":20,"remaining":4999}}}`, strings.Join(data, ","))
+		})
+		defer ts.Close()
+
+		var many []models.RepoMeta
+		for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i"} {
+			many = append(many, models.RepoMeta{Name: name, FullName: "testorg/" + name})
+		}
+		f := fetcher.NewRepoFetcherWithClient(config.Config{Org: "testorg", Token: "fake-token", GraphQLBatchSize: 4, HTTPRecord: "capture.jsonl.gz"}, client, nil)
+		_, errs := f.FetchReposGraphQL(context.Background(), many)
+
+		Expect(errs).To(HaveEach(BeNil()))
+		// A failed batch and one over the budget both leave the size alone.
+		Expect(sizes).To(Equal([]int{4, 1, 1, 1, 1, 4, 1}))
+	})
+})
@@ -0,0 +1,254 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CachedResponse is a stored REST response body together with the validators
+// GitHub sent for it.
+type CachedResponse struct {
+	ETag         string
+	LastModified string
+	Body         []byte
+}
+
+// ResponseCache stores REST responses keyed by URL so later runs can send
+// conditional requests. GitHub does not count 304 responses against the core
+// rate limit. A miss is reported as a nil response without error.
+type ResponseCache interface {
+	Get(ctx context.Context, url string) (*CachedResponse, error)
+	Put(ctx context.Context, url string, resp CachedResponse) error
+}
+
+// ResponseCacheStats counts how conditional requests went during a run.
+type ResponseCacheStats struct {
+	Hits   int64 // 304 responses answered from the cache
+	Misses int64 // cacheable requests that downloaded the full body
+	Errors int64 // cache reads or writes that failed
+}
+
+// lookupCachedResponse returns the cached response for a core GET, or nil when
+// the request is not cacheable or nothing is cached. Cache errors only cost
+// us the conditional request, so they are logged and treated as misses.
+func (c *Client) lookupCachedResponse(ctx context.Context, resource RateLimitResource, method, url string) *CachedResponse {
+	cache := c.Cache
+	if cache == nil || resource != RateLimitResourceCore || method != http.MethodGet {
+		return nil
+	}
+	cached, err := cache.Get(ctx, url)
+	if err != nil {
+		c.cacheErrors.Add(1)
+		slog.Warn("Kunne ikke lese fra HTTP-cache", "url", url, "error", err)
+		return nil
+	}
+	return cached
+}
+
+// setConditionalHeaders adds the validators of a cached response to req.
+func setConditionalHeaders(req *http.Request, cached *CachedResponse) {
+	if cached == nil {
+		return
+	}
+	if cached.ETag != "" {
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+	if cached.LastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+}
+
+// storeCachedResponse stores a successful core GET response that has validators.
+func (c *Client) storeCachedResponse(ctx context.Context, resource RateLimitResource, method, url string, headers http.Header, body []byte) {
+	cache := c.Cache
+	if cache == nil || resource != RateLimitResourceCore || method != http.MethodGet {
+		return
+	}
+	c.cacheMisses.Add(1)
+
+	resp := CachedResponse{
+		ETag:         headers.Get("ETag"),
+		LastModified: headers.Get("Last-Modified"),
+		Body:         body,
+	}
+	if resp.ETag == "" && resp.LastModified == "" {
+		return
+	}
+	if err := cache.Put(ctx, url, resp); err != nil {
+		c.cacheErrors.Add(1)
+		slog.Warn("Kunne ikke skrive til HTTP-cache", "url", url, "error", err)
+	}
+}
+
+// FileResponseCache keeps cached responses as files in a directory. When the
+// files grow past maxBytes, the least recently used ones are removed.
+type FileResponseCache struct {
+	dir      string
+	maxBytes int64
+
+	mu   sync.Mutex
+	size int64
+}
+
+type fileCacheEntry struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag"`
+	LastModified string `json:"last_modified"`
+	Body         []byte `json:"body"`
+}
+
+// NewFileResponseCache opens or creates a cache directory bounded to maxBytes.
+func NewFileResponseCache(dir string, maxBytes int64) (*FileResponseCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create cache directory: %w", err)
+	}
+	c := &FileResponseCache{dir: dir, maxBytes: maxBytes}
+	files, err := c.files()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		c.size += f.size
+	}
+	return c, nil
+}
+
+// Get returns the cached response for url and marks it as recently used.
+func (c *FileResponseCache) Get(_ context.Context, url string) (*CachedResponse, error) {
+	path := c.path(url)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entry fileCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.URL != url {
+		// A corrupt or colliding entry is just a miss; Put will replace it.
+		return nil, nil
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return &CachedResponse{ETag: entry.ETag, LastModified: entry.LastModified, Body: entry.Body}, nil
+}
+
+// Put stores resp for url and evicts old entries if the cache is over its size.
+func (c *FileResponseCache) Put(_ context.Context, url string, resp CachedResponse) error {
+	data, err := json.Marshal(fileCacheEntry{URL: url, ETag: resp.ETag, LastModified: resp.LastModified, Body: resp.Body})
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > c.maxBytes {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path := c.path(url)
+	var oldSize int64
+	if info, err := os.Stat(path); err == nil {
+		oldSize = info.Size()
+	}
+
+	tmp, err := os.CreateTemp(c.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	c.size += int64(len(data)) - oldSize
+	if c.size > c.maxBytes {
+		return c.evict()
+	}
+	return nil
+}
+
+// Size returns the number of bytes the cache currently holds on disk.
+func (c *FileResponseCache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+type cacheFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// evict removes the least recently used entries until the cache is back under
+// its size. Callers must hold c.mu.
+func (c *FileResponseCache) evict() error {
+	files, err := c.files()
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	c.size = 0
+	for _, f := range files {
+		c.size += f.size
+	}
+	for _, f := range files {
+		if c.size <= c.maxBytes {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		c.size -= f.size
+	}
+	return nil
+}
+
+func (c *FileResponseCache) files() ([]cacheFile, error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []cacheFile
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cacheFile{path: filepath.Join(c.dir, e.Name()), size: info.Size(), modTime: info.ModTime()})
+	}
+	return files, nil
+}
+
+func (c *FileResponseCache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
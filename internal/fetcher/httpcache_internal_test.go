@@ -0,0 +1,121 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDoRequestAnswersNotModifiedFromCache(t *testing.T) {
+	cache, err := NewFileResponseCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("could not create cache: %v", err)
+	}
+	reset := fmt.Sprint(time.Now().Add(time.Hour).Unix())
+	var conditional []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional = append(conditional, r.Header.Get("If-None-Match"))
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "4000")
+		w.Header().Set("X-RateLimit-Reset", reset)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = fmt.Fprintln(w, `{"name":"repo"}`)
+	}))
+	defer ts.Close()
+	client := newTestClient(ts)
+	client.Cache = cache
+
+	for range 2 {
+		var out map[string]string
+		if err := client.doRequest(context.Background(), RateLimitResourceCore, "GET", ts.URL, "token", nil, &out, false); err != nil {
+			t.Fatalf("request returned error: %v", err)
+		}
+		if out["name"] != "repo" {
+			t.Fatalf("expected body from server or cache, got %v", out)
+		}
+	}
+
+	if len(conditional) != 2 || conditional[0] != "" || conditional[1] != `"v1"` {
+		t.Fatalf("expected second request to be conditional, got %q", conditional)
+	}
+	if stats := client.Stats().Cache; stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("expected one hit and one miss, got %+v", stats)
+	}
+	if got := client.Limiter.Stats()[RateLimitResourceCore].Remaining; got != 4000 {
+		t.Fatalf("expected the 304 not to use budget, remaining %d", got)
+	}
+}
+
+func TestDoRequestDoesNotCachePostRequests(t *testing.T) {
+	cache, err := NewFileResponseCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("could not create cache: %v", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = fmt.Fprintln(w, `{}`)
+	}))
+	defer ts.Close()
+	client := newTestClient(ts)
+	client.Cache = cache
+
+	var out map[string]interface{}
+	if err := client.doRequest(context.Background(), RateLimitResourceGraphQL, "POST", ts.URL, "token", []byte(`{}`), &out, false); err != nil {
+		t.Fatalf("request returned error: %v", err)
+	}
+	if cache.Size() != 0 {
+		t.Fatalf("expected GraphQL POST not to be cached, cache holds %d bytes", cache.Size())
+	}
+}
+
+func TestFileResponseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	body := []byte(strings.Repeat("x", 400))
+	cache, err := NewFileResponseCache(dir, 1500)
+	if err != nil {
+		t.Fatalf("could not create cache: %v", err)
+	}
+
+	ctx := context.Background()
+	for _, url := range []string{"https://a", "https://b"} {
+		if err := cache.Put(ctx, url, CachedResponse{ETag: url, Body: body}); err != nil {
+			t.Fatalf("put %s: %v", url, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cached, _ := cache.Get(ctx, "https://a"); cached == nil {
+		t.Fatal("expected a to be cached")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := cache.Put(ctx, "https://c", CachedResponse{ETag: "c", Body: body}); err != nil {
+		t.Fatalf("put c: %v", err)
+	}
+
+	if cached, _ := cache.Get(ctx, "https://b"); cached != nil {
+		t.Fatal("expected least recently used entry b to be evicted")
+	}
+	for _, url := range []string{"https://a", "https://c"} {
+		if cached, _ := cache.Get(ctx, url); cached == nil || string(cached.Body) != string(body) {
+			t.Fatalf("expected %s to survive eviction", url)
+		}
+	}
+	if cache.Size() > 1500 {
+		t.Fatalf("expected cache to stay within its bound, holds %d bytes", cache.Size())
+	}
+
+	reopened, err := NewFileResponseCache(dir, 1500)
+	if err != nil {
+		t.Fatalf("could not reopen cache: %v", err)
+	}
+	if reopened.Size() != cache.Size() {
+		t.Fatalf("expected reopened cache to count existing files, got %d want %d", reopened.Size(), cache.Size())
+	}
+}
@@ -2,6 +2,9 @@ package fetcher
 
 import (
 	"context"
+	"fmt"
+	"log/slog"
+	"math"
 	"sync"
 	"time"
 )
@@ -13,12 +16,28 @@ const (
 	RateLimitResourceGraphQL RateLimitResource = "graphql"
 )
 
-// RateLimitStats summarizes how often a resource was blocked and waited on.
+// rateLimitPacingFraction is the share of the limit below which the remaining
+// budget is spread evenly over the time left until reset. Above it requests
+// run at full speed.
+const rateLimitPacingFraction = 0.25
+
+// RateLimitStats summarizes how often a resource was blocked and waited on,
+// and the last budget GitHub reported for it.
 type RateLimitStats struct {
 	Hits       int64
 	Extensions int64
 	Waits      int64
 	TotalWait  time.Duration
+
+	Limit           int64
+	Remaining       int64
+	ResetAt         time.Time
+	BudgetWaits     int64
+	TotalBudgetWait time.Duration // summed over all waiting workers
+
+	// Secondary (abuse-detection) limits are counted apart from primary ones.
+	SecondaryHits      int64
+	TotalSecondaryWait time.Duration
 }
 
 type rateLimitState struct {
@@ -27,6 +46,26 @@ type rateLimitState struct {
 	extensions   int64
 	waits        int64
 	totalWait    time.Duration
+
+	// Budget reported by GitHub for the current window, with remaining
+	// decremented locally between responses.
+	limit           int64
+	remaining       int64
+	resetAt         time.Time
+	nextRequest     time.Time
+	exhaustedLogged time.Time
+	budgetWaits     int64
+	totalBudgetWait time.Duration
+
+	secondaryHits      int64
+	totalSecondaryWait time.Duration
+}
+
+// rateLimitKey identifies one budget: a resource bucket of one credential. The
+// empty credential is used when requests are made with a single token.
+type rateLimitKey struct {
+	credential string
+	resource   RateLimitResource
 }
 
 type BlockResult struct {
@@ -38,39 +77,148 @@ type BlockResult struct {
 
 type ResourceRateLimiter struct {
 	mu     sync.Mutex
-	states map[RateLimitResource]*rateLimitState
-}
+	states map[rateLimitKey]*rateLimitState
 
-// SharedRateLimiter coordinates cooldowns across all fetcher requests.
-var SharedRateLimiter = NewResourceRateLimiter()
+	// reservePercent of each limit is left for other consumers of the token.
+	reservePercent int64
+}
 
 // NewResourceRateLimiter creates limiter state for the GitHub resources we track.
 func NewResourceRateLimiter() *ResourceRateLimiter {
-	return &ResourceRateLimiter{
-		states: map[RateLimitResource]*rateLimitState{
-			RateLimitResourceCore:    {},
-			RateLimitResourceGraphQL: {},
-		},
+	return &ResourceRateLimiter{states: newRateLimitStates()}
+}
+
+func newRateLimitStates() map[rateLimitKey]*rateLimitState {
+	return map[rateLimitKey]*rateLimitState{
+		{resource: RateLimitResourceCore}:    {},
+		{resource: RateLimitResourceGraphQL}: {},
 	}
 }
 
-// Wait blocks until the selected resource is no longer in cooldown.
+// Wait blocks until the selected resource is no longer in cooldown and the
+// known budget allows another request.
 func (l *ResourceRateLimiter) Wait(ctx context.Context, resource RateLimitResource) error {
+	return l.waitFor(ctx, rateLimitKey{resource: resource})
+}
+
+func (l *ResourceRateLimiter) waitFor(ctx context.Context, key rateLimitKey) error {
 	for {
 		l.mu.Lock()
-		state := l.state(resource)
-		wait := time.Until(state.blockedUntil)
-		if wait <= 0 {
+		state := l.state(key)
+		now := time.Now()
+		wait := state.blockedUntil.Sub(now)
+		if wait > 0 {
+			state.waits++
+		} else if wait = l.budgetDelay(key, state, now); wait > 0 {
+			state.budgetWaits++
+			state.totalBudgetWait += wait
+		} else {
+			if state.remaining > 0 && now.Before(state.resetAt) {
+				state.remaining--
+			}
 			l.mu.Unlock()
 			return nil
 		}
-		state.waits++
 		l.mu.Unlock()
 
 		if err := sleepWithContext(ctx, wait); err != nil {
-			return err
+			return fmt.Errorf("%w: %w", ErrRateLimited, err)
+		}
+	}
+}
+
+// budgetDelay returns how long the next request has to wait to keep the budget
+// lasting until reset. Callers must hold l.mu. A zero delay claims the slot.
+func (l *ResourceRateLimiter) budgetDelay(key rateLimitKey, state *rateLimitState, now time.Time) time.Duration {
+	if state.limit <= 0 || !now.Before(state.resetAt) {
+		return 0
+	}
+
+	usable := state.remaining - state.limit*l.reservePercent/100
+	if usable <= 0 {
+		if !state.exhaustedLogged.Equal(state.resetAt) {
+			state.exhaustedLogged = state.resetAt
+			slog.Warn("Rate limit-budsjett brukt opp, venter til reset", "ressurs", key.resource, "credential", key.credential, "gjenstår", state.remaining, "grense", state.limit, "reset_at", formatResetAtForLog(state.resetAt))
 		}
+		return state.resetAt.Sub(now) + time.Second
+	}
+	if float64(usable) >= float64(state.limit)*rateLimitPacingFraction {
+		return 0
+	}
+
+	if state.nextRequest.After(now) {
+		return state.nextRequest.Sub(now)
 	}
+	state.nextRequest = now.Add(state.resetAt.Sub(now) / time.Duration(usable))
+	return 0
+}
+
+// headroom returns when a budget can next be used and how many requests it
+// has left above the reserve. An unknown budget has unlimited headroom, so
+// untried credentials are preferred until their first response is observed.
+func (l *ResourceRateLimiter) headroom(key rateLimitKey, now time.Time) (time.Time, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := l.state(key)
+	readyAt := state.blockedUntil
+	if state.limit <= 0 || !now.Before(state.resetAt) {
+		return readyAt, math.MaxInt64
+	}
+	usable := state.remaining - state.limit*l.reservePercent/100
+	if usable <= 0 && state.resetAt.After(readyAt) {
+		readyAt = state.resetAt
+	}
+	return readyAt, usable
+}
+
+// Observe records the budget GitHub reported in a response. Responses can
+// arrive out of order, so within one window the lowest remaining count wins
+// and responses from an earlier window are ignored.
+func (l *ResourceRateLimiter) Observe(resource RateLimitResource, limit, remaining int64, resetAt time.Time) {
+	l.observe(rateLimitKey{resource: resource}, limit, remaining, resetAt)
+}
+
+func (l *ResourceRateLimiter) observe(key rateLimitKey, limit, remaining int64, resetAt time.Time) {
+	if limit <= 0 || resetAt.IsZero() {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := l.state(key)
+	switch {
+	case resetAt.After(state.resetAt.Add(time.Second)):
+		state.limit = limit
+		state.remaining = remaining
+		state.resetAt = resetAt
+		state.nextRequest = time.Time{}
+	case resetAt.Before(state.resetAt.Add(-time.Second)):
+		return
+	default:
+		state.limit = limit
+		state.remaining = min(state.remaining, remaining)
+	}
+}
+
+// refund gives back a request claimed by Wait that GitHub did not charge for.
+func (l *ResourceRateLimiter) refund(key rateLimitKey) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := l.state(key)
+	if state.remaining < state.limit && time.Now().Before(state.resetAt) {
+		state.remaining++
+	}
+}
+
+// SetReservePercent sets how much of each limit is left unused for other
+// consumers of the same token.
+func (l *ResourceRateLimiter) SetReservePercent(percent int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reservePercent = int64(min(max(percent, 0), 100))
 }
 
 // BlockFor marks a resource as unavailable for the next wait duration.
@@ -82,9 +230,20 @@ func (l *ResourceRateLimiter) BlockFor(resource RateLimitResource, wait time.Dur
 	return l.BlockUntil(resource, time.Now().Add(wait))
 }
 
+func (l *ResourceRateLimiter) blockFor(key rateLimitKey, wait time.Duration) BlockResult {
+	if wait <= 0 {
+		return BlockResult{}
+	}
+	return l.blockUntil(key, time.Now().Add(wait))
+}
+
 // BlockUntil extends a resource cooldown if the new deadline is later.
 // It returns whether this call started or extended the active cooldown window.
 func (l *ResourceRateLimiter) BlockUntil(resource RateLimitResource, until time.Time) BlockResult {
+	return l.blockUntil(rateLimitKey{resource: resource}, until)
+}
+
+func (l *ResourceRateLimiter) blockUntil(key rateLimitKey, until time.Time) BlockResult {
 	if until.IsZero() {
 		return BlockResult{}
 	}
@@ -92,78 +251,106 @@ func (l *ResourceRateLimiter) BlockUntil(resource RateLimitResource, until time.
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	state := l.state(resource)
+	state := l.state(key)
+	result, added := state.extendBlock(until, time.Now())
+	state.totalWait += added
+	if result.StartedNewBlock {
+		state.hits++
+	}
+	if result.ExtendedBlock {
+		state.extensions++
+	}
+	return result
+}
+
+// BlockSecondary blocks a resource after a secondary rate limit. It shares the
+// cooldown with primary limits but is counted separately.
+func (l *ResourceRateLimiter) BlockSecondary(resource RateLimitResource, wait time.Duration) BlockResult {
+	return l.blockSecondary(rateLimitKey{resource: resource}, wait)
+}
+
+func (l *ResourceRateLimiter) blockSecondary(key rateLimitKey, wait time.Duration) BlockResult {
+	if wait <= 0 {
+		return BlockResult{}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state := l.state(key)
 	now := time.Now()
+	result, added := state.extendBlock(now.Add(wait), now)
+	state.totalSecondaryWait += added
+	if result.StartedNewBlock || result.ExtendedBlock {
+		state.secondaryHits++
+	}
+	return result
+}
+
+// extendBlock moves blockedUntil to until if that is later and returns how
+// much blocked time it added. Callers must hold l.mu.
+func (state *rateLimitState) extendBlock(until, now time.Time) (BlockResult, time.Duration) {
 	prevUntil := state.blockedUntil
 	result := BlockResult{
 		StartedNewBlock: !prevUntil.After(now) && until.After(now),
 	}
+	var added time.Duration
 	if until.After(prevUntil) {
 		start := now
 		if prevUntil.After(start) {
 			start = prevUntil
 		}
 		state.blockedUntil = until
-		state.totalWait += until.Sub(start)
+		added = until.Sub(start)
 		result.ExtendedBlock = prevUntil.After(now)
 	}
-	if result.StartedNewBlock {
-		state.hits++
-	}
-	if result.ExtendedBlock {
-		state.extensions++
-	}
-	result.RemainingCooldown = time.Until(state.blockedUntil)
-	if result.RemainingCooldown < 0 {
-		result.RemainingCooldown = 0
-	}
+	result.RemainingCooldown = max(state.blockedUntil.Sub(now), 0)
 	result.BlockedUntil = state.blockedUntil
-	return result
+	return result, added
 }
 
-// Stats returns a snapshot of the accumulated limiter counters per resource.
+// Stats returns a snapshot of the accumulated limiter counters per resource,
+// summed over all credentials. ResetAt is the earliest reset among them.
 func (l *ResourceRateLimiter) Stats() map[RateLimitResource]RateLimitStats {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	stats := make(map[RateLimitResource]RateLimitStats, len(l.states))
-	for resource, state := range l.states {
-		stats[resource] = RateLimitStats{
-			Hits:       state.hits,
-			Extensions: state.extensions,
-			Waits:      state.waits,
-			TotalWait:  state.totalWait,
+	stats := map[RateLimitResource]RateLimitStats{}
+	for key, state := range l.states {
+		s := stats[key.resource]
+		s.Hits += state.hits
+		s.Extensions += state.extensions
+		s.Waits += state.waits
+		s.TotalWait += state.totalWait
+
+		s.Limit += state.limit
+		s.Remaining += state.remaining
+		if !state.resetAt.IsZero() && (s.ResetAt.IsZero() || state.resetAt.Before(s.ResetAt)) {
+			s.ResetAt = state.resetAt
 		}
+		s.BudgetWaits += state.budgetWaits
+		s.TotalBudgetWait += state.totalBudgetWait
+
+		s.SecondaryHits += state.secondaryHits
+		s.TotalSecondaryWait += state.totalSecondaryWait
+		stats[key.resource] = s
 	}
 	return stats
 }
 
-// Reset clears all tracked cooldowns and counters.
+// Reset clears all tracked cooldowns, budgets and counters. The reserve is kept.
 func (l *ResourceRateLimiter) Reset() {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	l.states = map[RateLimitResource]*rateLimitState{
-		RateLimitResourceCore:    {},
-		RateLimitResourceGraphQL: {},
-	}
+	l.states = newRateLimitStates()
 }
 
-func (l *ResourceRateLimiter) state(resource RateLimitResource) *rateLimitState {
-	state, ok := l.states[resource]
+func (l *ResourceRateLimiter) state(key rateLimitKey) *rateLimitState {
+	state, ok := l.states[key]
 	if !ok {
 		state = &rateLimitState{}
-		l.states[resource] = state
+		l.states[key] = state
 	}
 	return state
 }
-
-// GetRateLimitStats returns the shared limiter stats used by the runner summary.
-func GetRateLimitStats() map[RateLimitResource]RateLimitStats {
-	return SharedRateLimiter.Stats()
-}
-
-// ResetRateLimitStats clears shared limiter state before a new run starts.
-func ResetRateLimitStats() {
-	SharedRateLimiter.Reset()
-}
@@ -7,6 +7,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/config"
 )
 
 func TestResourceRateLimiterBlocksOnlyMatchingResource(t *testing.T) {
@@ -135,3 +137,101 @@ func TestResourceRateLimiterBlockForReportsCooldownLifecycle(t *testing.T) {
 		t.Fatalf("expected 1 extension, got %d", stats.Extensions)
 	}
 }
+
+func TestResourceRateLimiterSpendsFreelyWithAmpleBudget(t *testing.T) {
+	limiter := NewResourceRateLimiter()
+	limiter.Observe(RateLimitResourceCore, 5000, 4000, time.Now().Add(time.Hour))
+
+	start := time.Now()
+	for range 3 {
+		if err := limiter.Wait(context.Background(), RateLimitResourceCore); err != nil {
+			t.Fatalf("wait returned error: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= 20*time.Millisecond {
+		t.Fatalf("expected no pacing with ample budget, took %s", elapsed)
+	}
+
+	stats := limiter.Stats()[RateLimitResourceCore]
+	if stats.Remaining != 3997 || stats.Limit != 5000 {
+		t.Fatalf("expected remaining 3997 of 5000, got %d of %d", stats.Remaining, stats.Limit)
+	}
+}
+
+func TestResourceRateLimiterWaitsForResetWhenOnlyReserveIsLeft(t *testing.T) {
+	limiter := NewResourceRateLimiter()
+	limiter.SetReservePercent(10)
+	limiter.Observe(RateLimitResourceGraphQL, 5000, 500, time.Now().Add(time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx, RateLimitResourceGraphQL); err == nil {
+		t.Fatal("expected wait to block until reset when only the reserve is left")
+	}
+
+	stats := limiter.Stats()[RateLimitResourceGraphQL]
+	if stats.BudgetWaits != 1 {
+		t.Fatalf("expected 1 budget wait, got %d", stats.BudgetWaits)
+	}
+	if stats.Hits != 0 {
+		t.Fatalf("proactive budget waits should not count as rate limit hits, got %d", stats.Hits)
+	}
+}
+
+func TestResourceRateLimiterPacesLowBudgetUntilReset(t *testing.T) {
+	limiter := NewResourceRateLimiter()
+	// 20 usable requests over ~400ms gives one request per ~20ms.
+	limiter.Observe(RateLimitResourceCore, 100, 20, time.Now().Add(400*time.Millisecond))
+
+	if err := limiter.Wait(context.Background(), RateLimitResourceCore); err != nil {
+		t.Fatalf("first wait returned error: %v", err)
+	}
+	start := time.Now()
+	if err := limiter.Wait(context.Background(), RateLimitResourceCore); err != nil {
+		t.Fatalf("second wait returned error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Fatalf("expected second request to be paced, took %s", elapsed)
+	}
+}
+
+func TestResourceRateLimiterObserveKeepsLowestRemainingInWindow(t *testing.T) {
+	limiter := NewResourceRateLimiter()
+	reset := time.Now().Add(time.Hour)
+
+	limiter.Observe(RateLimitResourceCore, 5000, 100, reset)
+	limiter.Observe(RateLimitResourceCore, 5000, 150, reset)
+	if got := limiter.Stats()[RateLimitResourceCore].Remaining; got != 100 {
+		t.Fatalf("expected late response not to raise remaining, got %d", got)
+	}
+
+	limiter.Observe(RateLimitResourceCore, 5000, 5000, reset.Add(-time.Hour))
+	if got := limiter.Stats()[RateLimitResourceCore].Remaining; got != 100 {
+		t.Fatalf("expected response from earlier window to be ignored, got %d", got)
+	}
+
+	limiter.Observe(RateLimitResourceCore, 5000, 4999, reset.Add(time.Hour))
+	if got := limiter.Stats()[RateLimitResourceCore].Remaining; got != 4999 {
+		t.Fatalf("expected new window to reset remaining, got %d", got)
+	}
+}
+
+func TestObserveRateLimitHeadersIgnoresOtherBuckets(t *testing.T) {
+	c := NewClient(config.Config{})
+
+	headers := http.Header{}
+	headers.Set("X-RateLimit-Limit", "30")
+	headers.Set("X-RateLimit-Remaining", "29")
+	headers.Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(time.Minute).Unix()))
+	headers.Set("X-RateLimit-Resource", "search")
+	c.observeRateLimitHeaders(rateLimitKey{resource: RateLimitResourceCore}, headers)
+	if got := c.Limiter.Stats()[RateLimitResourceCore].Limit; got != 0 {
+		t.Fatalf("expected search bucket to be ignored, got limit %d", got)
+	}
+
+	headers.Set("X-RateLimit-Resource", "core")
+	c.observeRateLimitHeaders(rateLimitKey{resource: RateLimitResourceCore}, headers)
+	if got := c.Limiter.Stats()[RateLimitResourceCore].Remaining; got != 29 {
+		t.Fatalf("expected core budget to be recorded, got remaining %d", got)
+	}
+}
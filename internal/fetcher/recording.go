@@ -0,0 +1,241 @@
+package fetcher
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordedExchange is one request and response in a capture archive. Request
+// headers are never stored, so the archive holds no Authorization header, and
+// minted installation tokens are scrubbed from response bodies.
+type recordedExchange struct {
+	Method            string      `json:"method"`
+	URL               string      `json:"url"`
+	RequestBodySHA256 string      `json:"request_body_sha256,omitempty"`
+	Status            int         `json:"status"`
+	Header            http.Header `json:"header"`
+	Body              []byte      `json:"body"`
+	RecordedAt        time.Time   `json:"recorded_at"`
+}
+
+func (e recordedExchange) key() string {
+	return e.Method + " " + e.URL + " " + e.RequestBodySHA256
+}
+
+// scrubbedResponseHeaders are left out of captures.
+var scrubbedResponseHeaders = []string{"Set-Cookie", "Authorization"}
+
+// scrubbedInstallationToken replaces the token in recorded responses that mint
+// GitHub App installation tokens. A replay never sends it anywhere.
+const scrubbedInstallationToken = "scrubbed"
+
+// scrubResponseBody removes installation tokens from the body of a response
+// to req. Other bodies are returned as they are.
+func scrubResponseBody(req *http.Request, body []byte) []byte {
+	if req.Method != http.MethodPost || !strings.HasSuffix(req.URL.Path, "/access_tokens") {
+		return body
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields["token"] == nil {
+		return body
+	}
+	fields["token"], _ = json.Marshal(scrubbedInstallationToken)
+	scrubbed, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return scrubbed
+}
+
+// RecordingTransport passes requests on to a base transport and writes every
+// response to a gzip-compressed JSON Lines archive that ReplayTransport can
+// serve later. Close must be called to flush the archive.
+type RecordingTransport struct {
+	base http.RoundTripper
+
+	mu   sync.Mutex
+	file *os.File
+	gz   *gzip.Writer
+	enc  *json.Encoder
+}
+
+// NewRecordingTransport creates the archive at path. A nil base means
+// http.DefaultTransport.
+func NewRecordingTransport(base http.RoundTripper, path string) (*RecordingTransport, error) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not create capture archive: %w", err)
+	}
+	gz := gzip.NewWriter(file)
+	return &RecordingTransport{base: base, file: file, gz: gz, enc: json.NewEncoder(gz)}, nil
+}
+
+// RoundTrip sends req and records the response.
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if reqBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	header := resp.Header.Clone()
+	for _, name := range scrubbedResponseHeaders {
+		header.Del(name)
+	}
+	exchange := recordedExchange{
+		Method:            req.Method,
+		URL:               req.URL.String(),
+		RequestBodySHA256: bodyHash(reqBody),
+		Status:            resp.StatusCode,
+		Header:            header,
+		Body:              scrubResponseBody(req, respBody),
+		RecordedAt:        time.Now().UTC(),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.enc == nil {
+		return nil, errors.New("capture archive is closed")
+	}
+	if err := t.enc.Encode(exchange); err != nil {
+		return nil, fmt.Errorf("could not write to capture archive: %w", err)
+	}
+	return resp, nil
+}
+
+// Close flushes and closes the archive.
+func (t *RecordingTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.enc == nil {
+		return nil
+	}
+	t.enc = nil
+	return errors.Join(t.gz.Close(), t.file.Close())
+}
+
+// ReplayTransport serves the responses of a capture archive without network
+// access. Requests are matched on method, URL and request body. Repeated
+// requests get the recorded responses in order, and the last one again once
+// they run out, so retries replay the way they were recorded.
+type ReplayTransport struct {
+	mu        sync.Mutex
+	exchanges map[string][]recordedExchange
+	served    map[string]int
+}
+
+// NewReplayTransport loads the archive at path.
+func NewReplayTransport(path string) (*ReplayTransport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open capture archive: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read capture archive: %w", err)
+	}
+
+	t := &ReplayTransport{exchanges: map[string][]recordedExchange{}, served: map[string]int{}}
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 0, 1<<20), 256<<20)
+	for scanner.Scan() {
+		var exchange recordedExchange
+		if err := json.Unmarshal(scanner.Bytes(), &exchange); err != nil {
+			return nil, fmt.Errorf("could not parse capture archive: %w", err)
+		}
+		t.exchanges[exchange.key()] = append(t.exchanges[exchange.key()], exchange)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read capture archive: %w", err)
+	}
+	return t, nil
+}
+
+// RoundTrip returns the next recorded response for req.
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if reqBody, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+	}
+	key := recordedExchange{Method: req.Method, URL: req.URL.String(), RequestBodySHA256: bodyHash(reqBody)}.key()
+
+	t.mu.Lock()
+	exchanges := t.exchanges[key]
+	if len(exchanges) == 0 {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("no recorded response for %s %s", req.Method, req.URL)
+	}
+	exchange := exchanges[min(t.served[key], len(exchanges)-1)]
+	t.served[key]++
+	t.mu.Unlock()
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.Status, http.StatusText(exchange.Status)),
+		StatusCode:    exchange.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        shiftRateLimitReset(exchange.Header, time.Since(exchange.RecordedAt)),
+		Body:          io.NopCloser(bytes.NewReader(exchange.Body)),
+		ContentLength: int64(len(exchange.Body)),
+		Request:       req,
+	}, nil
+}
+
+// shiftRateLimitReset moves X-RateLimit-Reset forward by the time since the
+// recording, so rate-limit waits in a replay are as long as they were live.
+func shiftRateLimitReset(header http.Header, elapsed time.Duration) http.Header {
+	header = header.Clone()
+	if header == nil {
+		return http.Header{}
+	}
+	if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		header.Set("X-RateLimit-Reset", strconv.FormatInt(time.Unix(reset, 0).Add(elapsed).Unix(), 10))
+	}
+	return header
+}
+
+func bodyHash(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,193 @@
+package fetcher
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/config"
+)
+
+func TestRecordAndReplayServesTheSameRun(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Unix()
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "4000")
+		w.Header().Set("X-RateLimit-Reset", fmt.Sprint(reset))
+		w.Header().Set("Set-Cookie", "session=secret")
+		if r.Method == http.MethodPost {
+			body, _ := io.ReadAll(r.Body)
+			_, _ = fmt.Fprintf(w, `{"echo":%q}`, body)
+			return
+		}
+		if calls == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"call":%d}`, calls)
+	}))
+	defer ts.Close()
+
+	archive := filepath.Join(t.TempDir(), "capture.jsonl.gz")
+	recorder, err := NewRecordingTransport(ts.Client().Transport, archive)
+	if err != nil {
+		t.Fatalf("could not create recorder: %v", err)
+	}
+	client := newTestClient(ts)
+	client.HTTP.Transport = recorder
+
+	run := func(c *Client) (map[string]interface{}, map[string]interface{}) {
+		ctx := context.Background()
+		var get, post map[string]interface{}
+		if err := c.doRequest(ctx, RateLimitResourceCore, "GET", ts.URL+"/repos/o/r", "secret-token", nil, &get, false); err != nil {
+			t.Fatalf("GET returned error: %v", err)
+		}
+		if err := c.doRequest(ctx, RateLimitResourceGraphQL, "POST", ts.URL+"/graphql", "secret-token", []byte(`{"query":"a"}`), &post, false); err != nil {
+			t.Fatalf("POST returned error: %v", err)
+		}
+		return get, post
+	}
+	recordedGet, recordedPost := run(client)
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("could not close recorder: %v", err)
+	}
+
+	raw := readGzip(t, archive)
+	if strings.Contains(raw, "secret-token") || strings.Contains(raw, "session=secret") {
+		t.Fatalf("expected credentials to be scrubbed from the archive:\n%s", raw)
+	}
+
+	ts.Close()
+	replay, err := NewReplayTransport(archive)
+	if err != nil {
+		t.Fatalf("could not load archive: %v", err)
+	}
+	offline := newTestClient(ts)
+	offline.HTTP = &http.Client{Transport: replay}
+	replayedGet, replayedPost := run(offline)
+
+	if fmt.Sprint(replayedGet) != fmt.Sprint(recordedGet) || fmt.Sprint(replayedPost) != fmt.Sprint(recordedPost) {
+		t.Fatalf("replay differs from recording: %v %v, want %v %v", replayedGet, replayedPost, recordedGet, recordedPost)
+	}
+	if got := offline.Limiter.Stats()[RateLimitResourceCore].Limit; got != 5000 {
+		t.Fatalf("expected recorded rate-limit headers to be replayed, limit %d", got)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/not-recorded", nil)
+	if _, err := replay.RoundTrip(req); err == nil {
+		t.Fatal("expected an error for a request that was not recorded")
+	}
+}
+
+func TestRecordAndReplayMintGitHubAppTokens(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	mints := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/app/installations/42/access_tokens") {
+			mints++
+			w.WriteHeader(http.StatusCreated)
+			_, _ = fmt.Fprintf(w, `{"token":"ghs_installation","expires_at":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer ghs_installation" {
+			t.Errorf("expected the minted token, got %q", got)
+		}
+		_, _ = fmt.Fprintln(w, `{"name":"r"}`)
+	}))
+	defer ts.Close()
+
+	cfg := config.Config{
+		GitHubURL:         ts.URL,
+		Feature_GitHubApp: true,
+		GitHubAppConfig:   &config.GitHubAppConfig{AppID: 1, InstallationID: 42, PrivateKey: privateKey},
+	}
+	archive := filepath.Join(t.TempDir(), "capture.jsonl.gz")
+	recorder, err := NewRecordingTransport(ts.Client().Transport, archive)
+	if err != nil {
+		t.Fatalf("could not create recorder: %v", err)
+	}
+	client := newTestClient(ts)
+	client.HTTP.Transport = recorder
+
+	run := func(c *Client) map[string]interface{} {
+		var out map[string]interface{}
+		f := NewRepoFetcherWithClient(cfg, c, nil)
+		if err := f.getREST(context.Background(), ts.URL+"/repos/o/r", &out, false); err != nil {
+			t.Fatalf("request returned error: %v", err)
+		}
+		return out
+	}
+	recorded := run(client)
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("could not close recorder: %v", err)
+	}
+	if mints != 1 {
+		t.Fatalf("expected the token to be minted through the recorder once, got %d", mints)
+	}
+
+	raw := readGzip(t, archive)
+	if !strings.Contains(raw, "/app/installations/42/access_tokens") || strings.Contains(raw, "ghs_installation") {
+		t.Fatalf("expected the mint to be recorded without the token:\n%s", raw)
+	}
+
+	ts.Close()
+	replay, err := NewReplayTransport(archive)
+	if err != nil {
+		t.Fatalf("could not load archive: %v", err)
+	}
+	offline := newTestClient(ts)
+	offline.HTTP = &http.Client{Transport: replay}
+	if replayed := run(offline); fmt.Sprint(replayed) != fmt.Sprint(recorded) {
+		t.Fatalf("replay differs from recording: %v, want %v", replayed, recorded)
+	}
+}
+
+func TestShiftRateLimitResetKeepsWaitsRelative(t *testing.T) {
+	recordedReset := time.Now().Add(-time.Hour + time.Minute).Unix()
+	header := http.Header{"X-Ratelimit-Reset": []string{fmt.Sprint(recordedReset)}}
+
+	shifted := shiftRateLimitReset(header, time.Hour)
+	if got := shifted.Get("X-RateLimit-Reset"); got != fmt.Sprint(recordedReset+3600) {
+		t.Fatalf("expected reset to move forward by an hour, got %s", got)
+	}
+	if header.Get("X-RateLimit-Reset") != fmt.Sprint(recordedReset) {
+		t.Fatal("expected the recorded header to be left unchanged")
+	}
+}
+
+func readGzip(t *testing.T, path string) string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("could not open archive: %v", err)
+	}
+	defer func() { _ = file.Close() }()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("could not read archive: %v", err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("could not read archive: %v", err)
+	}
+	return string(data)
+}
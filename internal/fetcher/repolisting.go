@@ -0,0 +1,224 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+)
+
+// repoListingPageSize is the number of repos per page, the maximum GraphQL allows.
+const repoListingPageSize = 100
+
+// repoListingQuery lists the repositories of an organization in creation
+// order, so repos created mid-run end up on later pages instead of shifting
+// the ones we have already seen.
+const repoListingQuery = `
+	query OrgRepos($org: String!, $first: Int!, $cursor: String) {
+		organization(login: $org) {
+			repositories(first: $first, after: $cursor, orderBy: {field: CREATED_AT, direction: ASC}) {
+				pageInfo {
+					hasNextPage
+					endCursor
+				}
+				nodes {
+					databaseId
+					name
+					nameWithOwner
+					description
+					stargazerCount
+					forkCount
+					isArchived
+					isPrivate
+					isFork
+					primaryLanguage {
+						name
+					}
+					diskUsage
+					updatedAt
+					pushedAt
+					createdAt
+					url
+					visibility
+					repositoryTopics(first: 100) {
+						nodes {
+							topic {
+								name
+							}
+						}
+					}
+					licenseInfo {
+						spdxId
+					}
+					defaultBranchRef {
+						name
+					}
+					issues(states: OPEN) {
+						totalCount
+					}
+					pullRequests(states: OPEN) {
+						totalCount
+					}
+				}
+			}
+		}
+	}`
+
+type repoListingResponse struct {
+	Data struct {
+		Organization *struct {
+			Repositories struct {
+				PageInfo struct {
+					HasNextPage bool   `json:"hasNextPage"`
+					EndCursor   string `json:"endCursor"`
+				} `json:"pageInfo"`
+				Nodes []repoListingNode `json:"nodes"`
+			} `json:"repositories"`
+		} `json:"organization"`
+	} `json:"data"`
+	Errors json.RawMessage `json:"errors"`
+}
+
+type repoListingNode struct {
+	DatabaseID      int64  `json:"databaseId"`
+	Name            string `json:"name"`
+	NameWithOwner   string `json:"nameWithOwner"`
+	Description     string `json:"description"`
+	StargazerCount  int64  `json:"stargazerCount"`
+	ForkCount       int64  `json:"forkCount"`
+	IsArchived      bool   `json:"isArchived"`
+	IsPrivate       bool   `json:"isPrivate"`
+	IsFork          bool   `json:"isFork"`
+	PrimaryLanguage *struct {
+		Name string `json:"name"`
+	} `json:"primaryLanguage"`
+	DiskUsage        int64  `json:"diskUsage"`
+	UpdatedAt        string `json:"updatedAt"`
+	PushedAt         string `json:"pushedAt"`
+	CreatedAt        string `json:"createdAt"`
+	URL              string `json:"url"`
+	Visibility       string `json:"visibility"`
+	RepositoryTopics struct {
+		Nodes []struct {
+			Topic struct {
+				Name string `json:"name"`
+			} `json:"topic"`
+		} `json:"nodes"`
+	} `json:"repositoryTopics"`
+	LicenseInfo *struct {
+		SpdxID string `json:"spdxId"`
+	} `json:"licenseInfo"`
+	DefaultBranchRef *struct {
+		Name string `json:"name"`
+	} `json:"defaultBranchRef"`
+	Issues struct {
+		TotalCount int64 `json:"totalCount"`
+	} `json:"issues"`
+	PullRequests struct {
+		TotalCount int64 `json:"totalCount"`
+	} `json:"pullRequests"`
+}
+
+// toRepoMeta maps a listing node onto the fields the REST listing fills in.
+// baseURL is the REST API root the languages URL points into.
+func (n repoListingNode) toRepoMeta(baseURL string) models.RepoMeta {
+	repo := models.RepoMeta{
+		ID:          n.DatabaseID,
+		Name:        n.Name,
+		FullName:    n.NameWithOwner,
+		Description: n.Description,
+		Stars:       n.StargazerCount,
+		Forks:       n.ForkCount,
+		Archived:    n.IsArchived,
+		Private:     n.IsPrivate,
+		IsFork:      n.IsFork,
+		Size:        n.DiskUsage,
+		UpdatedAt:   n.UpdatedAt,
+		PushedAt:    n.PushedAt,
+		CreatedAt:   n.CreatedAt,
+		HtmlUrl:     n.URL,
+		Visibility:  strings.ToLower(n.Visibility),
+		// REST counts open pull requests as issues.
+		OpenIssues:   n.Issues.TotalCount + n.PullRequests.TotalCount,
+		LanguagesURL: fmt.Sprintf("%s/repos/%s/languages", strings.TrimSuffix(baseURL, "/"), n.NameWithOwner),
+		Topics:       []string{},
+	}
+	if n.PrimaryLanguage != nil {
+		repo.Language = n.PrimaryLanguage.Name
+	}
+	for _, t := range n.RepositoryTopics.Nodes {
+		repo.Topics = append(repo.Topics, t.Topic.Name)
+	}
+	if n.LicenseInfo != nil {
+		repo.License = &models.License{SpdxID: n.LicenseInfo.SpdxID}
+	}
+	if n.DefaultBranchRef != nil {
+		repo.DefaultBranch = n.DefaultBranchRef.Name
+	}
+	return repo
+}
+
+// getReposPageGraphQL lists one page of repos with cursor pagination. The
+// runner asks for pages by number, so the cursor for page n+1 is remembered
+// when page n is fetched. Pages must therefore be requested in order.
+func (r *RepoFetcher) getReposPageGraphQL(ctx context.Context, org string, page int) ([]models.RepoMeta, error) {
+	r.listingMu.Lock()
+	cursor, known := r.listingCursors[page]
+	lastPage := r.listingLastPage
+	r.listingMu.Unlock()
+
+	if lastPage > 0 && page > lastPage {
+		return []models.RepoMeta{}, nil
+	}
+	if page > 1 && !known {
+		return nil, fmt.Errorf("mangler cursor for side %d, sidene må hentes i rekkefølge", page)
+	}
+	slog.Info("Henter repos via GraphQL", "page", page)
+
+	variables := map[string]interface{}{"org": org, "first": repoListingPageSize, "cursor": nil}
+	if cursor != "" {
+		variables["cursor"] = cursor
+	}
+	bodyBytes, err := json.Marshal(map[string]interface{}{
+		"query":     repoListingQuery,
+		"variables": variables,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result repoListingResponse
+	if _, err := r.postGraphQL(ctx, org, bodyBytes, &result); err != nil {
+		return nil, err
+	}
+
+	if len(result.Errors) > 0 && string(result.Errors) != "null" {
+		return nil, fmt.Errorf("GraphQL returnerte feil ved listing av repos for %s: %s", org, result.Errors)
+	}
+
+	if result.Data.Organization == nil {
+		return nil, fmt.Errorf("fant ikke organisasjonen %s", org)
+	}
+
+	repositories := result.Data.Organization.Repositories
+	repos := make([]models.RepoMeta, 0, len(repositories.Nodes))
+	for _, node := range repositories.Nodes {
+		repos = append(repos, node.toRepoMeta(r.client.BaseURL))
+	}
+
+	r.listingMu.Lock()
+	if repositories.PageInfo.HasNextPage {
+		if r.listingCursors == nil {
+			r.listingCursors = map[int]string{}
+		}
+		r.listingCursors[page+1] = repositories.PageInfo.EndCursor
+	} else {
+		r.listingLastPage = page
+	}
+	r.listingMu.Unlock()
+
+	return repos, nil
+}
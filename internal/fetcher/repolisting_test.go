@@ -0,0 +1,119 @@
+package fetcher_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/jonmartinstorm/reposnusern/internal/config"
+	"github.com/jonmartinstorm/reposnusern/internal/fetcher"
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+)
+
+var _ = Describe("GetReposPage med GraphQL-listing", func() {
+	var client *fetcher.Client
+	var cfg config.Config
+
+	BeforeEach(func() {
+		cfg = config.Config{Org: "testorg", Token: "fake-token", RepoListing: config.RepoListingGraphQL}
+		client = fetcher.NewClient(cfg)
+	})
+
+	It("skal følge cursorer side for side og mappe til RepoMeta", func() {
+		var cursors []interface{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body struct {
+				Variables map[string]interface{} `json:"variables"`
+			}
+			Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+			Expect(body.Variables).To(HaveKeyWithValue("org", "testorg"))
+			cursors = append(cursors, body.Variables["cursor"])
+
+			w.Header().Set("Content-Type", "application/json")
+			if body.Variables["cursor"] == nil {
+				_, _ = fmt.Fprintln(w, `{"data":{"organization":{"repositories":{
+					"pageInfo":{"hasNextPage":true,"endCursor":"Y3Vyc29yOjE="},
+					"nodes":[{
+						"databaseId":42,"name":"repo","nameWithOwner":"testorg/repo","description":"desc",
+						"stargazerCount":3,"forkCount":1,"isArchived":false,"isPrivate":false,"isFork":false,
+						"primaryLanguage":{"name":"Go"},"diskUsage":2048,
+						"updatedAt":"2025-06-17T10:00:00Z","pushedAt":"2025-06-16T10:00:00Z","createdAt":"2025-01-01T10:00:00Z",
+						"url":"https://github.com/testorg/repo","visibility":"INTERNAL",
+						"repositoryTopics":{"nodes":[{"topic":{"name":"go"}}]},
+						"licenseInfo":{"spdxId":"MIT"},"defaultBranchRef":{"name":"main"},
+						"issues":{"totalCount":2},"pullRequests":{"totalCount":1}
+					}]}}}}`)
+				return
+			}
+			_, _ = fmt.Fprintln(w, `{"data":{"organization":{"repositories":{
+				"pageInfo":{"hasNextPage":false,"endCursor":null},
+				"nodes":[{"databaseId":43,"name":"empty","nameWithOwner":"testorg/empty","primaryLanguage":null,
+					"licenseInfo":null,"defaultBranchRef":null,"repositoryTopics":{"nodes":[]}}]}}}}`)
+		}))
+		defer ts.Close()
+
+		client.HTTP = ts.Client()
+		client.GraphQLURL = ts.URL
+		f := fetcher.NewRepoFetcherWithClient(cfg, client, nil)
+
+		page1, err := f.GetReposPage(context.Background(), cfg, 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(page1).To(Equal([]models.RepoMeta{{
+			ID:            42,
+			Name:          "repo",
+			FullName:      "testorg/repo",
+			Description:   "desc",
+			Stars:         3,
+			Forks:         1,
+			Language:      "Go",
+			Size:          2048,
+			UpdatedAt:     "2025-06-17T10:00:00Z",
+			PushedAt:      "2025-06-16T10:00:00Z",
+			CreatedAt:     "2025-01-01T10:00:00Z",
+			HtmlUrl:       "https://github.com/testorg/repo",
+			Topics:        []string{"go"},
+			Visibility:    "internal",
+			OpenIssues:    3,
+			LanguagesURL:  "https://api.github.com/repos/testorg/repo/languages",
+			License:       &models.License{SpdxID: "MIT"},
+			DefaultBranch: "main",
+		}}))
+
+		page2, err := f.GetReposPage(context.Background(), cfg, 2)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(page2).To(HaveLen(1))
+		Expect(page2[0].License).To(BeNil())
+		Expect(page2[0].DefaultBranch).To(BeEmpty())
+
+		page3, err := f.GetReposPage(context.Background(), cfg, 3)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(page3).To(BeEmpty())
+
+		Expect(cursors).To(Equal([]interface{}{nil, "Y3Vyc29yOjE="}))
+	})
+
+	It("skal feile når en side hentes uten at forrige side er hentet", func() {
+		f := fetcher.NewRepoFetcherWithClient(cfg, client, nil)
+		_, err := f.GetReposPage(context.Background(), cfg, 2)
+		Expect(err).To(MatchError(ContainSubstring("mangler cursor for side 2")))
+	})
+
+	It("skal returnere feil når organisasjonen ikke finnes", func() {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprintln(w, `{"data":{"organization":null},"errors":[{"type":"NOT_FOUND","path":["organization"],"message":"Could not resolve to an Organization"}]}`)
+		}))
+		defer ts.Close()
+
+		client.HTTP = ts.Client()
+		client.GraphQLURL = ts.URL
+
+		_, err := fetcher.NewRepoFetcherWithClient(cfg, client, nil).GetReposPage(context.Background(), cfg, 1)
+		Expect(err).To(MatchError(ContainSubstring("GraphQL returnerte feil ved listing av repos")))
+	})
+})
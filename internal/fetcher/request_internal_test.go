@@ -0,0 +1,202 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/config"
+)
+
+// getCore issues a core REST request through doRequest, the way the fetcher does.
+func getCore(ctx context.Context, c *Client, method, url string, body []byte, out interface{}) error {
+	return c.doRequest(ctx, RateLimitResourceCore, method, url, "token", body, out, false)
+}
+
+func TestDoRequestRetriesAfterPrimaryRateLimit(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			// GitHub answers 403 with X-RateLimit-Remaining: 0 when the budget is spent.
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(50*time.Millisecond).Unix()))
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = fmt.Fprintln(w, `{}`)
+			return
+		}
+		_, _ = fmt.Fprintln(w, `{"message": "ok"}`)
+	}))
+	defer ts.Close()
+
+	var result struct{ Message string }
+	if err := getCore(context.Background(), newTestClient(ts), "GET", ts.URL, nil, &result); err != nil {
+		t.Fatalf("request returned error: %v", err)
+	}
+	if result.Message != "ok" || calls < 2 {
+		t.Fatalf("expected a retry that succeeds, got %q after %d calls", result.Message, calls)
+	}
+}
+
+func TestDoRequestDoesNotRetrySuccessWithEmptyBudget(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		_, _ = fmt.Fprintln(w, `{"message": "last-call"}`)
+	}))
+	defer ts.Close()
+
+	var result struct{ Message string }
+	if err := getCore(context.Background(), newTestClient(ts), "GET", ts.URL, nil, &result); err != nil {
+		t.Fatalf("request returned error: %v", err)
+	}
+	if result.Message != "last-call" || calls != 1 {
+		t.Fatalf("expected one call, got %q after %d calls", result.Message, calls)
+	}
+}
+
+func TestDoRequestSetsContentTypeForPost(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected %s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		_, _ = fmt.Fprintln(w, `{"message": "ok"}`)
+	}))
+	defer ts.Close()
+
+	var result struct{ Message string }
+	if err := getCore(context.Background(), newTestClient(ts), "POST", ts.URL, []byte(`{}`), &result); err != nil {
+		t.Fatalf("request returned error: %v", err)
+	}
+	if result.Message != "ok" {
+		t.Fatalf("unexpected response %q", result.Message)
+	}
+}
+
+func TestDoRequestFailsOnInvalidURLs(t *testing.T) {
+	c := NewClient(config.Config{})
+	c.RetryBackoff = func(int) time.Duration { return time.Millisecond }
+
+	for _, url := range []string{"http://invalid-url", ":"} {
+		var result any
+		if err := getCore(context.Background(), c, "GET", url, nil, &result); err == nil {
+			t.Errorf("expected an error for %q", url)
+		}
+	}
+}
+
+func TestDoRequestReturnsAPIErrorsWithStatusAndBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = fmt.Fprint(w, `{"message":"access denied"}`)
+	}))
+	defer ts.Close()
+
+	var result any
+	err := getCore(context.Background(), newTestClient(ts), "GET", ts.URL, nil, &result)
+	if err == nil {
+		t.Fatal("expected an error for 403")
+	}
+	for _, want := range []string{"GitHub API-feil", "403", "access denied"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error to contain %q, got %v", want, err)
+		}
+	}
+}
+
+// rateLimitedServer always answers with a primary rate limit that resets in a minute.
+func rateLimitedServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(time.Minute).Unix()))
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = fmt.Fprintln(w, `{}`)
+	}))
+}
+
+func TestDoRequestStopsRateLimitWaitWhenCanceled(t *testing.T) {
+	ts := rateLimitedServer()
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	var result any
+	err := getCore(ctx, newTestClient(ts), "GET", ts.URL, nil, &result)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the wait to stop early, took %s", elapsed)
+	}
+}
+
+func TestDoRequestStopsRateLimitWaitOnShutdownWithoutCancelingTheRequest(t *testing.T) {
+	ts := rateLimitedServer()
+	defer ts.Close()
+
+	baseCtx := context.Background()
+	waitCtx, cancelWait := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancelWait)
+
+	start := time.Now()
+	var result any
+	err := getCore(WithWaitInterrupt(baseCtx, waitCtx), newTestClient(ts), "GET", ts.URL, nil, &result)
+	if !errors.Is(err, ErrWaitInterrupted) {
+		t.Fatalf("expected ErrWaitInterrupted, got %v", err)
+	}
+	if baseCtx.Err() != nil {
+		t.Fatal("expected the request context to stay open")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the wait to stop early, took %s", elapsed)
+	}
+}
+
+func TestDoRequestRetriesServerErrors(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = fmt.Fprintln(w, `{"message":"internal error"}`)
+			return
+		}
+		_, _ = fmt.Fprintln(w, `{"message":"ok"}`)
+	}))
+	defer ts.Close()
+
+	var result struct{ Message string }
+	if err := getCore(context.Background(), newTestClient(ts), "GET", ts.URL, nil, &result); err != nil {
+		t.Fatalf("request returned error: %v", err)
+	}
+	if result.Message != "ok" || calls != 3 {
+		t.Fatalf("expected success on the third call, got %q after %d calls", result.Message, calls)
+	}
+}
+
+func TestDoRequestGivesUpAfterMaxServerErrors(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = fmt.Fprintln(w, `{"message":"always fails"}`)
+	}))
+	defer ts.Close()
+
+	var result any
+	err := getCore(context.Background(), newTestClient(ts), "GET", ts.URL, nil, &result)
+	if err == nil || !strings.Contains(err.Error(), "GitHub API-feil etter") {
+		t.Fatalf("expected to give up with an API error, got %v", err)
+	}
+	if calls != MaxAttempts {
+		t.Fatalf("expected %d attempts, got %d", MaxAttempts, calls)
+	}
+}
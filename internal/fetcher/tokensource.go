@@ -0,0 +1,102 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/jonmartinstorm/reposnusern/internal/config"
+)
+
+// tokenRefreshMargin is how long before expiry a cached token is replaced, so
+// a token never expires between being handed out and the request being sent.
+const tokenRefreshMargin = 5 * time.Minute
+
+// TokenSource supplies the token used to authenticate GitHub requests.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticTokenSource always returns the same token, e.g. a personal access token.
+type StaticTokenSource string
+
+func (s StaticTokenSource) Token(context.Context) (string, error) {
+	return string(s), nil
+}
+
+// acquire lets a StaticTokenSource authenticate requests on its own, outside a
+// CredentialPool. Its budgets are tracked under the resource alone.
+func (s StaticTokenSource) acquire(ctx context.Context, limiter *ResourceRateLimiter, resource RateLimitResource) (credentialLease, error) {
+	key := rateLimitKey{resource: resource}
+	if err := limiter.waitFor(ctx, key); err != nil {
+		return credentialLease{}, err
+	}
+	return credentialLease{key: key, token: string(s)}, nil
+}
+
+// MintFunc creates a new token and reports when it expires.
+type MintFunc func(ctx context.Context) (token string, expiresAt time.Time, err error)
+
+// CachedTokenSource reuses a minted token until shortly before it expires.
+// Concurrent callers wait for a single refresh instead of minting their own.
+type CachedTokenSource struct {
+	mint MintFunc
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// NewCachedTokenSource creates a token source that caches tokens from mint.
+func NewCachedTokenSource(mint MintFunc) *CachedTokenSource {
+	return &CachedTokenSource{mint: mint}
+}
+
+// Token returns the cached token, minting a new one when it is missing or
+// about to expire. Failed mints are not cached.
+func (s *CachedTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Until(s.expiresAt) > tokenRefreshMargin {
+		return s.token, nil
+	}
+
+	token, expiresAt, err := s.mint(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token, s.expiresAt = token, expiresAt
+	return token, nil
+}
+
+// NewGitHubAppTokenSource creates a cached source of installation tokens for
+// a GitHub App, minted through the REST API at baseURL over transport, or
+// http.DefaultTransport when it is nil. The private key is parsed once, up front.
+func NewGitHubAppTokenSource(cfg *config.GitHubAppConfig, baseURL string, transport http.RoundTripper) (*CachedTokenSource, error) {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	appsTransport, err := ghinstallation.NewAppsTransport(transport, cfg.AppID, cfg.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GitHub apps transport: %w", err)
+	}
+	appsTransport.BaseURL = baseURL
+	installationID := cfg.InstallationID
+
+	return NewCachedTokenSource(func(ctx context.Context) (string, time.Time, error) {
+		itr := ghinstallation.NewFromAppsTransport(appsTransport, installationID)
+		token, err := itr.Token(ctx)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("failed to get installation token: %w", err)
+		}
+		expiresAt, _, err := itr.Expiry()
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("failed to read installation token expiry: %w", err)
+		}
+		return token, expiresAt, nil
+	}), nil
+}
@@ -0,0 +1,108 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/config"
+)
+
+func TestCachedTokenSourceMintsOnceForConcurrentCallers(t *testing.T) {
+	var mints atomic.Int64
+	source := NewCachedTokenSource(func(context.Context) (string, time.Time, error) {
+		n := mints.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return fmt.Sprintf("token-%d", n), time.Now().Add(time.Hour), nil
+	})
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(func() {
+			token, err := source.Token(context.Background())
+			if err != nil || token != "token-1" {
+				t.Errorf("expected cached token-1, got %q (err %v)", token, err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if got := mints.Load(); got != 1 {
+		t.Fatalf("expected a single mint, got %d", got)
+	}
+}
+
+func TestCachedTokenSourceRefreshesBeforeExpiry(t *testing.T) {
+	var mints atomic.Int64
+	source := NewCachedTokenSource(func(context.Context) (string, time.Time, error) {
+		mints.Add(1)
+		// Expires within the refresh margin, so it is never reused.
+		return "short-lived", time.Now().Add(tokenRefreshMargin / 2), nil
+	})
+
+	for range 3 {
+		if _, err := source.Token(context.Background()); err != nil {
+			t.Fatalf("token returned error: %v", err)
+		}
+	}
+	if got := mints.Load(); got != 3 {
+		t.Fatalf("expected a mint per call for a token about to expire, got %d", got)
+	}
+}
+
+func TestCachedTokenSourceDoesNotCacheFailures(t *testing.T) {
+	fail := true
+	source := NewCachedTokenSource(func(context.Context) (string, time.Time, error) {
+		if fail {
+			return "", time.Time{}, errors.New("boom")
+		}
+		return "token", time.Now().Add(time.Hour), nil
+	})
+
+	if _, err := source.Token(context.Background()); err == nil {
+		t.Fatal("expected mint error to be returned")
+	}
+	fail = false
+	if token, err := source.Token(context.Background()); err != nil || token != "token" {
+		t.Fatalf("expected retry after failure to mint, got %q (err %v)", token, err)
+	}
+}
+
+func TestRepoFetcherUsesInjectedTokenSource(t *testing.T) {
+	var authHeaders []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeaders = append(authHeaders, r.Header.Get("Authorization"))
+		_, _ = fmt.Fprintln(w, `{}`)
+	}))
+	defer ts.Close()
+
+	var mints atomic.Int64
+	source := NewCachedTokenSource(func(context.Context) (string, time.Time, error) {
+		mints.Add(1)
+		return "installation-token", time.Now().Add(time.Hour), nil
+	})
+	pool := NewCredentialPool([]Credential{{Name: "app-1", Source: source}})
+	f := NewRepoFetcherWithClient(config.Config{Token: "ignored"}, newTestClient(ts), pool)
+
+	var out map[string]interface{}
+	for range 3 {
+		if err := f.getREST(context.Background(), ts.URL, &out, false); err != nil {
+			t.Fatalf("request returned error: %v", err)
+		}
+	}
+
+	if mints.Load() != 1 {
+		t.Fatalf("expected the installation token to be minted once, got %d", mints.Load())
+	}
+	for _, got := range authHeaders {
+		if got != "Bearer installation-token" {
+			t.Fatalf("expected injected token, got %q", got)
+		}
+	}
+}
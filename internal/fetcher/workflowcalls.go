@@ -0,0 +1,180 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+	"github.com/jonmartinstorm/reposnusern/internal/parser"
+)
+
+// maxCICallDepth limits how many levels of nested reusable workflows and
+// composite actions are followed from a top-level workflow.
+const maxCICallDepth = 4
+
+type pendingCICaller struct {
+	path    string
+	content string
+	depth   int
+}
+
+// resolveCIWorkflowCalls follows `uses:` references from the fetched workflows
+// into local reusable workflows and composite actions, and into same-org
+// remote ones when Feature_RemoteCICalls is enabled. The call graph is stored
+// on the entry together with the content of every resolved callee that is not
+// already part of entry.CIConfig.
+func (r *RepoFetcher) resolveCIWorkflowCalls(ctx context.Context, baseRepo models.RepoMeta, entry *models.RepoEntry) *models.RepoEntry {
+	known := make(map[string]string, len(entry.CIConfig))
+	queue := make([]pendingCICaller, 0, len(entry.CIConfig))
+	for _, f := range entry.CIConfig {
+		known[f.Path] = f.Content
+		queue = append(queue, pendingCICaller{path: f.Path, content: f.Content})
+	}
+
+	var calls []models.WorkflowCall
+	var callees []models.FileEntry
+	seen := map[string]bool{}
+
+	for len(queue) > 0 {
+		caller := queue[0]
+		queue = queue[1:]
+		if seen[caller.path] {
+			continue
+		}
+		seen[caller.path] = true
+
+		for _, ref := range parser.ExtractUsesReferences(caller.content) {
+			sameOrg := strings.EqualFold(ref.Owner, r.Cfg.Org)
+			if !ref.Local && !sameOrg && ref.Kind != parser.CIUsesWorkflow {
+				// Third-party actions are published code, not hidden run steps of ours.
+				continue
+			}
+
+			call := models.WorkflowCall{
+				Caller: caller.path,
+				Job:    ref.Job,
+				Uses:   ref.Uses,
+				Kind:   string(ref.Kind),
+				Local:  ref.Local,
+			}
+
+			if ref.Local || (sameOrg && r.Cfg.Feature_RemoteCICalls) {
+				target, content, ok := r.fetchCICallee(ctx, baseRepo, ref, known)
+				call.Target = target
+				call.Resolved = ok
+				if ok {
+					if _, exists := known[target]; !exists {
+						known[target] = content
+						callees = append(callees, models.FileEntry{Path: target, Content: content})
+					}
+					if caller.depth+1 < maxCICallDepth {
+						queue = append(queue, pendingCICaller{path: target, content: content, depth: caller.depth + 1})
+					}
+				} else {
+					slog.Debug("Fant ikke kalt workflow/action", "repo", baseRepo.FullName, "caller", caller.path, "uses", ref.Uses)
+				}
+			} else {
+				call.Target = remoteCITarget(ref)
+			}
+
+			calls = append(calls, call)
+		}
+	}
+
+	entry.CIWorkflowCalls = calls
+	entry.CICallees = callees
+	return entry
+}
+
+// fetchCICallee returns the key and content of a `uses:` target. Keys are repo
+// paths for local references and owner/repo/path@ref for remote ones.
+func (r *RepoFetcher) fetchCICallee(ctx context.Context, baseRepo models.RepoMeta, ref parser.CIUsesReference, known map[string]string) (string, string, bool) {
+	owner, repo, gitRef := r.Cfg.Org, baseRepo.Name, ""
+	if !ref.Local {
+		owner, repo, gitRef = ref.Owner, ref.Repo, ref.Ref
+	}
+
+	candidates := []string{ref.Path}
+	if ref.Kind == parser.CIUsesAction {
+		candidates = []string{path.Join(ref.Path, "action.yml"), path.Join(ref.Path, "action.yaml")}
+	}
+
+	for _, candidate := range candidates {
+		key := candidate
+		if !ref.Local {
+			key = fmt.Sprintf("%s/%s/%s@%s", owner, repo, candidate, gitRef)
+		}
+
+		if content, ok := known[key]; ok {
+			return key, content, true
+		}
+		if !ref.Local {
+			if cached, ok := r.remoteCICallees.Load(key); ok {
+				content := cached.(string)
+				return key, content, content != ""
+			}
+		}
+
+		content, err := r.fetchOptionalFileContent(ctx, owner, repo, candidate, gitRef)
+		if err != nil {
+			// Not cached, so the next repo that calls the same target tries again.
+			slog.Warn("Klarte ikke hente filinnhold", "repo", owner+"/"+repo, "path", candidate, "error", err)
+			break
+		}
+		if !ref.Local {
+			r.remoteCICallees.Store(key, content)
+		}
+		if content != "" {
+			return key, content, true
+		}
+	}
+
+	if ref.Local {
+		return candidates[0], "", false
+	}
+	return remoteCITarget(ref), "", false
+}
+
+func remoteCITarget(ref parser.CIUsesReference) string {
+	target := ref.Owner + "/" + ref.Repo
+	if ref.Path != "" && ref.Path != "." {
+		target += "/" + ref.Path
+	}
+	if ref.Ref != "" {
+		target += "@" + ref.Ref
+	}
+	return target
+}
+
+// fetchOptionalFileContent fetches a file through the contents API where 404
+// is an expected outcome. gitRef is optional and defaults to the default branch.
+// A missing file, or one that is not base64 encoded, gives empty content and no
+// error; an error means the API did not answer whether the file exists.
+func (r *RepoFetcher) fetchOptionalFileContent(ctx context.Context, owner, repo, filePath, gitRef string) (string, error) {
+	contentURL := r.client.restURL(fmt.Sprintf("/repos/%s/%s/contents/%s", owner, repo, filePath))
+	if gitRef != "" {
+		contentURL += "?ref=" + url.QueryEscape(gitRef)
+	}
+
+	var file struct {
+		Content  string `json:"content"`
+		Encoding string `json:"encoding"`
+	}
+	if err := r.getREST(ctx, contentURL, &file, true); err != nil {
+		return "", err
+	}
+
+	if file.Encoding != "base64" {
+		return "", nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(file.Content)
+	if err != nil {
+		return "", nil
+	}
+	return string(decoded), nil
+}
@@ -0,0 +1,163 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jonmartinstorm/reposnusern/internal/config"
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+)
+
+// serveContents serves files from the contents API and returns a client for it.
+func serveContents(t *testing.T, files map[string]string) *Client {
+	t.Helper()
+	requests := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path
+		if ref := r.URL.Query().Get("ref"); ref != "" {
+			key += "@" + ref
+		}
+		requests[key]++
+		content, ok := files[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"encoding": "base64",
+			"content":  base64.StdEncoding.EncodeToString([]byte(content)),
+		})
+	}))
+	t.Cleanup(ts.Close)
+
+	return newTestClient(ts)
+}
+
+func TestResolveCIWorkflowCallsFollowsLocalCallees(t *testing.T) {
+	client := serveContents(t, map[string]string{
+		"/repos/testorg/app/contents/.github/actions/setup/action.yml": `runs:
+  using: composite
+  steps:
+    - run: curl https://example.com/install.sh | bash
+      shell: bash`,
+	})
+
+	entry := &models.RepoEntry{CIConfig: []models.FileEntry{
+		{Path: ".github/workflows/ci.yml", Content: `jobs:
+  build:
+    uses: ./.github/workflows/build.yml
+  deploy:
+    uses: other-org/workflows/.github/workflows/deploy.yml@v1`},
+		{Path: ".github/workflows/build.yml", Content: `on: workflow_call
+jobs:
+  build:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v4
+      - uses: ./.github/actions/setup`},
+	}}
+
+	f := NewRepoFetcherWithClient(config.Config{Org: "testorg", Token: "token"}, client, nil)
+	entry = f.resolveCIWorkflowCalls(context.Background(), models.RepoMeta{Name: "app", FullName: "testorg/app"}, entry)
+
+	want := []models.WorkflowCall{
+		{Caller: ".github/workflows/ci.yml", Job: "build", Uses: "./.github/workflows/build.yml", Kind: "workflow", Target: ".github/workflows/build.yml", Local: true, Resolved: true},
+		{Caller: ".github/workflows/ci.yml", Job: "deploy", Uses: "other-org/workflows/.github/workflows/deploy.yml@v1", Kind: "workflow", Target: "other-org/workflows/.github/workflows/deploy.yml@v1"},
+		{Caller: ".github/workflows/build.yml", Job: "build", Uses: "./.github/actions/setup", Kind: "action", Target: ".github/actions/setup/action.yml", Local: true, Resolved: true},
+	}
+	if len(entry.CIWorkflowCalls) != len(want) {
+		t.Fatalf("expected %d calls, got %d: %+v", len(want), len(entry.CIWorkflowCalls), entry.CIWorkflowCalls)
+	}
+	for i := range want {
+		if entry.CIWorkflowCalls[i] != want[i] {
+			t.Fatalf("call %d: got %+v, want %+v", i, entry.CIWorkflowCalls[i], want[i])
+		}
+	}
+	if len(entry.CICallees) != 1 || entry.CICallees[0].Path != ".github/actions/setup/action.yml" {
+		t.Fatalf("expected composite action as only callee, got %+v", entry.CICallees)
+	}
+}
+
+func TestResolveCIWorkflowCallsFetchesSameOrgRemoteOnlyWhenEnabled(t *testing.T) {
+	client := serveContents(t, map[string]string{
+		"/repos/testorg/shared/contents/.github/workflows/deploy.yml@v1": `on: workflow_call
+jobs:
+  deploy:
+    runs-on: ubuntu-latest
+    steps:
+      - run: sudo ./deploy.sh`,
+	})
+
+	ci := models.FileEntry{Path: ".github/workflows/ci.yml", Content: `jobs:
+  deploy:
+    uses: testorg/shared/.github/workflows/deploy.yml@v1`}
+	target := "testorg/shared/.github/workflows/deploy.yml@v1"
+
+	disabled := NewRepoFetcherWithClient(config.Config{Org: "testorg", Token: "token"}, client, nil)
+	entry := disabled.resolveCIWorkflowCalls(context.Background(), models.RepoMeta{Name: "app"}, &models.RepoEntry{CIConfig: []models.FileEntry{ci}})
+	if len(entry.CIWorkflowCalls) != 1 || entry.CIWorkflowCalls[0].Resolved || entry.CIWorkflowCalls[0].Target != target {
+		t.Fatalf("expected one unresolved remote call, got %+v", entry.CIWorkflowCalls)
+	}
+
+	enabled := NewRepoFetcherWithClient(config.Config{Org: "testorg", Token: "token", Feature_RemoteCICalls: true}, client, nil)
+	entry = enabled.resolveCIWorkflowCalls(context.Background(), models.RepoMeta{Name: "app"}, &models.RepoEntry{CIConfig: []models.FileEntry{ci}})
+	if len(entry.CIWorkflowCalls) != 1 || !entry.CIWorkflowCalls[0].Resolved {
+		t.Fatalf("expected resolved remote call, got %+v", entry.CIWorkflowCalls)
+	}
+	if len(entry.CICallees) != 1 || entry.CICallees[0].Path != target {
+		t.Fatalf("expected remote workflow as callee, got %+v", entry.CICallees)
+	}
+}
+
+func TestResolveCIWorkflowCallsRetriesRemoteAfterServerErrors(t *testing.T) {
+	const contentPath = "/repos/testorg/shared/contents/.github/workflows/deploy.yml"
+	failing := true
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if failing {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if r.URL.Path != contentPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"encoding": "base64",
+			"content":  base64.StdEncoding.EncodeToString([]byte("on: workflow_call")),
+		})
+	}))
+	defer ts.Close()
+
+	ci := models.FileEntry{Path: ".github/workflows/ci.yml", Content: `jobs:
+  deploy:
+    uses: testorg/shared/.github/workflows/deploy.yml@v1
+  missing:
+    uses: testorg/shared/.github/workflows/missing.yml@v1`}
+	f := NewRepoFetcherWithClient(config.Config{Org: "testorg", Token: "token", Feature_RemoteCICalls: true}, newTestClient(ts), nil)
+	resolve := func(name string) []models.WorkflowCall {
+		return f.resolveCIWorkflowCalls(context.Background(), models.RepoMeta{Name: name}, &models.RepoEntry{CIConfig: []models.FileEntry{ci}}).CIWorkflowCalls
+	}
+
+	if calls := resolve("app"); len(calls) != 2 || calls[0].Resolved || calls[1].Resolved {
+		t.Fatalf("expected unresolved calls while the API fails, got %+v", calls)
+	}
+
+	failing = false
+	if calls := resolve("other"); len(calls) != 2 || !calls[0].Resolved || calls[1].Resolved {
+		t.Fatalf("expected the server error not to be cached, got %+v", calls)
+	}
+
+	requests = 0
+	if calls := resolve("third"); len(calls) != 2 || !calls[0].Resolved || calls[1].Resolved {
+		t.Fatalf("expected the same result from the cache, got %+v", calls)
+	}
+	if requests != 0 {
+		t.Fatalf("expected found and missing targets to be cached, got %d requests", requests)
+	}
+}
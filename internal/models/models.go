@@ -1,5 +1,19 @@
 package models
 
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrRowsFailed is returned by writers that drop a whole repo because some of
+// its rows could not be written.
+var ErrRowsFailed = errors.New("rows could not be written")
+
 type FileEntry struct {
 	Path    string `json:"path"`
 	Content string `json:"content"`
@@ -35,6 +49,7 @@ type RepoMeta struct {
 	OpenIssues           int64             `json:"open_issues_count"`
 	LanguagesURL         string            `json:"languages_url"`
 	License              *License          `json:"license"`
+	DefaultBranch        string            `json:"default_branch"`
 	Readme               string            `json:"readme"`
 	Security             map[string]bool   `json:"security"`
 	LockfilePairings     []LockfilePairing `json:"lockfile_pairings"`
@@ -42,15 +57,202 @@ type RepoMeta struct {
 	Lockfile_pair_count  int               `json:"lockfile_pair_count"`
 }
 
+// WorkflowCall is one edge in the CI call graph: a workflow or composite
+// action (Caller) that uses a reusable workflow or action (Uses). Target is
+// the path (or owner/repo/path@ref) the call resolves to.
+type WorkflowCall struct {
+	Caller   string `json:"caller"`
+	Job      string `json:"job"`
+	Uses     string `json:"uses"`
+	Kind     string `json:"kind"`
+	Target   string `json:"target"`
+	Local    bool   `json:"local"`
+	Resolved bool   `json:"resolved"`
+}
+
 type RepoEntry struct {
-	Repo      RepoMeta               `json:"repo"`
-	Languages map[string]int         `json:"languages"`
-	Files     map[string][]FileEntry `json:"files"`
-	CIConfig  []FileEntry            `json:"ci_config"`
-	SBOM      map[string]interface{} `json:"sbom"`
+	Repo            RepoMeta               `json:"repo"`
+	Languages       map[string]int         `json:"languages"`
+	Files           map[string][]FileEntry `json:"files"`
+	CIConfig        []FileEntry            `json:"ci_config"`
+	CIWorkflowCalls []WorkflowCall         `json:"ci_workflow_calls"`
+	CICallees       []FileEntry            `json:"ci_callees"`
+	SBOM            map[string]interface{} `json:"sbom"`
+	// FetchIssues are problems that left the entry with less data than the
+	// repo has, like a truncated git tree.
+	FetchIssues []FetchIssue `json:"fetch_issues,omitempty"`
 }
 
 type OrgRepos struct {
 	Org   string      `json:"org"`
 	Repos []RepoEntry `json:"repos"`
 }
+
+// TableResult counts the rows a writer wrote to and failed to write to one table.
+type TableResult struct {
+	Written int `json:"written"`
+	Failed  int `json:"failed"`
+}
+
+// ImportResult is what a writer reports for one repo, keyed by table name.
+type ImportResult struct {
+	Tables map[string]TableResult `json:"tables"`
+}
+
+// Add counts written and failed rows for table.
+func (r *ImportResult) Add(table string, written, failed int) {
+	if r.Tables == nil {
+		r.Tables = map[string]TableResult{}
+	}
+	t := r.Tables[table]
+	t.Written += written
+	t.Failed += failed
+	r.Tables[table] = t
+}
+
+// Merge adds the counts of other to r.
+func (r *ImportResult) Merge(other ImportResult) {
+	for table, t := range other.Tables {
+		r.Add(table, t.Written, t.Failed)
+	}
+}
+
+// Failed is the number of failed rows across all tables.
+func (r ImportResult) Failed() int {
+	failed := 0
+	for _, t := range r.Tables {
+		failed += t.Failed
+	}
+	return failed
+}
+
+// Written is the number of written rows across all tables.
+func (r ImportResult) Written() int {
+	written := 0
+	for _, t := range r.Tables {
+		written += t.Written
+	}
+	return written
+}
+
+// RunStatus is the outcome of a snapshot run.
+type RunStatus string
+
+const (
+	RunStatusRunning   RunStatus = "running"
+	RunStatusCompleted RunStatus = "completed" // every repo was written
+	RunStatusPartial   RunStatus = "partial"   // stopped early, or some repos failed
+	RunStatusFailed    RunStatus = "failed"    // the run ended with an error
+)
+
+// SnapshotRun describes one execution of reposnusern. Writers that implement
+// runner.RunRecorder store it when the run starts and again when it finishes.
+type SnapshotRun struct {
+	ID        string
+	Org       string
+	StartedAt time.Time
+	// FinishedAt is zero while the run is going.
+	FinishedAt time.Time
+	Status     RunStatus
+	Version    string
+	// Config is Config.DebugPrint, which leaves out secrets.
+	Config string
+	Error  string
+
+	ReposProcessed     int64
+	ReposFailedGraphQL int64
+	ReposFailedRows    int64
+	RowsWritten        int64
+	RowsFailed         int64
+	APICalls           int64
+	RateLimitWaits     int64
+	RateLimitWait      time.Duration
+	GracefulShutdown   bool
+	FailedRepos        []string
+}
+
+// FetchErrorClass says why fetching a repo from GitHub went wrong.
+type FetchErrorClass string
+
+const (
+	FetchErrorRateLimit       FetchErrorClass = "rate_limit"
+	FetchErrorServer          FetchErrorClass = "server_error" // 5xx after all attempts
+	FetchErrorNetwork         FetchErrorClass = "network_error"
+	FetchErrorGraphQL         FetchErrorClass = "graphql_error"
+	FetchErrorParse           FetchErrorClass = "parse_failure"
+	FetchErrorTreeTruncated   FetchErrorClass = "tree_truncated"
+	FetchErrorSBOMUnavailable FetchErrorClass = "sbom_unavailable"
+	FetchErrorOther           FetchErrorClass = "other"
+)
+
+// Transient reports whether fetching again later is likely to work.
+func (c FetchErrorClass) Transient() bool {
+	switch c {
+	case FetchErrorRateLimit, FetchErrorServer, FetchErrorNetwork:
+		return true
+	}
+	return false
+}
+
+// FetchIssue is a problem met while fetching a repo that did not stop the
+// repo from being written.
+type FetchIssue struct {
+	Class   FetchErrorClass `json:"class"`
+	Message string          `json:"message"`
+}
+
+// RepoFetchError is one repo that failed, or was degraded, during a run.
+// Writers that implement runner.FetchErrorRecorder store them so a repo
+// missing from a snapshot can be told apart from a deleted one.
+type RepoFetchError struct {
+	Repo    RepoMeta
+	Class   FetchErrorClass
+	Message string
+	// Fatal means the repo is missing from the snapshot. Issues like a
+	// truncated tree are not fatal: the repo is written with less data.
+	Fatal bool
+	// Attempts is how many times the repo was fetched, including the retry pass.
+	Attempts int
+	// Recovered means the retry pass fetched the repo after all.
+	Recovered bool
+}
+
+// FileBlob is file content stored once in file_blobs and referenced by its
+// SHA256 from every snapshot that has the same content.
+type FileBlob struct {
+	SHA256  string
+	Content string
+}
+
+// ContentSHA256 is the hex SHA-256 of content, the key of file_blobs.
+func ContentSHA256(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Blobs returns the content of the entry that writers store in file_blobs:
+// the README, Dockerfiles and CI configs. Each blob is returned once, sorted by
+// hash so concurrent writers insert them in the same order.
+func (e RepoEntry) Blobs() []FileBlob {
+	var blobs []FileBlob
+	add := func(content string) {
+		blobs = append(blobs, FileBlob{SHA256: ContentSHA256(content), Content: content})
+	}
+	if e.Repo.Readme != "" {
+		add(e.Repo.Readme)
+	}
+	for filetype, files := range e.Files {
+		if !strings.HasPrefix(strings.ToLower(filetype), "dockerfile") {
+			continue
+		}
+		for _, f := range files {
+			add(f.Content)
+		}
+	}
+	for _, f := range e.CIConfig {
+		add(f.Content)
+	}
+
+	slices.SortFunc(blobs, func(a, b FileBlob) int { return cmp.Compare(a.SHA256, b.SHA256) })
+	return slices.CompactFunc(blobs, func(a, b FileBlob) bool { return a.SHA256 == b.SHA256 })
+}
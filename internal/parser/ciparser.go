@@ -6,6 +6,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/jonmartinstorm/reposnusern/internal/models"
 	"gopkg.in/yaml.v3"
 )
 
@@ -27,6 +28,7 @@ type CIFeatures struct {
 	UsesPackagePublish            bool
 	UsesPullRequestTarget         bool
 	SecretNames                   []string
+	PackageManagerFeatures
 }
 
 // extractRunLines parses a GitHub Actions workflow YAML (as raw text) and
@@ -86,42 +88,83 @@ func extractRunLines(content string) []string {
 	return result
 }
 
+// extractRunScripts returns the full script of every `run:` field in a
+// workflow or composite action, with YAML quoting and block scalars already
+// resolved, so multi-line commands keep their line continuations. Content that
+// is not valid YAML falls back to the line-based extractRunLines.
+func extractRunScripts(content string) []string {
+	decoder := yaml.NewDecoder(strings.NewReader(content))
+	var scripts []string
+	for {
+		var doc yaml.Node
+		err := decoder.Decode(&doc)
+		if err == io.EOF {
+			return scripts
+		}
+		if err != nil {
+			return extractRunLines(content)
+		}
+		collectRunScripts(&doc, &scripts)
+	}
+}
+
+func collectRunScripts(node *yaml.Node, scripts *[]string) {
+	node = dereferenceAlias(node)
+	if node == nil {
+		return
+	}
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], dereferenceAlias(node.Content[i+1])
+			if key.Value == "run" && value != nil && value.Kind == yaml.ScalarNode {
+				*scripts = append(*scripts, value.Value)
+				continue
+			}
+			collectRunScripts(value, scripts)
+		}
+		return
+	}
+	for _, child := range node.Content {
+		collectRunScripts(child, scripts)
+	}
+}
+
 // ParseCIConfig scans CI YAML content for known antipatterns and returns a
 // CIFeatures struct with a boolean flag per detected antipattern.
 func ParseCIConfig(content string) CIFeatures {
 	var f CIFeatures
 
-	lines := extractRunLines(content)
-	for _, raw := range lines {
-		line := strings.ToLower(raw)
+	for _, raw := range extractRunScripts(content) {
+		script := parseShellScript(strings.ToLower(raw))
 
-		if isNpmInstall(line) {
+		if isNpmInstall(script) {
 			f.UsesNpmInstall = true
 		}
-		if isNpmCiWithoutIgnoreScripts(line) {
+		if isNpmCiWithoutIgnoreScripts(script) {
 			f.UsesNpmCiWithoutIgnoreScripts = true
 		}
-		if isYarnInstallWithoutFrozen(line) {
+		if isYarnInstallWithoutFrozen(script) {
 			f.UsesYarnInstallWithoutFrozen = true
 		}
-		if isNpxUsage(line) {
+		if isNpxUsage(script) {
 			f.UsesNpx = true
 		}
-		if isPipInstallWithoutNoCache(line) {
+		if isPipInstallWithoutNoCache(script) {
 			f.UsesPipInstallWithoutNoCache = true
 		}
-		if isPipInstallWithoutHashes(line) {
+		if isPipInstallWithoutHashes(script) {
 			f.UsesPipInstallWithoutHashes = true
 		}
-		if isCurlBashPipe(line) {
+		if isCurlBashPipe(script) {
 			f.UsesCurlBashPipe = true
 		}
-		if isSudo(line) {
+		if isSudo(script) {
 			f.UsesSudo = true
 		}
-		if isPackagePublish(line) {
+		if isPackagePublish(script) {
 			f.UsesPackagePublish = true
 		}
+		detectPackageManagerFeatures(script, &f.PackageManagerFeatures)
 	}
 
 	f.UsesPullRequestTarget = hasPullRequestTargetTrigger(content)
@@ -130,6 +173,74 @@ func ParseCIConfig(content string) CIFeatures {
 	return f
 }
 
+// ParseCIConfigWithCallees parses a workflow and merges in the antipatterns of
+// every resolved reusable workflow and composite action it calls, directly or
+// transitively. Triggers are taken from the caller only, since a callee always
+// runs in the context of the event that started the calling workflow.
+func ParseCIConfigWithCallees(file models.FileEntry, entry models.RepoEntry) CIFeatures {
+	features := ParseCIConfig(file.Content)
+	if len(entry.CIWorkflowCalls) == 0 {
+		return features
+	}
+
+	contents := make(map[string]string, len(entry.CIConfig)+len(entry.CICallees))
+	for _, f := range entry.CIConfig {
+		contents[f.Path] = f.Content
+	}
+	for _, f := range entry.CICallees {
+		contents[f.Path] = f.Content
+	}
+
+	visited := map[string]bool{file.Path: true}
+	queue := []string{file.Path}
+	for len(queue) > 0 {
+		caller := queue[0]
+		queue = queue[1:]
+
+		for _, call := range entry.CIWorkflowCalls {
+			if call.Caller != caller || !call.Resolved || visited[call.Target] {
+				continue
+			}
+			content, ok := contents[call.Target]
+			if !ok {
+				continue
+			}
+			visited[call.Target] = true
+			queue = append(queue, call.Target)
+			mergeCalleeFeatures(&features, ParseCIConfig(content))
+		}
+	}
+
+	return features
+}
+
+// mergeCalleeFeatures adds the run-step antipatterns and secret names of a
+// callee to the caller's features.
+func mergeCalleeFeatures(dst *CIFeatures, callee CIFeatures) {
+	dst.UsesNpmInstall = dst.UsesNpmInstall || callee.UsesNpmInstall
+	dst.UsesNpmCiWithoutIgnoreScripts = dst.UsesNpmCiWithoutIgnoreScripts || callee.UsesNpmCiWithoutIgnoreScripts
+	dst.UsesYarnInstallWithoutFrozen = dst.UsesYarnInstallWithoutFrozen || callee.UsesYarnInstallWithoutFrozen
+	dst.UsesNpx = dst.UsesNpx || callee.UsesNpx
+	dst.UsesPipInstallWithoutNoCache = dst.UsesPipInstallWithoutNoCache || callee.UsesPipInstallWithoutNoCache
+	dst.UsesPipInstallWithoutHashes = dst.UsesPipInstallWithoutHashes || callee.UsesPipInstallWithoutHashes
+	dst.UsesCurlBashPipe = dst.UsesCurlBashPipe || callee.UsesCurlBashPipe
+	dst.UsesSudo = dst.UsesSudo || callee.UsesSudo
+	dst.UsesPackagePublish = dst.UsesPackagePublish || callee.UsesPackagePublish
+	dst.PackageManagerFeatures.merge(callee.PackageManagerFeatures)
+
+	if len(callee.SecretNames) == 0 {
+		return
+	}
+	namesByKey := make(map[string]string, len(dst.SecretNames)+len(callee.SecretNames))
+	for _, name := range dst.SecretNames {
+		addSecretName(namesByKey, name)
+	}
+	for _, name := range callee.SecretNames {
+		addSecretName(namesByKey, name)
+	}
+	dst.SecretNames = sortedSecretNames(namesByKey)
+}
+
 func hasPullRequestTargetTrigger(content string) bool {
 	decoder := yaml.NewDecoder(strings.NewReader(content))
 
@@ -224,6 +335,10 @@ func extractSecretNames(content string) []string {
 		collectSecretNames(&doc, namesByKey)
 	}
 
+	return sortedSecretNames(namesByKey)
+}
+
+func sortedSecretNames(namesByKey map[string]string) []string {
 	if len(namesByKey) == 0 {
 		return []string{}
 	}
@@ -106,14 +106,47 @@ var _ = Describe("ParseCIConfig", func() {
 			parser.CIFeatures{},
 		),
 
+		Entry("curl piped to shasum is NOT flagged as curl to shell",
+			`run: curl -sL https://example.com/tool.tgz |shasum -a 256`,
+			parser.CIFeatures{},
+		),
+
+		Entry("line continuations in block scalars keep the command together",
+			`steps:
+  - run: |
+      npm ci \
+        --ignore-scripts
+      pip install \
+        --no-cache-dir requests`,
+			parser.CIFeatures{UsesPipInstallWithoutHashes: true},
+		),
+
+		Entry("package-manager antipatterns are detected in run steps",
+			`steps:
+  - run: |
+      pnpm install
+      go install github.com/golangci/golangci-lint/cmd/golangci-lint@latest`,
+			parser.CIFeatures{
+				PackageManagerFeatures: parser.PackageManagerFeatures{
+					UsesPnpmInstallWithoutFrozen: true,
+					UsesGoInstallLatest:          true,
+				},
+			},
+		),
+
 		Entry("sudo apt-get install is flagged",
 			`run: sudo apt-get install -y git`,
-			parser.CIFeatures{UsesSudo: true},
+			parser.CIFeatures{
+				UsesSudo:               true,
+				PackageManagerFeatures: parser.PackageManagerFeatures{UsesAptGetInstallWithoutNoRecommends: true},
+			},
 		),
 
 		Entry("step with no sudo is not flagged",
 			`run: apt-get install -y git`,
-			parser.CIFeatures{},
+			parser.CIFeatures{
+				PackageManagerFeatures: parser.PackageManagerFeatures{UsesAptGetInstallWithoutNoRecommends: true},
+			},
 		),
 
 		Entry("npm-family package publishing is detected",
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"encoding/json"
 	"regexp"
 	"strings"
 	"unicode/utf8"
@@ -31,6 +32,7 @@ type DockerfileFeatures struct {
 	UsesPipInstallWithoutNoCache  bool
 	UsesPipInstallWithoutHashes   bool
 	UsesCurlBashPipe              bool
+	PackageManagerFeatures
 }
 
 type DockerStageMeta struct {
@@ -146,27 +148,29 @@ func ParseDockerfile(content string) (DockerfileFeatures, []DockerStageMeta) {
 			if strings.Contains(lowerValue, "chmod 777") {
 				features.WorldWritable = true
 			}
-			if isNpmInstall(lowerValue) {
+			script := parseRunInstruction(lowerValue)
+			if isNpmInstall(script) {
 				features.UsesNpmInstall = true
 			}
-			if isNpmCiWithoutIgnoreScripts(lowerValue) {
+			if isNpmCiWithoutIgnoreScripts(script) {
 				features.UsesNpmCiWithoutIgnoreScripts = true
 			}
-			if isYarnInstallWithoutFrozen(lowerValue) {
+			if isYarnInstallWithoutFrozen(script) {
 				features.UsesYarnInstallWithoutFrozen = true
 			}
-			if isNpxUsage(lowerValue) {
+			if isNpxUsage(script) {
 				features.UsesNpx = true
 			}
-			if isPipInstallWithoutNoCache(lowerValue) {
+			if isPipInstallWithoutNoCache(script) {
 				features.UsesPipInstallWithoutNoCache = true
 			}
-			if isPipInstallWithoutHashes(lowerValue) {
+			if isPipInstallWithoutHashes(script) {
 				features.UsesPipInstallWithoutHashes = true
 			}
-			if isCurlBashPipe(lowerValue) {
+			if isCurlBashPipe(script) {
 				features.UsesCurlBashPipe = true
 			}
+			detectPackageManagerFeatures(script, &features.PackageManagerFeatures)
 		case "env":
 			lowerValue := strings.ToLower(instruction.value)
 			if strings.Contains(lowerValue, "password") || strings.Contains(lowerValue, "token") || strings.Contains(lowerValue, "secret") {
@@ -179,6 +183,25 @@ func ParseDockerfile(content string) (DockerfileFeatures, []DockerStageMeta) {
 	return features, stages
 }
 
+// parseRunInstruction parses the command of a RUN instruction. BuildKit flags
+// such as --mount are dropped, and the JSON exec form is taken as-is since it
+// bypasses the shell.
+func parseRunInstruction(value string) shellScript {
+	fields := strings.Fields(value)
+	for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+		value = strings.TrimSpace(strings.TrimPrefix(value, fields[0]))
+		fields = fields[1:]
+	}
+
+	if strings.HasPrefix(value, "[") {
+		var argv []string
+		if err := json.Unmarshal([]byte(value), &argv); err == nil {
+			return parseExecForm(argv)
+		}
+	}
+	return parseShellScript(value)
+}
+
 func parseDockerInstructions(content string) []dockerInstruction {
 	lines := strings.Split(content, "\n")
 	var instructions []dockerInstruction
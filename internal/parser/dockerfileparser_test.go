@@ -46,6 +46,9 @@ ENTRYPOINT ["./start.sh"]`,
 				HasAptGetClean:       false,
 				WorldWritable:        false,
 				HasSecretsInEnvOrArg: true,
+				PackageManagerFeatures: parser.PackageManagerFeatures{
+					UsesAptGetInstallWithoutNoRecommends: true,
+				},
 			},
 		),
 
@@ -130,6 +133,9 @@ RUN apt-get update && apt-get install -y gcc make curl && apt-get clean`,
 				HasAptGetClean:       true,
 				WorldWritable:        false,
 				HasSecretsInEnvOrArg: false,
+				PackageManagerFeatures: parser.PackageManagerFeatures{
+					UsesAptGetInstallWithoutNoRecommends: true,
+				},
 			},
 		),
 
@@ -348,6 +354,34 @@ RUN pip install \
 			},
 		),
 
+		Entry("RUN --mount flags and exec form are parsed as commands",
+			`FROM node:20
+RUN --mount=type=cache,target=/root/.npm npm ci
+RUN ["npx", "tsc"]`,
+			parser.DockerfileFeatures{
+				BaseImage:                     "node",
+				BaseTag:                       "20",
+				UsesNpmCiWithoutIgnoreScripts: true,
+				UsesNpx:                       true,
+			},
+		),
+
+		Entry("Package-manager antipatterns are detected in RUN",
+			`FROM alpine:3.20
+RUN apk add git && git clone https://github.com/org/tool.git
+RUN gem install bundler`,
+			parser.DockerfileFeatures{
+				BaseImage:          "alpine",
+				BaseTag:            "3.20",
+				HasPackageInstalls: true,
+				PackageManagerFeatures: parser.PackageManagerFeatures{
+					UsesApkAddWithoutNoCache:     true,
+					UsesGemInstallWithoutVersion: true,
+					UsesGitCloneUnpinned:         true,
+				},
+			},
+		),
+
 		Entry("Global ARG default resolves FROM image reference",
 			`ARG NODE_BUILD_IMG=node:20-alpine
 FROM --platform=${BUILDPLATFORM} ${NODE_BUILD_IMG} AS prepare`,
@@ -0,0 +1,302 @@
+package parser
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	gitCommitPattern  = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+	gitVersionPattern = regexp.MustCompile(`^v?[0-9]+(\.[0-9]+)*([-+][0-9a-z.-]+)?$`)
+)
+
+// PackageManagerFeatures holds the package-manager antipatterns that are
+// detected the same way in Dockerfile RUN instructions and CI run steps.
+type PackageManagerFeatures struct {
+	UsesPnpmInstallWithoutFrozen         bool
+	UsesBunInstallWithoutFrozen          bool
+	UsesGoInstallLatest                  bool
+	UsesGemInstallWithoutVersion         bool
+	UsesApkAddWithoutNoCache             bool
+	UsesAptGetInstallWithoutNoRecommends bool
+	UsesPoetryInstallWithoutLockCheck    bool
+	UsesUvPipInstallWithoutHashes        bool
+	UsesGitCloneUnpinned                 bool
+}
+
+// detectPackageManagerFeatures sets every antipattern found in script on f.
+// Flags that are already set are left untouched.
+func detectPackageManagerFeatures(script shellScript, f *PackageManagerFeatures) {
+	f.merge(PackageManagerFeatures{
+		UsesPnpmInstallWithoutFrozen:         isPnpmInstallWithoutFrozen(script),
+		UsesBunInstallWithoutFrozen:          isBunInstallWithoutFrozen(script),
+		UsesGoInstallLatest:                  isGoInstallLatest(script),
+		UsesGemInstallWithoutVersion:         isGemInstallWithoutVersion(script),
+		UsesApkAddWithoutNoCache:             isApkAddWithoutNoCache(script),
+		UsesAptGetInstallWithoutNoRecommends: isAptGetInstallWithoutNoRecommends(script),
+		UsesPoetryInstallWithoutLockCheck:    isPoetryInstallWithoutLockCheck(script),
+		UsesUvPipInstallWithoutHashes:        isUvPipInstallWithoutHashes(script),
+		UsesGitCloneUnpinned:                 isGitCloneUnpinned(script),
+	})
+}
+
+func (f *PackageManagerFeatures) merge(other PackageManagerFeatures) {
+	f.UsesPnpmInstallWithoutFrozen = f.UsesPnpmInstallWithoutFrozen || other.UsesPnpmInstallWithoutFrozen
+	f.UsesBunInstallWithoutFrozen = f.UsesBunInstallWithoutFrozen || other.UsesBunInstallWithoutFrozen
+	f.UsesGoInstallLatest = f.UsesGoInstallLatest || other.UsesGoInstallLatest
+	f.UsesGemInstallWithoutVersion = f.UsesGemInstallWithoutVersion || other.UsesGemInstallWithoutVersion
+	f.UsesApkAddWithoutNoCache = f.UsesApkAddWithoutNoCache || other.UsesApkAddWithoutNoCache
+	f.UsesAptGetInstallWithoutNoRecommends = f.UsesAptGetInstallWithoutNoRecommends || other.UsesAptGetInstallWithoutNoRecommends
+	f.UsesPoetryInstallWithoutLockCheck = f.UsesPoetryInstallWithoutLockCheck || other.UsesPoetryInstallWithoutLockCheck
+	f.UsesUvPipInstallWithoutHashes = f.UsesUvPipInstallWithoutHashes || other.UsesUvPipInstallWithoutHashes
+	f.UsesGitCloneUnpinned = f.UsesGitCloneUnpinned || other.UsesGitCloneUnpinned
+}
+
+// operands returns the arguments that are not options. Options that take a
+// separate value are listed in withValue so the value is skipped as well.
+func (c shellCommand) operands(withValue ...string) []string {
+	var result []string
+	for i := 0; i < len(c.args); i++ {
+		arg := c.args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			result = append(result, arg)
+			continue
+		}
+		for _, opt := range withValue {
+			if arg == opt {
+				i++
+				break
+			}
+		}
+	}
+	return result
+}
+
+// flagValue returns the value of the first of flags, given as `--flag value`
+// or `--flag=value`.
+func (c shellCommand) flagValue(flags ...string) (string, bool) {
+	for i, arg := range c.args {
+		for _, flag := range flags {
+			if arg == flag && i+1 < len(c.args) {
+				return c.args[i+1], true
+			}
+			if strings.HasPrefix(arg, flag+"=") {
+				return arg[len(flag)+1:], true
+			}
+		}
+	}
+	return "", false
+}
+
+// isPnpmInstallWithoutFrozen detects `pnpm install` without `--frozen-lockfile`.
+func isPnpmInstallWithoutFrozen(script shellScript) bool {
+	return script.anyCommand(func(c shellCommand) bool {
+		sub := c.subcommand()
+		return c.name == "pnpm" && (sub == "install" || sub == "i") && !c.hasFlag("--frozen-lockfile")
+	})
+}
+
+// isBunInstallWithoutFrozen detects `bun install` without `--frozen-lockfile`.
+func isBunInstallWithoutFrozen(script shellScript) bool {
+	return script.anyCommand(func(c shellCommand) bool {
+		sub := c.subcommand()
+		return c.name == "bun" && (sub == "install" || sub == "i") && !c.hasFlag("--frozen-lockfile")
+	})
+}
+
+// isGoInstallLatest detects `go install module@latest`.
+func isGoInstallLatest(script shellScript) bool {
+	return script.anyCommand(func(c shellCommand) bool {
+		if c.name != "go" || c.subcommand() != "install" {
+			return false
+		}
+		for _, arg := range c.args[1:] {
+			if strings.HasSuffix(arg, "@latest") {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// isGemInstallWithoutVersion detects `gem install name` without `-v`/`--version`
+// or a `name:version` requirement. Installing from a Gemfile is not flagged.
+func isGemInstallWithoutVersion(script shellScript) bool {
+	return script.anyCommand(func(c shellCommand) bool {
+		if c.name != "gem" || c.subcommand() != "install" {
+			return false
+		}
+		if c.hasFlag("-v", "--version", "-g", "--file") {
+			return false
+		}
+		for _, gem := range c.operands("-i", "--install-dir", "-n", "--bindir", "-s", "--source", "-p", "--http-proxy")[1:] {
+			if !strings.Contains(gem, ":") {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// isApkAddWithoutNoCache detects `apk add` without `--no-cache`, which leaves
+// the package index in the image.
+func isApkAddWithoutNoCache(script shellScript) bool {
+	return script.anyCommand(func(c shellCommand) bool {
+		ops := c.operands("-X", "--repository", "-p", "--root", "-t", "--virtual")
+		return c.name == "apk" && len(ops) > 0 && ops[0] == "add" && !c.hasFlag("--no-cache")
+	})
+}
+
+// isAptGetInstallWithoutNoRecommends detects `apt-get install` (or `apt
+// install`) without `--no-install-recommends` or the equivalent APT option.
+func isAptGetInstallWithoutNoRecommends(script shellScript) bool {
+	return script.anyCommand(func(c shellCommand) bool {
+		if c.name != "apt-get" && c.name != "apt" {
+			return false
+		}
+		ops := c.operands("-o", "-c", "-t", "--target-release")
+		if len(ops) == 0 || ops[0] != "install" || c.hasFlag("--no-install-recommends") {
+			return false
+		}
+		if option, ok := c.flagValue("-o"); ok {
+			option = strings.ToLower(option)
+			if option == "apt::install-recommends=false" || option == "apt::install-recommends=0" {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// isPoetryInstallWithoutLockCheck detects `poetry install` that neither uses
+// `--no-root` nor is preceded by a lock file check (`poetry check --lock` or
+// the older `poetry lock --check`) in the same script.
+func isPoetryInstallWithoutLockCheck(script shellScript) bool {
+	lockChecked := false
+	for _, pipeline := range script {
+		for _, c := range pipeline {
+			if c.name != "poetry" {
+				continue
+			}
+			switch c.subcommand() {
+			case "check":
+				lockChecked = lockChecked || c.hasFlag("--lock")
+			case "lock":
+				lockChecked = lockChecked || c.hasFlag("--check")
+			case "install":
+				if !lockChecked && !c.hasFlag("--no-root") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// isUvPipInstallWithoutHashes detects `uv pip install` without `--require-hashes`.
+func isUvPipInstallWithoutHashes(script shellScript) bool {
+	return script.anyCommand(func(c shellCommand) bool {
+		return c.name == "uv" && len(c.args) >= 2 && c.args[0] == "pip" && c.args[1] == "install" &&
+			!c.hasFlag("--require-hashes") && !c.hasAssignment("uv_require_hashes")
+	})
+}
+
+// gitSubcommand returns the git verb and its arguments, skipping global
+// options such as `-C dir` and `-c key=value`. dir is the directory given with
+// -C, if any.
+func gitSubcommand(c shellCommand) (sub string, cmd shellCommand, dir string) {
+	args := c.args
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if args[0] == "-C" && len(args) > 1 {
+			dir = changeDir(dir, args[1])
+		}
+		if args[0] == "-C" || args[0] == "-c" || args[0] == "--git-dir" || args[0] == "--work-tree" {
+			args = args[1:]
+		}
+		if len(args) > 0 {
+			args = args[1:]
+		}
+	}
+	if len(args) == 0 {
+		return "", c, dir
+	}
+	c.args = args[1:]
+	return args[0], c, dir
+}
+
+// gitCloneOptionsWithValue are the `git clone` options that take a separate value.
+var gitCloneOptionsWithValue = []string{
+	"-b", "--branch", "--depth", "-o", "--origin", "-c", "--config", "-u", "--upload-pack",
+	"--reference", "--reference-if-able", "--separate-git-dir", "--template", "--filter",
+	"-j", "--jobs", "--shallow-since", "--shallow-exclude", "--server-option",
+}
+
+// gitCloneDir returns the directory `git clone` clones into: the second
+// operand, or the last part of the repository URL without ".git".
+func gitCloneDir(clone shellCommand) string {
+	operands := clone.operands(gitCloneOptionsWithValue...)
+	switch len(operands) {
+	case 0:
+		return ""
+	case 1:
+		repo := strings.TrimRight(operands[0], "/")
+		repo = repo[strings.LastIndexAny(repo, "/:")+1:]
+		return strings.TrimSuffix(repo, ".git")
+	default:
+		return operands[1]
+	}
+}
+
+// isGitCloneUnpinned detects `git clone` of a moving branch. A clone counts as
+// pinned when --branch names a version tag or commit, or when the script later
+// checks out or hard-resets the cloned directory to a commit hash. The
+// directory a command runs in follows `cd` and `git -C`.
+func isGitCloneUnpinned(script shellScript) bool {
+	unpinned := map[string]bool{}
+	cwd := "."
+	for _, pipeline := range script {
+		for _, c := range pipeline {
+			if c.name == "cd" {
+				if dirs := c.operands(); len(dirs) > 0 {
+					cwd = changeDir(cwd, dirs[0])
+				}
+				continue
+			}
+			if c.name != "git" {
+				continue
+			}
+			sub, gitCmd, dir := gitSubcommand(c)
+			workDir := changeDir(cwd, dir)
+			switch sub {
+			case "clone":
+				branch, ok := gitCmd.flagValue("-b", "--branch")
+				target := changeDir(workDir, gitCloneDir(gitCmd))
+				unpinned[target] = !ok || !(gitVersionPattern.MatchString(branch) || gitCommitPattern.MatchString(branch))
+			case "checkout", "reset", "switch":
+				if _, cloned := unpinned[workDir]; !cloned {
+					continue
+				}
+				for _, arg := range gitCmd.operands() {
+					if gitCommitPattern.MatchString(arg) {
+						unpinned[workDir] = false
+					}
+				}
+			}
+		}
+	}
+	for _, u := range unpinned {
+		if u {
+			return true
+		}
+	}
+	return false
+}
+
+// changeDir returns the directory `cd dir` moves to from cwd.
+func changeDir(cwd, dir string) string {
+	if path.IsAbs(dir) {
+		return path.Clean(dir)
+	}
+	return path.Join(cwd, dir)
+}
@@ -0,0 +1,46 @@
+package parser
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("package-manager detection", func() {
+	DescribeTable("detects each antipattern from a parsed script",
+		func(line string, expected PackageManagerFeatures) {
+			var f PackageManagerFeatures
+			detectPackageManagerFeatures(parseShellScript(line), &f)
+			Expect(f).To(Equal(expected))
+		},
+		Entry("pnpm install without frozen lockfile", "pnpm install", PackageManagerFeatures{UsesPnpmInstallWithoutFrozen: true}),
+		Entry("pnpm install with frozen lockfile", "pnpm install --frozen-lockfile", PackageManagerFeatures{}),
+		Entry("pnpm add is not an install of the lockfile", "pnpm add left-pad", PackageManagerFeatures{}),
+		Entry("bun install without frozen lockfile", "bun i", PackageManagerFeatures{UsesBunInstallWithoutFrozen: true}),
+		Entry("bun install with frozen lockfile", "bun install --frozen-lockfile", PackageManagerFeatures{}),
+		Entry("go install at latest", "go install golang.org/x/tools/gopls@latest", PackageManagerFeatures{UsesGoInstallLatest: true}),
+		Entry("go install at a version", "go install golang.org/x/tools/gopls@v0.16.2", PackageManagerFeatures{}),
+		Entry("gem install without version", "gem install bundler", PackageManagerFeatures{UsesGemInstallWithoutVersion: true}),
+		Entry("gem install with -v", "gem install bundler -v 2.5.6", PackageManagerFeatures{}),
+		Entry("gem install with name:version", "gem install bundler:2.5.6", PackageManagerFeatures{}),
+		Entry("apk add without no-cache", "apk update && apk add git", PackageManagerFeatures{UsesApkAddWithoutNoCache: true}),
+		Entry("apk add with no-cache before the verb", "apk --no-cache add git", PackageManagerFeatures{}),
+		Entry("apt-get install without no-install-recommends", "apt-get install -y git", PackageManagerFeatures{UsesAptGetInstallWithoutNoRecommends: true}),
+		Entry("apt-get install with no-install-recommends", "apt-get install -y --no-install-recommends git", PackageManagerFeatures{}),
+		Entry("apt-get install with apt option", "apt-get -o apt::install-recommends=false install git", PackageManagerFeatures{}),
+		Entry("apt-get update is not an install", "apt-get update", PackageManagerFeatures{}),
+		Entry("poetry install without checks", "poetry install", PackageManagerFeatures{UsesPoetryInstallWithoutLockCheck: true}),
+		Entry("poetry install with no-root", "poetry install --no-root", PackageManagerFeatures{}),
+		Entry("poetry install after lock check", "poetry check --lock && poetry install", PackageManagerFeatures{}),
+		Entry("uv pip install without hashes", "uv pip install -r requirements.txt", PackageManagerFeatures{UsesUvPipInstallWithoutHashes: true}),
+		Entry("uv pip install with hashes", "uv pip install --require-hashes -r requirements.txt", PackageManagerFeatures{}),
+		Entry("git clone of default branch", "git clone https://github.com/org/repo.git", PackageManagerFeatures{UsesGitCloneUnpinned: true}),
+		Entry("git clone of a named branch", "git clone -b main https://github.com/org/repo.git", PackageManagerFeatures{UsesGitCloneUnpinned: true}),
+		Entry("git clone of a version tag", "git clone --depth 1 --branch v1.2.3 https://github.com/org/repo.git", PackageManagerFeatures{}),
+		Entry("git clone followed by commit checkout", "git clone https://github.com/org/repo.git && git -C repo checkout 3f2a9c1d", PackageManagerFeatures{}),
+		Entry("git clone followed by cd and commit checkout", "git clone --depth 50 https://github.com/org/tool.git /opt/tool && cd /opt/tool && git reset --hard 3f2a9c1d", PackageManagerFeatures{}),
+		Entry("git clone into a named directory followed by commit checkout", "git clone https://github.com/org/repo.git src && git -C src checkout 3f2a9c1d", PackageManagerFeatures{}),
+		Entry("commit checkout only pins the clone it runs in", "git clone https://github.com/org/a.git && git clone https://github.com/org/b.git && git -C b checkout 3f2a9c1d", PackageManagerFeatures{UsesGitCloneUnpinned: true}),
+		Entry("commit checkout outside the cloned directory", "git clone https://github.com/org/repo.git && git checkout 3f2a9c1d", PackageManagerFeatures{UsesGitCloneUnpinned: true}),
+		Entry("quoted commands are not detected", "echo 'apk add git; go install x@latest'", PackageManagerFeatures{}),
+	)
+})
@@ -0,0 +1,126 @@
+package parser
+
+import (
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+)
+
+// SecretFinding is a secret-like literal found in fetched content. It
+// deliberately carries no part of the matched value.
+type SecretFinding struct {
+	Path string
+	Line int
+	Rule string
+}
+
+type secretRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// secretRules are matched against every line. They target formats with a
+// recognisable prefix or header, so a match is a strong signal on its own.
+var secretRules = []secretRule{
+	{"github-token", regexp.MustCompile(`\b(ghp|gho|ghu|ghs|ghr)_[A-Za-z0-9]{36}\b`)},
+	{"github-fine-grained-pat", regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{82}\b`)},
+	{"aws-access-key-id", regexp.MustCompile(`\b(AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`)},
+	{"aws-secret-access-key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}\b`)},
+	{"slack-token", regexp.MustCompile(`\bxox[abprs]-[0-9A-Za-z-]{10,}`)},
+	{"slack-webhook", regexp.MustCompile(`https://hooks\.slack\.com/services/T[A-Za-z0-9_]+/B[A-Za-z0-9_]+/[A-Za-z0-9_]+`)},
+	{"private-key", regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----`)},
+}
+
+const (
+	highEntropyRule      = "high-entropy-assignment"
+	highEntropyMinLength = 16
+	highEntropyThreshold = 3.5
+)
+
+// highEntropyAssignment matches a value assigned to a secret-sounding name,
+// e.g. `DB_PASSWORD=...` or `apiKey: "..."`. The value must also look random.
+var highEntropyAssignment = regexp.MustCompile(
+	`(?i)[a-z0-9_.-]*(?:password|passwd|secret|token|api[_-]?key|access[_-]?key|private[_-]?key|credential)[a-z0-9_.-]*["']?\s*[:=]\s*["']?([^\s"'#]+)`)
+
+var secretPlaceholderHints = []string{"example", "changeme", "placeholder", "dummy", "xxxx", "****", "redacted"}
+
+// ScanSecrets returns the secret-like literals in file, at most one finding
+// per rule and line. Line numbers are 1-based.
+func ScanSecrets(file models.FileEntry) []SecretFinding {
+	var findings []SecretFinding
+	for i, line := range strings.Split(file.Content, "\n") {
+		matched := false
+		for _, rule := range secretRules {
+			if rule.pattern.MatchString(line) {
+				findings = append(findings, SecretFinding{Path: file.Path, Line: i + 1, Rule: rule.name})
+				matched = true
+			}
+		}
+		if !matched && hasHighEntropyAssignment(line) {
+			findings = append(findings, SecretFinding{Path: file.Path, Line: i + 1, Rule: highEntropyRule})
+		}
+	}
+	return findings
+}
+
+// ScanRepoSecrets scans the Dockerfiles, workflows and README of an entry.
+func ScanRepoSecrets(entry models.RepoEntry) []SecretFinding {
+	var findings []SecretFinding
+	for _, f := range entry.Files["dockerfile"] {
+		findings = append(findings, ScanSecrets(f)...)
+	}
+	for _, f := range entry.CIConfig {
+		findings = append(findings, ScanSecrets(f)...)
+	}
+	if entry.Repo.Readme != "" {
+		findings = append(findings, ScanSecrets(models.FileEntry{Path: "README.md", Content: entry.Repo.Readme})...)
+	}
+	return findings
+}
+
+func hasHighEntropyAssignment(line string) bool {
+	for _, m := range highEntropyAssignment.FindAllStringSubmatch(line, -1) {
+		value := m[1]
+		if len(value) < highEntropyMinLength || isSecretPlaceholder(value) {
+			continue
+		}
+		if shannonEntropy(value) >= highEntropyThreshold {
+			return true
+		}
+	}
+	return false
+}
+
+// isSecretPlaceholder filters out references and obvious dummy values, such
+// as `${{ secrets.TOKEN }}`, `$DB_PASSWORD` or `<your-token-here>`.
+func isSecretPlaceholder(value string) bool {
+	if strings.ContainsAny(value[:1], "$<%{") || strings.Contains(value, "${") || strings.Contains(value, "$(") {
+		return true
+	}
+	lower := strings.ToLower(value)
+	for _, hint := range secretPlaceholderHints {
+		if strings.Contains(lower, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// shannonEntropy returns the entropy of s in bits per byte.
+func shannonEntropy(s string) float64 {
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	entropy := 0.0
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / float64(len(s))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
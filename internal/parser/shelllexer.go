@@ -0,0 +1,544 @@
+package parser
+
+import (
+	"path"
+	"strings"
+)
+
+// maxShellNesting bounds recursion into command substitutions and `sh -c`
+// scripts so hostile input cannot blow the stack.
+const maxShellNesting = 8
+
+type shellTokenKind int
+
+const (
+	shellWord shellTokenKind = iota
+	shellOperator
+	shellRedirect
+)
+
+// shellToken is a lexed word (with quotes and escapes removed), a control
+// operator or a redirection operator. substitutions holds the raw scripts of
+// any $(...), `...`, <(...) or >(...) found inside a word.
+type shellToken struct {
+	kind          shellTokenKind
+	value         string
+	substitutions []string
+}
+
+type pendingHeredoc struct {
+	delimiter string
+	stripTabs bool
+}
+
+type shellLexer struct {
+	src       string
+	pos       int
+	tokens    []shellToken
+	heredocs  []pendingHeredoc
+	wantDelim bool
+	stripTabs bool
+}
+
+// lexShell splits a POSIX-ish shell script into tokens. It is deliberately
+// forgiving: unterminated quotes or substitutions simply run to the end of
+// the input instead of failing.
+func lexShell(src string) []shellToken {
+	l := &shellLexer{src: src}
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case c == '\\' && l.peek(1) == '\n':
+			l.pos += 2
+		case c == '\n':
+			l.pos++
+			l.emit(shellToken{kind: shellOperator, value: "\n"})
+			l.skipHeredocBodies()
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case isShellOperatorByte(c):
+			l.lexOperator()
+		default:
+			l.lexWord()
+		}
+	}
+	return l.tokens
+}
+
+func isShellOperatorByte(c byte) bool {
+	return c == '|' || c == '&' || c == ';' || c == '(' || c == ')' || c == '<' || c == '>'
+}
+
+func (l *shellLexer) peek(offset int) byte {
+	if l.pos+offset < len(l.src) {
+		return l.src[l.pos+offset]
+	}
+	return 0
+}
+
+func (l *shellLexer) emit(tok shellToken) {
+	if tok.kind == shellWord && l.wantDelim {
+		l.heredocs = append(l.heredocs, pendingHeredoc{delimiter: tok.value, stripTabs: l.stripTabs})
+		l.wantDelim = false
+	}
+	l.tokens = append(l.tokens, tok)
+}
+
+func (l *shellLexer) lexOperator() {
+	rest := l.src[l.pos:]
+
+	// Process substitution behaves like a word whose content is executed.
+	if strings.HasPrefix(rest, "<(") || strings.HasPrefix(rest, ">(") {
+		l.pos += 2
+		l.emit(shellToken{kind: shellWord, substitutions: []string{l.captureParens()}})
+		return
+	}
+
+	for _, op := range []string{"&&", "||", "|&", ";;", "|", "&", ";", "(", ")"} {
+		if strings.HasPrefix(rest, op) && !strings.HasPrefix(rest, "&>") {
+			l.pos += len(op)
+			l.emit(shellToken{kind: shellOperator, value: op})
+			return
+		}
+	}
+
+	for _, op := range []string{"&>>", "&>", "<<<", "<<-", "<<", "<>", "<&", ">>", ">&", ">|", "<", ">"} {
+		if strings.HasPrefix(rest, op) {
+			l.pos += len(op)
+			if op == "<<" || op == "<<-" {
+				l.wantDelim = true
+				l.stripTabs = op == "<<-"
+			}
+			l.emit(shellToken{kind: shellRedirect, value: op})
+			return
+		}
+	}
+}
+
+func (l *shellLexer) lexWord() {
+	var b strings.Builder
+	var subs []string
+	quoted := false
+
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			l.emit(shellToken{kind: shellWord, value: b.String(), substitutions: subs})
+			return
+		case isShellOperatorByte(c):
+			if (c == '<' || c == '>') && !quoted && isDigits(b.String()) {
+				// "2>" style file descriptor prefix belongs to the redirect.
+				return
+			}
+			l.emit(shellToken{kind: shellWord, value: b.String(), substitutions: subs})
+			return
+		case c == '\\':
+			if l.peek(1) != '\n' && l.pos+1 < len(l.src) {
+				b.WriteByte(l.src[l.pos+1])
+			}
+			l.pos += 2
+		case c == '\'':
+			quoted = true
+			end := strings.IndexByte(l.src[l.pos+1:], '\'')
+			if end < 0 {
+				b.WriteString(l.src[l.pos+1:])
+				l.pos = len(l.src)
+			} else {
+				b.WriteString(l.src[l.pos+1 : l.pos+1+end])
+				l.pos += end + 2
+			}
+		case c == '"':
+			quoted = true
+			l.pos++
+			subs = l.lexDoubleQuoted(&b, subs)
+		case c == '$' || c == '`':
+			subs = l.lexExpansion(&b, subs)
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+	l.emit(shellToken{kind: shellWord, value: b.String(), substitutions: subs})
+}
+
+func (l *shellLexer) lexDoubleQuoted(b *strings.Builder, subs []string) []string {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return subs
+		case c == '\\':
+			next := l.peek(1)
+			switch next {
+			case '\n':
+			case '$', '`', '"', '\\':
+				b.WriteByte(next)
+			default:
+				b.WriteByte(c)
+				if next != 0 {
+					b.WriteByte(next)
+				}
+			}
+			l.pos += 2
+		case c == '$' || c == '`':
+			subs = l.lexExpansion(b, subs)
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+	return subs
+}
+
+// lexExpansion handles $(...), `...` and ${...} starting at l.pos. Command
+// substitutions are recorded so their content can be parsed as commands.
+func (l *shellLexer) lexExpansion(b *strings.Builder, subs []string) []string {
+	switch {
+	case l.src[l.pos] == '`':
+		l.pos++
+		var inner strings.Builder
+		for l.pos < len(l.src) && l.src[l.pos] != '`' {
+			if l.src[l.pos] == '\\' && l.pos+1 < len(l.src) {
+				l.pos++
+			}
+			inner.WriteByte(l.src[l.pos])
+			l.pos++
+		}
+		l.pos++
+		return append(subs, inner.String())
+	case l.peek(1) == '(':
+		l.pos += 2
+		return append(subs, l.captureParens())
+	case l.peek(1) == '{':
+		end := strings.IndexByte(l.src[l.pos:], '}')
+		if end < 0 {
+			end = len(l.src) - l.pos - 1
+		}
+		b.WriteString(l.src[l.pos : l.pos+end+1])
+		l.pos += end + 1
+	default:
+		b.WriteByte('$')
+		l.pos++
+	}
+	return subs
+}
+
+// captureParens returns the text up to the parenthesis that closes an already
+// consumed opening one, skipping over quoted sections.
+func (l *shellLexer) captureParens() string {
+	start := l.pos
+	depth := 1
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos++
+		case '\'':
+			if end := strings.IndexByte(l.src[l.pos+1:], '\''); end >= 0 {
+				l.pos += end + 1
+			}
+		case '"':
+			for l.pos++; l.pos < len(l.src) && l.src[l.pos] != '"'; l.pos++ {
+				if l.src[l.pos] == '\\' {
+					l.pos++
+				}
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				inner := l.src[start:l.pos]
+				l.pos++
+				return inner
+			}
+		}
+		l.pos++
+	}
+	l.pos = len(l.src)
+	return l.src[start:]
+}
+
+// skipHeredocBodies drops here-document bodies that start after a newline so
+// their content is not mistaken for commands.
+func (l *shellLexer) skipHeredocBodies() {
+	for _, doc := range l.heredocs {
+		for l.pos < len(l.src) {
+			end := strings.IndexByte(l.src[l.pos:], '\n')
+			var line string
+			if end < 0 {
+				line = l.src[l.pos:]
+				l.pos = len(l.src)
+			} else {
+				line = l.src[l.pos : l.pos+end]
+				l.pos += end + 1
+			}
+			if doc.stripTabs {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if strings.TrimRight(line, "\r") == doc.delimiter {
+				break
+			}
+		}
+	}
+	l.heredocs = nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// shellCommand is a single simple command after wrappers such as sudo, env
+// and xargs have been peeled off. name is the base name of the executable.
+type shellCommand struct {
+	name          string
+	args          []string
+	assignments   []string
+	wrappers      []string
+	substitutions shellScript
+}
+
+// shellPipeline is a sequence of commands connected with `|`.
+type shellPipeline []shellCommand
+
+// shellScript is every pipeline found in a snippet, including the ones nested
+// in command substitutions and `sh -c` arguments.
+type shellScript []shellPipeline
+
+// parseShellScript lexes and parses src into pipelines of commands.
+func parseShellScript(src string) shellScript {
+	return parseShellTokens(lexShell(src), 0)
+}
+
+// parseExecForm builds a script from an exec-form argument list such as
+// Dockerfile `RUN ["npm", "ci"]`, which bypasses the shell entirely.
+func parseExecForm(argv []string) shellScript {
+	words := make([]shellToken, 0, len(argv))
+	for _, arg := range argv {
+		words = append(words, shellToken{kind: shellWord, value: arg})
+	}
+	cmd, nested, ok := buildShellCommand(words, nil, 0)
+	if !ok {
+		return nil
+	}
+	return append(shellScript{{cmd}}, append(cmd.substitutions, nested...)...)
+}
+
+func parseShellTokens(tokens []shellToken, depth int) shellScript {
+	var script, nested shellScript
+	var pipeline shellPipeline
+	var words, redirectTargets []shellToken
+	redirectPending := false
+
+	flushCommand := func() {
+		if cmd, inner, ok := buildShellCommand(words, redirectTargets, depth); ok {
+			pipeline = append(pipeline, cmd)
+			nested = append(nested, cmd.substitutions...)
+			nested = append(nested, inner...)
+		}
+		words, redirectTargets = nil, nil
+	}
+	flushPipeline := func() {
+		flushCommand()
+		if len(pipeline) > 0 {
+			script = append(script, pipeline)
+		}
+		pipeline = nil
+	}
+
+	for _, tok := range tokens {
+		switch tok.kind {
+		case shellRedirect:
+			redirectPending = true
+		case shellWord:
+			if redirectPending {
+				redirectTargets = append(redirectTargets, tok)
+				redirectPending = false
+				continue
+			}
+			words = append(words, tok)
+		case shellOperator:
+			redirectPending = false
+			switch tok.value {
+			case "|", "|&", "(", ")":
+				flushCommand()
+			default:
+				flushPipeline()
+			}
+		}
+	}
+	flushPipeline()
+
+	return append(script, nested...)
+}
+
+var (
+	shellReservedWords = map[string]bool{
+		"!": true, "{": true, "}": true, "if": true, "then": true, "else": true, "elif": true,
+		"fi": true, "do": true, "done": true, "while": true, "until": true, "esac": true,
+	}
+	shellCompoundHeaders = map[string]bool{"for": true, "case": true, "select": true, "function": true}
+	shellInterpreters    = map[string]bool{"sh": true, "bash": true, "zsh": true, "dash": true, "ksh": true, "ash": true}
+)
+
+// buildShellCommand turns the words of one simple command into a shellCommand.
+// The second return value holds scripts executed through `sh -c`.
+func buildShellCommand(words, redirectTargets []shellToken, depth int) (shellCommand, shellScript, bool) {
+	var cmd shellCommand
+	if depth < maxShellNesting {
+		for _, tok := range append(append([]shellToken{}, words...), redirectTargets...) {
+			for _, sub := range tok.substitutions {
+				cmd.substitutions = append(cmd.substitutions, parseShellTokens(lexShell(sub), depth+1)...)
+			}
+		}
+	}
+
+	argv := make([]string, 0, len(words))
+	for _, tok := range words {
+		argv = append(argv, tok.value)
+	}
+
+	for len(argv) > 0 && shellReservedWords[argv[0]] {
+		argv = argv[1:]
+	}
+	if len(argv) > 0 && shellCompoundHeaders[argv[0]] {
+		return shellCommand{substitutions: cmd.substitutions}, nil, len(cmd.substitutions) > 0
+	}
+	argv = cmd.takeAssignments(argv)
+
+	for len(argv) > 0 {
+		wrapper := shellCommandName(argv[0])
+		rest, ok := unwrapShellWrapper(wrapper, argv[1:])
+		if !ok {
+			break
+		}
+		cmd.wrappers = append(cmd.wrappers, wrapper)
+		if len(rest) == 0 {
+			// A bare wrapper such as `sudo -v` is the command itself.
+			cmd.name = wrapper
+			cmd.args = argv[1:]
+			return cmd, nil, true
+		}
+		argv = cmd.takeAssignments(rest)
+	}
+
+	if len(argv) == 0 {
+		return cmd, nil, len(cmd.substitutions) > 0
+	}
+	cmd.name = shellCommandName(argv[0])
+	cmd.args = argv[1:]
+
+	var nested shellScript
+	if shellInterpreters[cmd.name] && depth < maxShellNesting {
+		if script, ok := interpreterScript(cmd.args); ok {
+			nested = parseShellTokens(lexShell(script), depth+1)
+		}
+	}
+	return cmd, nested, true
+}
+
+// shellCommandName strips the directory from an executable path so that
+// /usr/bin/sudo and sudo are treated alike.
+func shellCommandName(word string) string {
+	if word == "" {
+		return ""
+	}
+	return path.Base(word)
+}
+
+func (c *shellCommand) takeAssignments(argv []string) []string {
+	for len(argv) > 0 && isShellAssignment(argv[0]) {
+		c.assignments = append(c.assignments, argv[0])
+		argv = argv[1:]
+	}
+	return argv
+}
+
+func isShellAssignment(word string) bool {
+	eq := strings.IndexByte(word, '=')
+	if eq <= 0 {
+		return false
+	}
+	for i := 0; i < eq; i++ {
+		c := word[i]
+		isLetter := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+		if !isLetter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// wrapperOptionsWithValue lists the options that consume the following word
+// for each command that runs another command.
+var wrapperOptionsWithValue = map[string]map[string]bool{
+	"sudo":    {"-u": true, "-g": true, "-h": true, "-p": true, "-C": true, "-c": true, "-D": true, "-r": true, "-t": true, "-U": true, "-T": true},
+	"doas":    {"-u": true, "-C": true},
+	"env":     {"-u": true, "-C": true, "-S": true},
+	"xargs":   {"-I": true, "-L": true, "-n": true, "-P": true, "-d": true, "-E": true, "-s": true, "-a": true},
+	"nice":    {"-n": true},
+	"timeout": {"-s": true, "-k": true},
+	"time":    {"-f": true, "-o": true},
+	"nohup":   {},
+	"exec":    {"-a": true},
+	"command": {},
+	"builtin": {},
+}
+
+// unwrapShellWrapper strips the options of a wrapper command and returns the
+// command it runs. ok is false when name is not a wrapper.
+func unwrapShellWrapper(name string, args []string) ([]string, bool) {
+	withValue, ok := wrapperOptionsWithValue[name]
+	if !ok {
+		return nil, false
+	}
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-" {
+		opt := args[0]
+		args = args[1:]
+		if opt == "--" {
+			break
+		}
+		if name == "command" && (opt == "-v" || opt == "-V") {
+			// `command -v npm` only looks the command up.
+			return nil, false
+		}
+		if withValue[opt] && len(args) > 0 {
+			args = args[1:]
+		}
+	}
+	if name == "timeout" && len(args) > 0 {
+		args = args[1:] // duration
+	}
+	return args, true
+}
+
+// interpreterScript returns the script passed to a shell with `-c`.
+func interpreterScript(args []string) (string, bool) {
+	hasC := false
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") {
+			if strings.Contains(arg, "c") {
+				hasC = true
+			}
+			continue
+		}
+		if hasC {
+			return arg, true
+		}
+		return "", false
+	}
+	return "", false
+}
@@ -0,0 +1,82 @@
+package parser
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// commandLines flattens a parsed script into "name arg..." strings per command.
+func commandLines(script shellScript) [][]string {
+	var result [][]string
+	for _, pipeline := range script {
+		var commands []string
+		for _, cmd := range pipeline {
+			line := cmd.name
+			for _, arg := range cmd.args {
+				line += " " + arg
+			}
+			commands = append(commands, line)
+		}
+		result = append(result, commands)
+	}
+	return result
+}
+
+var _ = Describe("parseShellScript", func() {
+	DescribeTable("splits scripts into pipelines of commands",
+		func(src string, expected [][]string) {
+			Expect(commandLines(parseShellScript(src))).To(Equal(expected))
+		},
+		Entry("operators inside quotes are kept in the argument",
+			`echo "a | b && c" ; grep 'x;y' file`,
+			[][]string{{"echo a | b && c"}, {"grep x;y file"}},
+		),
+		Entry("pipes form one pipeline",
+			"cat file | sort|uniq -c",
+			[][]string{{"cat file", "sort", "uniq -c"}},
+		),
+		Entry("escapes and line continuations",
+			"apt-get install -y \\\n  git \\\"quoted\\\"",
+			[][]string{{`apt-get install -y git "quoted"`}},
+		),
+		Entry("comments are dropped",
+			"npm test # && npm publish",
+			[][]string{{"npm test"}},
+		),
+		Entry("redirections are not arguments",
+			"make build > out.log 2>&1 </dev/null",
+			[][]string{{"make build"}},
+		),
+		Entry("wrappers and assignments are peeled off",
+			"sudo -u root env -i CI=true xargs -I{} npm ci",
+			[][]string{{"npm ci"}},
+		),
+		Entry("subshells and control structures expose inner commands",
+			"(cd app && npm ci); if true; then npx jest; fi",
+			[][]string{{"cd app"}, {"npm ci"}, {"true"}, {"npx jest"}},
+		),
+		Entry("command substitutions and sh -c scripts are parsed",
+			`echo "$(npm pack)" && bash -c 'pip install x'`,
+			[][]string{{"echo "}, {"bash -c pip install x"}, {"npm pack"}, {"pip install x"}},
+		),
+		Entry("heredoc bodies are skipped",
+			"cat <<EOF > script.sh\nnpm publish\nEOF\nnpm test",
+			[][]string{{"cat"}, {"npm test"}},
+		),
+		Entry("command -v only looks commands up",
+			"command -v sudo",
+			[][]string{{"command -v sudo"}},
+		),
+	)
+
+	It("records the wrappers that were removed", func() {
+		script := parseShellScript("/usr/bin/sudo -E nice -n 10 pip install x")
+		Expect(script).To(HaveLen(1))
+		Expect(script[0][0].name).To(Equal("pip"))
+		Expect(script[0][0].wrappers).To(Equal([]string{"sudo", "nice"}))
+	})
+
+	It("parses the Dockerfile exec form without a shell", func() {
+		Expect(commandLines(parseExecForm([]string{"npm", "install", "a|b"}))).To(Equal([][]string{{"npm install a|b"}}))
+	})
+})
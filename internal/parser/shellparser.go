@@ -2,142 +2,166 @@ package parser
 
 import "strings"
 
-// splitRunSegments splits a shell command line on `&&`, `||`, `;` and `|` so
-// that each individual command can be checked independently.
-func splitRunSegments(line string) []string {
-	replacer := strings.NewReplacer("&&", "\x00", "||", "\x00", ";", "\x00", "|", "\x00")
-	return strings.Split(replacer.Replace(line), "\x00")
-}
-
-// isWordChar returns true for characters that can appear in a command name.
-func isWordChar(b byte) bool {
-	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') || b == '-' || b == '_'
+// anyCommand reports whether pred holds for any command in the script.
+func (s shellScript) anyCommand(pred func(shellCommand) bool) bool {
+	for _, pipeline := range s {
+		for _, cmd := range pipeline {
+			if pred(cmd) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-// containsCmd returns true if segment contains cmd as a standalone command.
-// cmd must be preceded by a space or start-of-string, and followed by a
-// non-word character or end-of-string. This prevents "pnpm install" from
-// matching "npm install", and allows trailing YAML quotes like `npm install"`.
-func containsCmd(segment, cmd string) bool {
-	idx := strings.Index(segment, cmd)
-	if idx < 0 {
-		return false
+// subcommand returns the first argument, which is where npm-family tools
+// expect their verb (`npm ci`, `yarn install`).
+func (c shellCommand) subcommand() string {
+	if len(c.args) == 0 {
+		return ""
 	}
-	if idx > 0 && segment[idx-1] != ' ' {
-		return false
-	}
-	end := idx + len(cmd)
-	return end == len(segment) || !isWordChar(segment[end])
+	return c.args[0]
 }
 
-func startsWithCmd(segment, cmd string) bool {
-	if !strings.HasPrefix(segment, cmd) {
-		return false
+// hasFlag reports whether any argument is one of flags, either bare or in
+// `--flag=value` form.
+func (c shellCommand) hasFlag(flags ...string) bool {
+	for _, arg := range c.args {
+		for _, flag := range flags {
+			if arg == flag || strings.HasPrefix(arg, flag+"=") {
+				return true
+			}
+		}
 	}
-
-	end := len(cmd)
-	return end == len(segment) || !isWordChar(segment[end])
+	return false
 }
 
-// isNpmInstall detects bare `npm install` (not `npm install-ci-test` or similar).
-func isNpmInstall(line string) bool {
-	for _, segment := range splitRunSegments(line) {
-		if containsCmd(strings.TrimSpace(segment), "npm install") {
+// hasAssignment reports whether the command is prefixed with NAME=... for the
+// given (lowercase) variable name.
+func (c shellCommand) hasAssignment(name string) bool {
+	for _, assignment := range c.assignments {
+		if strings.HasPrefix(strings.ToLower(assignment), name+"=") {
 			return true
 		}
 	}
 	return false
 }
 
-func commandMissingFlag(line string, commands []string, requiredFlag string) bool {
-	for _, segment := range splitRunSegments(line) {
-		s := strings.TrimSpace(segment)
-		for _, cmd := range commands {
-			if containsCmd(s, cmd) {
-				return !strings.Contains(s, requiredFlag)
-			}
+func (c shellCommand) hasWrapper(name string) bool {
+	for _, wrapper := range c.wrappers {
+		if wrapper == name {
+			return true
 		}
 	}
 	return false
 }
 
+// isNpmInstall detects bare `npm install` (not `npm install-ci-test` or similar).
+func isNpmInstall(script shellScript) bool {
+	return script.anyCommand(func(c shellCommand) bool {
+		return c.name == "npm" && (c.subcommand() == "install" || c.subcommand() == "i")
+	})
+}
+
 // isNpmCiWithoutIgnoreScripts returns true only when `npm ci` is present but
 // `--ignore-scripts` is absent. Returns false when npm ci is not used at all.
-func isNpmCiWithoutIgnoreScripts(line string) bool {
-	return commandMissingFlag(line, []string{"npm ci"}, "--ignore-scripts")
+func isNpmCiWithoutIgnoreScripts(script shellScript) bool {
+	return script.anyCommand(func(c shellCommand) bool {
+		return c.name == "npm" && c.subcommand() == "ci" &&
+			!c.hasFlag("--ignore-scripts") && !c.hasAssignment("npm_config_ignore_scripts")
+	})
 }
 
-// isYarnInstallWithoutFrozen detects `yarn install` without `--frozen-lockfile`.
-func isYarnInstallWithoutFrozen(line string) bool {
-	return commandMissingFlag(line, []string{"yarn install"}, "--frozen-lockfile")
+// isYarnInstallWithoutFrozen detects `yarn install` (or bare `yarn`) without
+// `--frozen-lockfile` or its Yarn Berry equivalent `--immutable`.
+func isYarnInstallWithoutFrozen(script shellScript) bool {
+	return script.anyCommand(func(c shellCommand) bool {
+		if c.name != "yarn" {
+			return false
+		}
+		sub := c.subcommand()
+		return (sub == "install" || sub == "") && !c.hasFlag("--frozen-lockfile", "--immutable")
+	})
 }
 
-// isNpxUsage detects standalone `npx` execution at the start of a shell segment.
-func isNpxUsage(line string) bool {
-	for _, segment := range splitRunSegments(line) {
-		if startsWithCmd(strings.TrimSpace(segment), "npx") {
-			return true
-		}
-	}
+// isNpxUsage detects `npx` being executed as a command.
+func isNpxUsage(script shellScript) bool {
+	return script.anyCommand(func(c shellCommand) bool {
+		return c.name == "npx"
+	})
+}
 
-	return false
+// pipInstall returns the pip arguments when c runs `pip install`, either
+// directly (pip, pip3, pip3.12) or as `python -m pip install`.
+func pipInstall(c shellCommand) (shellCommand, bool) {
+	switch {
+	case c.name == "pip" || strings.HasPrefix(c.name, "pip3"):
+	case strings.HasPrefix(c.name, "python") && len(c.args) >= 2 && c.args[0] == "-m" && (c.args[1] == "pip" || c.args[1] == "pip3"):
+		c.args = c.args[2:]
+	default:
+		return c, false
+	}
+	return c, c.subcommand() == "install"
 }
 
-// isPipInstallWithoutNoCache detects `pip install` (or `pip3 install`) without
-// the `--no-cache-dir` flag.
-func isPipInstallWithoutNoCache(line string) bool {
-	return commandMissingFlag(line, []string{"pip install", "pip3 install"}, "--no-cache-dir")
+// isPipInstallWithoutNoCache detects `pip install` without `--no-cache-dir`.
+func isPipInstallWithoutNoCache(script shellScript) bool {
+	return script.anyCommand(func(c shellCommand) bool {
+		pip, ok := pipInstall(c)
+		return ok && !pip.hasFlag("--no-cache-dir") && !pip.hasAssignment("pip_no_cache_dir")
+	})
 }
 
-// isPipInstallWithoutHashes detects `pip install` (or `pip3 install`) without
-// the `--require-hashes` flag.
-func isPipInstallWithoutHashes(line string) bool {
-	return commandMissingFlag(line, []string{"pip install", "pip3 install"}, "--require-hashes")
+// isPipInstallWithoutHashes detects `pip install` without `--require-hashes`.
+func isPipInstallWithoutHashes(script shellScript) bool {
+	return script.anyCommand(func(c shellCommand) bool {
+		pip, ok := pipInstall(c)
+		return ok && !pip.hasFlag("--require-hashes") && !pip.hasAssignment("pip_require_hashes")
+	})
 }
 
-// isCurlBashPipe detects `curl ... | bash` or `wget ... | sh`.
-func isCurlBashPipe(line string) bool {
-	hasCurlOrWget := strings.Contains(line, "curl ") || strings.Contains(line, "wget ")
-	if !hasCurlOrWget {
-		return false
-	}
-	return strings.Contains(line, "| bash") ||
-		strings.Contains(line, "| sh") ||
-		strings.Contains(line, "|bash") ||
-		strings.Contains(line, "|sh")
+func isDownloadCommand(c shellCommand) bool {
+	return c.name == "curl" || c.name == "wget"
 }
 
-// isSudo detects usage of sudo.
-func isSudo(line string) bool {
-	for _, segment := range splitRunSegments(line) {
-		s := strings.TrimSpace(segment)
-		if s == "sudo" || strings.HasPrefix(s, "sudo ") {
-			return true
+// isCurlBashPipe detects a download piped into a shell (`curl ... | bash`,
+// `wget -O- ... | sudo sh`) and a shell fed a download through substitution
+// (`bash <(curl ...)`, `sh -c "$(curl ...)"`).
+func isCurlBashPipe(script shellScript) bool {
+	for _, pipeline := range script {
+		downloaded := false
+		for _, cmd := range pipeline {
+			if shellInterpreters[cmd.name] && (downloaded || cmd.substitutions.anyCommand(isDownloadCommand)) {
+				return true
+			}
+			if isDownloadCommand(cmd) {
+				downloaded = true
+			}
 		}
 	}
 	return false
 }
 
-// isPackagePublish detects direct npm-family publish commands in run segments.
-func isPackagePublish(line string) bool {
-	commands := []string{
-		"npm publish",
-		"pnpm publish",
-		"yarn npm publish",
-		"yarn publish",
-	}
+// isSudo detects commands run through sudo.
+func isSudo(script shellScript) bool {
+	return script.anyCommand(func(c shellCommand) bool {
+		return c.name == "sudo" || c.hasWrapper("sudo")
+	})
+}
 
-	for _, segment := range splitRunSegments(line) {
-		s := strings.TrimSpace(segment)
-		if strings.Contains(s, "--dry-run") {
-			continue
+// isPackagePublish detects direct npm-family publish commands that are not
+// dry runs.
+func isPackagePublish(script shellScript) bool {
+	return script.anyCommand(func(c shellCommand) bool {
+		if c.hasFlag("--dry-run") {
+			return false
 		}
-		for _, cmd := range commands {
-			if containsCmd(s, cmd) {
-				return true
-			}
+		switch c.name {
+		case "npm", "pnpm":
+			return c.subcommand() == "publish"
+		case "yarn":
+			return c.subcommand() == "publish" || (c.subcommand() == "npm" && len(c.args) > 1 && c.args[1] == "publish")
 		}
-	}
-
-	return false
+		return false
+	})
 }
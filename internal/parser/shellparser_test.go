@@ -76,10 +76,10 @@ jobs:
 var _ = Describe("shell command detection", func() {
 	DescribeTable("checks mitigation flags per command segment",
 		func(line string, npmCi, yarnInstall, pipNoCache, pipHashes bool) {
-			Expect(isNpmCiWithoutIgnoreScripts(line)).To(Equal(npmCi))
-			Expect(isYarnInstallWithoutFrozen(line)).To(Equal(yarnInstall))
-			Expect(isPipInstallWithoutNoCache(line)).To(Equal(pipNoCache))
-			Expect(isPipInstallWithoutHashes(line)).To(Equal(pipHashes))
+			Expect(isNpmCiWithoutIgnoreScripts(parseShellScript(line))).To(Equal(npmCi))
+			Expect(isYarnInstallWithoutFrozen(parseShellScript(line))).To(Equal(yarnInstall))
+			Expect(isPipInstallWithoutNoCache(parseShellScript(line))).To(Equal(pipNoCache))
+			Expect(isPipInstallWithoutHashes(parseShellScript(line))).To(Equal(pipHashes))
 		},
 		Entry("npm ci is still flagged when another segment has ignore-scripts",
 			"npm install --ignore-scripts && npm ci",
@@ -97,22 +97,36 @@ var _ = Describe("shell command detection", func() {
 			"pip install --no-cache-dir --require-hashes -r requirements.txt",
 			false, false, false, false,
 		),
+		Entry("mitigation split across a line continuation is honoured",
+			"npm ci \\\n  --ignore-scripts",
+			false, false, false, false,
+		),
+		Entry("quoted commands are arguments and yarn berry immutable counts as frozen",
+			"echo 'npm ci | pip install x' && yarn install --immutable && python -m pip install x",
+			false, false, true, true,
+		),
+		Entry("env wrapper and npm_config assignment are understood",
+			"env npm_config_ignore_scripts=true npm ci && sudo pip3 install --no-cache-dir --require-hashes x",
+			false, false, false, false,
+		),
 	)
 
 	DescribeTable("detects npx execution",
 		func(line string, expected bool) {
-			Expect(isNpxUsage(line)).To(Equal(expected))
+			Expect(isNpxUsage(parseShellScript(line))).To(Equal(expected))
 		},
 		Entry("simple npx command", "npx tsx script.ts", true),
 		Entry("npx after chained build", "npm test && npx eslint .", true),
 		Entry("echoing npx is ignored", "echo npx", false),
 		Entry("quoted npx string is ignored", "echo \"npx tsx script.ts\"", false),
 		Entry("pnpx is ignored", "pnpx prisma generate", false),
+		Entry("npx through xargs", "ls packages | xargs -n1 npx tsc -p", true),
+		Entry("npx inside a quoted sh -c script", "sh -c 'npx tsc'", true),
 	)
 
 	DescribeTable("detects sudo across chained commands",
 		func(line string, expected bool) {
-			Expect(isSudo(line)).To(Equal(expected))
+			Expect(isSudo(parseShellScript(line))).To(Equal(expected))
 		},
 		Entry("sudo at start", "sudo apt-get install -y git", true),
 		Entry("sudo after semicolon", "echo hi;sudo apt-get install -y git", true),
@@ -120,11 +134,14 @@ var _ = Describe("shell command detection", func() {
 		Entry("sudo after logical or", "false||sudo apt-get install -y git", true),
 		Entry("sudo after pipe", "cat file |sudo tee /tmp/file", true),
 		Entry("no sudo present", "apt-get install -y git", false),
+		Entry("sudo as an argument is ignored", "apt-get remove -y sudo", false),
+		Entry("sudo behind env", "env DEBIAN_FRONTEND=noninteractive sudo apt-get install -y git", true),
+		Entry("sudo inside a subshell", "(cd /tmp && sudo make install)", true),
 	)
 
 	DescribeTable("detects npm-family package publishing",
 		func(line string, expected bool) {
-			Expect(isPackagePublish(line)).To(Equal(expected))
+			Expect(isPackagePublish(parseShellScript(line))).To(Equal(expected))
 		},
 		Entry("npm publish", "npm publish", true),
 		Entry("pnpm publish", "pnpm publish --access public", true),
@@ -135,5 +152,20 @@ var _ = Describe("shell command detection", func() {
 		Entry("npm publish dry run is ignored", "npm publish --dry-run", false),
 		Entry("npm run publish script is ignored", "npm run publish", false),
 		Entry("echoing npm publish is ignored", "echo \"npm publish\"", false),
+		Entry("dry run on another command does not hide publish", "npm pack --dry-run && npm publish", true),
+	)
+
+	DescribeTable("detects downloads executed by a shell",
+		func(line string, expected bool) {
+			Expect(isCurlBashPipe(parseShellScript(line))).To(Equal(expected))
+		},
+		Entry("curl piped to bash", "curl -fsSL https://get.example.com | bash", true),
+		Entry("wget piped to sudo sh", "wget -qO- https://get.example.com|sudo sh -s -- -y", true),
+		Entry("bash with process substitution", "bash <(curl -s https://get.example.com)", true),
+		Entry("sh -c with command substitution", `sh -c "$(curl -fsSL https://get.example.com)"`, true),
+		Entry("download verified with shasum is ignored", "curl -sL https://example.com/tool.tgz |shasum -a 256", false),
+		Entry("pipe inside a quoted argument is ignored", `curl -H "x: a|sh" https://example.com -o out`, false),
+		Entry("shell without a download is ignored", "cat install.sh | bash", false),
+		Entry("downloading a script and running it later is ignored", "curl -o install.sh https://example.com && bash -c 'echo ok'", false),
 	)
 })
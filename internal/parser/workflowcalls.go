@@ -0,0 +1,140 @@
+package parser
+
+import (
+	"io"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+type CIUsesKind string
+
+const (
+	// CIUsesWorkflow is a job-level `uses:` calling a reusable workflow.
+	CIUsesWorkflow CIUsesKind = "workflow"
+	// CIUsesAction is a step-level `uses:` calling an action.
+	CIUsesAction CIUsesKind = "action"
+)
+
+// CIUsesReference is one `uses:` found in a workflow or composite action.
+// Local references (`./...`) only carry Path; remote ones are split into
+// owner, repo, path inside the repo and the ref after `@`.
+type CIUsesReference struct {
+	Uses  string
+	Job   string
+	Kind  CIUsesKind
+	Local bool
+	Owner string
+	Repo  string
+	Path  string
+	Ref   string
+}
+
+// ExtractUsesReferences returns all job-level and step-level `uses:` in a
+// workflow, plus the steps of a composite action (`runs.steps`). Docker
+// references (`docker://`) are skipped since they hide no run steps.
+func ExtractUsesReferences(content string) []CIUsesReference {
+	decoder := yaml.NewDecoder(strings.NewReader(content))
+	var refs []CIUsesReference
+
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return refs
+		}
+
+		root := dereferenceAlias(&doc)
+		if root != nil && root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+			root = dereferenceAlias(root.Content[0])
+		}
+		if root == nil || root.Kind != yaml.MappingNode {
+			continue
+		}
+
+		if jobs := mappingValue(root, "jobs"); jobs != nil && jobs.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(jobs.Content); i += 2 {
+				jobID := jobs.Content[i].Value
+				job := dereferenceAlias(jobs.Content[i+1])
+				if job == nil || job.Kind != yaml.MappingNode {
+					continue
+				}
+				if uses := mappingValue(job, "uses"); uses != nil && uses.Kind == yaml.ScalarNode {
+					if ref, ok := parseUsesReference(uses.Value, jobID, CIUsesWorkflow); ok {
+						refs = append(refs, ref)
+					}
+				}
+				refs = append(refs, stepUsesReferences(mappingValue(job, "steps"), jobID)...)
+			}
+		}
+
+		if runs := mappingValue(root, "runs"); runs != nil && runs.Kind == yaml.MappingNode {
+			refs = append(refs, stepUsesReferences(mappingValue(runs, "steps"), "")...)
+		}
+	}
+
+	return refs
+}
+
+func stepUsesReferences(steps *yaml.Node, jobID string) []CIUsesReference {
+	if steps == nil || steps.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	var refs []CIUsesReference
+	for _, rawStep := range steps.Content {
+		step := dereferenceAlias(rawStep)
+		if step == nil || step.Kind != yaml.MappingNode {
+			continue
+		}
+		uses := mappingValue(step, "uses")
+		if uses == nil || uses.Kind != yaml.ScalarNode {
+			continue
+		}
+		if ref, ok := parseUsesReference(uses.Value, jobID, CIUsesAction); ok {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return dereferenceAlias(node.Content[i+1])
+		}
+	}
+	return nil
+}
+
+func parseUsesReference(uses, jobID string, kind CIUsesKind) (CIUsesReference, bool) {
+	uses = strings.TrimSpace(uses)
+	if uses == "" || strings.HasPrefix(uses, "docker://") {
+		return CIUsesReference{}, false
+	}
+
+	ref := CIUsesReference{Uses: uses, Job: jobID, Kind: kind}
+
+	if strings.HasPrefix(uses, "./") {
+		ref.Local = true
+		ref.Path = path.Clean(strings.TrimPrefix(uses, "./"))
+		return ref, true
+	}
+
+	target, version, _ := strings.Cut(uses, "@")
+	parts := strings.SplitN(target, "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return CIUsesReference{}, false
+	}
+
+	ref.Owner = parts[0]
+	ref.Repo = parts[1]
+	if len(parts) == 3 {
+		ref.Path = path.Clean(parts[2])
+	}
+	ref.Ref = version
+	return ref, true
+}
@@ -0,0 +1,123 @@
+package parser_test
+
+import (
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+	"github.com/jonmartinstorm/reposnusern/internal/parser"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ExtractUsesReferences", func() {
+	It("finds reusable workflows, local actions and remote actions", func() {
+		content := `name: CI
+on: [push]
+jobs:
+  build:
+    uses: ./.github/workflows/build.yml
+  deploy:
+    uses: our-org/shared-workflows/.github/workflows/deploy.yml@v1
+  test:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v4
+      - uses: ./.github/actions/setup
+      - uses: docker://alpine:3.20
+      - run: make test`
+
+		Expect(parser.ExtractUsesReferences(content)).To(Equal([]parser.CIUsesReference{
+			{Uses: "./.github/workflows/build.yml", Job: "build", Kind: parser.CIUsesWorkflow, Local: true, Path: ".github/workflows/build.yml"},
+			{Uses: "our-org/shared-workflows/.github/workflows/deploy.yml@v1", Job: "deploy", Kind: parser.CIUsesWorkflow, Owner: "our-org", Repo: "shared-workflows", Path: ".github/workflows/deploy.yml", Ref: "v1"},
+			{Uses: "actions/checkout@v4", Job: "test", Kind: parser.CIUsesAction, Owner: "actions", Repo: "checkout", Ref: "v4"},
+			{Uses: "./.github/actions/setup", Job: "test", Kind: parser.CIUsesAction, Local: true, Path: ".github/actions/setup"},
+		}))
+	})
+
+	It("finds steps inside a composite action", func() {
+		content := `name: Setup
+runs:
+  using: composite
+  steps:
+    - uses: our-org/actions/cache@main
+    - run: curl https://example.com/install.sh | bash
+      shell: bash`
+
+		Expect(parser.ExtractUsesReferences(content)).To(Equal([]parser.CIUsesReference{
+			{Uses: "our-org/actions/cache@main", Kind: parser.CIUsesAction, Owner: "our-org", Repo: "actions", Path: "cache", Ref: "main"},
+		}))
+	})
+
+	It("returns nothing for invalid YAML", func() {
+		Expect(parser.ExtractUsesReferences("jobs: [unclosed")).To(BeEmpty())
+	})
+})
+
+var _ = Describe("ParseCIConfigWithCallees", func() {
+	caller := models.FileEntry{
+		Path: ".github/workflows/ci.yml",
+		Content: `on: pull_request_target
+jobs:
+  build:
+    uses: ./.github/workflows/build.yml`,
+	}
+	build := models.FileEntry{
+		Path: ".github/workflows/build.yml",
+		Content: `on: workflow_call
+jobs:
+  build:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: ./.github/actions/setup
+      - run: npm ci
+        env:
+          NPM_TOKEN: ${{ secrets.NPM_TOKEN }}`,
+	}
+	setup := models.FileEntry{
+		Path: ".github/actions/setup/action.yml",
+		Content: `runs:
+  using: composite
+  steps:
+    - run: curl https://example.com/install.sh | bash
+      shell: bash`,
+	}
+
+	It("attributes transitive callee antipatterns to the caller", func() {
+		entry := models.RepoEntry{
+			CIConfig:  []models.FileEntry{caller, build},
+			CICallees: []models.FileEntry{setup},
+			CIWorkflowCalls: []models.WorkflowCall{
+				{Caller: caller.Path, Job: "build", Uses: "./.github/workflows/build.yml", Kind: "workflow", Target: build.Path, Local: true, Resolved: true},
+				{Caller: build.Path, Job: "build", Uses: "./.github/actions/setup", Kind: "action", Target: setup.Path, Local: true, Resolved: true},
+			},
+		}
+
+		Expect(parser.ParseCIConfigWithCallees(caller, entry)).To(Equal(parser.CIFeatures{
+			UsesNpmCiWithoutIgnoreScripts: true,
+			UsesCurlBashPipe:              true,
+			UsesPullRequestTarget:         true,
+			SecretNames:                   []string{"NPM_TOKEN"},
+		}))
+	})
+
+	It("ignores unresolved calls", func() {
+		entry := models.RepoEntry{
+			CIConfig: []models.FileEntry{caller},
+			CIWorkflowCalls: []models.WorkflowCall{
+				{Caller: caller.Path, Job: "build", Uses: "./.github/workflows/build.yml", Kind: "workflow", Target: build.Path, Local: true},
+			},
+		}
+
+		Expect(parser.ParseCIConfigWithCallees(caller, entry)).To(Equal(parser.ParseCIConfig(caller.Content)))
+	})
+
+	It("terminates on call cycles", func() {
+		entry := models.RepoEntry{
+			CIConfig: []models.FileEntry{caller, build},
+			CIWorkflowCalls: []models.WorkflowCall{
+				{Caller: caller.Path, Uses: "./.github/workflows/build.yml", Target: build.Path, Resolved: true},
+				{Caller: build.Path, Uses: "./.github/workflows/ci.yml", Target: caller.Path, Resolved: true},
+			},
+		}
+
+		Expect(parser.ParseCIConfigWithCallees(caller, entry).UsesNpmCiWithoutIgnoreScripts).To(BeTrue())
+	})
+})
@@ -0,0 +1,280 @@
+// Package rows turns a fetched repo into the rows the writers store. Both the
+// PostgreSQL and the BigQuery writer map these rows to their own tables, so the
+// backends parse files the same way and drop the same duplicates.
+package rows
+
+import (
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+	"github.com/jonmartinstorm/reposnusern/internal/parser"
+)
+
+// Snapshot is every row stored for one repo in one snapshot. Each list is
+// unique on the key the PostgreSQL table for it is unique on.
+type Snapshot struct {
+	Repo           Repo
+	Languages      []Language
+	Dockerfiles    []Dockerfile
+	DockerStages   []DockerStage
+	CIConfigs      []CIConfig
+	WorkflowCalls  []models.WorkflowCall
+	SecretFindings []parser.SecretFinding
+	SBOMPackages   []SBOMPackage
+	// Blobs is the content Dockerfiles, CIConfigs and the README point to.
+	Blobs []models.FileBlob
+}
+
+// Repo is the repo row. Timestamps are kept as GitHub returned them.
+type Repo struct {
+	ID                   int64
+	Name                 string
+	FullName             string
+	Description          string
+	Stars                int64
+	Forks                int64
+	Archived             bool
+	Private              bool
+	IsFork               bool
+	Language             string
+	SizeMB               float32
+	UpdatedAt            string
+	PushedAt             string
+	CreatedAt            string
+	HtmlUrl              string
+	Topics               string
+	Visibility           string
+	License              string
+	OpenIssues           int64
+	LanguagesURL         string
+	ReadmeSHA256         string // empty when the repo has no README
+	HasSecurityMD        bool
+	HasDependabot        bool
+	HasCodeQL            bool
+	HasCompleteLockfiles bool
+	LockfilePairings     []models.LockfilePairing
+	LockfilePairCount    int
+	DefaultBranch        string
+}
+
+type Language struct {
+	Language string
+	Bytes    int64
+}
+
+type Dockerfile struct {
+	FileType      string
+	Path          string
+	ContentSHA256 string
+	Features      parser.DockerfileFeatures
+}
+
+type DockerStage struct {
+	Path string
+	parser.DockerStageMeta
+}
+
+type CIConfig struct {
+	Path          string
+	ContentSHA256 string
+	Features      parser.CIFeatures
+}
+
+// SBOMPackage is one package from the GitHub SBOM. Missing fields are empty.
+type SBOMPackage struct {
+	Name    string
+	Version string
+	License string
+	PURL    string
+}
+
+// FromEntry builds the rows for entry. Where a key shows up more than once the
+// last row wins, except for secret findings, where they are all the same.
+func FromEntry(entry models.RepoEntry) Snapshot {
+	dockerfiles, stages := dockerfiles(entry.Files)
+	return Snapshot{
+		Repo:           repo(entry.Repo),
+		Languages:      languages(entry.Languages),
+		Dockerfiles:    dockerfiles,
+		DockerStages:   stages,
+		CIConfigs:      ciConfigs(entry),
+		WorkflowCalls:  workflowCalls(entry.CIWorkflowCalls),
+		SecretFindings: secretFindings(entry),
+		SBOMPackages:   sbomPackages(entry.Repo.FullName, entry.SBOM),
+		Blobs:          entry.Blobs(),
+	}
+}
+
+func repo(r models.RepoMeta) Repo {
+	var readmeSHA256 string
+	if r.Readme != "" {
+		readmeSHA256 = models.ContentSHA256(r.Readme)
+	}
+	var license string
+	if r.License != nil {
+		license = r.License.SpdxID
+	}
+	return Repo{
+		ID:                   r.ID,
+		Name:                 r.Name,
+		FullName:             r.FullName,
+		Description:          r.Description,
+		Stars:                r.Stars,
+		Forks:                r.Forks,
+		Archived:             r.Archived,
+		Private:              r.Private,
+		IsFork:               r.IsFork,
+		Language:             r.Language,
+		SizeMB:               float32(r.Size) / 1024.0,
+		UpdatedAt:            r.UpdatedAt,
+		PushedAt:             r.PushedAt,
+		CreatedAt:            r.CreatedAt,
+		HtmlUrl:              r.HtmlUrl,
+		Topics:               strings.Join(r.Topics, ","),
+		Visibility:           r.Visibility,
+		License:              license,
+		OpenIssues:           r.OpenIssues,
+		LanguagesURL:         r.LanguagesURL,
+		ReadmeSHA256:         readmeSHA256,
+		HasSecurityMD:        r.Security["has_security_md"],
+		HasDependabot:        r.Security["has_dependabot"],
+		HasCodeQL:            r.Security["has_codeql"],
+		HasCompleteLockfiles: r.HasCompleteLockfiles,
+		LockfilePairings:     r.LockfilePairings,
+		LockfilePairCount:    r.Lockfile_pair_count,
+		DefaultBranch:        r.DefaultBranch,
+	}
+}
+
+func languages(langs map[string]int) []Language {
+	var out []Language
+	for _, lang := range slices.Sorted(maps.Keys(langs)) {
+		out = append(out, Language{Language: lang, Bytes: int64(langs[lang])})
+	}
+	return out
+}
+
+// dockerfiles parses every file under a "dockerfile" file type. File types are
+// visited in sorted order, so which copy of a path wins does not depend on map
+// order.
+func dockerfiles(files map[string][]models.FileEntry) ([]Dockerfile, []DockerStage) {
+	var entries []Dockerfile
+	stagesByPath := map[string][]parser.DockerStageMeta{}
+	for _, fileType := range slices.Sorted(maps.Keys(files)) {
+		if !strings.HasPrefix(strings.ToLower(fileType), "dockerfile") {
+			continue
+		}
+		for _, f := range files[fileType] {
+			features, stages := parser.ParseDockerfile(f.Content)
+			entries = append(entries, Dockerfile{
+				FileType:      fileType,
+				Path:          f.Path,
+				ContentSHA256: models.ContentSHA256(f.Content),
+				Features:      features,
+			})
+			stagesByPath[f.Path] = stages
+		}
+	}
+	entries = dedupeLast(entries, func(d Dockerfile) string { return d.Path })
+
+	var stages []DockerStage
+	for _, d := range entries {
+		for _, s := range stagesByPath[d.Path] {
+			stages = append(stages, DockerStage{Path: d.Path, DockerStageMeta: s})
+		}
+	}
+	return entries, stages
+}
+
+func ciConfigs(entry models.RepoEntry) []CIConfig {
+	var out []CIConfig
+	for _, f := range entry.CIConfig {
+		out = append(out, CIConfig{
+			Path:          f.Path,
+			ContentSHA256: models.ContentSHA256(f.Content),
+			Features:      parser.ParseCIConfigWithCallees(f, entry),
+		})
+	}
+	return dedupeLast(out, func(c CIConfig) string { return c.Path })
+}
+
+func workflowCalls(calls []models.WorkflowCall) []models.WorkflowCall {
+	return dedupeLast(calls, func(c models.WorkflowCall) [3]string { return [3]string{c.Caller, c.Job, c.Uses} })
+}
+
+func secretFindings(entry models.RepoEntry) []parser.SecretFinding {
+	return dedupeLast(parser.ScanRepoSecrets(entry), func(f parser.SecretFinding) parser.SecretFinding { return f })
+}
+
+func sbomPackages(repo string, sbomRaw map[string]interface{}) []SBOMPackage {
+	if sbomRaw == nil {
+		return nil
+	}
+
+	sbomInner, ok := sbomRaw["sbom"].(map[string]interface{})
+	if !ok {
+		slog.Warn("Ugyldig sbom-format", "repo", repo)
+		return nil
+	}
+
+	packages, ok := sbomInner["packages"].([]interface{})
+	if !ok {
+		slog.Warn("Ingen pakker i sbom", "repo", repo)
+		return nil
+	}
+
+	var out []SBOMPackage
+	for _, p := range packages {
+		pkg, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		out = append(out, SBOMPackage{
+			Name:    safeString(pkg["name"]),
+			Version: safeString(pkg["versionInfo"]),
+			License: safeString(pkg["licenseConcluded"]),
+			PURL:    purl(pkg),
+		})
+	}
+	return dedupeLast(out, func(p SBOMPackage) [2]string { return [2]string{p.Name, p.Version} })
+}
+
+// purl returns the Package URL from the package's externalRefs, if it has one.
+func purl(pkg map[string]interface{}) string {
+	refs, ok := pkg["externalRefs"].([]interface{})
+	if !ok {
+		return ""
+	}
+	for _, ref := range refs {
+		refMap, ok := ref.(map[string]interface{})
+		if ok && refMap["referenceType"] == "purl" {
+			return safeString(refMap["referenceLocator"])
+		}
+	}
+	return ""
+}
+
+func safeString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// dedupeLast drops rows with the same key and keeps the last one, in the order
+// the key was first seen.
+func dedupeLast[T any, K comparable](rows []T, key func(T) K) []T {
+	index := make(map[K]int, len(rows))
+	out := make([]T, 0, len(rows))
+	for _, row := range rows {
+		k := key(row)
+		if i, ok := index[k]; ok {
+			out[i] = row
+			continue
+		}
+		index[k] = len(out)
+		out = append(out, row)
+	}
+	return out
+}
@@ -0,0 +1,149 @@
+package rows_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+	"github.com/jonmartinstorm/reposnusern/internal/rows"
+)
+
+func TestRows(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Rows Suite")
+}
+
+func sbomPackage(name, version, license, purl string) map[string]interface{} {
+	pkg := map[string]interface{}{
+		"name":             name,
+		"versionInfo":      version,
+		"licenseConcluded": license,
+	}
+	if purl != "" {
+		pkg["externalRefs"] = []interface{}{
+			map[string]interface{}{"referenceType": "cpe23Type", "referenceLocator": "cpe:2.3:a:x"},
+			map[string]interface{}{"referenceType": "purl", "referenceLocator": purl},
+		}
+	}
+	return pkg
+}
+
+var _ = Describe("FromEntry", func() {
+	It("keeps one SBOM package per name and version, the last one, in first seen order", func() {
+		entry := models.RepoEntry{SBOM: map[string]interface{}{
+			"sbom": map[string]interface{}{
+				"packages": []interface{}{
+					sbomPackage("a", "1", "MIT", ""),
+					sbomPackage("b", "1", "", ""),
+					"not a package",
+					sbomPackage("a", "1", "Apache-2.0", "pkg:npm/a@1"),
+					sbomPackage("a", "2", "", ""),
+				},
+			},
+		}}
+
+		Expect(rows.FromEntry(entry).SBOMPackages).To(Equal([]rows.SBOMPackage{
+			{Name: "a", Version: "1", License: "Apache-2.0", PURL: "pkg:npm/a@1"},
+			{Name: "b", Version: "1"},
+			{Name: "a", Version: "2"},
+		}))
+	})
+
+	It("has no SBOM packages when the SBOM has an unknown shape", func() {
+		entry := models.RepoEntry{SBOM: map[string]interface{}{"sbom": "nope"}}
+		Expect(rows.FromEntry(entry).SBOMPackages).To(BeEmpty())
+	})
+
+	It("keeps the last workflow call with the same caller, job and uses", func() {
+		entry := models.RepoEntry{CIWorkflowCalls: []models.WorkflowCall{
+			{Caller: "ci.yml", Job: "build", Uses: "org/wf.yml@v1", Target: "old"},
+			{Caller: "ci.yml", Job: "test", Uses: "org/wf.yml@v1"},
+			{Caller: "ci.yml", Job: "build", Uses: "org/wf.yml@v1", Target: "new"},
+		}}
+
+		calls := rows.FromEntry(entry).WorkflowCalls
+		Expect(calls).To(HaveLen(2))
+		Expect(calls[0].Target).To(Equal("new"))
+		Expect(calls[1].Job).To(Equal("test"))
+	})
+
+	It("keeps one Dockerfile per path and only the stages of the one kept", func() {
+		entry := models.RepoEntry{Files: map[string][]models.FileEntry{
+			"dockerfile":     {{Path: "Dockerfile", Content: "FROM alpine:3.19"}},
+			"dockerfile_dev": {{Path: "Dockerfile", Content: "FROM golang:1.22 AS build\nFROM scratch"}},
+			"package.json":   {{Path: "package.json", Content: "{}"}},
+		}}
+
+		snap := rows.FromEntry(entry)
+		Expect(snap.Dockerfiles).To(HaveLen(1))
+		Expect(snap.Dockerfiles[0].FileType).To(Equal("dockerfile_dev"))
+		Expect(snap.Dockerfiles[0].ContentSHA256).To(Equal(models.ContentSHA256("FROM golang:1.22 AS build\nFROM scratch")))
+		Expect(snap.DockerStages).To(HaveLen(2))
+		Expect(snap.DockerStages[0].Path).To(Equal("Dockerfile"))
+		Expect(snap.DockerStages[0].BaseImage).To(Equal("golang"))
+	})
+
+	It("sorts languages by name", func() {
+		entry := models.RepoEntry{Languages: map[string]int{"Shell": 10, "Go": 20}}
+		Expect(rows.FromEntry(entry).Languages).To(Equal([]rows.Language{
+			{Language: "Go", Bytes: 20},
+			{Language: "Shell", Bytes: 10},
+		}))
+	})
+
+	It("leaves license and README hash empty when the repo has neither", func() {
+		repo := rows.FromEntry(models.RepoEntry{Repo: models.RepoMeta{ID: 1}}).Repo
+		Expect(repo.License).To(BeEmpty())
+		Expect(repo.ReadmeSHA256).To(BeEmpty())
+
+		repo = rows.FromEntry(models.RepoEntry{Repo: models.RepoMeta{
+			License: &models.License{SpdxID: "MIT"},
+			Readme:  "# repo",
+			Topics:  []string{"a", "b"},
+			Size:    2048,
+		}}).Repo
+		Expect(repo.License).To(Equal("MIT"))
+		Expect(repo.ReadmeSHA256).To(Equal(models.ContentSHA256("# repo")))
+		Expect(repo.Topics).To(Equal("a,b"))
+		Expect(repo.SizeMB).To(BeNumerically("==", 2))
+	})
+})
+
+var _ = Describe("Safe conversion of optional fields", func() {
+	Describe("license", func() {
+		It("is empty when the repo has no license", func() {
+			Expect(rows.FromEntry(models.RepoEntry{}).Repo.License).To(Equal(""))
+		})
+
+		It("is the SPDX ID when the repo has a license", func() {
+			entry := models.RepoEntry{Repo: models.RepoMeta{License: &models.License{SpdxID: "MIT"}}}
+			Expect(rows.FromEntry(entry).Repo.License).To(Equal("MIT"))
+		})
+	})
+
+	Describe("SBOM package fields", func() {
+		sbom := func(pkg map[string]interface{}) models.RepoEntry {
+			return models.RepoEntry{SBOM: map[string]interface{}{
+				"sbom": map[string]interface{}{"packages": []interface{}{pkg}},
+			}}
+		}
+
+		It("are empty when they are missing or not text", func() {
+			entry := sbom(map[string]interface{}{
+				"name":         nil,
+				"versionInfo":  42,
+				"externalRefs": []interface{}{map[string]interface{}{"referenceType": "purl"}},
+			})
+			Expect(rows.FromEntry(entry).SBOMPackages).To(Equal([]rows.SBOMPackage{{}}))
+		})
+
+		It("keep the text when they are text", func() {
+			entry := sbom(sbomPackage("hello", "1.0", "MIT", "pkg:npm/hello@1.0"))
+			Expect(rows.FromEntry(entry).SBOMPackages).To(Equal([]rows.SBOMPackage{
+				{Name: "hello", Version: "1.0", License: "MIT", PURL: "pkg:npm/hello@1.0"},
+			}))
+		})
+	})
+})
@@ -2,11 +2,15 @@ package runner
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"runtime"
+	"slices"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -17,8 +21,11 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// DBWriter writes one repo and reports how many rows it wrote and failed to
+// write per table. An error wrapping models.ErrRowsFailed means the repo was
+// dropped because of failed rows, and the run goes on with the next repo.
 type DBWriter interface {
-	ImportRepo(ctx context.Context, entry models.RepoEntry, snapshotDate time.Time) error
+	ImportRepo(ctx context.Context, entry models.RepoEntry, snapshotDate time.Time) (models.ImportResult, error)
 }
 
 type Fetcher interface {
@@ -26,10 +33,49 @@ type Fetcher interface {
 	FetchRepoGraphQL(ctx context.Context, baseRepo models.RepoMeta) (*models.RepoEntry, error)
 }
 
+// BatchFetcher is implemented by fetchers that can fetch several repos in one
+// GraphQL query. Results and errors are index-aligned with repos.
+type BatchFetcher interface {
+	FetchReposGraphQL(ctx context.Context, repos []models.RepoMeta) ([]*models.RepoEntry, []error)
+}
+
+// StatsReporter is implemented by fetchers that count their GitHub requests.
+// The counters are logged when the run is finished.
+type StatsReporter interface {
+	Stats() fetcher.ClientStats
+}
+
+// RunRecorder is implemented by writers that keep a record of every run. The
+// run is stored when it starts and updated when it finishes.
+type RunRecorder interface {
+	StartRun(ctx context.Context, run models.SnapshotRun) error
+	FinishRun(ctx context.Context, run models.SnapshotRun) error
+}
+
+// FetchErrorRecorder is implemented by writers that store the repos that
+// failed, or were fetched with less data, during a run. They are stored once
+// the retry pass is done.
+type FetchErrorRecorder interface {
+	RecordFetchErrors(ctx context.Context, runID string, snapshotDate time.Time, fetchErrors []models.RepoFetchError) error
+}
+
+// SnapshotCommitter is implemented by writers that hold back the rows of a
+// run and make them visible all at once. Commit is called after the fetch
+// errors are recorded, and only if the run did not fail.
+type SnapshotCommitter interface {
+	Commit(ctx context.Context) error
+}
+
+// commitTimeout bounds how long the writer may spend making the snapshot
+// visible once the run is done.
+const commitTimeout = 15 * time.Minute
+
 type App struct {
 	Cfg     config.Config
 	Writer  DBWriter
 	Fetcher Fetcher
+	// Version is the version of the binary, stored with each run.
+	Version string
 }
 
 var OpenSQL = sql.Open
@@ -42,32 +88,270 @@ func NewApp(cfg config.Config, writer DBWriter, fetcher Fetcher) *App {
 	}
 }
 
+// runState holds the counters of one run. They are logged and, when the
+// writer is a RunRecorder, stored when the run ends.
+type runState struct {
+	processed        atomic.Int64
+	failedGraphQL    atomic.Int64
+	failedRows       atomic.Int64
+	recovered        atomic.Int64
+	gracefulShutdown atomic.Bool
+
+	mu          sync.Mutex
+	imported    models.ImportResult
+	failedRepos []string
+	fetchErrors []models.RepoFetchError
+}
+
+// discardWritten counts the rows that were written but never made visible as
+// failed, for writers whose snapshot was not committed.
+func (s *runState) discardWritten() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for table, t := range s.imported.Tables {
+		s.imported.Tables[table] = models.TableResult{Failed: t.Written + t.Failed}
+	}
+}
+
+func (s *runState) repoFailed(fullName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failedRepos = append(s.failedRepos, fullName)
+}
+
+// fetchFailed records a repo that could not be fetched. It counts as failed
+// unless the retry pass fetches it.
+func (s *runState) fetchFailed(repo models.RepoMeta, err error) {
+	s.failedGraphQL.Add(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failedRepos = append(s.failedRepos, repo.FullName)
+	s.fetchErrors = append(s.fetchErrors, models.RepoFetchError{
+		Repo:     repo,
+		Class:    fetcher.ClassifyFetchError(err),
+		Message:  err.Error(),
+		Fatal:    true,
+		Attempts: 1,
+	})
+}
+
+// fetchIssues records the issues of a fetched repo.
+func (s *runState) fetchIssues(repo models.RepoMeta, issues []models.FetchIssue) {
+	if len(issues) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, issue := range issues {
+		s.fetchErrors = append(s.fetchErrors, models.RepoFetchError{
+			Repo:     repo,
+			Class:    issue.Class,
+			Message:  issue.Message,
+			Attempts: 1,
+		})
+	}
+}
+
+// retryable returns the repos that failed to fetch with a transient error.
+func (s *runState) retryable() []models.RepoMeta {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var repos []models.RepoMeta
+	for _, fe := range s.fetchErrors {
+		if fe.Fatal && fe.Class.Transient() {
+			repos = append(repos, fe.Repo)
+		}
+	}
+	return repos
+}
+
+// retried updates a failed repo with the outcome of the retry pass. A nil err
+// means the repo was fetched and no longer counts as failed.
+func (s *runState) retried(repo models.RepoMeta, err error) {
+	if err == nil {
+		s.failedGraphQL.Add(-1)
+		s.recovered.Add(1)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.fetchErrors {
+		fe := &s.fetchErrors[i]
+		if !fe.Fatal || fe.Repo.FullName != repo.FullName {
+			continue
+		}
+		fe.Attempts++
+		if err != nil {
+			fe.Class = fetcher.ClassifyFetchError(err)
+			fe.Message = err.Error()
+			return
+		}
+		fe.Fatal = false
+		fe.Recovered = true
+		s.failedRepos = slices.DeleteFunc(s.failedRepos, func(name string) bool { return name == repo.FullName })
+		return
+	}
+}
+
 func (a *App) Run(processingCtx, shutdownCtx context.Context) error {
-	fetcher.ResetRateLimitStats()
 	snapshotTime := time.Now()
 	slog.Info("Starter snapshot", "dato", snapshotTime.Format("2006-01-02"))
 	slog.Debug(a.Cfg.DebugPrint())
 
+	record := models.SnapshotRun{
+		ID:        rand.Text(),
+		Org:       a.Cfg.Org,
+		StartedAt: snapshotTime,
+		Status:    models.RunStatusRunning,
+		Version:   a.Version,
+		Config:    a.Cfg.DebugPrint(),
+	}
+	recorder, _ := a.Writer.(RunRecorder)
+	if recorder != nil {
+		if err := recorder.StartRun(processingCtx, record); err != nil {
+			slog.Warn("Kunne ikke lagre start av kjøringen", "run_id", record.ID, "error", err)
+		}
+	}
+
+	state := &runState{}
+	err := a.run(processingCtx, shutdownCtx, snapshotTime, state)
+
+	// Store the outcome even when the run was cancelled.
+	finishCtx, cancel := context.WithTimeout(context.WithoutCancel(processingCtx), 30*time.Second)
+	defer cancel()
+
+	if fetchRecorder, ok := a.Writer.(FetchErrorRecorder); ok {
+		state.mu.Lock()
+		fetchErrors := slices.Clone(state.fetchErrors)
+		state.mu.Unlock()
+		if len(fetchErrors) > 0 {
+			if err := fetchRecorder.RecordFetchErrors(finishCtx, record.ID, snapshotTime, fetchErrors); err != nil {
+				slog.Warn("Kunne ikke lagre hentefeil", "run_id", record.ID, "antall", len(fetchErrors), "error", err)
+			}
+		}
+	}
+
+	if committer, ok := a.Writer.(SnapshotCommitter); ok {
+		if err != nil {
+			slog.Warn("Snapshotet gjøres ikke synlig fordi kjøringen feilet", "run_id", record.ID)
+		} else {
+			commitCtx, cancelCommit := context.WithTimeout(context.WithoutCancel(processingCtx), commitTimeout)
+			if commitErr := committer.Commit(commitCtx); commitErr != nil {
+				err = fmt.Errorf("kunne ikke gjøre snapshotet synlig: %w", commitErr)
+			}
+			cancelCommit()
+		}
+		if err != nil {
+			state.discardWritten()
+		}
+	}
+
+	if recorder != nil {
+		record = a.finishedRecord(record, state, err)
+		if err := recorder.FinishRun(finishCtx, record); err != nil {
+			slog.Warn("Kunne ikke lagre slutten av kjøringen", "run_id", record.ID, "error", err)
+		}
+	}
+	return err
+}
+
+// finishedRecord fills in the outcome and counters of a finished run.
+func (a *App) finishedRecord(record models.SnapshotRun, state *runState, runErr error) models.SnapshotRun {
+	var stats fetcher.ClientStats
+	if reporter, ok := a.Fetcher.(StatsReporter); ok {
+		stats = reporter.Stats()
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	record.FinishedAt = time.Now()
+	record.ReposProcessed = state.processed.Load()
+	record.ReposFailedGraphQL = state.failedGraphQL.Load()
+	record.ReposFailedRows = state.failedRows.Load()
+	record.RowsWritten = int64(state.imported.Written())
+	record.RowsFailed = int64(state.imported.Failed())
+	record.APICalls = stats.APICalls
+	for _, rl := range stats.RateLimits {
+		record.RateLimitWaits += rl.Waits
+		record.RateLimitWait += rl.TotalWait + rl.TotalSecondaryWait
+	}
+	record.GracefulShutdown = state.gracefulShutdown.Load()
+	record.FailedRepos = slices.Clone(state.failedRepos)
+
+	switch {
+	case runErr != nil:
+		record.Status = models.RunStatusFailed
+		record.Error = runErr.Error()
+	case record.GracefulShutdown || len(record.FailedRepos) > 0 || record.RowsFailed > 0:
+		record.Status = models.RunStatusPartial
+	default:
+		record.Status = models.RunStatusCompleted
+	}
+	return record
+}
+
+func (a *App) run(processingCtx, shutdownCtx context.Context, snapshotTime time.Time, state *runState) error {
 	page := 1
-	var repoIndex int64
 	var debugDispatchCount int64
-	var skippedForGraphqlFailure int64
-	var gracefulShutdown atomic.Bool
+
+	batchSize := a.graphQLBatchSize()
+	var pending []models.RepoMeta
+	var pendingDebugSlots int64
 
 	sem := make(chan struct{}, a.Cfg.Parallelism)
 	g, groupCtx := errgroup.WithContext(processingCtx)
 
+	// dispatch starts a worker for the pending repos, which share one GraphQL batch.
+	dispatch := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		batch, reservedDebugSlots := pending, pendingDebugSlots
+		pending, pendingDebugSlots = nil, 0
+
+		if err := acquireWorkerSlot(groupCtx, shutdownCtx, sem); err != nil {
+			atomic.AddInt64(&debugDispatchCount, -reservedDebugSlots)
+			return err
+		}
+
+		g.Go(func() error {
+			defer func() { <-sem }()
+
+			workerCtx := fetcher.WithWaitInterrupt(groupCtx, shutdownCtx)
+			entries, errs := a.fetchRepos(workerCtx, batch)
+			for i, repo := range batch {
+				if err := errs[i]; err != nil {
+					if errors.Is(err, fetcher.ErrWaitInterrupted) && shutdownRequested(shutdownCtx) {
+						state.gracefulShutdown.Store(true)
+						slog.Info("Avbryter repo etter shutdown under venting", "repo", repo.FullName)
+						continue
+					}
+					slog.Error("Kunne ikke hente repo via GraphQL", "repo", repo.FullName, "error", err)
+					state.fetchFailed(repo, err)
+					continue // ikke fatal
+				}
+
+				if err := a.importRepo(groupCtx, repo, *entries[i], snapshotTime, state); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		return nil
+	}
+
 loop:
 	for {
 		if shutdownRequested(shutdownCtx) {
-			gracefulShutdown.Store(true)
+			state.gracefulShutdown.Store(true)
 			break
 		}
 
 		repos, err := a.Fetcher.GetReposPage(shutdownCtx, a.Cfg, page)
 		if err != nil {
 			if errors.Is(err, context.Canceled) && shutdownRequested(shutdownCtx) {
-				gracefulShutdown.Store(true)
+				state.gracefulShutdown.Store(true)
 				break
 			}
 			return fmt.Errorf("klarte ikke hente repo-side: %w", err)
@@ -76,71 +360,52 @@ loop:
 			break
 		}
 
+		debugLimitReached := false
 		for _, repo := range repos {
 			if shutdownRequested(shutdownCtx) {
-				gracefulShutdown.Store(true)
+				state.gracefulShutdown.Store(true)
 				break loop
 			}
 
-			repo := repo
-
 			if a.Cfg.SkipArchived && repo.Archived {
 				slog.Debug("Skipper arkivert repo", "repo", repo.FullName)
 				continue
 			}
 
-			reservedDebugSlot := false
 			if a.Cfg.Debug {
 				nextDispatchCount := atomic.AddInt64(&debugDispatchCount, 1)
 				if nextDispatchCount > a.Cfg.MaxDebugRepos {
 					atomic.AddInt64(&debugDispatchCount, -1)
 					slog.Info("Debug-modus: nådd maks antall repos", "antall", a.Cfg.MaxDebugRepos)
-					break loop
+					debugLimitReached = true
+					break
 				}
-				reservedDebugSlot = true
+				pendingDebugSlots++
 			}
 
-			if err := acquireWorkerSlot(groupCtx, shutdownCtx, sem); err != nil {
-				if reservedDebugSlot {
-					atomic.AddInt64(&debugDispatchCount, -1)
-				}
+			pending = append(pending, repo)
+			if len(pending) < batchSize {
+				continue
+			}
+			if err := dispatch(); err != nil {
 				if errors.Is(err, context.Canceled) && shutdownRequested(shutdownCtx) {
-					gracefulShutdown.Store(true)
+					state.gracefulShutdown.Store(true)
 					break loop
 				}
 				return err
 			}
+		}
 
-			g.Go(func() error {
-				defer func() { <-sem }()
-
-				workerCtx := fetcher.WithWaitInterrupt(groupCtx, shutdownCtx)
-				entry, err := a.Fetcher.FetchRepoGraphQL(workerCtx, repo)
-				if err != nil {
-					if errors.Is(err, fetcher.ErrWaitInterrupted) && shutdownRequested(shutdownCtx) {
-						gracefulShutdown.Store(true)
-						slog.Info("Avbryter repo etter shutdown under venting", "repo", repo.FullName)
-						return nil
-					}
-					slog.Error("Kunne ikke hente repo via GraphQL", "repo", repo.FullName, "error", err)
-					atomic.AddInt64(&skippedForGraphqlFailure, 1)
-					return nil // ikke fatal
-				}
-
-				idx := atomic.AddInt64(&repoIndex, 1)
-				slog.Info("Behandler repo", "nummer", idx, "navn", repo.FullName)
-
-				if err := a.Writer.ImportRepo(groupCtx, *entry, snapshotTime); err != nil {
-					slog.Error("Import feilet", "repo", repo.FullName, "error", err)
-					return fmt.Errorf("import repo: %w", err)
-				}
-
-				if idx%25 == 0 {
-					runtime.GC()
-				}
-
-				return nil
-			})
+		// Batches do not span pages, so the last repos of a page go out now.
+		if err := dispatch(); err != nil {
+			if errors.Is(err, context.Canceled) && shutdownRequested(shutdownCtx) {
+				state.gracefulShutdown.Store(true)
+				break
+			}
+			return err
+		}
+		if debugLimitReached {
+			break
 		}
 
 		page++
@@ -150,36 +415,170 @@ loop:
 		return err
 	}
 
+	if !shutdownRequested(shutdownCtx) {
+		if err := a.retryFailedRepos(processingCtx, shutdownCtx, snapshotTime, state); err != nil {
+			return err
+		}
+	}
+
 	logMemoryStats()
+	logImportResult(state.imported)
 
 	// Log API call statistics
-	apiCalls := fetcher.GetAPICallCount()
-	rateLimitStats := fetcher.GetRateLimitStats()
-	coreStats := rateLimitStats[fetcher.RateLimitResourceCore]
-	graphQLStats := rateLimitStats[fetcher.RateLimitResourceGraphQL]
+	var stats fetcher.ClientStats
+	if reporter, ok := a.Fetcher.(StatsReporter); ok {
+		stats = reporter.Stats()
+	}
+	apiCalls := stats.APICalls
+	coreStats := stats.RateLimits[fetcher.RateLimitResourceCore]
+	graphQLStats := stats.RateLimits[fetcher.RateLimitResourceGraphQL]
+	concurrencyStats := stats.Concurrency
+	cacheStats := stats.Cache
 	logMessage := "Ferdig med alle repos!"
-	if gracefulShutdown.Load() {
+	if state.gracefulShutdown.Load() {
 		logMessage = "Avslutter kontrollert etter signal"
 	}
 	slog.Info(
 		logMessage,
-		"behandlet", atomic.LoadInt64(&repoIndex),
-		"Feilet gql-import", atomic.LoadInt64(&skippedForGraphqlFailure),
-		"graceful_shutdown", gracefulShutdown.Load(),
+		"behandlet", state.processed.Load(),
+		"Feilet gql-import", state.failedGraphQL.Load(),
+		"Feilet rad-import", state.failedRows.Load(),
+		"Hentet ved nytt forsøk", state.recovered.Load(),
+		"rader_skrevet", state.imported.Written(),
+		"rader_feilet", state.imported.Failed(),
+		"graceful_shutdown", state.gracefulShutdown.Load(),
 		"core_rate_limit_hits", coreStats.Hits,
 		"core_rate_limit_extensions", coreStats.Extensions,
 		"core_rate_limit_waits", coreStats.Waits,
 		"core_rate_limit_wait_time", coreStats.TotalWait.String(),
+		"core_rate_limit_budget_waits", coreStats.BudgetWaits,
+		"core_rate_limit_budget_wait_time", coreStats.TotalBudgetWait.String(),
+		"core_rate_limit_remaining", coreStats.Remaining,
+		"core_rate_limit_limit", coreStats.Limit,
+		"core_secondary_rate_limit_hits", coreStats.SecondaryHits,
+		"core_secondary_rate_limit_wait_time", coreStats.TotalSecondaryWait.String(),
 		"graphql_rate_limit_hits", graphQLStats.Hits,
 		"graphql_rate_limit_extensions", graphQLStats.Extensions,
 		"graphql_rate_limit_waits", graphQLStats.Waits,
 		"graphql_rate_limit_wait_time", graphQLStats.TotalWait.String(),
+		"graphql_rate_limit_budget_waits", graphQLStats.BudgetWaits,
+		"graphql_rate_limit_budget_wait_time", graphQLStats.TotalBudgetWait.String(),
+		"graphql_rate_limit_remaining", graphQLStats.Remaining,
+		"graphql_rate_limit_limit", graphQLStats.Limit,
+		"graphql_secondary_rate_limit_hits", graphQLStats.SecondaryHits,
+		"graphql_secondary_rate_limit_wait_time", graphQLStats.TotalSecondaryWait.String(),
+		"concurrency_limit", concurrencyStats.Limit,
+		"concurrency_limit_lowest", concurrencyStats.Lowest,
+		"http_cache_hits", cacheStats.Hits,
+		"http_cache_misses", cacheStats.Misses,
+		"http_cache_errors", cacheStats.Errors,
 		"varighet", time.Since(snapshotTime).String(),
 		"Totalt antall eksterne API-kall", apiCalls,
 	)
 	return nil
 }
 
+// importRepo writes one fetched repo. Only errors that should stop the run are
+// returned; a repo dropped because of failed rows is counted and skipped.
+func (a *App) importRepo(ctx context.Context, repo models.RepoMeta, entry models.RepoEntry, snapshotTime time.Time, state *runState) error {
+	idx := state.processed.Add(1)
+	slog.Info("Behandler repo", "nummer", idx, "navn", repo.FullName)
+	state.fetchIssues(repo, entry.FetchIssues)
+
+	result, err := a.Writer.ImportRepo(ctx, entry, snapshotTime)
+	state.mu.Lock()
+	state.imported.Merge(result)
+	state.mu.Unlock()
+	if errors.Is(err, models.ErrRowsFailed) {
+		slog.Error("Hopper over repo med rader som ikke kunne skrives", "repo", repo.FullName, "error", err)
+		state.failedRows.Add(1)
+		state.repoFailed(repo.FullName)
+		return nil
+	}
+	if err != nil {
+		slog.Error("Import feilet", "repo", repo.FullName, "error", err)
+		return fmt.Errorf("import repo: %w", err)
+	}
+	if failed := result.Failed(); failed > 0 {
+		slog.Warn("Repo importert med rader som feilet", "repo", repo.FullName, "feilet", failed)
+	}
+
+	if idx%25 == 0 {
+		runtime.GC()
+	}
+	return nil
+}
+
+// retryFailedRepos fetches the repos that failed with a transient error once
+// more. It runs when every other repo is done, so rate limits and outages have
+// had the whole run to pass.
+func (a *App) retryFailedRepos(processingCtx, shutdownCtx context.Context, snapshotTime time.Time, state *runState) error {
+	repos := state.retryable()
+	if len(repos) == 0 {
+		return nil
+	}
+	slog.Info("Prøver repos som feilet midlertidig på nytt", "antall", len(repos))
+
+	g, groupCtx := errgroup.WithContext(processingCtx)
+	g.SetLimit(max(a.Cfg.Parallelism, 1))
+	for _, repo := range repos {
+		if shutdownRequested(shutdownCtx) {
+			state.gracefulShutdown.Store(true)
+			break
+		}
+		g.Go(func() error {
+			workerCtx := fetcher.WithWaitInterrupt(groupCtx, shutdownCtx)
+			entry, err := a.Fetcher.FetchRepoGraphQL(workerCtx, repo)
+			if err != nil {
+				if errors.Is(err, fetcher.ErrWaitInterrupted) && shutdownRequested(shutdownCtx) {
+					state.gracefulShutdown.Store(true)
+					return nil
+				}
+				slog.Error("Repo feilet også ved nytt forsøk", "repo", repo.FullName, "error", err)
+				state.retried(repo, err)
+				return nil
+			}
+			slog.Info("Hentet repo ved nytt forsøk", "repo", repo.FullName)
+			state.retried(repo, nil)
+			return a.importRepo(groupCtx, repo, *entry, snapshotTime, state)
+		})
+	}
+	return g.Wait()
+}
+
+// logImportResult logs the rows written and failed per table.
+func logImportResult(result models.ImportResult) {
+	for _, table := range slices.Sorted(maps.Keys(result.Tables)) {
+		t := result.Tables[table]
+		level := slog.LevelInfo
+		if t.Failed > 0 {
+			level = slog.LevelWarn
+		}
+		slog.Log(context.Background(), level, "Rader skrevet", "tabell", table, "skrevet", t.Written, "feilet", t.Failed)
+	}
+}
+
+// graphQLBatchSize returns how many repos each worker fetches at once. Batching
+// is only used when the fetcher supports it.
+func (a *App) graphQLBatchSize() int {
+	if _, ok := a.Fetcher.(BatchFetcher); ok && a.Cfg.GraphQLBatchSize > 1 {
+		return a.Cfg.GraphQLBatchSize
+	}
+	return 1
+}
+
+func (a *App) fetchRepos(ctx context.Context, repos []models.RepoMeta) ([]*models.RepoEntry, []error) {
+	if batchFetcher, ok := a.Fetcher.(BatchFetcher); ok && len(repos) > 1 {
+		return batchFetcher.FetchReposGraphQL(ctx, repos)
+	}
+	entries := make([]*models.RepoEntry, len(repos))
+	errs := make([]error, len(repos))
+	for i, repo := range repos {
+		entries[i], errs[i] = a.Fetcher.FetchRepoGraphQL(ctx, repo)
+	}
+	return entries, errs
+}
+
 func shutdownRequested(ctx context.Context) bool {
 	select {
 	case <-ctx.Done():
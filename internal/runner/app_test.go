@@ -3,6 +3,8 @@ package runner_test
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -35,8 +37,76 @@ func (f *rateLimitWaitingFetcher) GetReposPage(ctx context.Context, cfg config.C
 
 func (f *rateLimitWaitingFetcher) FetchRepoGraphQL(ctx context.Context, baseRepo models.RepoMeta) (*models.RepoEntry, error) {
 	close(f.started)
-	fetcherpkg.SharedRateLimiter.BlockFor(fetcherpkg.RateLimitResourceGraphQL, time.Minute)
-	return nil, fetcherpkg.SharedRateLimiter.Wait(ctx, fetcherpkg.RateLimitResourceGraphQL)
+	limiter := fetcherpkg.NewResourceRateLimiter()
+	limiter.BlockFor(fetcherpkg.RateLimitResourceGraphQL, time.Minute)
+	return nil, limiter.Wait(ctx, fetcherpkg.RateLimitResourceGraphQL)
+}
+
+type batchingFetcher struct {
+	mu      sync.Mutex
+	pages   [][]models.RepoMeta
+	batches [][]string
+}
+
+func (f *batchingFetcher) GetReposPage(ctx context.Context, cfg config.Config, page int) ([]models.RepoMeta, error) {
+	if page > len(f.pages) {
+		return []models.RepoMeta{}, nil
+	}
+	return f.pages[page-1], nil
+}
+
+func (f *batchingFetcher) FetchRepoGraphQL(ctx context.Context, baseRepo models.RepoMeta) (*models.RepoEntry, error) {
+	entries, errs := f.FetchReposGraphQL(ctx, []models.RepoMeta{baseRepo})
+	return entries[0], errs[0]
+}
+
+func (f *batchingFetcher) FetchReposGraphQL(ctx context.Context, repos []models.RepoMeta) ([]*models.RepoEntry, []error) {
+	var names []string
+	entries := make([]*models.RepoEntry, len(repos))
+	errs := make([]error, len(repos))
+	for i, repo := range repos {
+		names = append(names, repo.Name)
+		if repo.Name == "fails" {
+			errs[i] = errors.New("graphql error")
+			continue
+		}
+		entries[i] = &models.RepoEntry{Repo: repo}
+	}
+	f.mu.Lock()
+	f.batches = append(f.batches, names)
+	f.mu.Unlock()
+	return entries, errs
+}
+
+// recordingWriter is a MockDBWriter that also keeps the runs and fetch errors
+// it is asked to record, and counts commits.
+type recordingWriter struct {
+	*mocks.MockDBWriter
+	started     []models.SnapshotRun
+	finished    []models.SnapshotRun
+	fetchErrors []models.RepoFetchError
+	commits     int
+	commitErr   error
+}
+
+func (w *recordingWriter) Commit(ctx context.Context) error {
+	w.commits++
+	return w.commitErr
+}
+
+func (w *recordingWriter) RecordFetchErrors(ctx context.Context, runID string, snapshotDate time.Time, fetchErrors []models.RepoFetchError) error {
+	w.fetchErrors = append(w.fetchErrors, fetchErrors...)
+	return nil
+}
+
+func (w *recordingWriter) StartRun(ctx context.Context, run models.SnapshotRun) error {
+	w.started = append(w.started, run)
+	return nil
+}
+
+func (w *recordingWriter) FinishRun(ctx context.Context, run models.SnapshotRun) error {
+	w.finished = append(w.finished, run)
+	return nil
 }
 
 var _ = Describe("App.Run", func() {
@@ -101,7 +171,7 @@ var _ = Describe("App.Run", func() {
 		for i := 0; i < 10; i++ {
 			entry := &models.RepoEntry{}
 			fetcher.On("FetchRepoGraphQL", mock.Anything, repos[i]).Return(entry, nil)
-			writer.On("ImportRepo", mock.Anything, *entry, mock.AnythingOfType("time.Time")).Return(nil)
+			writer.On("ImportRepo", mock.Anything, *entry, mock.AnythingOfType("time.Time")).Return(models.ImportResult{}, nil)
 
 		}
 
@@ -127,7 +197,7 @@ var _ = Describe("App.Run", func() {
 			close(repoStarted)
 			<-releaseRepo
 		}).Return(entry, nil).Once()
-		writer.On("ImportRepo", mock.Anything, *entry, mock.AnythingOfType("time.Time")).Return(nil).Once()
+		writer.On("ImportRepo", mock.Anything, *entry, mock.AnythingOfType("time.Time")).Return(models.ImportResult{}, nil).Once()
 
 		done := make(chan error, 1)
 		go func() {
@@ -142,6 +212,23 @@ var _ = Describe("App.Run", func() {
 		writer.AssertNumberOfCalls(GinkgoT(), "ImportRepo", 1)
 	})
 
+	It("sender repos i GraphQL-batcher som ikke krysser sider", func() {
+		cfg.Debug = false
+		cfg.SkipArchived = true
+		cfg.Parallelism = 1
+		cfg.GraphQLBatchSize = 2
+		batcher := &batchingFetcher{pages: [][]models.RepoMeta{
+			{{Name: "a"}, {Name: "arkivert", Archived: true}, {Name: "fails"}, {Name: "b"}},
+			{{Name: "c"}},
+		}}
+		app = runner.NewApp(cfg, writer, batcher)
+		writer.On("ImportRepo", mock.Anything, mock.Anything, mock.AnythingOfType("time.Time")).Return(models.ImportResult{}, nil)
+
+		Expect(app.Run(processingCtx, shutdownCtx)).To(Succeed())
+		Expect(batcher.batches).To(Equal([][]string{{"a", "fails"}, {"b"}, {"c"}}))
+		writer.AssertNumberOfCalls(GinkgoT(), "ImportRepo", 3)
+	})
+
 	It("hopper over repo der GraphQL feiler og fortsetter", func() {
 		repo := models.RepoMeta{FullName: "testorg/fails", Name: "fails"}
 		fetcher.On("GetReposPage", mock.Anything, cfg, 1).Return([]models.RepoMeta{repo}, nil)
@@ -153,6 +240,145 @@ var _ = Describe("App.Run", func() {
 		writer.AssertNotCalled(GinkgoT(), "ImportRepo")
 	})
 
+	It("hopper over repo der rader ikke kan skrives og fortsetter med neste", func() {
+		cfg.Parallelism = 1
+		app = runner.NewApp(cfg, writer, fetcher)
+
+		failing := models.RepoMeta{FullName: "testorg/failing", Name: "failing"}
+		ok := models.RepoMeta{FullName: "testorg/ok", Name: "ok"}
+		failingEntry := &models.RepoEntry{Repo: failing}
+		okEntry := &models.RepoEntry{Repo: ok}
+		fetcher.On("GetReposPage", mock.Anything, cfg, 1).Return([]models.RepoMeta{failing, ok}, nil)
+		fetcher.On("GetReposPage", mock.Anything, cfg, 2).Return([]models.RepoMeta{}, nil)
+		fetcher.On("FetchRepoGraphQL", mock.Anything, failing).Return(failingEntry, nil)
+		fetcher.On("FetchRepoGraphQL", mock.Anything, ok).Return(okEntry, nil)
+
+		var failedResult models.ImportResult
+		failedResult.Add("repos", 0, 1)
+		failedResult.Add("dockerfiles", 0, 1)
+		writer.On("ImportRepo", mock.Anything, *failingEntry, mock.AnythingOfType("time.Time")).
+			Return(failedResult, fmt.Errorf("%w: dockerfiles: ugyldig innhold", models.ErrRowsFailed))
+		writer.On("ImportRepo", mock.Anything, *okEntry, mock.AnythingOfType("time.Time")).
+			Return(models.ImportResult{}, nil)
+
+		Expect(app.Run(processingCtx, shutdownCtx)).To(Succeed())
+		writer.AssertNumberOfCalls(GinkgoT(), "ImportRepo", 2)
+	})
+
+	It("avbryter kjøringen når importen feiler av andre grunner", func() {
+		repo := models.RepoMeta{FullName: "testorg/repo", Name: "repo"}
+		entry := &models.RepoEntry{Repo: repo}
+		fetcher.On("GetReposPage", mock.Anything, cfg, 1).Return([]models.RepoMeta{repo}, nil)
+		fetcher.On("GetReposPage", mock.Anything, cfg, 2).Return([]models.RepoMeta{}, nil)
+		fetcher.On("FetchRepoGraphQL", mock.Anything, repo).Return(entry, nil)
+		writer.On("ImportRepo", mock.Anything, *entry, mock.AnythingOfType("time.Time")).
+			Return(models.ImportResult{}, errors.New("databasen er borte"))
+
+		Expect(app.Run(processingCtx, shutdownCtx)).To(MatchError(ContainSubstring("databasen er borte")))
+	})
+
+	It("lagrer kjøringen ved start og slutt med status og feilede repos", func() {
+		cfg.Debug = false
+		cfg.Parallelism = 1
+		cfg.GraphQLBatchSize = 2
+		batcher := &batchingFetcher{pages: [][]models.RepoMeta{
+			{{Name: "a", FullName: "testorg/a"}, {Name: "fails", FullName: "testorg/fails"}},
+		}}
+		recorder := &recordingWriter{MockDBWriter: writer}
+		app = runner.NewApp(cfg, recorder, batcher)
+		app.Version = "v1.2.3"
+		writer.On("ImportRepo", mock.Anything, mock.Anything, mock.AnythingOfType("time.Time")).Return(models.ImportResult{}, nil)
+
+		Expect(app.Run(processingCtx, shutdownCtx)).To(Succeed())
+
+		Expect(recorder.started).To(HaveLen(1))
+		Expect(recorder.started[0].Status).To(Equal(models.RunStatusRunning))
+		Expect(recorder.started[0].Version).To(Equal("v1.2.3"))
+		Expect(recorder.started[0].Config).NotTo(ContainSubstring("fake-token"))
+
+		Expect(recorder.finished).To(HaveLen(1))
+		run := recorder.finished[0]
+		Expect(run.ID).To(Equal(recorder.started[0].ID))
+		Expect(run.Status).To(Equal(models.RunStatusPartial))
+		Expect(run.ReposProcessed).To(Equal(int64(1)))
+		Expect(run.ReposFailedGraphQL).To(Equal(int64(1)))
+		Expect(run.FailedRepos).To(Equal([]string{"testorg/fails"}))
+		Expect(run.FinishedAt).NotTo(BeZero())
+		Expect(recorder.commits).To(Equal(1))
+	})
+
+	It("prøver repos med midlertidige feil på nytt og lagrer hentefeilene", func() {
+		cfg.Debug = false
+		cfg.Parallelism = 1
+		recorder := &recordingWriter{MockDBWriter: writer}
+		app = runner.NewApp(cfg, recorder, fetcher)
+
+		flaky := models.RepoMeta{ID: 1, FullName: "testorg/flaky", Name: "flaky"}
+		gone := models.RepoMeta{ID: 2, FullName: "testorg/gone", Name: "gone"}
+		big := models.RepoMeta{ID: 3, FullName: "testorg/big", Name: "big"}
+		truncated := models.FetchIssue{Class: models.FetchErrorTreeTruncated, Message: "git tree was truncated"}
+		fetcher.On("GetReposPage", mock.Anything, cfg, 1).Return([]models.RepoMeta{flaky, gone, big}, nil)
+		fetcher.On("GetReposPage", mock.Anything, cfg, 2).Return([]models.RepoMeta{}, nil)
+		fetcher.On("FetchRepoGraphQL", mock.Anything, flaky).
+			Return(nil, &fetcherpkg.APIError{StatusCode: 502, Attempts: 3}).Once()
+		fetcher.On("FetchRepoGraphQL", mock.Anything, flaky).Return(&models.RepoEntry{Repo: flaky}, nil)
+		fetcher.On("FetchRepoGraphQL", mock.Anything, gone).
+			Return(nil, &fetcherpkg.GraphQLError{Repo: gone.FullName, Errors: "NOT_FOUND"})
+		fetcher.On("FetchRepoGraphQL", mock.Anything, big).
+			Return(&models.RepoEntry{Repo: big, FetchIssues: []models.FetchIssue{truncated}}, nil)
+		writer.On("ImportRepo", mock.Anything, mock.Anything, mock.AnythingOfType("time.Time")).Return(models.ImportResult{}, nil)
+
+		Expect(app.Run(processingCtx, shutdownCtx)).To(Succeed())
+
+		fetcher.AssertNumberOfCalls(GinkgoT(), "FetchRepoGraphQL", 4)
+		writer.AssertNumberOfCalls(GinkgoT(), "ImportRepo", 2)
+		Expect(recorder.fetchErrors).To(ConsistOf(
+			models.RepoFetchError{Repo: flaky, Class: models.FetchErrorServer, Message: "GitHub API-feil etter 3 forsøk: status 502", Attempts: 2, Recovered: true},
+			models.RepoFetchError{Repo: gone, Class: models.FetchErrorGraphQL, Message: "GraphQL returnerte feil for testorg/gone: NOT_FOUND", Fatal: true, Attempts: 1},
+			models.RepoFetchError{Repo: big, Class: models.FetchErrorTreeTruncated, Message: "git tree was truncated", Attempts: 1},
+		))
+
+		run := recorder.finished[0]
+		Expect(run.ReposProcessed).To(Equal(int64(2)))
+		Expect(run.ReposFailedGraphQL).To(Equal(int64(1)))
+		Expect(run.FailedRepos).To(Equal([]string{"testorg/gone"}))
+	})
+
+	It("lagrer kjøringen som feilet når den avbrytes av en feil", func() {
+		recorder := &recordingWriter{MockDBWriter: writer}
+		app = runner.NewApp(cfg, recorder, fetcher)
+		fetcher.On("GetReposPage", mock.Anything, cfg, 1).Return(nil, errors.New("API-feil"))
+
+		Expect(app.Run(processingCtx, shutdownCtx)).NotTo(Succeed())
+		Expect(recorder.finished).To(HaveLen(1))
+		Expect(recorder.finished[0].Status).To(Equal(models.RunStatusFailed))
+		Expect(recorder.finished[0].Error).To(ContainSubstring("API-feil"))
+		Expect(recorder.commits).To(BeZero())
+	})
+
+	It("lagrer kjøringen som feilet når snapshotet ikke kan gjøres synlig", func() {
+		cfg.Debug = false
+		recorder := &recordingWriter{MockDBWriter: writer, commitErr: errors.New("load-jobb feilet")}
+		app = runner.NewApp(cfg, recorder, fetcher)
+		repo := models.RepoMeta{ID: 1, FullName: "testorg/repo", Name: "repo"}
+		fetcher.On("GetReposPage", mock.Anything, cfg, 1).Return([]models.RepoMeta{repo}, nil)
+		fetcher.On("GetReposPage", mock.Anything, cfg, 2).Return([]models.RepoMeta{}, nil)
+		fetcher.On("FetchRepoGraphQL", mock.Anything, repo).Return(&models.RepoEntry{Repo: repo}, nil)
+		var result models.ImportResult
+		result.Add("repos", 1, 0)
+		result.Add("repo_languages", 2, 1)
+		writer.On("ImportRepo", mock.Anything, mock.Anything, mock.AnythingOfType("time.Time")).Return(result, nil)
+
+		Expect(app.Run(processingCtx, shutdownCtx)).To(MatchError(ContainSubstring("load-jobb feilet")))
+		Expect(recorder.commits).To(Equal(1))
+		Expect(recorder.finished).To(HaveLen(1))
+		run := recorder.finished[0]
+		Expect(run.Status).To(Equal(models.RunStatusFailed))
+		// Ingen av radene ble synlige, så de telles som feilet.
+		Expect(run.RowsWritten).To(BeZero())
+		Expect(run.RowsFailed).To(Equal(int64(4)))
+	})
+
 	It("stopper nye repos ved shutdown men fullfører pågående arbeid", func() {
 		cfg.Debug = false
 		cfg.Parallelism = 1
@@ -174,7 +400,7 @@ var _ = Describe("App.Run", func() {
 			close(repoStarted)
 			<-releaseRepo
 		}).Return(entry, nil)
-		writer.On("ImportRepo", mock.Anything, *entry, mock.AnythingOfType("time.Time")).Return(nil)
+		writer.On("ImportRepo", mock.Anything, *entry, mock.AnythingOfType("time.Time")).Return(models.ImportResult{}, nil)
 
 		done := make(chan error, 1)
 		go func() {
@@ -196,9 +422,6 @@ var _ = Describe("App.Run", func() {
 		shutdownCtx, stopShutdown := context.WithCancel(context.Background())
 		defer stopShutdown()
 
-		fetcherpkg.ResetRateLimitStats()
-		defer fetcherpkg.ResetRateLimitStats()
-
 		waitingFetcher := &rateLimitWaitingFetcher{started: make(chan struct{})}
 		app = runner.NewApp(cfg, writer, waitingFetcher)
 
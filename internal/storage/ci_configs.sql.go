@@ -14,8 +14,8 @@ import (
 )
 
 const insertOrUpdateCIConfig = `-- name: InsertOrUpdateCIConfig :exec
-INSERT INTO ci_configs (
-  repo_id, hentet_dato, path, content,
+INSERT INTO ci_config_snapshots (
+  repo_id, hentet_dato, path, content_sha256,
   uses_npm_install,
   uses_npm_ci_without_ignore_scripts,
   uses_yarn_install_without_frozen,
@@ -26,13 +26,23 @@ INSERT INTO ci_configs (
   uses_sudo,
   uses_package_publish,
   uses_pull_request_target,
-  secret_names
+  secret_names,
+  uses_pnpm_install_without_frozen,
+  uses_bun_install_without_frozen,
+  uses_go_install_latest,
+  uses_gem_install_without_version,
+  uses_apk_add_without_no_cache,
+  uses_apt_get_install_without_no_recommends,
+  uses_poetry_install_without_lock_check,
+  uses_uv_pip_install_without_hashes,
+  uses_git_clone_unpinned
 ) VALUES (
   $1, $2, $3, $4,
-  $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15
+  $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15,
+  $16, $17, $18, $19, $20, $21, $22, $23, $24
 )
 ON CONFLICT (repo_id, hentet_dato, path) DO UPDATE SET
-  content = EXCLUDED.content,
+  content_sha256 = EXCLUDED.content_sha256,
   uses_npm_install = EXCLUDED.uses_npm_install,
   uses_npm_ci_without_ignore_scripts = EXCLUDED.uses_npm_ci_without_ignore_scripts,
   uses_yarn_install_without_frozen = EXCLUDED.uses_yarn_install_without_frozen,
@@ -43,25 +53,43 @@ ON CONFLICT (repo_id, hentet_dato, path) DO UPDATE SET
   uses_sudo = EXCLUDED.uses_sudo,
   uses_package_publish = EXCLUDED.uses_package_publish,
   uses_pull_request_target = EXCLUDED.uses_pull_request_target,
-  secret_names = EXCLUDED.secret_names
+  secret_names = EXCLUDED.secret_names,
+  uses_pnpm_install_without_frozen = EXCLUDED.uses_pnpm_install_without_frozen,
+  uses_bun_install_without_frozen = EXCLUDED.uses_bun_install_without_frozen,
+  uses_go_install_latest = EXCLUDED.uses_go_install_latest,
+  uses_gem_install_without_version = EXCLUDED.uses_gem_install_without_version,
+  uses_apk_add_without_no_cache = EXCLUDED.uses_apk_add_without_no_cache,
+  uses_apt_get_install_without_no_recommends = EXCLUDED.uses_apt_get_install_without_no_recommends,
+  uses_poetry_install_without_lock_check = EXCLUDED.uses_poetry_install_without_lock_check,
+  uses_uv_pip_install_without_hashes = EXCLUDED.uses_uv_pip_install_without_hashes,
+  uses_git_clone_unpinned = EXCLUDED.uses_git_clone_unpinned
 `
 
 type InsertOrUpdateCIConfigParams struct {
-	RepoID                        int64
-	HentetDato                    time.Time
-	Path                          string
-	Content                       string
-	UsesNpmInstall                sql.NullBool
-	UsesNpmCiWithoutIgnoreScripts sql.NullBool
-	UsesYarnInstallWithoutFrozen  sql.NullBool
-	UsesNpx                       sql.NullBool
-	UsesPipInstallWithoutNoCache  sql.NullBool
-	UsesPipInstallWithoutHashes   sql.NullBool
-	UsesCurlBashPipe              sql.NullBool
-	UsesSudo                      sql.NullBool
-	UsesPackagePublish            bool
-	UsesPullRequestTarget         bool
-	SecretNames                   []string
+	RepoID                               int64
+	HentetDato                           time.Time
+	Path                                 string
+	ContentSha256                        string
+	UsesNpmInstall                       sql.NullBool
+	UsesNpmCiWithoutIgnoreScripts        sql.NullBool
+	UsesYarnInstallWithoutFrozen         sql.NullBool
+	UsesNpx                              sql.NullBool
+	UsesPipInstallWithoutNoCache         sql.NullBool
+	UsesPipInstallWithoutHashes          sql.NullBool
+	UsesCurlBashPipe                     sql.NullBool
+	UsesSudo                             sql.NullBool
+	UsesPackagePublish                   bool
+	UsesPullRequestTarget                bool
+	SecretNames                          []string
+	UsesPnpmInstallWithoutFrozen         bool
+	UsesBunInstallWithoutFrozen          bool
+	UsesGoInstallLatest                  bool
+	UsesGemInstallWithoutVersion         bool
+	UsesApkAddWithoutNoCache             bool
+	UsesAptGetInstallWithoutNoRecommends bool
+	UsesPoetryInstallWithoutLockCheck    bool
+	UsesUvPipInstallWithoutHashes        bool
+	UsesGitCloneUnpinned                 bool
 }
 
 func (q *Queries) InsertOrUpdateCIConfig(ctx context.Context, arg InsertOrUpdateCIConfigParams) error {
@@ -69,7 +97,7 @@ func (q *Queries) InsertOrUpdateCIConfig(ctx context.Context, arg InsertOrUpdate
 		arg.RepoID,
 		arg.HentetDato,
 		arg.Path,
-		arg.Content,
+		arg.ContentSha256,
 		arg.UsesNpmInstall,
 		arg.UsesNpmCiWithoutIgnoreScripts,
 		arg.UsesYarnInstallWithoutFrozen,
@@ -81,6 +109,15 @@ func (q *Queries) InsertOrUpdateCIConfig(ctx context.Context, arg InsertOrUpdate
 		arg.UsesPackagePublish,
 		arg.UsesPullRequestTarget,
 		pq.Array(arg.SecretNames),
+		arg.UsesPnpmInstallWithoutFrozen,
+		arg.UsesBunInstallWithoutFrozen,
+		arg.UsesGoInstallLatest,
+		arg.UsesGemInstallWithoutVersion,
+		arg.UsesApkAddWithoutNoCache,
+		arg.UsesAptGetInstallWithoutNoRecommends,
+		arg.UsesPoetryInstallWithoutLockCheck,
+		arg.UsesUvPipInstallWithoutHashes,
+		arg.UsesGitCloneUnpinned,
 	)
 	return err
 }
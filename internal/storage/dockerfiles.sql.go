@@ -12,8 +12,8 @@ import (
 )
 
 const insertOrUpdateDockerfile = `-- name: InsertOrUpdateDockerfile :one
-INSERT INTO dockerfiles (
-  repo_id, hentet_dato, full_name, path, content,
+INSERT INTO dockerfile_snapshots (
+  repo_id, hentet_dato, full_name, path, content_sha256,
   base_image, base_tag, uses_latest_tag,
   has_user_instruction, has_copy_sensitive, has_package_installs,
   uses_multistage, has_healthcheck, uses_add_instruction,
@@ -23,7 +23,12 @@ INSERT INTO dockerfiles (
   uses_npm_install, uses_npm_ci_without_ignore_scripts,
   uses_yarn_install_without_frozen, uses_npx,
   uses_pip_install_without_no_cache, uses_pip_install_without_hashes,
-  uses_curl_bash_pipe
+  uses_curl_bash_pipe,
+  uses_pnpm_install_without_frozen, uses_bun_install_without_frozen,
+  uses_go_install_latest, uses_gem_install_without_version,
+  uses_apk_add_without_no_cache, uses_apt_get_install_without_no_recommends,
+  uses_poetry_install_without_lock_check, uses_uv_pip_install_without_hashes,
+  uses_git_clone_unpinned
 )
 VALUES (
   $1, $2, $3, $4, $5,
@@ -35,11 +40,16 @@ VALUES (
   $21, $22,
   $23, $24,
   $25, $26, $27,
-  $28, $29
+  $28, $29,
+  $30, $31,
+  $32, $33,
+  $34, $35,
+  $36, $37,
+  $38
 )
 ON CONFLICT (repo_id, hentet_dato, path) DO UPDATE SET
   full_name = EXCLUDED.full_name,
-  content = EXCLUDED.content,
+  content_sha256 = EXCLUDED.content_sha256,
   base_image = EXCLUDED.base_image,
   base_tag = EXCLUDED.base_tag,
   uses_latest_tag = EXCLUDED.uses_latest_tag,
@@ -63,40 +73,58 @@ ON CONFLICT (repo_id, hentet_dato, path) DO UPDATE SET
   uses_npx = EXCLUDED.uses_npx,
   uses_pip_install_without_no_cache = EXCLUDED.uses_pip_install_without_no_cache,
   uses_pip_install_without_hashes = EXCLUDED.uses_pip_install_without_hashes,
-  uses_curl_bash_pipe = EXCLUDED.uses_curl_bash_pipe
+  uses_curl_bash_pipe = EXCLUDED.uses_curl_bash_pipe,
+  uses_pnpm_install_without_frozen = EXCLUDED.uses_pnpm_install_without_frozen,
+  uses_bun_install_without_frozen = EXCLUDED.uses_bun_install_without_frozen,
+  uses_go_install_latest = EXCLUDED.uses_go_install_latest,
+  uses_gem_install_without_version = EXCLUDED.uses_gem_install_without_version,
+  uses_apk_add_without_no_cache = EXCLUDED.uses_apk_add_without_no_cache,
+  uses_apt_get_install_without_no_recommends = EXCLUDED.uses_apt_get_install_without_no_recommends,
+  uses_poetry_install_without_lock_check = EXCLUDED.uses_poetry_install_without_lock_check,
+  uses_uv_pip_install_without_hashes = EXCLUDED.uses_uv_pip_install_without_hashes,
+  uses_git_clone_unpinned = EXCLUDED.uses_git_clone_unpinned
 RETURNING id
 `
 
 type InsertOrUpdateDockerfileParams struct {
-	RepoID                        int64
-	HentetDato                    time.Time
-	FullName                      string
-	Path                          string
-	Content                       string
-	BaseImage                     sql.NullString
-	BaseTag                       sql.NullString
-	UsesLatestTag                 sql.NullBool
-	HasUserInstruction            sql.NullBool
-	HasCopySensitive              sql.NullBool
-	HasPackageInstalls            sql.NullBool
-	UsesMultistage                sql.NullBool
-	HasHealthcheck                sql.NullBool
-	UsesAddInstruction            sql.NullBool
-	HasLabelMetadata              sql.NullBool
-	HasExpose                     sql.NullBool
-	HasEntrypointOrCmd            sql.NullBool
-	InstallsCurlOrWget            sql.NullBool
-	InstallsBuildTools            sql.NullBool
-	HasAptGetClean                sql.NullBool
-	WorldWritable                 sql.NullBool
-	HasSecretsInEnvOrArg          sql.NullBool
-	UsesNpmInstall                sql.NullBool
-	UsesNpmCiWithoutIgnoreScripts sql.NullBool
-	UsesYarnInstallWithoutFrozen  sql.NullBool
-	UsesNpx                       sql.NullBool
-	UsesPipInstallWithoutNoCache  sql.NullBool
-	UsesPipInstallWithoutHashes   sql.NullBool
-	UsesCurlBashPipe              sql.NullBool
+	RepoID                               int64
+	HentetDato                           time.Time
+	FullName                             string
+	Path                                 string
+	ContentSha256                        string
+	BaseImage                            sql.NullString
+	BaseTag                              sql.NullString
+	UsesLatestTag                        sql.NullBool
+	HasUserInstruction                   sql.NullBool
+	HasCopySensitive                     sql.NullBool
+	HasPackageInstalls                   sql.NullBool
+	UsesMultistage                       sql.NullBool
+	HasHealthcheck                       sql.NullBool
+	UsesAddInstruction                   sql.NullBool
+	HasLabelMetadata                     sql.NullBool
+	HasExpose                            sql.NullBool
+	HasEntrypointOrCmd                   sql.NullBool
+	InstallsCurlOrWget                   sql.NullBool
+	InstallsBuildTools                   sql.NullBool
+	HasAptGetClean                       sql.NullBool
+	WorldWritable                        sql.NullBool
+	HasSecretsInEnvOrArg                 sql.NullBool
+	UsesNpmInstall                       sql.NullBool
+	UsesNpmCiWithoutIgnoreScripts        sql.NullBool
+	UsesYarnInstallWithoutFrozen         sql.NullBool
+	UsesNpx                              sql.NullBool
+	UsesPipInstallWithoutNoCache         sql.NullBool
+	UsesPipInstallWithoutHashes          sql.NullBool
+	UsesCurlBashPipe                     sql.NullBool
+	UsesPnpmInstallWithoutFrozen         bool
+	UsesBunInstallWithoutFrozen          bool
+	UsesGoInstallLatest                  bool
+	UsesGemInstallWithoutVersion         bool
+	UsesApkAddWithoutNoCache             bool
+	UsesAptGetInstallWithoutNoRecommends bool
+	UsesPoetryInstallWithoutLockCheck    bool
+	UsesUvPipInstallWithoutHashes        bool
+	UsesGitCloneUnpinned                 bool
 }
 
 func (q *Queries) InsertOrUpdateDockerfile(ctx context.Context, arg InsertOrUpdateDockerfileParams) (int32, error) {
@@ -105,7 +133,7 @@ func (q *Queries) InsertOrUpdateDockerfile(ctx context.Context, arg InsertOrUpda
 		arg.HentetDato,
 		arg.FullName,
 		arg.Path,
-		arg.Content,
+		arg.ContentSha256,
 		arg.BaseImage,
 		arg.BaseTag,
 		arg.UsesLatestTag,
@@ -130,6 +158,15 @@ func (q *Queries) InsertOrUpdateDockerfile(ctx context.Context, arg InsertOrUpda
 		arg.UsesPipInstallWithoutNoCache,
 		arg.UsesPipInstallWithoutHashes,
 		arg.UsesCurlBashPipe,
+		arg.UsesPnpmInstallWithoutFrozen,
+		arg.UsesBunInstallWithoutFrozen,
+		arg.UsesGoInstallLatest,
+		arg.UsesGemInstallWithoutVersion,
+		arg.UsesApkAddWithoutNoCache,
+		arg.UsesAptGetInstallWithoutNoRecommends,
+		arg.UsesPoetryInstallWithoutLockCheck,
+		arg.UsesUvPipInstallWithoutHashes,
+		arg.UsesGitCloneUnpinned,
 	)
 	var id int32
 	err := row.Scan(&id)
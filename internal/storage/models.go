@@ -11,55 +11,171 @@ import (
 )
 
 type CiConfig struct {
-	ID                            int32
-	RepoID                        int64
-	HentetDato                    time.Time
-	Path                          string
-	Content                       string
-	UsesNpmInstall                sql.NullBool
-	UsesNpmCiWithoutIgnoreScripts sql.NullBool
-	UsesYarnInstallWithoutFrozen  sql.NullBool
-	UsesNpx                       sql.NullBool
-	UsesPipInstallWithoutNoCache  sql.NullBool
-	UsesPipInstallWithoutHashes   sql.NullBool
-	UsesCurlBashPipe              sql.NullBool
-	UsesSudo                      sql.NullBool
-	UsesPackagePublish            bool
-	UsesPullRequestTarget         bool
-	SecretNames                   []string
+	ID                                   int32
+	RepoID                               int64
+	HentetDato                           time.Time
+	Path                                 string
+	Content                              string
+	UsesNpmInstall                       sql.NullBool
+	UsesNpmCiWithoutIgnoreScripts        sql.NullBool
+	UsesYarnInstallWithoutFrozen         sql.NullBool
+	UsesNpx                              sql.NullBool
+	UsesPipInstallWithoutNoCache         sql.NullBool
+	UsesPipInstallWithoutHashes          sql.NullBool
+	UsesCurlBashPipe                     sql.NullBool
+	UsesSudo                             sql.NullBool
+	UsesPackagePublish                   bool
+	UsesPullRequestTarget                bool
+	SecretNames                          []string
+	UsesPnpmInstallWithoutFrozen         bool
+	UsesBunInstallWithoutFrozen          bool
+	UsesGoInstallLatest                  bool
+	UsesGemInstallWithoutVersion         bool
+	UsesApkAddWithoutNoCache             bool
+	UsesAptGetInstallWithoutNoRecommends bool
+	UsesPoetryInstallWithoutLockCheck    bool
+	UsesUvPipInstallWithoutHashes        bool
+	UsesGitCloneUnpinned                 bool
+}
+
+type CiConfigSnapshot struct {
+	ID                                   int32
+	RepoID                               int64
+	HentetDato                           time.Time
+	Path                                 string
+	UsesNpmInstall                       sql.NullBool
+	UsesNpmCiWithoutIgnoreScripts        sql.NullBool
+	UsesYarnInstallWithoutFrozen         sql.NullBool
+	UsesNpx                              sql.NullBool
+	UsesPipInstallWithoutNoCache         sql.NullBool
+	UsesPipInstallWithoutHashes          sql.NullBool
+	UsesCurlBashPipe                     sql.NullBool
+	UsesSudo                             sql.NullBool
+	UsesPackagePublish                   bool
+	UsesPullRequestTarget                bool
+	SecretNames                          []string
+	UsesPnpmInstallWithoutFrozen         bool
+	UsesBunInstallWithoutFrozen          bool
+	UsesGoInstallLatest                  bool
+	UsesGemInstallWithoutVersion         bool
+	UsesApkAddWithoutNoCache             bool
+	UsesAptGetInstallWithoutNoRecommends bool
+	UsesPoetryInstallWithoutLockCheck    bool
+	UsesUvPipInstallWithoutHashes        bool
+	UsesGitCloneUnpinned                 bool
+	ContentSha256                        string
+}
+
+type CiWorkflowCall struct {
+	ID         int32
+	RepoID     int64
+	HentetDato time.Time
+	CallerPath string
+	Job        string
+	Uses       string
+	Kind       string
+	Target     string
+	IsLocal    bool
+	Resolved   bool
 }
 
 type Dockerfile struct {
-	ID                            int32
-	RepoID                        int64
-	HentetDato                    time.Time
-	FullName                      string
-	Path                          string
-	Content                       string
-	BaseImage                     sql.NullString
-	BaseTag                       sql.NullString
-	UsesLatestTag                 sql.NullBool
-	HasUserInstruction            sql.NullBool
-	HasCopySensitive              sql.NullBool
-	HasPackageInstalls            sql.NullBool
-	UsesMultistage                sql.NullBool
-	HasHealthcheck                sql.NullBool
-	UsesAddInstruction            sql.NullBool
-	HasLabelMetadata              sql.NullBool
-	HasExpose                     sql.NullBool
-	HasEntrypointOrCmd            sql.NullBool
-	InstallsCurlOrWget            sql.NullBool
-	InstallsBuildTools            sql.NullBool
-	HasAptGetClean                sql.NullBool
-	WorldWritable                 sql.NullBool
-	HasSecretsInEnvOrArg          sql.NullBool
-	UsesNpmInstall                sql.NullBool
-	UsesNpmCiWithoutIgnoreScripts sql.NullBool
-	UsesYarnInstallWithoutFrozen  sql.NullBool
-	UsesNpx                       sql.NullBool
-	UsesPipInstallWithoutNoCache  sql.NullBool
-	UsesPipInstallWithoutHashes   sql.NullBool
-	UsesCurlBashPipe              sql.NullBool
+	ID                                   int32
+	RepoID                               int64
+	HentetDato                           time.Time
+	FullName                             string
+	Path                                 string
+	Content                              string
+	BaseImage                            sql.NullString
+	BaseTag                              sql.NullString
+	UsesLatestTag                        sql.NullBool
+	HasUserInstruction                   sql.NullBool
+	HasCopySensitive                     sql.NullBool
+	HasPackageInstalls                   sql.NullBool
+	UsesMultistage                       sql.NullBool
+	HasHealthcheck                       sql.NullBool
+	UsesAddInstruction                   sql.NullBool
+	HasLabelMetadata                     sql.NullBool
+	HasExpose                            sql.NullBool
+	HasEntrypointOrCmd                   sql.NullBool
+	InstallsCurlOrWget                   sql.NullBool
+	InstallsBuildTools                   sql.NullBool
+	HasAptGetClean                       sql.NullBool
+	WorldWritable                        sql.NullBool
+	HasSecretsInEnvOrArg                 sql.NullBool
+	UsesNpmInstall                       sql.NullBool
+	UsesNpmCiWithoutIgnoreScripts        sql.NullBool
+	UsesYarnInstallWithoutFrozen         sql.NullBool
+	UsesNpx                              sql.NullBool
+	UsesPipInstallWithoutNoCache         sql.NullBool
+	UsesPipInstallWithoutHashes          sql.NullBool
+	UsesCurlBashPipe                     sql.NullBool
+	UsesPnpmInstallWithoutFrozen         bool
+	UsesBunInstallWithoutFrozen          bool
+	UsesGoInstallLatest                  bool
+	UsesGemInstallWithoutVersion         bool
+	UsesApkAddWithoutNoCache             bool
+	UsesAptGetInstallWithoutNoRecommends bool
+	UsesPoetryInstallWithoutLockCheck    bool
+	UsesUvPipInstallWithoutHashes        bool
+	UsesGitCloneUnpinned                 bool
+}
+
+type DockerfileSnapshot struct {
+	ID                                   int32
+	RepoID                               int64
+	HentetDato                           time.Time
+	FullName                             string
+	Path                                 string
+	BaseImage                            sql.NullString
+	BaseTag                              sql.NullString
+	UsesLatestTag                        sql.NullBool
+	HasUserInstruction                   sql.NullBool
+	HasCopySensitive                     sql.NullBool
+	HasPackageInstalls                   sql.NullBool
+	UsesMultistage                       sql.NullBool
+	HasHealthcheck                       sql.NullBool
+	UsesAddInstruction                   sql.NullBool
+	HasLabelMetadata                     sql.NullBool
+	HasExpose                            sql.NullBool
+	HasEntrypointOrCmd                   sql.NullBool
+	InstallsCurlOrWget                   sql.NullBool
+	InstallsBuildTools                   sql.NullBool
+	HasAptGetClean                       sql.NullBool
+	WorldWritable                        sql.NullBool
+	HasSecretsInEnvOrArg                 sql.NullBool
+	UsesNpmInstall                       sql.NullBool
+	UsesNpmCiWithoutIgnoreScripts        sql.NullBool
+	UsesYarnInstallWithoutFrozen         sql.NullBool
+	UsesNpx                              sql.NullBool
+	UsesPipInstallWithoutNoCache         sql.NullBool
+	UsesPipInstallWithoutHashes          sql.NullBool
+	UsesCurlBashPipe                     sql.NullBool
+	UsesPnpmInstallWithoutFrozen         bool
+	UsesBunInstallWithoutFrozen          bool
+	UsesGoInstallLatest                  bool
+	UsesGemInstallWithoutVersion         bool
+	UsesApkAddWithoutNoCache             bool
+	UsesAptGetInstallWithoutNoRecommends bool
+	UsesPoetryInstallWithoutLockCheck    bool
+	UsesUvPipInstallWithoutHashes        bool
+	UsesGitCloneUnpinned                 bool
+	ContentSha256                        string
+}
+
+type FileBlob struct {
+	ContentSha256 string
+	Content       string
+	SizeBytes     int64
+}
+
+type HttpCache struct {
+	Url          string
+	Etag         string
+	LastModified string
+	Body         []byte
+	SizeBytes    int64
+	AccessedAt   time.Time
 }
 
 type Repo struct {
@@ -91,6 +207,21 @@ type Repo struct {
 	HasCompleteLockfiles bool
 	LockfilePairings     json.RawMessage
 	LockfilePairCount    int32
+	DefaultBranch        string
+}
+
+type RepoFetchError struct {
+	ID         int64
+	RunID      string
+	HentetDato time.Time
+	RepoID     int64
+	FullName   string
+	ErrorClass string
+	Message    string
+	Fatal      bool
+	Attempts   int32
+	Recovered  bool
+	RecordedAt time.Time
 }
 
 type RepoLanguage struct {
@@ -101,6 +232,38 @@ type RepoLanguage struct {
 	Bytes      int64
 }
 
+type RepoSnapshot struct {
+	ID                   int64
+	HentetDato           time.Time
+	Name                 string
+	FullName             string
+	Description          string
+	Stars                int64
+	Forks                int64
+	Archived             bool
+	Private              bool
+	IsFork               bool
+	Language             string
+	SizeMb               float32
+	UpdatedAt            string
+	PushedAt             string
+	CreatedAt            string
+	HtmlUrl              string
+	Topics               string
+	Visibility           string
+	License              string
+	OpenIssues           int64
+	LanguagesUrl         string
+	HasSecurityMd        bool
+	HasDependabot        bool
+	HasCodeql            bool
+	HasCompleteLockfiles bool
+	LockfilePairings     json.RawMessage
+	LockfilePairCount    int32
+	DefaultBranch        string
+	ReadmeSha256         sql.NullString
+}
+
 type SbomGithubPackage struct {
 	ID         int32
 	RepoID     int64
@@ -110,3 +273,34 @@ type SbomGithubPackage struct {
 	License    sql.NullString
 	Purl       sql.NullString
 }
+
+type SecretFinding struct {
+	ID         int32
+	RepoID     int64
+	HentetDato time.Time
+	Path       string
+	Line       int32
+	Rule       string
+}
+
+type SnapshotRun struct {
+	RunID                string
+	Org                  string
+	HentetDato           time.Time
+	StartedAt            time.Time
+	FinishedAt           sql.NullTime
+	Status               string
+	Version              string
+	Config               string
+	Error                string
+	ReposProcessed       int64
+	ReposFailedGraphql   int64
+	ReposFailedRows      int64
+	RowsWritten          int64
+	RowsFailed           int64
+	ApiCalls             int64
+	RateLimitWaits       int64
+	RateLimitWaitSeconds float64
+	GracefulShutdown     bool
+	FailedRepos          []string
+}
@@ -8,31 +8,33 @@ package storage
 import (
 	"context"
 	"time"
+
+	"github.com/lib/pq"
 )
 
-const insertOrUpdateRepoLanguage = `-- name: InsertOrUpdateRepoLanguage :exec
+const insertOrUpdateRepoLanguages = `-- name: InsertOrUpdateRepoLanguages :exec
 INSERT INTO repo_languages (
   repo_id, hentet_dato, language, bytes
-) VALUES (
-  $1, $2, $3, $4
 )
+SELECT $1::BIGINT, $2::DATE, l.language, l.bytes
+FROM unnest($3::TEXT[], $4::BIGINT[]) AS l(language, bytes)
 ON CONFLICT (repo_id, hentet_dato, language) DO UPDATE SET
   bytes = EXCLUDED.bytes
 `
 
-type InsertOrUpdateRepoLanguageParams struct {
+type InsertOrUpdateRepoLanguagesParams struct {
 	RepoID     int64
 	HentetDato time.Time
-	Language   string
-	Bytes      int64
+	Languages  []string
+	Bytes      []int64
 }
 
-func (q *Queries) InsertOrUpdateRepoLanguage(ctx context.Context, arg InsertOrUpdateRepoLanguageParams) error {
-	_, err := q.db.ExecContext(ctx, insertOrUpdateRepoLanguage,
+func (q *Queries) InsertOrUpdateRepoLanguages(ctx context.Context, arg InsertOrUpdateRepoLanguagesParams) error {
+	_, err := q.db.ExecContext(ctx, insertOrUpdateRepoLanguages,
 		arg.RepoID,
 		arg.HentetDato,
-		arg.Language,
-		arg.Bytes,
+		pq.Array(arg.Languages),
+		pq.Array(arg.Bytes),
 	)
 	return err
 }
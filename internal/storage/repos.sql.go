@@ -13,20 +13,22 @@ import (
 )
 
 const insertOrUpdateRepo = `-- name: InsertOrUpdateRepo :exec
-INSERT INTO repos (
+INSERT INTO repo_snapshots (
   id, hentet_dato,
   name, full_name, description, stars, forks, archived, private, is_fork,
   language, size_mb, updated_at, pushed_at, created_at, html_url, topics,
   visibility, license, open_issues, languages_url,
-  has_security_md, has_dependabot, has_codeql, readme_content,
-  has_complete_lockfiles, lockfile_pairings, lockfile_pair_count
+  has_security_md, has_dependabot, has_codeql, readme_sha256,
+  has_complete_lockfiles, lockfile_pairings, lockfile_pair_count,
+  default_branch
 ) VALUES (
   $1, $2,
   $3, $4, $5, $6, $7, $8, $9, $10,
   $11, $12, $13, $14, $15, $16, $17,
   $18, $19, $20, $21,
   $22, $23, $24, $25,
-  $26, $27, $28
+  $26, $27, $28,
+  $29
 )
 ON CONFLICT (id, hentet_dato) DO UPDATE SET
   name = EXCLUDED.name,
@@ -51,10 +53,11 @@ ON CONFLICT (id, hentet_dato) DO UPDATE SET
   has_security_md = EXCLUDED.has_security_md,
   has_dependabot = EXCLUDED.has_dependabot,
   has_codeql = EXCLUDED.has_codeql,
-  readme_content = EXCLUDED.readme_content,
+  readme_sha256 = EXCLUDED.readme_sha256,
   has_complete_lockfiles = EXCLUDED.has_complete_lockfiles,
   lockfile_pairings = EXCLUDED.lockfile_pairings,
-  lockfile_pair_count = EXCLUDED.lockfile_pair_count
+  lockfile_pair_count = EXCLUDED.lockfile_pair_count,
+  default_branch = EXCLUDED.default_branch
 `
 
 type InsertOrUpdateRepoParams struct {
@@ -82,10 +85,11 @@ type InsertOrUpdateRepoParams struct {
 	HasSecurityMd        bool
 	HasDependabot        bool
 	HasCodeql            bool
-	ReadmeContent        sql.NullString
+	ReadmeSha256         sql.NullString
 	HasCompleteLockfiles bool
 	LockfilePairings     json.RawMessage
 	LockfilePairCount    int32
+	DefaultBranch        string
 }
 
 func (q *Queries) InsertOrUpdateRepo(ctx context.Context, arg InsertOrUpdateRepoParams) error {
@@ -114,10 +118,11 @@ func (q *Queries) InsertOrUpdateRepo(ctx context.Context, arg InsertOrUpdateRepo
 		arg.HasSecurityMd,
 		arg.HasDependabot,
 		arg.HasCodeql,
-		arg.ReadmeContent,
+		arg.ReadmeSha256,
 		arg.HasCompleteLockfiles,
 		arg.LockfilePairings,
 		arg.LockfilePairCount,
+		arg.DefaultBranch,
 	)
 	return err
 }
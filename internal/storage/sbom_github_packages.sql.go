@@ -7,38 +7,39 @@ package storage
 
 import (
 	"context"
-	"database/sql"
 	"time"
+
+	"github.com/lib/pq"
 )
 
-const insertOrUpdateGithubSBOM = `-- name: InsertOrUpdateGithubSBOM :exec
+const insertOrUpdateGithubSBOMPackages = `-- name: InsertOrUpdateGithubSBOMPackages :exec
 INSERT INTO sbom_github_packages (
   repo_id, hentet_dato, name, version, license, purl
-) VALUES (
-  $1, $2, $3, $4, $5, $6
 )
+SELECT $1::BIGINT, $2::DATE, p.name, NULLIF(p.version, ''), NULLIF(p.license, ''), NULLIF(p.purl, '')
+FROM unnest($3::TEXT[], $4::TEXT[], $5::TEXT[], $6::TEXT[]) AS p(name, version, license, purl)
 ON CONFLICT (repo_id, hentet_dato, name, version) DO UPDATE SET
   license = EXCLUDED.license,
   purl = EXCLUDED.purl
 `
 
-type InsertOrUpdateGithubSBOMParams struct {
+type InsertOrUpdateGithubSBOMPackagesParams struct {
 	RepoID     int64
 	HentetDato time.Time
-	Name       string
-	Version    sql.NullString
-	License    sql.NullString
-	Purl       sql.NullString
+	Names      []string
+	Versions   []string
+	Licenses   []string
+	Purls      []string
 }
 
-func (q *Queries) InsertOrUpdateGithubSBOM(ctx context.Context, arg InsertOrUpdateGithubSBOMParams) error {
-	_, err := q.db.ExecContext(ctx, insertOrUpdateGithubSBOM,
+func (q *Queries) InsertOrUpdateGithubSBOMPackages(ctx context.Context, arg InsertOrUpdateGithubSBOMPackagesParams) error {
+	_, err := q.db.ExecContext(ctx, insertOrUpdateGithubSBOMPackages,
 		arg.RepoID,
 		arg.HentetDato,
-		arg.Name,
-		arg.Version,
-		arg.License,
-		arg.Purl,
+		pq.Array(arg.Names),
+		pq.Array(arg.Versions),
+		pq.Array(arg.Licenses),
+		pq.Array(arg.Purls),
 	)
 	return err
 }
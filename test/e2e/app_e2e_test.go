@@ -0,0 +1,133 @@
+//go:build e2e
+
+package e2e_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/config"
+	"github.com/jonmartinstorm/reposnusern/internal/fetcher"
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+	"github.com/jonmartinstorm/reposnusern/internal/runner"
+	"github.com/jonmartinstorm/reposnusern/test/githubfake"
+	"github.com/jonmartinstorm/reposnusern/test/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestEndToEnd(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Ende-til-ende mot falsk GitHub")
+}
+
+// newConfig peker en konfigurasjon mot den falske GitHub-en som en GitHub
+// Enterprise Server.
+func newConfig(github *githubfake.Server) config.Config {
+	return config.Config{
+		Org:              "testorg",
+		Token:            "fake-token",
+		GitHubURL:        github.URL,
+		SkipArchived:     true,
+		Parallelism:      2,
+		GraphQLBatchSize: 10,
+		RepoListing:      config.RepoListingREST,
+		Feature_Sbom:     true,
+	}
+}
+
+func newFetcher(github *githubfake.Server, cfg config.Config) *fetcher.RepoFetcher {
+	client := fetcher.NewClient(cfg)
+	client.HTTP = github.Client()
+	client.RetryBackoff = func(int) time.Duration { return 10 * time.Millisecond }
+	client.SecondaryRateLimitBackoff = time.Second
+	return fetcher.NewRepoFetcherWithClient(cfg, client, nil)
+}
+
+func injectFaults(github *githubfake.Server) {
+	github.Inject(githubfake.Fault{Path: "/orgs/testorg/repos", Kind: githubfake.FaultRateLimit, Times: 1})
+	github.Inject(githubfake.Fault{Path: "/graphql", Kind: githubfake.FaultSecondaryRateLimit, Times: 1})
+	github.Inject(githubfake.Fault{Path: "/repos/testorg/monorepo/contents/", Kind: githubfake.FaultServerError, Times: 2})
+}
+
+func paths(files []models.FileEntry) []string {
+	var out []string
+	for _, f := range files {
+		out = append(out, f.Path)
+	}
+	return out
+}
+
+var _ = Describe("runner.App mot filskriver", func() {
+	var github *githubfake.Server
+
+	BeforeEach(func() {
+		github = githubfake.New("testdata/github")
+		DeferCleanup(github.Close)
+	})
+
+	It("henter alle repoer gjennom rate limit, sekundær rate limit og serverfeil", func() {
+		injectFaults(github)
+		cfg := newConfig(github)
+		getter := newFetcher(github, cfg)
+		writer := &testutils.JSONFileWriter{Dir: GinkgoT().TempDir()}
+
+		ctx := context.Background()
+		Expect(runner.NewApp(cfg, writer, getter).Run(ctx, ctx)).To(Succeed())
+
+		demo, err := writer.ReadEntry("testorg/demo")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(demo.Repo.Readme).To(ContainSubstring("Demo-tjeneste"))
+		Expect(demo.Languages).To(HaveKeyWithValue("Go", 5000))
+		Expect(paths(demo.Files["dockerfile"])).To(ConsistOf("Dockerfile"))
+		Expect(paths(demo.CIConfig)).To(ConsistOf(".github/workflows/ci.yml"))
+		Expect(demo.Repo.Security).To(HaveKeyWithValue("has_dependabot", true))
+		Expect(demo.SBOM).To(HaveKey("sbom"))
+
+		monorepo, err := writer.ReadEntry("testorg/monorepo")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(paths(monorepo.Files["dockerfile"])).To(ConsistOf("services/api/Dockerfile"))
+		Expect(paths(monorepo.Files["dependencies"])).To(ContainElements("services/api/go.mod", "services/web/package.json"))
+		Expect(monorepo.SBOM).To(BeNil())
+
+		stats := getter.Stats()
+		Expect(stats.RateLimits[fetcher.RateLimitResourceCore].Hits).To(BeNumerically(">=", 1))
+		Expect(stats.RateLimits[fetcher.RateLimitResourceGraphQL].SecondaryHits).To(BeNumerically(">=", 1))
+		Expect(github.Count("/repos/testorg/monorepo/contents/services/api/Dockerfile")).To(Equal(3))
+	})
+})
+
+var _ = Describe("runner.App mot PostgreSQL", Ordered, func() {
+	var (
+		github *githubfake.Server
+		testDB *testutils.TestDB
+	)
+
+	BeforeAll(func() {
+		testDB = testutils.StartTestPostgresContainer()
+		testutils.RunMigrations(testDB.DB)
+		DeferCleanup(testDB.Close)
+
+		github = githubfake.New("testdata/github")
+		DeferCleanup(github.Close)
+	})
+
+	It("lagrer repoer, Dockerfiler og SBOM-pakker fra den falske GitHub-en", func() {
+		injectFaults(github)
+		cfg := newConfig(github)
+		writer := testutils.NewRealPostgresWriter(testDB.DB)
+
+		ctx := context.Background()
+		Expect(runner.NewApp(cfg, writer, newFetcher(github, cfg)).Run(ctx, ctx)).To(Succeed())
+
+		count := func(query string) int {
+			var n int
+			Expect(testDB.DB.QueryRow(query).Scan(&n)).To(Succeed())
+			return n
+		}
+		Expect(count(`SELECT COUNT(*) FROM repos WHERE full_name LIKE 'testorg/%'`)).To(Equal(2))
+		Expect(count(`SELECT COUNT(*) FROM dockerfiles`)).To(Equal(2))
+		Expect(count(`SELECT COUNT(*) FROM sbom_github_packages`)).To(Equal(1))
+	})
+})
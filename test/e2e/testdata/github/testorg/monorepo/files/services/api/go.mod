@@ -0,0 +1,3 @@
+module github.com/testorg/monorepo/services/api
+
+go 1.22
@@ -0,0 +1,410 @@
+// Package githubfake er en falsk GitHub for ende-til-ende-tester. Den serverer
+// org-lister, GraphQL-spørringer etter repoer, git-trær, filinnhold og SBOM-er
+// fra en fixture-katalog, og kan simulere rate limit, serverfeil og sekundær
+// rate limit.
+//
+// Fixture-katalogen har én katalog per org og repo:
+//
+//	<org>/<repo>/repo.json       REST-metadata slik /orgs/{org}/repos returnerer dem
+//	<org>/<repo>/languages.json  valgfri, f.eks. {"Go": 1234}
+//	<org>/<repo>/sbom.json       valgfri, mangler den svarer SBOM-endepunktet 404
+//	<org>/<repo>/files/...       filene i repoet på HEAD
+//
+// REST-kall besvares både på rot og under /api/v3, og GraphQL både på /graphql
+// og /api/graphql, så serveren kan brukes som github.com og som GitHub
+// Enterprise Server.
+package githubfake
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FaultKind er en feil serveren kan simulere.
+type FaultKind int
+
+const (
+	// FaultRateLimit svarer 403 med brukt opp kvote og Retry-After.
+	FaultRateLimit FaultKind = iota
+	// FaultSecondaryRateLimit svarer 403 med GitHubs melding om sekundær rate limit.
+	FaultSecondaryRateLimit
+	// FaultServerError svarer 502.
+	FaultServerError
+)
+
+// Fault simulerer en feil for de neste Times kallene mot stier som starter
+// med Path. Tom Path gjelder alle kall. GraphQL-kall har stien /graphql.
+type Fault struct {
+	Path  string
+	Kind  FaultKind
+	Times int
+}
+
+// Server er en falsk GitHub bygget på httptest.Server.
+type Server struct {
+	*httptest.Server
+
+	// RetryAfter er ventetiden simulerte rate limits ber klienten om.
+	RetryAfter time.Duration
+
+	dir string
+
+	mu        sync.Mutex
+	faults    []Fault
+	requests  []string
+	remaining map[string]int
+}
+
+// New starter en falsk GitHub som serverer fra fixture-katalogen dir.
+func New(dir string) *Server {
+	s := &Server{
+		RetryAfter: time.Second,
+		dir:        dir,
+		remaining:  map[string]int{"core": 5000, "graphql": 5000},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Inject legger til en feil som simuleres før vanlige svar.
+func (s *Server) Inject(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, f)
+}
+
+// Requests returnerer metode og sti for alle kall serveren har fått.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Count returnerer antall kall mot stier som starter med prefix.
+func (s *Server) Count(prefix string) int {
+	n := 0
+	for _, r := range s.Requests() {
+		if _, p, _ := strings.Cut(r, " "); strings.HasPrefix(p, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/api/v3")
+	if p == "/api/graphql" {
+		p = "/graphql"
+	}
+	resource := "core"
+	if p == "/graphql" {
+		resource = "graphql"
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+p)
+	fault, faulted := s.nextFault(p)
+	s.remaining[resource] = max(0, s.remaining[resource]-1)
+	remaining := s.remaining[resource]
+	s.mu.Unlock()
+
+	if faulted {
+		s.writeFault(w, fault)
+		return
+	}
+
+	w.Header().Set("X-RateLimit-Limit", "5000")
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	w.Header().Set("X-RateLimit-Resource", resource)
+
+	if resource == "graphql" {
+		s.handleGraphQL(w, r)
+		return
+	}
+	s.handleREST(w, r, p)
+}
+
+// nextFault tar den første feilen som gjelder p. Kalleren må holde s.mu.
+func (s *Server) nextFault(p string) (Fault, bool) {
+	for i, f := range s.faults {
+		if !strings.HasPrefix(p, f.Path) {
+			continue
+		}
+		f.Times--
+		if f.Times <= 0 {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+		} else {
+			s.faults[i] = f
+		}
+		return f, true
+	}
+	return Fault{}, false
+}
+
+func (s *Server) writeFault(w http.ResponseWriter, f Fault) {
+	retryAfter := strconv.Itoa(max(1, int(s.RetryAfter.Seconds())))
+	switch f.Kind {
+	case FaultRateLimit:
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(s.RetryAfter).Unix(), 10))
+		w.Header().Set("Retry-After", retryAfter)
+		writeJSON(w, http.StatusForbidden, map[string]string{"message": "API rate limit exceeded"})
+	case FaultSecondaryRateLimit:
+		w.Header().Set("Retry-After", retryAfter)
+		writeJSON(w, http.StatusForbidden, map[string]string{"message": "You have exceeded a secondary rate limit. Please wait a few minutes before you try again."})
+	default:
+		writeJSON(w, http.StatusBadGateway, map[string]string{"message": "Server Error"})
+	}
+}
+
+func (s *Server) handleREST(w http.ResponseWriter, r *http.Request, p string) {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	switch {
+	case len(parts) == 3 && parts[0] == "orgs" && parts[2] == "repos":
+		s.serveRepoPage(w, r, parts[1])
+	case len(parts) >= 5 && parts[0] == "repos" && parts[3] == "git" && parts[4] == "trees":
+		s.serveTree(w, parts[1], parts[2])
+	case len(parts) >= 5 && parts[0] == "repos" && parts[3] == "contents":
+		s.serveContents(w, parts[1], parts[2], strings.Join(parts[4:], "/"))
+	case len(parts) == 5 && parts[0] == "repos" && parts[3] == "dependency-graph" && parts[4] == "sbom":
+		s.serveFile(w, filepath.Join(s.dir, parts[1], parts[2], "sbom.json"))
+	default:
+		writeNotFound(w)
+	}
+}
+
+func (s *Server) serveRepoPage(w http.ResponseWriter, r *http.Request, org string) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	page, perPage = max(page, 1), max(perPage, 1)
+
+	names, err := s.repoNames(org)
+	if err != nil {
+		writeNotFound(w)
+		return
+	}
+	repos := []json.RawMessage{}
+	for i := (page - 1) * perPage; i < min(page*perPage, len(names)); i++ {
+		data, err := os.ReadFile(filepath.Join(s.dir, org, names[i], "repo.json"))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"message": err.Error()})
+			return
+		}
+		repos = append(repos, data)
+	}
+	writeJSON(w, http.StatusOK, repos)
+}
+
+func (s *Server) serveTree(w http.ResponseWriter, org, repo string) {
+	root := s.filesDir(org, repo)
+	type treeEntry struct {
+		Path string `json:"path"`
+		Type string `json:"type"`
+		Size int64  `json:"size,omitempty"`
+	}
+	tree := []treeEntry{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == root {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		entry := treeEntry{Path: filepath.ToSlash(rel), Type: "tree"}
+		if !d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			entry.Type, entry.Size = "blob", info.Size()
+		}
+		tree = append(tree, entry)
+		return nil
+	})
+	if err != nil {
+		writeNotFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tree": tree, "truncated": false})
+}
+
+func (s *Server) serveContents(w http.ResponseWriter, org, repo, file string) {
+	data, err := os.ReadFile(filepath.Join(s.filesDir(org, repo), filepath.FromSlash(path.Clean(file))))
+	if err != nil {
+		writeNotFound(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"path":     file,
+		"encoding": "base64",
+		"content":  base64.StdEncoding.EncodeToString(data),
+	})
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		writeNotFound(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// handleGraphQL svarer på repository-spørringene til fetcheren, både enkeltvis
+// (variabelen name) og batchet (name0, name1, ...). Spørringen i seg selv
+// leses ikke; svaret inneholder alltid alle feltene i RepoFields-fragmentet.
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Variables map[string]interface{} `json:"variables"`
+	}
+	data, _ := io.ReadAll(r.Body)
+	if err := json.Unmarshal(data, &body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Problems parsing JSON"})
+		return
+	}
+	owner, _ := body.Variables["owner"].(string)
+
+	result := map[string]interface{}{}
+	var errs []map[string]interface{}
+	lookup := func(alias, name string) {
+		repo, err := s.repository(owner, name)
+		if err != nil {
+			errs = append(errs, map[string]interface{}{
+				"type":    "NOT_FOUND",
+				"path":    []string{alias},
+				"message": fmt.Sprintf("Could not resolve to a Repository with the name '%s/%s'.", owner, name),
+			})
+		}
+		result[alias] = repo
+	}
+
+	if name, ok := body.Variables["name"].(string); ok {
+		lookup("repository", name)
+	} else {
+		for i := 0; ; i++ {
+			name, ok := body.Variables[fmt.Sprintf("name%d", i)].(string)
+			if !ok {
+				break
+			}
+			lookup(fmt.Sprintf("r%d", i), name)
+		}
+		result["rateLimit"] = map[string]interface{}{
+			"cost":      1,
+			"limit":     5000,
+			"remaining": s.remainingGraphQL(),
+			"resetAt":   time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		}
+	}
+
+	response := map[string]interface{}{"data": result}
+	if len(errs) > 0 {
+		response["errors"] = errs
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) remainingGraphQL() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.remaining["graphql"]
+}
+
+// repository bygger GraphQL-svaret for ett repo fra filene på HEAD.
+func (s *Server) repository(org, name string) (map[string]interface{}, error) {
+	root := s.filesDir(org, name)
+	if _, err := os.Stat(filepath.Join(s.dir, org, name, "repo.json")); err != nil {
+		return nil, err
+	}
+
+	blob := func(file string) interface{} {
+		data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(file)))
+		if err != nil {
+			return nil
+		}
+		return map[string]string{"text": string(data)}
+	}
+	tree := func(dir string) interface{} {
+		entries, err := os.ReadDir(filepath.Join(root, filepath.FromSlash(dir)))
+		if err != nil {
+			return nil
+		}
+		list := []map[string]interface{}{}
+		for _, e := range entries {
+			var object interface{} = map[string]interface{}{}
+			if !e.IsDir() {
+				object = blob(path.Join(dir, e.Name()))
+			}
+			list = append(list, map[string]interface{}{"name": e.Name(), "object": object})
+		}
+		return map[string]interface{}{"entries": list}
+	}
+
+	languages := map[string]int{}
+	if data, err := os.ReadFile(filepath.Join(s.dir, org, name, "languages.json")); err == nil {
+		if err := json.Unmarshal(data, &languages); err != nil {
+			return nil, err
+		}
+	}
+	langNames := make([]string, 0, len(languages))
+	for lang := range languages {
+		langNames = append(langNames, lang)
+	}
+	sort.Slice(langNames, func(i, j int) bool { return languages[langNames[i]] > languages[langNames[j]] })
+	edges := []map[string]interface{}{}
+	for _, lang := range langNames {
+		edges = append(edges, map[string]interface{}{"size": languages[lang], "node": map[string]string{"name": lang}})
+	}
+
+	return map[string]interface{}{
+		"defaultBranchRef": map[string]string{"name": "main"},
+		"README":           blob("README.md"),
+		"SECURITY":         blob("SECURITY.md"),
+		"dependabot":       blob(".github/dependabot.yml"),
+		"codeql":           blob(".github/codeql.yml"),
+		"workflows":        tree(".github/workflows"),
+		"dependencies":     tree(""),
+		"languages":        map[string]interface{}{"edges": edges},
+	}, nil
+}
+
+func (s *Server) repoNames(org string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, org))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s *Server) filesDir(org, repo string) string {
+	return filepath.Join(s.dir, org, repo, "files")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeNotFound(w http.ResponseWriter) {
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+}
@@ -0,0 +1,191 @@
+package postgres_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/bqwriter"
+	"github.com/jonmartinstorm/reposnusern/internal/config"
+	"github.com/jonmartinstorm/reposnusern/internal/dbwriter"
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+	"github.com/jonmartinstorm/reposnusern/test/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// Samme repo skal gi de samme radene i PostgreSQL og BigQuery. BigQuery-radene
+// er NDJSON-en BigQueryWriter.ImportRepo legger i bufferet til Commit,
+// PostgreSQL-radene leses tilbake fra databasen, så også ON CONFLICT og NULLIF
+// i spørringene blir sjekket.
+var _ = Describe("PostgreSQL og BigQuery lagrer de samme radene", Ordered, func() {
+	const repoID = 4242
+
+	var (
+		ctx    context.Context
+		testDB *testutils.TestDB
+		bq     *bqwriter.BigQueryWriter
+	)
+
+	sbomPackage := func(name, version, license, purl string) map[string]interface{} {
+		pkg := map[string]interface{}{"name": name, "versionInfo": version, "licenseConcluded": license}
+		if purl != "" {
+			pkg["externalRefs"] = []interface{}{
+				map[string]interface{}{"referenceType": "purl", "referenceLocator": purl},
+			}
+		}
+		return pkg
+	}
+
+	entry := models.RepoEntry{
+		Repo: models.RepoMeta{
+			ID:       repoID,
+			Name:     "likt",
+			FullName: "testorg/likt",
+			Topics:   []string{"go", "sikkerhet"},
+			License:  &models.License{SpdxID: "MIT"},
+			Readme:   "# likt\nAKIAABCDEFGHIJKLMNOP",
+		},
+		Languages: map[string]int{"Go": 1000, "Shell": 10},
+		Files: map[string][]models.FileEntry{
+			"dockerfile": {
+				{Path: "Dockerfile", Content: "FROM alpine:latest\nENV API_TOKEN=hemmelig"},
+				{Path: "build/Dockerfile", Content: "FROM golang:1.22 AS build\nFROM scratch"},
+			},
+			"dockerfile_dev": {
+				{Path: "Dockerfile", Content: "FROM alpine:3.19"},
+			},
+		},
+		CIConfig: []models.FileEntry{
+			{Path: ".github/workflows/ci.yml", Content: "on: pull_request_target\njobs:\n  b:\n    steps:\n      - run: npm install"},
+			{Path: ".github/workflows/ci.yml", Content: "on: push\njobs:\n  b:\n    steps:\n      - run: npm ci"},
+		},
+		CIWorkflowCalls: []models.WorkflowCall{
+			{Caller: ".github/workflows/ci.yml", Job: "b", Uses: "org/wf/.github/workflows/d.yml@v1", Kind: "workflow", Target: "gammel"},
+			{Caller: ".github/workflows/ci.yml", Job: "b", Uses: "org/wf/.github/workflows/d.yml@v1", Kind: "workflow", Target: "ny", Resolved: true},
+		},
+		SBOM: map[string]interface{}{"sbom": map[string]interface{}{"packages": []interface{}{
+			sbomPackage("a", "1.0", "MIT", ""),
+			sbomPackage("a", "1.0", "Apache-2.0", "pkg:npm/a@1.0"),
+			sbomPackage("a", "2.0", "", ""),
+			sbomPackage("b", "", "", ""),
+		}}},
+	}
+
+	BeforeAll(func() {
+		ctx = context.Background()
+		testDB = testutils.StartTestPostgresContainer()
+		testutils.RunMigrations(testDB.DB)
+
+		snapshot := time.Now()
+		_, err := (&dbwriter.PostgresWriter{DB: testDB.DB}).ImportRepo(ctx, entry, snapshot)
+		Expect(err).NotTo(HaveOccurred())
+
+		// Uten klient blir radene liggende i bufferet.
+		bq = &bqwriter.BigQueryWriter{Config: &config.Config{}}
+		result, err := bq.ImportRepo(ctx, entry, snapshot)
+		Expect(err).NotTo(HaveOccurred())
+		for table, count := range result.Tables {
+			Expect(count.Failed).To(BeZero(), table)
+		}
+	})
+
+	AfterAll(func() {
+		testDB.Close()
+	})
+
+	// pgRows leser columns fra table som én tekst per rad. NULL blir tom tekst,
+	// som tomme felter i BigQuery.
+	pgRows := func(table, where string, columns ...string) []string {
+		values := make([]string, len(columns))
+		for i, c := range columns {
+			values[i] = fmt.Sprintf("COALESCE(%s::text, '')", c)
+		}
+		query := fmt.Sprintf("SELECT %s FROM %s %s", strings.Join(values, " || '|' || "), table, where)
+		result, err := testDB.DB.QueryContext(ctx, query)
+		Expect(err).NotTo(HaveOccurred())
+		defer result.Close()
+
+		var out []string
+		for result.Next() {
+			var row string
+			Expect(result.Scan(&row)).To(Succeed())
+			out = append(out, row)
+		}
+		Expect(result.Err()).NotTo(HaveOccurred())
+		return out
+	}
+
+	// bqRows leser columns fra radene i bufferet til table på samme form.
+	bqRows := func(table string, columns ...string) []string {
+		dec := json.NewDecoder(bytes.NewReader(bq.BufferedRows(table)))
+		dec.UseNumber()
+		var out []string
+		for dec.More() {
+			var row map[string]any
+			Expect(dec.Decode(&row)).To(Succeed())
+			values := make([]string, len(columns))
+			for i, c := range columns {
+				if v, ok := row[c]; ok {
+					values[i] = fmt.Sprint(v)
+				}
+			}
+			out = append(out, strings.Join(values, "|"))
+		}
+		return out
+	}
+
+	byRepo := fmt.Sprintf("WHERE repo_id = %d", repoID)
+
+	It("repos", func() {
+		columns := []string{"full_name", "license", "topics", "readme_sha256", "default_branch"}
+		Expect(pgRows("repo_snapshots", fmt.Sprintf("WHERE id = %d", repoID), columns...)).
+			To(ConsistOf(bqRows("repos", columns...)))
+	})
+
+	It("repo_languages", func() {
+		columns := []string{"language", "bytes"}
+		Expect(pgRows("repo_languages", byRepo, columns...)).
+			To(ConsistOf(bqRows("repo_languages", columns...)))
+	})
+
+	It("dockerfiles", func() {
+		columns := []string{"path", "content_sha256", "uses_latest_tag", "has_secrets_in_env_or_arg"}
+		Expect(pgRows("dockerfile_snapshots", byRepo, columns...)).
+			To(ConsistOf(bqRows("dockerfile_features", columns...)))
+	})
+
+	It("ci_configs", func() {
+		columns := []string{"path", "content_sha256", "uses_npm_install", "uses_pull_request_target"}
+		Expect(pgRows("ci_config_snapshots", byRepo, columns...)).
+			To(ConsistOf(bqRows("ci_config", columns...)))
+	})
+
+	It("ci_workflow_calls", func() {
+		columns := []string{"caller_path", "job", "uses", "kind", "target", "is_local", "resolved"}
+		Expect(pgRows("ci_workflow_calls", byRepo, columns...)).
+			To(ConsistOf(bqRows("ci_workflow_calls", columns...)))
+	})
+
+	It("secret_findings", func() {
+		columns := []string{"path", "line", "rule"}
+		Expect(bqRows("secret_findings", columns...)).NotTo(BeEmpty())
+		Expect(pgRows("secret_findings", byRepo, columns...)).
+			To(ConsistOf(bqRows("secret_findings", columns...)))
+	})
+
+	It("sbom_github_packages og sbom_packages", func() {
+		columns := []string{"name", "version", "license", "purl"}
+		Expect(bqRows("sbom_packages", columns...)).To(HaveLen(3))
+		Expect(pgRows("sbom_github_packages", byRepo, columns...)).
+			To(ConsistOf(bqRows("sbom_packages", columns...)))
+	})
+
+	It("file_blobs", func() {
+		Expect(pgRows("file_blobs", "", "content_sha256")).
+			To(ConsistOf(bqRows("file_blobs", "content_sha256")))
+	})
+})
@@ -0,0 +1,185 @@
+package postgres_test
+
+import (
+	"context"
+	"io/fs"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/db"
+	"github.com/jonmartinstorm/reposnusern/internal/dbwriter"
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+	"github.com/jonmartinstorm/reposnusern/test/testutils"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dbwriter.Migrator", Ordered, func() {
+	var (
+		ctx      context.Context
+		testDB   *testutils.TestDB
+		migrator *dbwriter.Migrator
+	)
+
+	BeforeAll(func() {
+		ctx = context.Background()
+		testDB = testutils.StartTestPostgresContainer()
+
+		var err error
+		migrator, err = dbwriter.NewMigrator(testDB.DB)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterAll(func() {
+		testDB.Close()
+	})
+
+	It("migrerer en tom database til nyeste versjon", func() {
+		applied, err := migrator.Up(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(applied).To(Equal(len(migrator.Migrations)))
+		Expect(migrator.Check(ctx)).To(Succeed())
+
+		applied, err = migrator.Up(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(applied).To(Equal(0))
+	})
+
+	It("nekter å rulle tilbake 0001 uten Force", func() {
+		reverted, err := migrator.Down(ctx, len(migrator.Migrations))
+		Expect(err).To(MatchError(dbwriter.ErrBaselineDown))
+		Expect(reverted).To(Equal(0))
+		Expect(migrator.Check(ctx)).To(Succeed())
+	})
+
+	It("ruller tilbake og migrerer opp igjen", func() {
+		migrator.Force = true
+		DeferCleanup(func() { migrator.Force = false })
+
+		reverted, err := migrator.Down(ctx, len(migrator.Migrations))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reverted).To(Equal(len(migrator.Migrations)))
+
+		var tables int
+		Expect(testDB.DB.QueryRow(`SELECT COUNT(*) FROM information_schema.tables WHERE table_name = 'repos'`).Scan(&tables)).To(Succeed())
+		Expect(tables).To(Equal(0))
+		Expect(migrator.Check(ctx)).To(MatchError(dbwriter.ErrSchemaBehind))
+
+		_, err = migrator.Up(ctx)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("flytter eksisterende innhold til file_blobs", func() {
+		// Tilbake til versjonen før 0005_file_blobs
+		_, err := migrator.Down(ctx, 1)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = testDB.DB.Exec(`
+			INSERT INTO dockerfiles (repo_id, hentet_dato, full_name, path, content)
+			VALUES (1, '2025-01-06', 'testorg/a', 'Dockerfile', 'FROM alpine'),
+			       (1, '2025-01-13', 'testorg/a', 'Dockerfile', 'FROM alpine')`)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = migrator.Up(ctx)
+		Expect(err).NotTo(HaveOccurred())
+
+		var blobs, rows int
+		Expect(testDB.DB.QueryRow(`SELECT COUNT(*) FROM file_blobs`).Scan(&blobs)).To(Succeed())
+		Expect(testDB.DB.QueryRow(`SELECT COUNT(*) FROM dockerfiles WHERE content = 'FROM alpine'`).Scan(&rows)).To(Succeed())
+		Expect(blobs).To(Equal(1))
+		Expect(rows).To(Equal(2))
+	})
+
+	It("nekter å skrive når databasen er nyere enn binæren", func() {
+		_, err := testDB.DB.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, 'fra_fremtiden')`, migrator.Latest()+1)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(func() {
+			_, err := testDB.DB.Exec(`DELETE FROM schema_migrations WHERE version > $1`, migrator.Latest())
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Expect(migrator.Check(ctx)).To(MatchError(dbwriter.ErrSchemaAhead))
+		_, err = migrator.Up(ctx)
+		Expect(err).To(MatchError(dbwriter.ErrSchemaAhead))
+	})
+})
+
+// Databaser som ble laget før migreringene har skjemaet fra db/schema.sql, som
+// er 0001, men ingen schema_migrations. Up skal ta dem til nyeste versjon
+// uten å miste data, og importen skal virke etterpå.
+var _ = Describe("dbwriter.Migrator på en database fra før migreringene", Ordered, func() {
+	var (
+		ctx    context.Context
+		testDB *testutils.TestDB
+	)
+
+	BeforeAll(func() {
+		ctx = context.Background()
+		testDB = testutils.StartTestPostgresContainer()
+
+		baseline, err := fs.ReadFile(db.Migrations, "migrations/0001_initial.up.sql")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = testDB.DB.Exec(string(baseline))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = testDB.DB.Exec(`
+			INSERT INTO repos (id, hentet_dato, name, full_name, description, stars, forks, archived, private,
+				is_fork, language, size_mb, updated_at, pushed_at, created_at, html_url, topics, visibility,
+				license, open_issues, languages_url, readme_content)
+			VALUES (7, '2025-01-06', 'gammel', 'testorg/gammel', '', 1, 0, false, false, false, 'Go', 1,
+				'', '', '', '', '', 'public', 'MIT', 0, '', '# gammel');
+			INSERT INTO dockerfiles (repo_id, hentet_dato, full_name, path, content)
+			VALUES (7, '2025-01-06', 'testorg/gammel', 'Dockerfile', 'FROM alpine');
+			INSERT INTO ci_configs (repo_id, hentet_dato, path, content)
+			VALUES (7, '2025-01-06', '.github/workflows/ci.yml', 'on: push')`)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterAll(func() {
+		testDB.Close()
+	})
+
+	It("migrerer til nyeste versjon og beholder de gamle radene", func() {
+		migrator, err := dbwriter.NewMigrator(testDB.DB)
+		Expect(err).NotTo(HaveOccurred())
+
+		applied, err := migrator.Up(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(applied).To(Equal(len(migrator.Migrations)))
+		Expect(migrator.Check(ctx)).To(Succeed())
+
+		var readme, defaultBranch string
+		var gitCloneUnpinned bool
+		Expect(testDB.DB.QueryRow(`SELECT readme_content, default_branch FROM repos WHERE id = 7`).Scan(&readme, &defaultBranch)).To(Succeed())
+		Expect(readme).To(Equal("# gammel"))
+		Expect(defaultBranch).To(BeEmpty())
+		Expect(testDB.DB.QueryRow(`SELECT uses_git_clone_unpinned FROM dockerfiles WHERE repo_id = 7`).Scan(&gitCloneUnpinned)).To(Succeed())
+		Expect(gitCloneUnpinned).To(BeFalse())
+		Expect(testDB.DB.QueryRow(`SELECT uses_git_clone_unpinned FROM ci_configs WHERE repo_id = 7`).Scan(&gitCloneUnpinned)).To(Succeed())
+		Expect(gitCloneUnpinned).To(BeFalse())
+	})
+
+	It("importerer et repo etter migreringen", func() {
+		entry := models.RepoEntry{
+			Repo: models.RepoMeta{ID: 7, Name: "gammel", FullName: "testorg/gammel", DefaultBranch: "main"},
+			Files: map[string][]models.FileEntry{
+				"dockerfile": {{Path: "Dockerfile", Content: "FROM alpine\nRUN git clone https://github.com/org/x"}},
+			},
+			CIConfig:        []models.FileEntry{{Path: ".github/workflows/ci.yml", Content: "on: push"}},
+			CIWorkflowCalls: []models.WorkflowCall{{Caller: ".github/workflows/ci.yml", Job: "b", Uses: "./.github/workflows/b.yml", Kind: "workflow", Local: true}},
+		}
+		result, err := (&dbwriter.PostgresWriter{DB: testDB.DB}).ImportRepo(ctx, entry, time.Date(2025, 1, 13, 0, 0, 0, 0, time.UTC))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Failed()).To(Equal(0))
+
+		var snapshots int
+		Expect(testDB.DB.QueryRow(`SELECT COUNT(*) FROM repos WHERE id = 7`).Scan(&snapshots)).To(Succeed())
+		Expect(snapshots).To(Equal(2))
+
+		var defaultBranch string
+		Expect(testDB.DB.QueryRow(`SELECT default_branch FROM repos WHERE id = 7 AND hentet_dato = '2025-01-13'`).Scan(&defaultBranch)).To(Succeed())
+		Expect(defaultBranch).To(Equal("main"))
+
+		var gitCloneUnpinned bool
+		Expect(testDB.DB.QueryRow(`SELECT uses_git_clone_unpinned FROM dockerfiles WHERE repo_id = 7 AND hentet_dato = '2025-01-13'`).Scan(&gitCloneUnpinned)).To(Succeed())
+		Expect(gitCloneUnpinned).To(BeTrue())
+	})
+})
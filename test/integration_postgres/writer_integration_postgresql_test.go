@@ -0,0 +1,196 @@
+package postgres_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/config"
+	"github.com/jonmartinstorm/reposnusern/internal/dbwriter"
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+	"github.com/jonmartinstorm/reposnusern/test/testutils"
+	"github.com/lib/pq"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dbwriter.PostgresWriter", Ordered, func() {
+	var (
+		ctx    context.Context
+		testDB *testutils.TestDB
+		writer *dbwriter.PostgresWriter
+	)
+
+	BeforeAll(func() {
+		ctx = context.Background()
+		testDB = testutils.StartTestPostgresContainer()
+		testutils.RunMigrations(testDB.DB)
+		writer = &dbwriter.PostgresWriter{DB: testDB.DB}
+	})
+
+	AfterAll(func() {
+		testDB.Close()
+	})
+
+	It("skriver store SBOM-er og språk i bulk", func() {
+		// Flere pakker enn én bulk-insert tar, og én duplikat
+		var packages []interface{}
+		for i := range 2500 {
+			packages = append(packages, map[string]interface{}{
+				"name":             fmt.Sprintf("pkg-%d", i),
+				"versionInfo":      "1.0.0",
+				"licenseConcluded": "MIT",
+			})
+		}
+		packages = append(packages, map[string]interface{}{
+			"name":             "pkg-0",
+			"versionInfo":      "1.0.0",
+			"licenseConcluded": "Apache-2.0",
+		})
+
+		entry := models.RepoEntry{
+			Repo:      models.RepoMeta{ID: 42, Name: "stor", FullName: "testorg/stor"},
+			Languages: map[string]int{"Go": 1000, "Shell": 10},
+			SBOM:      map[string]interface{}{"sbom": map[string]interface{}{"packages": packages}},
+			CIWorkflowCalls: []models.WorkflowCall{
+				{Caller: "ci.yml", Job: "build", Uses: "./.github/workflows/b.yml", Kind: "workflow", Local: true},
+				{Caller: "ci.yml", Job: "build", Uses: "./.github/workflows/b.yml", Kind: "workflow", Local: true, Resolved: true},
+			},
+		}
+		result, err := writer.ImportRepo(ctx, entry, time.Now())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Tables["sbom_github_packages"]).To(Equal(models.TableResult{Written: 2500}))
+
+		var count int
+		Expect(testDB.DB.QueryRow(`SELECT COUNT(*) FROM sbom_github_packages WHERE repo_id = 42`).Scan(&count)).To(Succeed())
+		Expect(count).To(Equal(2500))
+
+		var license string
+		Expect(testDB.DB.QueryRow(`SELECT license FROM sbom_github_packages WHERE repo_id = 42 AND name = 'pkg-0'`).Scan(&license)).To(Succeed())
+		Expect(license).To(Equal("Apache-2.0"))
+
+		Expect(testDB.DB.QueryRow(`SELECT COUNT(*) FROM repo_languages WHERE repo_id = 42`).Scan(&count)).To(Succeed())
+		Expect(count).To(Equal(2))
+
+		var resolved bool
+		Expect(testDB.DB.QueryRow(`SELECT resolved FROM ci_workflow_calls WHERE repo_id = 42`).Scan(&resolved)).To(Succeed())
+		Expect(resolved).To(BeTrue())
+	})
+
+	// Postgres godtar ikke NUL-tegn i TEXT, så Dockerfilen kan ikke skrives
+	brokenEntry := func(id int64) models.RepoEntry {
+		return models.RepoEntry{
+			Repo:      models.RepoMeta{ID: id, Name: "delvis", FullName: fmt.Sprintf("testorg/delvis-%d", id)},
+			Languages: map[string]int{"Go": 100},
+			Files: map[string][]models.FileEntry{
+				"dockerfile": {{Path: "Dockerfile", Content: "FROM scratch\x00"}},
+			},
+		}
+	}
+
+	It("hopper over rader som feiler og skriver resten av repoet", func() {
+		writer.RowErrors = config.RowErrorsSkip
+
+		result, err := writer.ImportRepo(ctx, brokenEntry(43), time.Now())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Tables["file_blobs"]).To(Equal(models.TableResult{Failed: 1}))
+		Expect(result.Tables["dockerfiles"]).To(Equal(models.TableResult{Failed: 1}))
+		Expect(result.Tables["repo_languages"]).To(Equal(models.TableResult{Written: 1}))
+
+		var count int
+		Expect(testDB.DB.QueryRow(`SELECT COUNT(*) FROM repo_languages WHERE repo_id = 43`).Scan(&count)).To(Succeed())
+		Expect(count).To(Equal(1))
+	})
+
+	It("ruller tilbake hele repoet når rader feiler med RowErrorsFail", func() {
+		writer.RowErrors = config.RowErrorsFail
+
+		result, err := writer.ImportRepo(ctx, brokenEntry(44), time.Now())
+		Expect(err).To(MatchError(models.ErrRowsFailed))
+		Expect(result.Written()).To(Equal(0))
+
+		var count int
+		Expect(testDB.DB.QueryRow(`SELECT COUNT(*) FROM repos WHERE id = 44`).Scan(&count)).To(Succeed())
+		Expect(count).To(Equal(0))
+	})
+
+	It("lagrer likt innhold én gang og viser det gjennom viewene", func() {
+		writer.RowErrors = config.RowErrorsSkip
+		entry := models.RepoEntry{
+			Repo: models.RepoMeta{ID: 45, Name: "blobs", FullName: "testorg/blobs", Readme: "# blobs"},
+			Files: map[string][]models.FileEntry{
+				"dockerfile": {{Path: "Dockerfile", Content: "FROM alpine:3.20"}},
+			},
+			CIConfig: []models.FileEntry{{Path: ".github/workflows/ci.yml", Content: "on: push"}},
+		}
+		lastWeek := time.Now().AddDate(0, 0, -7)
+		_, err := writer.ImportRepo(ctx, entry, lastWeek)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = writer.ImportRepo(ctx, entry, time.Now())
+		Expect(err).NotTo(HaveOccurred())
+
+		var count int
+		Expect(testDB.DB.QueryRow(`SELECT COUNT(*) FROM dockerfile_snapshots WHERE repo_id = 45`).Scan(&count)).To(Succeed())
+		Expect(count).To(Equal(2))
+		Expect(testDB.DB.QueryRow(`SELECT COUNT(*) FROM file_blobs WHERE content_sha256 = $1`,
+			models.ContentSHA256("FROM alpine:3.20")).Scan(&count)).To(Succeed())
+		Expect(count).To(Equal(1))
+
+		var readme, dockerfile, ciConfig string
+		Expect(testDB.DB.QueryRow(`SELECT readme_content FROM repos WHERE id = 45 LIMIT 1`).Scan(&readme)).To(Succeed())
+		Expect(testDB.DB.QueryRow(`SELECT content FROM dockerfiles WHERE repo_id = 45 LIMIT 1`).Scan(&dockerfile)).To(Succeed())
+		Expect(testDB.DB.QueryRow(`SELECT content FROM ci_configs WHERE repo_id = 45 LIMIT 1`).Scan(&ciConfig)).To(Succeed())
+		Expect(readme).To(Equal("# blobs"))
+		Expect(dockerfile).To(Equal("FROM alpine:3.20"))
+		Expect(ciConfig).To(Equal("on: push"))
+	})
+
+	It("lagrer kjøringer i snapshot_runs", func() {
+		run := models.SnapshotRun{
+			ID:        "run-1",
+			Org:       "testorg",
+			StartedAt: time.Now(),
+			Status:    models.RunStatusRunning,
+			Version:   "v1.0.0",
+			Config:    "Org: testorg",
+		}
+		Expect(writer.StartRun(ctx, run)).To(Succeed())
+
+		run.Status = models.RunStatusPartial
+		run.FinishedAt = time.Now()
+		run.ReposProcessed = 3
+		run.FailedRepos = []string{"testorg/fails"}
+		Expect(writer.FinishRun(ctx, run)).To(Succeed())
+
+		var status string
+		var processed int64
+		var failed []string
+		Expect(testDB.DB.QueryRow(`SELECT status, repos_processed, failed_repos FROM snapshot_runs WHERE run_id = 'run-1'`).
+			Scan(&status, &processed, pq.Array(&failed))).To(Succeed())
+		Expect(status).To(Equal("partial"))
+		Expect(processed).To(Equal(int64(3)))
+		Expect(failed).To(Equal([]string{"testorg/fails"}))
+	})
+
+	It("lagrer hentefeil i repo_fetch_errors", func() {
+		fetchErrors := []models.RepoFetchError{
+			{Repo: models.RepoMeta{ID: 1, FullName: "testorg/gone"}, Class: models.FetchErrorGraphQL, Message: "NOT_FOUND", Fatal: true, Attempts: 1},
+			{Repo: models.RepoMeta{ID: 2, FullName: "testorg/flaky"}, Class: models.FetchErrorServer, Message: "status 502", Attempts: 2, Recovered: true},
+		}
+		Expect(writer.RecordFetchErrors(ctx, "run-1", time.Now(), fetchErrors)).To(Succeed())
+
+		var class string
+		var fatal bool
+		var attempts int
+		Expect(testDB.DB.QueryRow(`SELECT error_class, fatal, attempts FROM repo_fetch_errors WHERE run_id = 'run-1' AND repo_id = 1`).
+			Scan(&class, &fatal, &attempts)).To(Succeed())
+		Expect(class).To(Equal("graphql_error"))
+		Expect(fatal).To(BeTrue())
+		Expect(attempts).To(Equal(1))
+
+		var recovered bool
+		Expect(testDB.DB.QueryRow(`SELECT recovered FROM repo_fetch_errors WHERE run_id = 'run-1' AND repo_id = 2`).
+			Scan(&recovered)).To(Succeed())
+		Expect(recovered).To(BeTrue())
+	})
+})
@@ -0,0 +1,42 @@
+package testutils
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jonmartinstorm/reposnusern/internal/models"
+)
+
+// JSONFileWriter skriver hver importerte RepoEntry som en JSON-fil i Dir, slik
+// at ende-til-ende-tester kan sjekke resultatet uten database.
+type JSONFileWriter struct {
+	Dir string
+}
+
+func (w *JSONFileWriter) ImportRepo(ctx context.Context, entry models.RepoEntry, snapshot time.Time) (models.ImportResult, error) {
+	var result models.ImportResult
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return result, err
+	}
+	name := strings.ReplaceAll(entry.Repo.FullName, "/", "__") + ".json"
+	if err := os.WriteFile(filepath.Join(w.Dir, name), data, 0o644); err != nil {
+		return result, err
+	}
+	result.Add("repos", 1, 0)
+	return result, nil
+}
+
+// ReadEntry leser RepoEntry for fullName som ImportRepo har skrevet.
+func (w *JSONFileWriter) ReadEntry(fullName string) (models.RepoEntry, error) {
+	var entry models.RepoEntry
+	data, err := os.ReadFile(filepath.Join(w.Dir, strings.ReplaceAll(fullName, "/", "__")+".json"))
+	if err != nil {
+		return entry, err
+	}
+	return entry, json.Unmarshal(data, &entry)
+}
@@ -5,7 +5,6 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"os"
 	"time"
 
 	"github.com/jonmartinstorm/reposnusern/internal/config"
@@ -44,7 +43,7 @@ func NewRealPostgresWriter(db *sql.DB) *RealPostgresWriter {
 	return &RealPostgresWriter{db: db}
 }
 
-func (r *RealPostgresWriter) ImportRepo(ctx context.Context, entry models.RepoEntry, snapshot time.Time) error {
+func (r *RealPostgresWriter) ImportRepo(ctx context.Context, entry models.RepoEntry, snapshot time.Time) (models.ImportResult, error) {
 	pw := &dbwriter.PostgresWriter{DB: r.db}
 	return pw.ImportRepo(ctx, entry, snapshot)
 }
@@ -115,22 +114,13 @@ func (t *TestDB) Close() {
 	}
 }
 
+// RunMigrations kjører de innebygde migreringene mot testdatabasen.
 func RunMigrations(db *sql.DB) {
-	root, err := os.Getwd()
+	migrator, err := dbwriter.NewMigrator(db)
 	if err != nil {
-		log.Fatalf("Kunne ikke hente arbeidskatalog: %v", err)
+		log.Fatalf("Kunne ikke laste migreringer: %v", err)
 	}
-
-	schemaPath := root + "/db/schema.sql"
-	if _, err := os.Stat(schemaPath); os.IsNotExist(err) {
-		schemaPath = root + "/../../db/schema.sql"
-	}
-
-	schema, err := os.ReadFile(schemaPath)
-	if err != nil {
-		log.Fatalf("Kunne ikke lese schema.sql: %v", err)
-	}
-	if _, err := db.Exec(string(schema)); err != nil {
+	if _, err := migrator.Up(context.Background()); err != nil {
 		log.Fatalf("Klarte ikke å kjøre migrering: %v", err)
 	}
 }